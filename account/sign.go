@@ -26,3 +26,28 @@ func CheckSignByHash32(hash32 []byte, publicKeyBytes33 []byte, signatureBytes64
 
 	return true, nil
 }
+
+// Whether the 64 bytes signature is canonical (R and S in range and low S)
+func IsCanonicalSignatureBytes64(signatureBytes64 []byte) bool {
+	sigobj, e := btcec.ParseSignatureByte64(signatureBytes64)
+	if e != nil {
+		return false
+	}
+	return sigobj.IsCanonical()
+}
+
+// Verify aggregated schnorr signature of multiple public keys, keys order matters
+func CheckAggregatedSignByHash32(hash32 []byte, publicKeysBytes33 [][]byte, signatureBytes64 []byte) (bool, error) {
+	if len(hash32) != 32 {
+		return false, fmt.Errorf("Hash length is not 32")
+	}
+	keyagg, e := btcec.MuSigAggregateKeys(publicKeysBytes33)
+	if e != nil {
+		return false, e
+	}
+	if !btcec.SchnorrVerify(keyagg.SchnorrPubKey(), hash32, signatureBytes64) {
+		return false, fmt.Errorf("Aggregated signature of %d public keys verify fail.", len(publicKeysBytes33))
+	}
+	// ok
+	return true, nil
+}
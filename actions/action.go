@@ -3,6 +3,7 @@ package actions
 import (
 	"encoding/binary"
 	"fmt"
+	"github.com/hacash/core/fields"
 	"github.com/hacash/core/interfaces"
 )
 
@@ -73,6 +74,12 @@ func NewActionByKind(kind uint16) (interfaces.Action, error) {
 		return new(Action_30_SupportDistinguishForkChainID), nil
 	case 31:
 		return new(Action_31_OpenPaymentChannelWithSatoshi), nil
+	case 32:
+		return new(Action_32_PaymantChannelAndOnchainAtomicExchangeByAggregatedSign), nil
+	case 33:
+		return new(Action_33_UnilateralCloseOrRespondChallengePaymentChannelByChannelChainTransferBodyAggregatedSign), nil
+	case 34:
+		return new(Action_34_UsersLendingCreateByAggregatedSign), nil
 
 	}
 	////////////////////    END      ////////////////////
@@ -81,7 +88,7 @@ func NewActionByKind(kind uint16) (interfaces.Action, error) {
 
 func ParseAction(buf []byte, seek uint32) (interfaces.Action, uint32, error) {
 	if seek+2 >= uint32(len(buf)) {
-		return nil, 0, fmt.Errorf("[ParseAction] seek out of buf len.")
+		return nil, 0, fmt.Errorf("[ParseAction] %w", fields.ErrSeekOutOfBuf)
 	}
 	var kind = binary.BigEndian.Uint16(buf[seek : seek+2])
 	var act, e1 = NewActionByKind(kind)
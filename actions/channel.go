@@ -421,7 +421,11 @@ func (elm *Action_3_ClosePaymentChannel) Serialize() ([]byte, error) {
 }
 
 func (elm *Action_3_ClosePaymentChannel) Parse(buf []byte, seek uint32) (uint32, error) {
-	seek, _ = elm.ChannelId.Parse(buf, seek)
+	var e error
+	seek, e = elm.ChannelId.Parse(buf, seek)
+	if e != nil {
+		return 0, e
+	}
 	return seek, nil
 }
 
@@ -268,6 +268,11 @@ func (act *Action_23_UnilateralCloseOrRespondChallengePaymentChannelByRealtimeRe
 	if paychan == nil {
 		return fmt.Errorf("Payment Channel <%s> not find.", hex.EncodeToString(channelId))
 	}
+	// Reject high S signature
+	e = checkCanonicalSignsByPendingHeight(state, []fields.Sign{act.Reconciliation.LeftSign, act.Reconciliation.RightSign})
+	if e != nil {
+		return e
+	}
 	// Check the signatures of both account addresses, and both parties check
 	// Enter a challenging period or seize funds
 	return checkChannelGotoChallegingOrFinalDistributionWriteinChainStateV3(state, act.AssertAddress, paychan, &act.Reconciliation)
@@ -399,9 +404,25 @@ func (elm *Action_24_UnilateralCloseOrRespondChallengePaymentChannelByChannelCha
 
 func (act *Action_24_UnilateralCloseOrRespondChallengePaymentChannelByChannelChainTransferBody) WriteInChainState(state interfaces.ChainStateOperation) error {
 
+	if act.belong_trs_v3 == nil {
+		panic("Action belong to transaction not be nil !")
+	}
+
+	return checkChannelChainTransferBodyWriteInChainStateV3(state, act.AssertAddress, &act.ChannelChainTransferData, &act.ChannelChainTransferTargetProveBody, func() error {
+		// Check that all signatures are complete and correct
+		e := act.ChannelChainTransferData.CheckMustAddressAndSigns()
+		if e != nil {
+			return e
+		}
+		return checkCanonicalSignsByPendingHeight(state, act.ChannelChainTransferData.MustSigns)
+	})
+}
+
+func (act *Action_24_UnilateralCloseOrRespondChallengePaymentChannelByChannelChainTransferBody) WriteinChainState(state interfacev2.ChainStateOperation) error {
+
 	var e error
 
-	if act.belong_trs_v3 == nil {
+	if act.belong_trs == nil {
 		panic("Action belong to transaction not be nil !")
 	}
 
@@ -449,31 +470,55 @@ func (act *Action_24_UnilateralCloseOrRespondChallengePaymentChannelByChannelCha
 	}
 
 	// Check whether it is a challenge or a final capture
-	return checkChannelGotoChallegingOrFinalDistributionWriteinChainStateV3(state, act.AssertAddress, paychan, &act.ChannelChainTransferTargetProveBody)
+	return checkChannelGotoChallegingOrFinalDistributionWriteinChainState(state, act.AssertAddress, paychan, &act.ChannelChainTransferTargetProveBody)
 }
 
-func (act *Action_24_UnilateralCloseOrRespondChallengePaymentChannelByChannelChainTransferBody) WriteinChainState(state interfacev2.ChainStateOperation) error {
-
-	var e error
+func (act *Action_24_UnilateralCloseOrRespondChallengePaymentChannelByChannelChainTransferBody) RecoverChainState(state interfacev2.ChainStateOperation) error {
 
-	if act.belong_trs == nil {
-		panic("Action belong to transaction not be nil !")
+	// Query channel
+	paychan, e := state.Channel(act.ChannelChainTransferTargetProveBody.ChannelId)
+	if e != nil {
+		return e
 	}
+	if paychan == nil {
+		return fmt.Errorf("Payment Channel Id <%s> not find.", hex.EncodeToString(act.ChannelChainTransferTargetProveBody.ChannelId))
+	}
+
+	// Fallback
+	return checkChannelGotoChallegingOrFinalDistributionRecoverChainState(state, act.AssertAddress, paychan, &act.ChannelChainTransferTargetProveBody)
+}
+
+func (elm *Action_24_UnilateralCloseOrRespondChallengePaymentChannelByChannelChainTransferBody) SetBelongTransaction(t interfacev2.Transaction) {
+	elm.belong_trs = t
+}
+
+func (elm *Action_24_UnilateralCloseOrRespondChallengePaymentChannelByChannelChainTransferBody) SetBelongTrs(t interfaces.Transaction) {
+	elm.belong_trs_v3 = t
+}
+
+// burning fees  // 是否销毁本笔交易的 90% 的交易费用
+func (act *Action_24_UnilateralCloseOrRespondChallengePaymentChannelByChannelChainTransferBody) IsBurning90PersentTxFees() bool {
+	return false
+}
+
+// Check the channel chain transfer data includes the target prove body and is signed by both channel addresses
+// The signatures are checked by checksigns, then it goes to challenge or final distribution
+func checkChannelChainTransferBodyWriteInChainStateV3(state interfaces.ChainStateOperation, assertAddress fields.Address, transferData *channel.OffChainFormPaymentChannelTransfer, proveBody *channel.ChannelChainTransferProveBodyInfo, checksigns func() error) error {
 
 	// Query channel
-	paychan, e := state.Channel(act.ChannelChainTransferTargetProveBody.ChannelId)
+	paychan, e := state.Channel(proveBody.ChannelId)
 	if e != nil {
 		return e
 	}
 	if paychan == nil {
-		return fmt.Errorf("Payment Channel <%s> not find.", hex.EncodeToString(act.ChannelChainTransferTargetProveBody.ChannelId))
+		return fmt.Errorf("Payment Channel <%s> not find.", hex.EncodeToString(proveBody.ChannelId))
 	}
 
 	// Check whether the channel hash is correct
-	hxhalf := act.ChannelChainTransferTargetProveBody.GetSignStuffHashHalfChecker()
+	hxhalf := proveBody.GetSignStuffHashHalfChecker()
 	// Check whether the hash value is included in the list
 	var isHashCheckOk = false
-	for _, hxckr := range act.ChannelChainTransferData.ChannelTransferProveHashHalfCheckers {
+	for _, hxckr := range transferData.ChannelTransferProveHashHalfCheckers {
 		if hxhalf.Equal(hxckr) {
 			isHashCheckOk = true
 			break
@@ -486,7 +531,7 @@ func (act *Action_24_UnilateralCloseOrRespondChallengePaymentChannelByChannelCha
 	// Check whether the channel addresses of both parties are included in the signature list
 	lsgok := false
 	rsgok := false
-	for _, v := range act.ChannelChainTransferData.MustSignAddresses {
+	for _, v := range transferData.MustSignAddresses {
 		if v.Equal(paychan.LeftAddress) {
 			lsgok = true
 		} else if v.Equal(paychan.RightAddress) {
@@ -497,41 +542,143 @@ func (act *Action_24_UnilateralCloseOrRespondChallengePaymentChannelByChannelCha
 		return fmt.Errorf("Channel signature address is missing.")
 	}
 
-	// Check that all signatures are complete and correct
-	e = act.ChannelChainTransferData.CheckMustAddressAndSigns()
+	// Check signatures
+	e = checksigns()
 	if e != nil {
 		return e
 	}
 
 	// Check whether it is a challenge or a final capture
-	return checkChannelGotoChallegingOrFinalDistributionWriteinChainState(state, act.AssertAddress, paychan, &act.ChannelChainTransferTargetProveBody)
+	return checkChannelGotoChallegingOrFinalDistributionWriteinChainStateV3(state, assertAddress, paychan, proveBody)
 }
 
-func (act *Action_24_UnilateralCloseOrRespondChallengePaymentChannelByChannelChainTransferBody) RecoverChainState(state interfacev2.ChainStateOperation) error {
+///////////////////////////////////////////////
 
-	// Query channel
-	paychan, e := state.Channel(act.ChannelChainTransferTargetProveBody.ChannelId)
+// Same as Action_24, all must sign addresses of the channel chain transfer sign by one aggregated schnorr signature
+// The transfer data is carried without the MustSigns
+type Action_33_UnilateralCloseOrRespondChallengePaymentChannelByChannelChainTransferBodyAggregatedSign struct {
+	// Proposer address
+	AssertAddress fields.Address
+	// Channel overall payment data without the MustSigns
+	ChannelChainTransferData channel.OffChainFormPaymentChannelTransfer
+	// Aggregated signature of all must sign addresses in order
+	AggregatedSign fields.AggregatedSign
+	// Payment entity data of this channel
+	ChannelChainTransferTargetProveBody channel.ChannelChainTransferProveBodyInfo
+
+	// data ptr
+	belong_trs    interfacev2.Transaction
+	belong_trs_v3 interfaces.Transaction
+}
+
+func (elm *Action_33_UnilateralCloseOrRespondChallengePaymentChannelByChannelChainTransferBodyAggregatedSign) Kind() uint16 {
+	return 33
+}
+
+func (elm *Action_33_UnilateralCloseOrRespondChallengePaymentChannelByChannelChainTransferBodyAggregatedSign) Size() uint32 {
+	data := elm.ChannelChainTransferData
+	size := data.Timestamp.Size() +
+		data.OrderNoteHashHalfChecker.Size() +
+		data.MustSignCount.Size() +
+		data.ChannelCount.Size()
+	size += uint32(len(data.MustSignAddresses)) * fields.AddressSize
+	size += uint32(len(data.ChannelTransferProveHashHalfCheckers)) * fields.HashHalfCheckerSize
+	return 2 + elm.AssertAddress.Size() +
+		size +
+		elm.AggregatedSign.Size() +
+		elm.ChannelChainTransferTargetProveBody.Size()
+}
+
+// json api
+func (elm *Action_33_UnilateralCloseOrRespondChallengePaymentChannelByChannelChainTransferBodyAggregatedSign) Describe() map[string]interface{} {
+	var data = map[string]interface{}{}
+	return data
+}
+
+func (elm *Action_33_UnilateralCloseOrRespondChallengePaymentChannelByChannelChainTransferBodyAggregatedSign) Serialize() ([]byte, error) {
+	var kindByte = make([]byte, 2)
+	binary.BigEndian.PutUint16(kindByte, elm.Kind())
+	var bt1, _ = elm.AssertAddress.Serialize()
+	var bt2, _ = elm.ChannelChainTransferData.SerializeNoSign()
+	var bt3, e = elm.AggregatedSign.Serialize()
 	if e != nil {
-		return e
+		return nil, e
 	}
-	if paychan == nil {
-		return fmt.Errorf("Payment Channel Id <%s> not find.", hex.EncodeToString(act.ChannelChainTransferTargetProveBody.ChannelId))
+	var bt4, _ = elm.ChannelChainTransferTargetProveBody.Serialize()
+	var buffer bytes.Buffer
+	buffer.Write(kindByte)
+	buffer.Write(bt1)
+	buffer.Write(bt2)
+	buffer.Write(bt3)
+	buffer.Write(bt4)
+	return buffer.Bytes(), nil
+}
+
+func (elm *Action_33_UnilateralCloseOrRespondChallengePaymentChannelByChannelChainTransferBodyAggregatedSign) Parse(buf []byte, seek uint32) (uint32, error) {
+	var e error
+	seek, e = elm.AssertAddress.Parse(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	seek, e = elm.ChannelChainTransferData.ParseNoSign(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	elm.ChannelChainTransferData.MustSigns = make([]fields.Sign, 0)
+	seek, e = elm.AggregatedSign.Parse(buf, seek)
+	if e != nil {
+		return 0, e
 	}
+	seek, e = elm.ChannelChainTransferTargetProveBody.Parse(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	return seek, nil
+}
 
-	// Fallback
-	return checkChannelGotoChallegingOrFinalDistributionRecoverChainState(state, act.AssertAddress, paychan, &act.ChannelChainTransferTargetProveBody)
+func (elm *Action_33_UnilateralCloseOrRespondChallengePaymentChannelByChannelChainTransferBodyAggregatedSign) RequestSignAddresses() []fields.Address {
+	// Check signature
+	return []fields.Address{
+		elm.AssertAddress,
+	}
 }
 
-func (elm *Action_24_UnilateralCloseOrRespondChallengePaymentChannelByChannelChainTransferBody) SetBelongTransaction(t interfacev2.Transaction) {
+func (act *Action_33_UnilateralCloseOrRespondChallengePaymentChannelByChannelChainTransferBodyAggregatedSign) WriteInChainState(state interfaces.ChainStateOperation) error {
+
+	if act.belong_trs_v3 == nil {
+		panic("Action belong to transaction not be nil !")
+	}
+
+	if !sys.IsAggregatedSignActive(state.GetPendingBlockHeight()) {
+		return fmt.Errorf("Aggregated sign not active at block height %d.", state.GetPendingBlockHeight())
+	}
+
+	return checkChannelChainTransferBodyWriteInChainStateV3(state, act.AssertAddress, &act.ChannelChainTransferData, &act.ChannelChainTransferTargetProveBody, func() error {
+		// Check the aggregated signature of all addresses
+		return act.ChannelChainTransferData.CheckMustAddressAndAggregatedSign(&act.AggregatedSign)
+	})
+}
+
+func (act *Action_33_UnilateralCloseOrRespondChallengePaymentChannelByChannelChainTransferBodyAggregatedSign) WriteinChainState(state interfacev2.ChainStateOperation) error {
+
+	panic("WriteinChainState in Action_33_UnilateralCloseOrRespondChallengePaymentChannelByChannelChainTransferBodyAggregatedSign be deprecated")
+}
+
+func (act *Action_33_UnilateralCloseOrRespondChallengePaymentChannelByChannelChainTransferBodyAggregatedSign) RecoverChainState(state interfacev2.ChainStateOperation) error {
+
+	panic("RecoverChainState be deprecated")
+}
+
+func (elm *Action_33_UnilateralCloseOrRespondChallengePaymentChannelByChannelChainTransferBodyAggregatedSign) SetBelongTransaction(t interfacev2.Transaction) {
 	elm.belong_trs = t
 }
 
-func (elm *Action_24_UnilateralCloseOrRespondChallengePaymentChannelByChannelChainTransferBody) SetBelongTrs(t interfaces.Transaction) {
+func (elm *Action_33_UnilateralCloseOrRespondChallengePaymentChannelByChannelChainTransferBodyAggregatedSign) SetBelongTrs(t interfaces.Transaction) {
 	elm.belong_trs_v3 = t
 }
 
-// burning fees  // 是否销毁本笔交易的 90% 的交易费用
-func (act *Action_24_UnilateralCloseOrRespondChallengePaymentChannelByChannelChainTransferBody) IsBurning90PersentTxFees() bool {
+// burning fees
+func (act *Action_33_UnilateralCloseOrRespondChallengePaymentChannelByChannelChainTransferBodyAggregatedSign) IsBurning90PersentTxFees() bool {
 	return false
 }
 
@@ -1182,3 +1329,11 @@ func (elm *Action_27_ClosePaymentChannelByClaimDistribution) SetBelongTrs(t inte
 func (act *Action_27_ClosePaymentChannelByClaimDistribution) IsBurning90PersentTxFees() bool {
 	return false
 }
+
+// Reject non-canonical high S bill signatures after the strict check height
+func checkCanonicalSignsByPendingHeight(state interfaces.ChainStateOperation, signs []fields.Sign) error {
+	if !sys.IsSignatureCanonicalCheckActive(state.GetPendingBlockHeight()) {
+		return nil
+	}
+	return fields.CheckCanonicalSigns(signs)
+}
@@ -78,6 +78,29 @@ func (elm *ChannelAmountAndOnChainAmountTransferEachOtherByAtomicExchange) Seria
 }
 
 func (elm *ChannelAmountAndOnChainAmountTransferEachOtherByAtomicExchange) Parse(buf []byte, seek uint32) (uint32, error) {
+	var e error
+	seek, e = elm.ParseNoSign(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	// autograph
+	scn := int(elm.AddressCount)
+	if int(seek)+scn*int(fields.SignSize) > len(buf) {
+		return 0, fmt.Errorf("[ChannelAmountAndOnChainAmountTransferEachOtherByAtomicExchange.Parse] %w", fields.ErrSeekOutOfBuf)
+	}
+	elm.MustSigns = make([]fields.Sign, scn)
+	for i := 0; i < scn; i++ {
+		seek, e = elm.MustSigns[i].Parse(buf, seek)
+		if e != nil {
+			return 0, e
+		}
+	}
+	// complete
+	return seek, nil
+}
+
+// Parse the data body without the signatures
+func (elm *ChannelAmountAndOnChainAmountTransferEachOtherByAtomicExchange) ParseNoSign(buf []byte, seek uint32) (uint32, error) {
 	var e error
 	seek, e = elm.ChannelTranferProveBodyHashChecker.Parse(buf, seek)
 	if e != nil {
@@ -97,6 +120,9 @@ func (elm *ChannelAmountAndOnChainAmountTransferEachOtherByAtomicExchange) Parse
 		return 0, e
 	}
 	scn := int(elm.AddressCount)
+	if int(seek)+scn*fields.AddressSize > len(buf) {
+		return 0, fmt.Errorf("[ChannelAmountAndOnChainAmountTransferEachOtherByAtomicExchange.Parse] %w", fields.ErrSeekOutOfBuf)
+	}
 	elm.OnchainTransferFromAndMustSignAddresses = make([]fields.Address, scn)
 	for i := 0; i < scn; i++ {
 		seek, e = elm.OnchainTransferFromAndMustSignAddresses[i].Parse(buf, seek)
@@ -104,15 +130,6 @@ func (elm *ChannelAmountAndOnChainAmountTransferEachOtherByAtomicExchange) Parse
 			return 0, e
 		}
 	}
-	// autograph
-	elm.MustSigns = make([]fields.Sign, scn)
-	for i := 0; i < scn; i++ {
-		seek, e = elm.MustSigns[i].Parse(buf, seek)
-		if e != nil {
-			return 0, e
-		}
-	}
-	// complete
 	return seek, nil
 }
 
@@ -240,23 +257,13 @@ func (act *Action_25_PaymantChannelAndOnchainAtomicExchange) WriteInChainState(s
 	if e != nil {
 		return e
 	}
-
-	// Create, save voucher
-	objsto := stores.Chaswap{
-		IsBeUsed:                                fields.CreateBool(false), // 未使用过
-		AddressCount:                            act.ExchangeEvidence.AddressCount,
-		OnchainTransferFromAndMustSignAddresses: act.ExchangeEvidence.OnchainTransferFromAndMustSignAddresses,
-	}
-	e = state.ChaswapCreate(swaphx, &objsto)
+	e = checkCanonicalSignsByPendingHeight(state, act.ExchangeEvidence.MustSigns)
 	if e != nil {
 		return e
 	}
 
-	// transfer accounts
-	fromAddr := act.ExchangeEvidence.OnchainTransferFromAndMustSignAddresses[0]
-	toAddr := act.ExchangeEvidence.OnChainTranferToAddress
-	trsAmt := act.ExchangeEvidence.OnChainTranferAmount
-	return DoSimpleTransferFromChainStateV3(state, fromAddr, toAddr, trsAmt)
+	// Save voucher and transfer
+	return createChaswapAndTransferWriteInChainStateV3(state, &act.ExchangeEvidence)
 }
 
 func (act *Action_25_PaymantChannelAndOnchainAtomicExchange) WriteinChainState(state interfacev2.ChainStateOperation) error {
@@ -337,3 +344,167 @@ func (elm *Action_25_PaymantChannelAndOnchainAtomicExchange) SetBelongTrs(t inte
 func (act *Action_25_PaymantChannelAndOnchainAtomicExchange) IsBurning90PersentTxFees() bool {
 	return false
 }
+
+////////////////////////////////////////////////////////
+
+// Atomic exchange of channel and chain, all parties sign by one aggregated schnorr signature
+// The evidence is the same as Action_25, the signature list is replaced by the aggregated sign
+type Action_32_PaymantChannelAndOnchainAtomicExchangeByAggregatedSign struct {
+
+	// Atomic swap transaction receipt without the MustSigns
+	ExchangeEvidence ChannelAmountAndOnChainAmountTransferEachOtherByAtomicExchange
+	// Aggregated signature of all addresses in order
+	AggregatedSign fields.AggregatedSign
+
+	// data ptr
+	belong_trs    interfacev2.Transaction
+	belong_trs_v3 interfaces.Transaction
+}
+
+func (elm *Action_32_PaymantChannelAndOnchainAtomicExchangeByAggregatedSign) Kind() uint16 {
+	return 32
+}
+
+func (elm *Action_32_PaymantChannelAndOnchainAtomicExchangeByAggregatedSign) Size() uint32 {
+	size := elm.ExchangeEvidence.ChannelTranferProveBodyHashChecker.Size() +
+		elm.ExchangeEvidence.OnChainTranferToAddress.Size() +
+		elm.ExchangeEvidence.OnChainTranferAmount.Size() +
+		elm.ExchangeEvidence.AddressCount.Size()
+	size += uint32(len(elm.ExchangeEvidence.OnchainTransferFromAndMustSignAddresses)) * fields.AddressSize
+	return 2 + size + elm.AggregatedSign.Size()
+}
+
+// json api
+func (elm *Action_32_PaymantChannelAndOnchainAtomicExchangeByAggregatedSign) Describe() map[string]interface{} {
+	var data = map[string]interface{}{}
+	return data
+}
+
+func (elm *Action_32_PaymantChannelAndOnchainAtomicExchangeByAggregatedSign) Serialize() ([]byte, error) {
+	var kindByte = make([]byte, 2)
+	binary.BigEndian.PutUint16(kindByte, elm.Kind())
+	var bt1, _ = elm.ExchangeEvidence.SerializeNoSign()
+	var bt2, e = elm.AggregatedSign.Serialize()
+	if e != nil {
+		return nil, e
+	}
+	var buffer bytes.Buffer
+	buffer.Write(kindByte)
+	buffer.Write(bt1)
+	buffer.Write(bt2)
+	return buffer.Bytes(), nil
+}
+
+func (elm *Action_32_PaymantChannelAndOnchainAtomicExchangeByAggregatedSign) Parse(buf []byte, seek uint32) (uint32, error) {
+	var e error
+	seek, e = elm.ExchangeEvidence.ParseNoSign(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	elm.ExchangeEvidence.MustSigns = make([]fields.Sign, 0)
+	seek, e = elm.AggregatedSign.Parse(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	return seek, nil
+}
+
+func (elm *Action_32_PaymantChannelAndOnchainAtomicExchangeByAggregatedSign) RequestSignAddresses() []fields.Address {
+	// Action internal judgment signature
+	return []fields.Address{}
+}
+
+// Check the addresses and the aggregated signature of the evidence
+func (elm *Action_32_PaymantChannelAndOnchainAtomicExchangeByAggregatedSign) CheckMustAddressAndSign() error {
+	addrs := elm.ExchangeEvidence.OnchainTransferFromAndMustSignAddresses
+	sgmn := len(addrs)
+	if sgmn < 2 || sgmn > 3 || sgmn != int(elm.ExchangeEvidence.AddressCount) {
+		return fmt.Errorf("Address length error, need 2~3 but got %d, %d.", sgmn, int(elm.ExchangeEvidence.AddressCount))
+	}
+	conhx, e := elm.ExchangeEvidence.SignStuffHash()
+	if e != nil {
+		return e
+	}
+	return elm.AggregatedSign.CheckMustAddressAndSign(conhx, addrs)
+}
+
+func (act *Action_32_PaymantChannelAndOnchainAtomicExchangeByAggregatedSign) WriteInChainState(state interfaces.ChainStateOperation) error {
+
+	if !sys.TestDebugLocalDevelopmentMark {
+		return fmt.Errorf("mainnet not yet") // Waiting for review is not enabled yet
+	}
+
+	if act.belong_trs_v3 == nil {
+		panic("Action belong to transaction not be nil !")
+	}
+
+	if !sys.IsAggregatedSignActive(state.GetPendingBlockHeight()) {
+		return fmt.Errorf("Aggregated sign not active at block height %d.", state.GetPendingBlockHeight())
+	}
+
+	// Query whether it is a duplicate submission
+	swaphx := act.ExchangeEvidence.ChannelTranferProveBodyHashChecker
+	chaswap, e := state.Chaswap(swaphx)
+	if e != nil {
+		return e
+	}
+	if chaswap != nil {
+		return fmt.Errorf("ChannelTranferProveBodyHashChecker <%s> is existence.",
+			swaphx.ToHex())
+	}
+
+	// Check the aggregated signature of all addresses
+	e = act.CheckMustAddressAndSign()
+	if e != nil {
+		return e
+	}
+
+	// Save voucher and transfer
+	return createChaswapAndTransferWriteInChainStateV3(state, &act.ExchangeEvidence)
+}
+
+func (act *Action_32_PaymantChannelAndOnchainAtomicExchangeByAggregatedSign) WriteinChainState(state interfacev2.ChainStateOperation) error {
+
+	panic("WriteinChainState in Action_32_PaymantChannelAndOnchainAtomicExchangeByAggregatedSign be deprecated")
+}
+
+func (act *Action_32_PaymantChannelAndOnchainAtomicExchangeByAggregatedSign) RecoverChainState(state interfacev2.ChainStateOperation) error {
+
+	panic("RecoverChainState be deprecated")
+}
+
+func (elm *Action_32_PaymantChannelAndOnchainAtomicExchangeByAggregatedSign) SetBelongTransaction(t interfacev2.Transaction) {
+	elm.belong_trs = t
+}
+
+func (elm *Action_32_PaymantChannelAndOnchainAtomicExchangeByAggregatedSign) SetBelongTrs(t interfaces.Transaction) {
+	elm.belong_trs_v3 = t
+}
+
+// burning fees
+func (act *Action_32_PaymantChannelAndOnchainAtomicExchangeByAggregatedSign) IsBurning90PersentTxFees() bool {
+	return false
+}
+
+//////////////////////////////////////////////////////////
+
+// Create the chaswap voucher of the evidence and transfer on chain from the first address
+func createChaswapAndTransferWriteInChainStateV3(state interfaces.ChainStateOperation, evidence *ChannelAmountAndOnChainAmountTransferEachOtherByAtomicExchange) error {
+
+	// Create, save voucher
+	objsto := stores.Chaswap{
+		IsBeUsed:                                fields.CreateBool(false), // 未使用过
+		AddressCount:                            evidence.AddressCount,
+		OnchainTransferFromAndMustSignAddresses: evidence.OnchainTransferFromAndMustSignAddresses,
+	}
+	e := state.ChaswapCreate(evidence.ChannelTranferProveBodyHashChecker, &objsto)
+	if e != nil {
+		return e
+	}
+
+	// transfer accounts
+	fromAddr := evidence.OnchainTransferFromAndMustSignAddresses[0]
+	toAddr := evidence.OnChainTranferToAddress
+	trsAmt := evidence.OnChainTranferAmount
+	return DoSimpleTransferFromChainStateV3(state, fromAddr, toAddr, trsAmt)
+}
@@ -892,8 +892,14 @@ func (elm *Action_5_DiamondTransfer) Serialize() ([]byte, error) {
 }
 
 func (elm *Action_5_DiamondTransfer) Parse(buf []byte, seek uint32) (uint32, error) {
-	var moveseek1, _ = elm.Diamond.Parse(buf, seek)
-	var moveseek2, _ = elm.ToAddress.Parse(buf, moveseek1)
+	var moveseek1, e = elm.Diamond.Parse(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	var moveseek2, e2 = elm.ToAddress.Parse(buf, moveseek1)
+	if e2 != nil {
+		return 0, e2
+	}
 	return moveseek2, nil
 }
 
@@ -0,0 +1,66 @@
+package actions
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/hacash/core/fields"
+)
+
+// Every action kind is parsed from random bodies, an action taken in must serialize back to the bytes the tx hash is over
+// Seeds are built from the action constructors, the genesis block has no actions to check in
+func FuzzParseActions(f *testing.F) {
+	addr, _ := fields.CheckReadableAddress("1AVRuFXNFi3rdMrPH4hdqSgFrEBnWisWaS")
+	amt, _ := fields.NewAmountFromFinString("ㄜ12345:248")
+	samples := [][]byte{}
+	for _, act := range []interface{ Serialize() ([]byte, error) }{
+		NewAction_1_SimpleToTransfer(*addr, amt),
+		&Action_5_DiamondTransfer{Diamond: fields.DiamondName("WTYUIA"), ToAddress: *addr},
+		&Action_3_ClosePaymentChannel{ChannelId: bytes.Repeat([]byte{7}, 16)},
+	} {
+		if bts, e := act.Serialize(); e == nil {
+			samples = append(samples, bts[2:])
+		}
+	}
+	samples = append(samples, []byte{}, []byte{0}, bytes.Repeat([]byte{1}, 128), bytes.Repeat([]byte{0xff}, 256))
+	for kind := 1; kind <= 34; kind++ {
+		for _, body := range samples {
+			f.Add(uint16(kind), body)
+		}
+	}
+	f.Fuzz(func(t *testing.T, kind uint16, body []byte) {
+		data := make([]byte, 2, 2+len(body))
+		binary.BigEndian.PutUint16(data, kind)
+		data = append(data, body...)
+		act, seek, e := ParseAction(data, 0)
+		if e != nil {
+			return
+		}
+		if int(seek) > len(data) {
+			t.Fatalf("seek %d out of data len %d", seek, len(data))
+		}
+		bts, e := act.Serialize()
+		if e != nil {
+			return
+		}
+		act2, seek, e := ParseAction(bts, 0)
+		if e != nil {
+			t.Fatalf("parse serialized data error: %v", e)
+		}
+		if int(seek) != len(bts) {
+			t.Fatalf("parse serialized data seek %d but len %d", seek, len(bts))
+		}
+		bts2, _ := act2.Serialize()
+		if !bytes.Equal(bts, bts2) {
+			t.Fatalf("round trip not match %x != %x", bts, bts2)
+		}
+		// a cut action asks for more data, see stream.Decoder
+		for i := 0; i < len(bts); i++ {
+			if _, _, e := ParseAction(bts[:i], 0); !errors.Is(e, fields.ErrSeekOutOfBuf) {
+				t.Fatalf("cut %d of %x must be out of buf: %v", i, bts, e)
+			}
+		}
+	})
+}
@@ -1132,3 +1132,122 @@ func (act *Action_20_UsersLendingRansom) SetBelongTrs(trs interfaces.Transaction
 func (act *Action_20_UsersLendingRansom) IsBurning90PersentTxFees() bool {
 	return false
 }
+
+////////////////////////////////////////////////////////
+
+// Same as Action_19, the mortgagor and the lender sign the lending by one aggregated schnorr signature instead of two tx signatures
+// The signed stuff is the hash of the Action_19 body, the lending id can be created only once so it cannot be replayed
+type Action_34_UsersLendingCreateByAggregatedSign struct {
+
+	// Lending of the two parties
+	Lending Action_19_UsersLendingCreate
+	// Aggregated signature of the mortgagor and the lender in order
+	AggregatedSign fields.AggregatedSign
+
+	// data ptr
+	belong_trs    interfacev2.Transaction
+	belong_trs_v3 interfaces.Transaction
+}
+
+func (elm *Action_34_UsersLendingCreateByAggregatedSign) Kind() uint16 {
+	return 34
+}
+
+// json api
+func (elm *Action_34_UsersLendingCreateByAggregatedSign) Describe() map[string]interface{} {
+	var data = map[string]interface{}{}
+	return data
+}
+
+func (elm *Action_34_UsersLendingCreateByAggregatedSign) Serialize() ([]byte, error) {
+	var kindByte = make([]byte, 2)
+	binary.BigEndian.PutUint16(kindByte, elm.Kind())
+	var bt1, _ = elm.Lending.Serialize()
+	var bt2, e = elm.AggregatedSign.Serialize()
+	if e != nil {
+		return nil, e
+	}
+	var buffer bytes.Buffer
+	buffer.Write(kindByte)
+	buffer.Write(bt1[2:]) // without kind 19
+	buffer.Write(bt2)
+	return buffer.Bytes(), nil
+}
+
+func (elm *Action_34_UsersLendingCreateByAggregatedSign) Parse(buf []byte, seek uint32) (uint32, error) {
+	var e error
+	seek, e = elm.Lending.Parse(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	seek, e = elm.AggregatedSign.Parse(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	return seek, nil
+}
+
+func (elm *Action_34_UsersLendingCreateByAggregatedSign) Size() uint32 {
+	return elm.Lending.Size() + elm.AggregatedSign.Size()
+}
+
+// Hash of the Action_19 body signed by the two parties
+func (elm *Action_34_UsersLendingCreateByAggregatedSign) SignStuffHash() fields.Hash {
+	var stuff, _ = elm.Lending.Serialize()
+	return fields.CalculateHash(stuff)
+}
+
+func (act *Action_34_UsersLendingCreateByAggregatedSign) RequestSignAddresses() []fields.Address {
+	// Action internal judgment signature
+	return []fields.Address{}
+}
+
+// Check the aggregated signature of the mortgagor and the lender
+func (act *Action_34_UsersLendingCreateByAggregatedSign) CheckMustAddressAndSign() error {
+	addrs := []fields.Address{act.Lending.MortgagorAddress, act.Lending.LenderAddress}
+	return act.AggregatedSign.CheckMustAddressAndSign(act.SignStuffHash(), addrs)
+}
+
+func (act *Action_34_UsersLendingCreateByAggregatedSign) WriteInChainState(state interfaces.ChainStateOperation) error {
+
+	if act.belong_trs_v3 == nil {
+		panic("Action belong to transaction not be nil !")
+	}
+
+	if !sys.IsAggregatedSignActive(state.GetPendingBlockHeight()) {
+		return fmt.Errorf("Aggregated sign not active at block height %d.", state.GetPendingBlockHeight())
+	}
+
+	// Check the aggregated signature of both parties
+	e := act.CheckMustAddressAndSign()
+	if e != nil {
+		return e
+	}
+
+	// Create the lending
+	act.Lending.SetBelongTrs(act.belong_trs_v3)
+	return act.Lending.WriteInChainState(state)
+}
+
+func (act *Action_34_UsersLendingCreateByAggregatedSign) WriteinChainState(state interfacev2.ChainStateOperation) error {
+
+	panic("WriteinChainState in Action_34_UsersLendingCreateByAggregatedSign be deprecated")
+}
+
+func (act *Action_34_UsersLendingCreateByAggregatedSign) RecoverChainState(state interfacev2.ChainStateOperation) error {
+
+	panic("RecoverChainState be deprecated")
+}
+
+func (elm *Action_34_UsersLendingCreateByAggregatedSign) SetBelongTransaction(t interfacev2.Transaction) {
+	elm.belong_trs = t
+}
+
+func (elm *Action_34_UsersLendingCreateByAggregatedSign) SetBelongTrs(t interfaces.Transaction) {
+	elm.belong_trs_v3 = t
+}
+
+// burning fees
+func (act *Action_34_UsersLendingCreateByAggregatedSign) IsBurning90PersentTxFees() bool {
+	return false
+}
@@ -0,0 +1,181 @@
+package audit
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/hacash/core/actions"
+	"github.com/hacash/core/blocks"
+	"github.com/hacash/core/coinbase"
+	"github.com/hacash/core/interfaces"
+	"github.com/hacash/core/stores"
+)
+
+/**
+ * Replay the blocks on an immutable state and audit the supply at the end of each block range
+ * The replay also recomputes the flows from the blocks:
+ * block reward by the reward schedule, burning fee by the fee of each tx, bitcoin transfer by the move actions
+ * Channel interest and the unlock flow come out of the action math only, they stay unchecked
+ */
+
+// Flow counters recomputed by the replay
+var replayFlowCounters = []uint8{
+	stores.TotalSupplyStoreTypeOfTransferBitcoin,
+	stores.TotalSupplyStoreTypeOfBlockReward,
+	stores.TotalSupplyStoreTypeOfBurningFee,
+}
+
+type ReplayState interface {
+	interfaces.ChainStateImmutable
+	SupplyState
+}
+
+type SupplyReplayer struct {
+	state     ReplayState
+	rangeSize uint64
+
+	flows      [supplyCounterCount]float64
+	rangeStart uint64
+}
+
+// The flows start from the counters stored in the base state, range size 0 means one report at finish
+func NewSupplyReplayer(base ReplayState, rangeSize uint64) (*SupplyReplayer, error) {
+	stored, e := base.ReadTotalSupply()
+	if e != nil {
+		return nil, e
+	}
+	r := &SupplyReplayer{
+		state:      base,
+		rangeSize:  rangeSize,
+		rangeStart: base.GetPendingBlockHeight() + 1,
+	}
+	for _, ty := range replayFlowCounters {
+		r.flows[ty] = stored.Get(ty)
+	}
+	return r, nil
+}
+
+func (r *SupplyReplayer) State() ReplayState {
+	return r.state
+}
+
+// Count the flows of the block
+func (r *SupplyReplayer) countFlows(block interfaces.Block) error {
+	burn := big.NewInt(0)
+	for _, tx := range block.GetTrsList() {
+		if tx.Type() == 0 {
+			r.flows[stores.TotalSupplyStoreTypeOfBlockReward] += coinbase.BlockCoinBaseReward(block.GetHeight()).ToMei()
+			continue
+		}
+		burn.Add(burn, tx.GetFee().GetValue())
+		burn.Sub(burn, tx.GetFeeOfMinerRealReceived().GetValue())
+		for _, act := range tx.GetActionList() {
+			if a, ok := act.(*actions.Action_7_SatoshiGenesis); ok {
+				r.flows[stores.TotalSupplyStoreTypeOfTransferBitcoin] += float64(a.BitcoinQuantity)
+			}
+		}
+	}
+	if burn.Sign() < 0 {
+		return fmt.Errorf("block %d miner received fee more than paid.", block.GetHeight())
+	}
+	r.flows[stores.TotalSupplyStoreTypeOfBurningFee] += meiOf(burn)
+	return nil
+}
+
+func (r *SupplyReplayer) report() (*SupplyReport, error) {
+	computed, e := ScanStateSupply(r.state)
+	if e != nil {
+		return nil, e
+	}
+	for _, ty := range replayFlowCounters {
+		computed.set(ty, r.flows[ty])
+	}
+	stored, e := r.state.ReadTotalSupply()
+	if e != nil {
+		return nil, e
+	}
+	end := r.state.GetPendingBlockHeight()
+	report := newSupplyReport(r.rangeStart, end, stored, computed)
+	r.rangeStart = end + 1
+	return report, nil
+}
+
+// Execute the next block, return the report if the block ends a range
+func (r *SupplyReplayer) ApplyBlock(block interfaces.Block) (*SupplyReport, error) {
+	height := block.GetHeight()
+	if height != r.state.GetPendingBlockHeight()+1 {
+		return nil, fmt.Errorf("block height %d not continuous with the replay height %d.", height, r.state.GetPendingBlockHeight())
+	}
+	if prev := r.state.GetPendingBlockHash(); prev != nil && prev.NotZeroBlank() && !block.GetPrevHash().Equal(prev) {
+		return nil, fmt.Errorf("block %d prev hash <%s> not the replay tip <%s>.", height, block.GetPrevHash().ToHex(), prev.ToHex())
+	}
+	fork, e := r.state.ForkNextBlock(height, block.Hash(), block)
+	if e != nil {
+		return nil, e
+	}
+	if e := block.WriteInChainState(fork); e != nil {
+		fork.Destory()
+		return nil, e
+	}
+	immutable, e := fork.ImmutableWriteToDisk()
+	if e != nil {
+		return nil, e
+	}
+	state, ok := immutable.(ReplayState)
+	if !ok {
+		return nil, fmt.Errorf("immutable state type not support replay.")
+	}
+	r.state = state
+	if e := r.countFlows(block); e != nil {
+		return nil, e
+	}
+	if r.rangeSize > 0 && (height-r.rangeStart+1) >= r.rangeSize {
+		return r.report()
+	}
+	return nil, nil
+}
+
+// Report of the blocks after the last range, nil if none
+func (r *SupplyReplayer) Finish() (*SupplyReport, error) {
+	if r.rangeStart > r.state.GetPendingBlockHeight() {
+		return nil, nil
+	}
+	return r.report()
+}
+
+// Replay the stored blocks after the base state up to the end height
+func ReplaySupplyAudit(base ReplayState, store interfaces.BlockStoreRead, endHeight uint64, rangeSize uint64) ([]*SupplyReport, error) {
+	r, e := NewSupplyReplayer(base, rangeSize)
+	if e != nil {
+		return nil, e
+	}
+	reports := make([]*SupplyReport, 0)
+	for h := base.GetPendingBlockHeight() + 1; h <= endHeight; h++ {
+		_, body, e := store.ReadBlockBytesByHeight(h)
+		if e != nil {
+			return reports, e
+		}
+		if body == nil {
+			return reports, fmt.Errorf("block %d not find in the store.", h)
+		}
+		block, _, e := blocks.ParseBlock(body, 0)
+		if e != nil {
+			return reports, e
+		}
+		report, e := r.ApplyBlock(block)
+		if e != nil {
+			return reports, e
+		}
+		if report != nil {
+			reports = append(reports, report)
+		}
+	}
+	last, e := r.Finish()
+	if e != nil {
+		return reports, e
+	}
+	if last != nil {
+		reports = append(reports, last)
+	}
+	return reports, nil
+}
@@ -0,0 +1,303 @@
+package audit
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/hacash/core/chainstate"
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/stores"
+)
+
+/**
+ * Total supply audit
+ * Recompute the counters of stores.TotalSupply from the state items and the blocks, then compare with the stored
+ * The stored counters are float64 in mei, so a tiny difference from the summing order is not drift
+ */
+
+const supplyCounterCount = 20
+
+var SupplyCounterNames = [supplyCounterCount]string{
+	"diamond",
+	"transfer_bitcoin",
+	"block_reward",
+	"channel_interest",
+	"bitcoin_transfer_unlock",
+	"channel_locked_hac",
+	"channel_locked_sat",
+	"channel_opening",
+	"burning_fee",
+	"diamond_lending_mortgage_count",
+	"diamond_lending_loan_hac",
+	"diamond_lending_ransom_hac",
+	"bitcoin_lending_mortgage_portion",
+	"bitcoin_lending_burning_interest_hac",
+	"bitcoin_lending_loan_hac",
+	"bitcoin_lending_ransom_hac",
+	"user_lending_diamond",
+	"user_lending_bitcoin",
+	"user_lending_loan_hac",
+	"user_lending_burning_interest_hac",
+}
+
+// Allowed float difference in mei or count
+const (
+	supplyDriftAbsolute = 0.000001
+	supplyDriftRelative = 0.000000001
+)
+
+type SupplyCounter struct {
+	Type     uint8
+	Name     string
+	Stored   float64
+	Computed float64
+	Checked  bool // false if it cannot be recomputed, such as the flows on a state scan
+}
+
+// Stored minus computed
+func (c *SupplyCounter) Drift() float64 {
+	return c.Stored - c.Computed
+}
+
+func (c *SupplyCounter) IsDrift() bool {
+	if !c.Checked {
+		return false
+	}
+	return math.Abs(c.Drift()) > supplyDriftAbsolute+supplyDriftRelative*math.Abs(c.Stored)
+}
+
+type SupplyReport struct {
+	StartHeight uint64 // first block of the range, equal to end height on a state scan
+	EndHeight   uint64
+	Counters    []*SupplyCounter
+
+	CirculatingHacash float64 // mei in balances, open channels and lockbls
+}
+
+func (r *SupplyReport) Drifts() []*SupplyCounter {
+	res := make([]*SupplyCounter, 0)
+	for _, c := range r.Counters {
+		if c.IsDrift() {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+func (r *SupplyReport) IsClean() bool {
+	return len(r.Drifts()) == 0
+}
+
+func (r *SupplyReport) String() string {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "total supply audit of block %d ~ %d, circulating %f HAC\n", r.StartHeight, r.EndHeight, r.CirculatingHacash)
+	for _, c := range r.Counters {
+		mark := "ok"
+		if !c.Checked {
+			mark = "-"
+		} else if c.IsDrift() {
+			mark = "DRIFT"
+		}
+		fmt.Fprintf(buf, "%2d %-38s stored %f computed %f %s\n", c.Type, c.Name, c.Stored, c.Computed, mark)
+	}
+	return buf.String()
+}
+
+/**************************** computed ****************************/
+
+// Counters recomputed from first principles
+type ComputedSupply struct {
+	values  [supplyCounterCount]float64
+	checked [supplyCounterCount]bool
+
+	CirculatingHacash float64
+}
+
+func (c *ComputedSupply) Get(ty uint8) (float64, bool) {
+	if int(ty) >= supplyCounterCount {
+		return 0, false
+	}
+	return c.values[ty], c.checked[ty]
+}
+
+func (c *ComputedSupply) set(ty uint8, value float64) {
+	c.values[ty] = value
+	c.checked[ty] = true
+}
+
+func meiOf(num *big.Int) float64 {
+	amt, e := fields.NewAmountByBigInt(new(big.Int).Set(num))
+	if e != nil {
+		f, _ := new(big.Float).Quo(new(big.Float).SetInt(num), new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(248), nil))).Float64()
+		return f
+	}
+	return amt.ToMei()
+}
+
+func addAmount(sum *big.Int, amt *fields.Amount) {
+	sum.Add(sum, amt.GetValue())
+}
+
+// Recompute the counters kept by the state items
+// The flows of block reward, burning fee, bitcoin transfer, channel interest and unlock need a replay
+func ScanStateSupply(state chainstate.StateIterator) (*ComputedSupply, error) {
+	res := &ComputedSupply{}
+	circulating := big.NewInt(0)
+
+	// balances
+	e := state.IterateBalances(func(addr fields.Address, bls *stores.Balance) bool {
+		addAmount(circulating, &bls.Hacash)
+		return true
+	})
+	if e != nil {
+		return nil, e
+	}
+
+	// diamonds are never deleted, so the count is the latest number
+	var diamonds uint64 = 0
+	e = state.IterateDiamonds(func(name fields.DiamondName, dia *stores.Diamond) bool {
+		diamonds++
+		return true
+	})
+	if e != nil {
+		return nil, e
+	}
+	res.set(stores.TotalSupplyStoreTypeOfDiamond, float64(diamonds))
+
+	// channels
+	chanhac := big.NewInt(0)
+	var chansat, opening uint64 = 0, 0
+	e = state.IterateChannels(func(id fields.ChannelId, paychan *stores.Channel) bool {
+		if paychan.IsClosed() {
+			return true
+		}
+		opening++
+		addAmount(chanhac, &paychan.LeftAmount)
+		addAmount(chanhac, &paychan.RightAmount)
+		chansat += uint64(paychan.LeftSatoshi.GetRealSatoshi() + paychan.RightSatoshi.GetRealSatoshi())
+		return true
+	})
+	if e != nil {
+		return nil, e
+	}
+	circulating.Add(circulating, chanhac)
+	res.set(stores.TotalSupplyStoreTypeOfLocatedHACInChannel, meiOf(chanhac))
+	res.set(stores.TotalSupplyStoreTypeOfLocatedSATInChannel, float64(chansat))
+	res.set(stores.TotalSupplyStoreTypeOfChannelOfOpening, float64(opening))
+
+	// lockbls
+	e = state.IterateLockbls(func(id fields.LockblsId, lock *stores.Lockbls) bool {
+		addAmount(circulating, &lock.BalanceAmount)
+		return true
+	})
+	if e != nil {
+		return nil, e
+	}
+
+	// diamond system lending
+	var diamort uint64 = 0
+	var dialoan float64 = 0
+	diaransom := big.NewInt(0)
+	e = state.IterateDiamondSystemLendings(func(id fields.DiamondSyslendId, lend *stores.DiamondSystemLending) bool {
+		dialoan += float64(lend.LoanTotalAmountMei)
+		if lend.IsRansomed.Check() {
+			addAmount(diaransom, &lend.RansomAmount)
+		} else {
+			diamort += uint64(lend.MortgageDiamondList.Count)
+		}
+		return true
+	})
+	if e != nil {
+		return nil, e
+	}
+	res.set(stores.TotalSupplyStoreTypeOfSystemLendingDiamondCurrentMortgageCount, float64(diamort))
+	res.set(stores.TotalSupplyStoreTypeOfSystemLendingDiamondCumulationLoanHacAmount, dialoan)
+	res.set(stores.TotalSupplyStoreTypeOfSystemLendingDiamondCumulationRansomHacAmount, meiOf(diaransom))
+
+	// bitcoin system lending
+	var btcmort uint64 = 0
+	btcinterest, btcloan, btcransom := big.NewInt(0), big.NewInt(0), big.NewInt(0)
+	e = state.IterateBitcoinSystemLendings(func(id fields.BitcoinSyslendId, lend *stores.BitcoinSystemLending) bool {
+		addAmount(btcinterest, &lend.PreBurningInterestAmount)
+		addAmount(btcloan, &lend.LoanTotalAmount)
+		if lend.IsRansomed.Check() {
+			addAmount(btcransom, &lend.RansomAmount)
+		} else {
+			btcmort += uint64(lend.MortgageBitcoinPortion)
+		}
+		return true
+	})
+	if e != nil {
+		return nil, e
+	}
+	res.set(stores.TotalSupplyStoreTypeOfSystemLendingBitcoinPortionCurrentMortgageCount, float64(btcmort))
+	res.set(stores.TotalSupplyStoreTypeOfSystemLendingBitcoinPortionBurningInterestHacAmount, meiOf(btcinterest))
+	res.set(stores.TotalSupplyStoreTypeOfSystemLendingBitcoinPortionCumulationLoanHacAmount, meiOf(btcloan))
+	res.set(stores.TotalSupplyStoreTypeOfSystemLendingBitcoinPortionCumulationRansomHacAmount, meiOf(btcransom))
+
+	// user lending, all flows are counted at creation
+	var userdia, usersat uint64 = 0, 0
+	userloan, userinterest := big.NewInt(0), big.NewInt(0)
+	e = state.IterateUserLendings(func(id fields.UserLendingId, lend *stores.UserLending) bool {
+		userdia += uint64(lend.MortgageDiamondList.Count)
+		if lend.MortgageBitcoin.NotEmpty.Check() {
+			usersat += uint64(lend.MortgageBitcoin.ValueSAT)
+		}
+		addAmount(userloan, &lend.LoanTotalAmount)
+		addAmount(userinterest, &lend.PreBurningInterestAmount)
+		return true
+	})
+	if e != nil {
+		return nil, e
+	}
+	res.set(stores.TotalSupplyStoreTypeOfUsersLendingCumulationDiamond, float64(userdia))
+	res.set(stores.TotalSupplyStoreTypeOfUsersLendingCumulationBitcoin, float64(usersat))
+	res.set(stores.TotalSupplyStoreTypeOfUsersLendingCumulationHacAmount, meiOf(userloan))
+	res.set(stores.TotalSupplyStoreTypeOfUsersLendingBurningOnePercentInterestHacAmount, meiOf(userinterest))
+
+	res.CirculatingHacash = meiOf(circulating)
+	return res, nil
+}
+
+func newSupplyReport(start, end uint64, stored *stores.TotalSupply, computed *ComputedSupply) *SupplyReport {
+	report := &SupplyReport{
+		StartHeight:       start,
+		EndHeight:         end,
+		Counters:          make([]*SupplyCounter, supplyCounterCount),
+		CirculatingHacash: computed.CirculatingHacash,
+	}
+	for i := 0; i < supplyCounterCount; i++ {
+		ty := uint8(i)
+		value, checked := computed.Get(ty)
+		report.Counters[i] = &SupplyCounter{
+			Type:     ty,
+			Name:     SupplyCounterNames[i],
+			Stored:   stored.Get(ty),
+			Computed: value,
+			Checked:  checked,
+		}
+	}
+	return report
+}
+
+// State type the audit can scan and read the stored counters from
+type SupplyState interface {
+	chainstate.StateIterator
+	ReadTotalSupply() (*stores.TotalSupply, error)
+}
+
+// Scan the state and compare the counters it keeps
+func AuditStateSupply(state SupplyState) (*SupplyReport, error) {
+	computed, e := ScanStateSupply(state)
+	if e != nil {
+		return nil, e
+	}
+	stored, e := state.ReadTotalSupply()
+	if e != nil {
+		return nil, e
+	}
+	height := state.GetPendingBlockHeight()
+	return newSupplyReport(height, height, stored, computed), nil
+}
@@ -0,0 +1,267 @@
+package blocks
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hacash/core/actions"
+	"github.com/hacash/core/coinbase"
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/interfaces"
+	"github.com/hacash/core/transactions"
+)
+
+const (
+	// Diamond error mark, means put the tx back to the pool and wait for next block
+	BackToPoolErrorMark = "{BACKTOPOOL}"
+
+	// Coinbase extend data version change to 1 from this height
+	CoinbaseExtendDataVersion1BlockHeight = coinbase.ExtendDataVersion1BlockHeight
+)
+
+// State with the state tree, see chainstate.MemoryChainState
+type stateRootReader interface {
+	StateRoot() (fields.Hash, error)
+}
+
+// Transaction not packed into the block
+type ExcludedTransaction struct {
+	Tx         interfaces.Transaction
+	Reason     error
+	BackToPool bool // Keep in pool and try again in later blocks
+}
+
+// Result of block assembly
+type AssembleResult struct {
+	Block    interfaces.Block
+	Excluded []*ExcludedTransaction
+	TxsSize  uint32 // Total size of packed customer transactions
+
+	TotalFeeUserPayed     *fields.Amount
+	TotalFeeMinerReceived *fields.Amount
+}
+
+// Drop transactions, not include back to pool ones
+func (r *AssembleResult) RemoveTxs() []interfaces.Transaction {
+	txs := make([]interfaces.Transaction, 0)
+	for _, v := range r.Excluded {
+		if !v.BackToPool {
+			txs = append(txs, v.Tx)
+		}
+	}
+	return txs
+}
+
+// Create next block template from pool transactions
+type BlockAssembler struct {
+	baseState interfaces.ChainState // state after prev block
+	prevBlock interfaces.BlockHeadMetaRead
+
+	CoinbaseAddress fields.Address
+	CoinbaseMessage string
+	RewardFunc      func(height uint64) *fields.Amount // Block reward of height, nil is the canonical reward
+
+	MaxTxCount uint32 // 0 is no limit
+	MaxTxsSize uint32 // 0 is no limit
+
+	NowTimestamp func() uint64
+}
+
+func NewBlockAssembler(base interfaces.ChainState, prev interfaces.BlockHeadMetaRead, rewardaddr fields.Address, rewardfunc func(uint64) *fields.Amount) *BlockAssembler {
+	return &BlockAssembler{
+		baseState:       base,
+		prevBlock:       prev,
+		CoinbaseAddress: rewardaddr,
+		CoinbaseMessage: "",
+		RewardFunc:      rewardfunc,
+		MaxTxCount:      0,
+		MaxTxsSize:      0,
+		NowTimestamp: func() uint64 {
+			return uint64(time.Now().Unix())
+		},
+	}
+}
+
+// Implement interfaces.BlockChain
+// Return the new block, the invalid txs should remove from pool and the total txs size
+func (a *BlockAssembler) CreateNextBlockByValidateTxs(txs []interfaces.Transaction) (interfaces.Block, []interfaces.Transaction, uint32, error) {
+	res, e := a.Assemble(txs)
+	if e != nil {
+		return nil, nil, 0, e
+	}
+	return res.Block, res.RemoveTxs(), res.TxsSize, nil
+}
+
+func isDiamondCreateTx(tx interfaces.Transaction) bool {
+	for _, act := range tx.GetActionList() {
+		if _, ok := act.(*actions.Action_4_DiamondCreate); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *BlockAssembler) newCoinbase(height uint64) *transactions.Transaction_0_Coinbase {
+	var cbtx *transactions.Transaction_0_Coinbase
+	if height >= CoinbaseExtendDataVersion1BlockHeight {
+		cbtx = transactions.NewTransaction_0_CoinbaseV1()
+	} else {
+		cbtx = transactions.NewTransaction_0_CoinbaseV0()
+	}
+	cbtx.Address = a.CoinbaseAddress
+	cbtx.Message = fields.TrimString16(a.CoinbaseMessage)
+	if a.RewardFunc != nil {
+		cbtx.Reward = *a.RewardFunc(height)
+	} else {
+		cbtx.Reward = *coinbase.BlockCoinBaseReward(height)
+	}
+	return cbtx
+}
+
+// Pack transactions in fee purity order
+func (a *BlockAssembler) Assemble(txs []interfaces.Transaction) (*AssembleResult, error) {
+	if a.prevBlock == nil {
+		return nil, fmt.Errorf("prev block cannot be nil")
+	}
+	if a.CoinbaseAddress == nil || !a.CoinbaseAddress.IsValid() {
+		return nil, fmt.Errorf("coinbase address is invalid")
+	}
+	block := NewEmptyBlockByPrev(a.prevBlock)
+	head := blockHeadFields(block)
+	height := block.GetHeight()
+	timestamp := a.NowTimestamp()
+	if timestamp <= a.prevBlock.GetTimestamp() {
+		timestamp = a.prevBlock.GetTimestamp() + 1
+	}
+	head.Timestamp = fields.BlockTxTimestamp(timestamp)
+
+	// Mining does not know the final block hash
+	blockstate, e := a.baseState.ForkNextBlock(height, nil, block)
+	if e != nil {
+		return nil, e
+	}
+	defer blockstate.Destory()
+
+	ordered := make([]interfaces.Transaction, len(txs))
+	copy(ordered, txs)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].FeePurity() > ordered[j].FeePurity()
+	})
+
+	result := &AssembleResult{
+		Excluded:              make([]*ExcludedTransaction, 0),
+		TotalFeeUserPayed:     fields.NewEmptyAmount(),
+		TotalFeeMinerReceived: fields.NewEmptyAmount(),
+	}
+	exclude := func(tx interfaces.Transaction, backtopool bool, reason error) {
+		result.Excluded = append(result.Excluded, &ExcludedTransaction{tx, reason, backtopool})
+	}
+	packed := make([]interfaces.Transaction, 0)
+	packedhx := make(map[string]bool)
+	hasDiamond := false
+	for _, tx := range ordered {
+		if tx.Type() == 0 {
+			exclude(tx, false, fmt.Errorf("coinbase tx cannot be packed"))
+			continue
+		}
+		txhx := tx.Hash()
+		if packedhx[string(txhx)] {
+			exclude(tx, false, fmt.Errorf("tx <%s> is duplicate in block", txhx.ToHex()))
+			continue
+		}
+		txsize := tx.Size()
+		if a.MaxTxCount > 0 && uint32(len(packed)) >= a.MaxTxCount {
+			exclude(tx, true, fmt.Errorf("block tx count limit %d", a.MaxTxCount))
+			continue
+		}
+		if a.MaxTxsSize > 0 && result.TxsSize+txsize > a.MaxTxsSize {
+			exclude(tx, true, fmt.Errorf("block txs size limit %d", a.MaxTxsSize))
+			continue
+		}
+		isdiamond := isDiamondCreateTx(tx)
+		if isdiamond {
+			if height%5 != 0 {
+				exclude(tx, true, fmt.Errorf("%s Diamond must be in block height multiple of 5.", BackToPoolErrorMark))
+				continue
+			}
+			if hasDiamond {
+				exclude(tx, true, fmt.Errorf("%s Block height %d already has a diamond.", BackToPoolErrorMark, height))
+				continue
+			}
+		}
+		ishav, e := blockstate.CheckTxHash(txhx)
+		if e != nil {
+			return nil, e
+		}
+		if ishav {
+			exclude(tx, false, fmt.Errorf("tx <%s> is exist", txhx.ToHex()))
+			continue
+		}
+		// try execute
+		txstate, e := blockstate.ForkSubChild()
+		if e != nil {
+			return nil, e
+		}
+		e = txstate.ContainTxHash(txhx, fields.BlockHeight(height))
+		if e == nil {
+			e = tx.WriteInChainState(txstate)
+		}
+		if e != nil {
+			txstate.Destory()
+			exclude(tx, strings.Contains(e.Error(), BackToPoolErrorMark), e)
+			continue
+		}
+		e = blockstate.TraversalCopy(txstate)
+		txstate.Destory()
+		if e != nil {
+			return nil, e
+		}
+		// fee
+		result.TotalFeeUserPayed, e = result.TotalFeeUserPayed.Add(tx.GetFee())
+		if e != nil {
+			return nil, e
+		}
+		result.TotalFeeMinerReceived, e = result.TotalFeeMinerReceived.Add(tx.GetFeeOfMinerRealReceived())
+		if e != nil {
+			return nil, e
+		}
+		packed = append(packed, tx)
+		packedhx[string(txhx)] = true
+		result.TxsSize += txsize
+		if isdiamond {
+			hasDiamond = true
+		}
+	}
+
+	// coinbase
+	cbtx := a.newCoinbase(height)
+	cbtx.TotalFeeUserPayed = *result.TotalFeeUserPayed
+	cbtx.TotalFeeMinerReceived = *result.TotalFeeMinerReceived
+	e = coinbase.CheckCoinbase(cbtx, height)
+	if e != nil {
+		return nil, e
+	}
+	trslist := append([]interfaces.Transaction{cbtx}, packed...)
+	block.SetTrsList(trslist)
+	head.TransactionCount = fields.VarUint4(len(trslist))
+	block.SetMrklRoot(CalculateMrklRoot(trslist))
+	// commit the state after the block
+	if b2, ok := block.(*Block_v2); ok {
+		if reader, ok := blockstate.(stateRootReader); ok {
+			if e := cbtx.WriteInChainState(blockstate); e != nil {
+				return nil, e
+			}
+			root, e := reader.StateRoot()
+			if e != nil {
+				return nil, e
+			}
+			if e := b2.Extension.SetStateRoot(root); e != nil {
+				return nil, e
+			}
+		}
+	}
+	result.Block = block
+	return result, nil
+}
@@ -28,14 +28,16 @@ func NewBlockByVersion(ty uint8) (interfaces.Block, error) {
 	////////////////////  BLOCK  ////////////////////
 	case 1:
 		return new(Block_v1), nil
+	case 2:
+		return new(Block_v2), nil
 		////////////////////   END   ////////////////////
 	}
 	return nil, fmt.Errorf("Cannot find Block type of " + string(ty))
 }
 
 func ParseBlock(buf []byte, seek uint32) (interfaces.Block, uint32, error) {
-	if len(buf) < 1 {
-		return nil, 0, fmt.Errorf("ParseBlock: buf too short")
+	if int(seek)+1 > len(buf) {
+		return nil, 0, fmt.Errorf("ParseBlock: %w", fields.ErrSeekOutOfBuf)
 	}
 	version := uint8(buf[seek])
 	var blk, e = NewBlockByVersion(version)
@@ -48,7 +50,7 @@ func ParseBlock(buf []byte, seek uint32) (interfaces.Block, uint32, error) {
 
 func ParseBlockHead(buf []byte, seek uint32) (interfaces.Block, uint32, error) {
 	if int(seek)+1 > len(buf) {
-		return nil, 0, fmt.Errorf("ParseBlockHead: buf too short")
+		return nil, 0, fmt.Errorf("ParseBlockHead: %w", fields.ErrSeekOutOfBuf)
 	}
 	version := uint8(buf[seek])
 	var blk, ee = NewBlockByVersion(version)
@@ -60,8 +62,8 @@ func ParseBlockHead(buf []byte, seek uint32) (interfaces.Block, uint32, error) {
 	return blk, mv, err
 }
 func ParseExcludeTransactions(buf []byte, seek uint32) (interfaces.Block, uint32, error) {
-	if buf == nil || len(buf) < 1 {
-		return nil, 0, fmt.Errorf("buf is too short")
+	if int(seek)+1 > len(buf) {
+		return nil, 0, fmt.Errorf("buf is too short, %w", fields.ErrSeekOutOfBuf)
 	}
 	version := uint8(buf[seek])
 	var blk, ee = NewBlockByVersion(version)
@@ -73,6 +75,14 @@ func ParseExcludeTransactions(buf []byte, seek uint32) (interfaces.Block, uint32
 	return blk, mv, err
 }
 
+// Size of head and meta, version 2 head has the extension
+func blockHeadMetaSize(block interfaces.Block) uint32 {
+	if b2, ok := block.(*Block_v2); ok {
+		return BlockHeadSize + b2.Extension.Size() + BlockMetaSizeV1
+	}
+	return BlockHeadSize + BlockMetaSizeV1
+}
+
 //////////////////////////////////
 
 func CalculateBlockHash(block interfaces.Block) fields.Hash {
@@ -1,9 +1,17 @@
 package blocks
 
 import (
+	"bytes"
+	"encoding/hex"
 	"fmt"
+	"github.com/hacash/core/account"
+	"github.com/hacash/core/actions"
+	"github.com/hacash/core/chainstate"
+	"github.com/hacash/core/coinbase"
 	"github.com/hacash/core/fields"
 	"github.com/hacash/core/interfaces"
+	"github.com/hacash/core/stores"
+	"github.com/hacash/core/sys"
 	"github.com/hacash/core/transactions"
 	"testing"
 )
@@ -26,3 +34,332 @@ func Test1(t *testing.T) {
 	}
 
 }
+
+func Test_assemble_next_block(t *testing.T) {
+
+	acc1 := account.CreateAccountByPassword("123456")
+	acc2 := account.CreateAccountByPassword("qwerty")
+	miner := account.CreateAccountByPassword("miner")
+
+	prev := NewEmptyBlockV1()
+	prev.Height = 300000
+	base := chainstate.NewMemoryChainStateImmutable(nil)
+	state, _ := base.ForkNextBlock(300000, prev.Hash(), prev)
+	state.BalanceSet(acc1.Address, stores.NewBalanceWithAmount(fields.NewAmountSmall(10, 248)))
+
+	newtx := func(fee uint8, amt uint8, ts uint64) interfaces.Transaction {
+		tx, _ := transactions.NewEmptyTransaction_2_Simple(acc1.Address)
+		tx.Timestamp = fields.BlockTxTimestamp(ts)
+		tx.Fee = *fields.NewAmountSmall(fee, 244)
+		tx.AppendAction(actions.NewAction_1_SimpleToTransfer(acc2.Address, fields.NewAmountSmall(amt, 248)))
+		return tx
+	}
+	tx1 := newtx(1, 4, 1)
+	tx2 := newtx(9, 5, 2)
+	tx3 := newtx(5, 3, 3) // tx1 balance not enough after tx2 and tx3
+	txs := []interfaces.Transaction{tx1, tx2, tx3, tx2}
+
+	assembler := NewBlockAssembler(state, prev, acc1.Address, nil)
+	assembler.CoinbaseAddress = miner.Address
+	res, e := assembler.Assemble(txs)
+	if e != nil {
+		t.Fatal(e)
+	}
+	for _, v := range res.Excluded {
+		fmt.Println(v.Tx.Hash().ToHex(), v.BackToPool, v.Reason)
+	}
+	blk := res.Block
+	trs := blk.GetTrsList()
+	if blk.GetHeight() != 300001 || len(trs) != 3 || len(res.Excluded) != 2 {
+		t.Fatal("assemble txs error")
+	}
+	if !trs[1].Hash().Equal(tx2.Hash()) || !trs[2].Hash().Equal(tx3.Hash()) {
+		t.Fatal("txs must be in fee purity order")
+	}
+	if !blk.GetMrklRoot().Equal(CalculateMrklRoot(trs)) {
+		t.Fatal("mrkl root error")
+	}
+	coinbase := trs[0].(*transactions.Transaction_0_Coinbase)
+	if coinbase.TotalFeeMinerReceived.NotEqual(fields.NewAmountSmall(14, 244)) || coinbase.ExtendDataVersion != 1 {
+		t.Fatal("coinbase error")
+	}
+	// the block is valid
+	blkstate, _ := state.ForkNextBlock(blk.GetHeight(), blk.Hash(), blk)
+	e = blk.WriteInChainState(blkstate)
+	if e != nil {
+		t.Fatal(e)
+	}
+	// assembling never changes the base state
+	bls, _ := state.Balance(acc2.Address)
+	if bls != nil || len(state.GetChilds()) != 1 {
+		t.Fatal("base state changed")
+	}
+}
+
+func Test_compact_block(t *testing.T) {
+
+	acc1 := account.CreateAccountByPassword("123456")
+	acc2 := account.CreateAccountByPassword("qwerty")
+
+	txs := make([]interfaces.Transaction, 0)
+	for i := 1; i <= 4; i++ {
+		tx, _ := transactions.NewEmptyTransaction_2_Simple(acc1.Address)
+		tx.Timestamp = fields.BlockTxTimestamp(i)
+		tx.Fee = *fields.NewAmountSmall(uint8(i), 244)
+		tx.AppendAction(actions.NewAction_1_SimpleToTransfer(acc2.Address, fields.NewAmountSmall(1, 248)))
+		txs = append(txs, tx)
+	}
+	coinbase := transactions.NewTransaction_0_CoinbaseV0()
+	coinbase.Address = acc1.Address
+	coinbase.Reward = *fields.NewAmountSmall(1, 248)
+	block := NewEmptyBlockV1()
+	block.Height = 100
+	for _, tx := range append([]interfaces.Transaction{coinbase}, txs...) {
+		block.AddTrs(tx)
+	}
+	block.SetMrklRoot(CalculateMrklRoot(block.GetTrsList()))
+
+	compact, e := NewCompactBlock(block)
+	if e != nil {
+		t.Fatal(e)
+	}
+	cbts, _ := compact.Serialize()
+	fullbts, _ := block.Serialize()
+	fmt.Println("compact size", len(cbts), "full size", len(fullbts))
+	if uint32(len(cbts)) != compact.Size() {
+		t.Fatal("compact size error")
+	}
+	compact2 := &CompactBlock{}
+	_, e = compact2.Parse(cbts, 0)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	// pool misses tx 2
+	rebuild := compact2.ReconstructByTxs([]interfaces.Transaction{txs[3], txs[0], txs[1]})
+	if rebuild.IsComplete() {
+		t.Fatal("must missing tx")
+	}
+	req := rebuild.CreateMissingTxsRequest()
+	reqbts, _ := req.Serialize()
+	req2 := &CompactBlockTxsRequest{}
+	req2.Parse(reqbts, 0)
+	if len(req2.Indexes) != 1 || req2.Indexes[0] != 2 {
+		t.Fatal("missing index error")
+	}
+	resp, e := req2.CreateResponse(block)
+	if e != nil {
+		t.Fatal(e)
+	}
+	respbts, _ := resp.Serialize()
+	resp2 := &CompactBlockTxsResponse{}
+	resp2.Parse(respbts, 0)
+	e = rebuild.FillMissingTxs(resp2)
+	if e != nil {
+		t.Fatal(e)
+	}
+	newblock, e := rebuild.Block()
+	if e != nil {
+		t.Fatal(e)
+	}
+	newbts, _ := newblock.Serialize()
+	if !newblock.Hash().Equal(block.Hash()) || string(newbts) != string(fullbts) {
+		t.Fatal("rebuild block error")
+	}
+
+	// wrong tx never be accepted
+	rebuild = compact2.ReconstructByTxs(txs)
+	rebuild.txs[1] = txs[0]
+	_, e = rebuild.Block()
+	if e == nil {
+		t.Fatal("mrkl root must be checked")
+	}
+}
+
+func Test_mrkl_proof(t *testing.T) {
+
+	acc1 := account.CreateAccountByPassword("123456")
+
+	for count := 1; count <= 7; count++ {
+		coinbase := transactions.NewTransaction_0_CoinbaseV0()
+		coinbase.Address = acc1.Address
+		coinbase.Reward = *fields.NewAmountSmall(1, 248)
+		block := NewEmptyBlockV1()
+		block.Height = 100
+		block.AddTrs(coinbase)
+		for i := 1; i < count; i++ {
+			tx, _ := transactions.NewEmptyTransaction_2_Simple(acc1.Address)
+			tx.Timestamp = fields.BlockTxTimestamp(i)
+			block.AddTrs(tx)
+		}
+		block.SetMrklRoot(CalculateMrklRoot(block.GetTrsList()))
+		for idx := 0; idx < count; idx++ {
+			proof, e := CreateTransactionInclusionProof(block, uint32(idx))
+			if e != nil {
+				t.Fatal(e)
+			}
+			bts, _ := proof.Serialize()
+			if uint32(len(bts)) != proof.Size() {
+				t.Fatal("proof size error")
+			}
+			proof2 := &TransactionInclusionProof{}
+			_, e = proof2.Parse(bts, 0)
+			if e != nil {
+				t.Fatal(e)
+			}
+			if e = proof2.Verify(); e != nil {
+				t.Fatal(count, idx, e)
+			}
+			// wrong index must fail
+			if VerifyMrklBranch(proof2.TxHashWithFee, uint32(idx+1), uint32(count), proof2.Branch, block.GetMrklRoot()) {
+				t.Fatal(count, idx, "wrong index verify ok")
+			}
+		}
+	}
+	fmt.Println("mrkl proof ok")
+}
+
+func Test_block_version2_round_trip(t *testing.T) {
+
+	acc1 := account.CreateAccountByPassword("123456")
+	root := fields.CalculateHash([]byte("state root"))
+	commit := fields.CalculateHash([]byte("witness commit"))
+
+	newblock := func(version int, txnum int, extset func(*BlockHeadExtension)) interfaces.Block {
+		prev := NewEmptyBlockV1()
+		prev.Height = 1000
+		var block interfaces.Block
+		if version == 1 {
+			block = NewEmptyBlockVersion1(prev)
+		} else {
+			b2 := NewEmptyBlockVersion2(prev)
+			extset(&b2.Extension)
+			block = b2
+		}
+		head := blockHeadFields(block)
+		head.Timestamp = 1600000000
+		head.Nonce = 12345
+		head.Difficulty = 0xfc123456
+		head.WitnessStage = 3
+		coinbase := transactions.NewTransaction_0_CoinbaseV0()
+		coinbase.Address = acc1.Address
+		coinbase.Reward = *fields.NewAmountSmall(1, 248)
+		block.AddTrs(coinbase)
+		for i := 1; i < txnum; i++ {
+			tx, _ := transactions.NewEmptyTransaction_2_Simple(acc1.Address)
+			tx.Timestamp = fields.BlockTxTimestamp(i)
+			block.AddTrs(tx)
+		}
+		block.SetMrklRoot(CalculateMrklRoot(block.GetTrsList()))
+		return block
+	}
+	extsets := []func(*BlockHeadExtension){
+		func(ext *BlockHeadExtension) {},
+		func(ext *BlockHeadExtension) { ext.SetStateRoot(root) },
+		func(ext *BlockHeadExtension) {
+			ext.SetChainId(7)
+			ext.SetWitnessCommitment(commit)
+			ext.SetStateRoot(root)
+		},
+		func(ext *BlockHeadExtension) { ext.Set(200, []byte("unknown future field")) },
+	}
+	cases := make([]interfaces.Block, 0)
+	for _, txnum := range []int{1, 3} {
+		cases = append(cases, newblock(1, txnum, nil))
+		for _, extset := range extsets {
+			cases = append(cases, newblock(2, txnum, extset))
+		}
+	}
+	for n, block := range cases {
+		bts, e := block.Serialize()
+		if e != nil {
+			t.Fatal(e)
+		}
+		if uint32(len(bts)) != block.Size() {
+			t.Fatal(n, "size error", len(bts), block.Size())
+		}
+		// full
+		block2, seek, e := ParseBlock(bts, 0)
+		if e != nil || int(seek) != len(bts) || block2.Version() != block.Version() {
+			t.Fatal(n, "parse block error", e)
+		}
+		bts2, _ := block2.Serialize()
+		if !bytes.Equal(bts, bts2) || !block2.Hash().Equal(block.Hash()) {
+			t.Fatal(n, "block round trip error")
+		}
+		// head and meta
+		hmbts, _ := block.SerializeExcludeTransactions()
+		if uint32(len(hmbts)) != blockHeadMetaSize(block) {
+			t.Fatal(n, "head meta size error")
+		}
+		block3, seek, e := ParseExcludeTransactions(hmbts, 0)
+		if e != nil || int(seek) != len(hmbts) || !block3.Hash().Equal(block.Hash()) {
+			t.Fatal(n, "parse exclude transactions error", e)
+		}
+		// head only
+		hdbts, _ := block.SerializeHead()
+		block4, seek, e := ParseBlockHead(hdbts, 0)
+		if e != nil || int(seek) != len(hdbts) || !block4.GetMrklRoot().Equal(block.GetMrklRoot()) {
+			t.Fatal(n, "parse head error", e)
+		}
+		// copy
+		if !block.CopyForMining().Hash().Equal(block.Hash()) || !block.CopyHeadMetaForMining().Hash().Equal(block.Hash()) {
+			t.Fatal(n, "copy error")
+		}
+	}
+	// extension fields
+	b2 := cases[3].(*Block_v2)
+	cid, ok := b2.Extension.GetChainId()
+	if !b2.Extension.GetStateRoot().Equal(root) || !b2.Extension.GetWitnessCommitment().Equal(commit) || !ok || cid != 7 {
+		t.Fatal("extension fields error")
+	}
+	if cases[2].Hash().Equal(cases[1].Hash()) {
+		t.Fatal("extension must change block hash")
+	}
+
+	// bad extension
+	badexts := [][]byte{
+		{0, 4, 3, 1, 7, 1},       // out of buf
+		{0, 6, 3, 1, 7, 1, 1, 0}, // not in order
+		{0, 6, 1, 1, 7, 1, 1, 0}, // duplicate
+		{0, 3, 1, 5, 7},          // value out of size
+		{0xff, 0xff},             // too big
+	}
+	for i, ext := range badexts {
+		var bhe BlockHeadExtension
+		if _, e := bhe.Parse(ext, 0); e == nil {
+			t.Fatal(i, "bad extension must fail")
+		}
+	}
+
+	// activation
+	if CheckBlockVersionByHeight(1, 100) != nil || CheckBlockVersionByHeight(2, 100) == nil {
+		t.Fatal("version 2 must not be active")
+	}
+	sys.BlockVersion2ActiveHeight = 100
+	defer func() { sys.BlockVersion2ActiveHeight = 0 }()
+	if CheckBlockVersionByHeight(1, 100) == nil || CheckBlockVersionByHeight(2, 100) != nil || CheckBlockVersionByHeight(1, 99) != nil {
+		t.Fatal("version 2 must be active")
+	}
+	prev := NewEmptyBlockV1()
+	prev.Height = 99
+	if NewEmptyBlockByPrev(prev).Version() != 2 {
+		t.Fatal("next block must be version 2")
+	}
+}
+
+// Only the mainnet genesis coinbase is in the tree, the heights around 220000 need a synced node
+func Test_mainnet_coinbase(t *testing.T) {
+	body, _ := hex.DecodeString(fuzzGenesisBlockHex)
+	blk, _, e := ParseBlock(body, 0)
+	if e != nil {
+		t.Fatal(e)
+	}
+	cbtx, ok := blk.GetTrsList()[0].(*transactions.Transaction_0_Coinbase)
+	if !ok {
+		t.Fatal("genesis has no coinbase")
+	}
+	if e := coinbase.CheckCoinbase(cbtx, blk.GetHeight()); e != nil {
+		t.Fatal(e)
+	}
+}
@@ -0,0 +1,339 @@
+package blocks
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/interfaces"
+	"github.com/hacash/core/transactions"
+)
+
+/**
+ * Compact block relay
+ * Head, meta and coinbase, other transactions replaced by 8 bytes short id
+ */
+
+// Short id of transaction in the block, salted by block hash so collisions cannot be prepared in advance
+func CalculateCompactShortId(blockhash fields.Hash, txhashwithfee fields.Hash) fields.HashNonceChecker {
+	stuff := append(append([]byte{}, blockhash...), txhashwithfee...)
+	return fields.CalculateHash(stuff).GetNonceChecker()
+}
+
+type CompactBlock struct {
+	Block    interfaces.Block // head and meta only
+	Coinbase interfaces.Transaction
+	ShortIds []fields.HashNonceChecker // customer transactions in block order
+}
+
+func NewCompactBlock(block interfaces.Block) (*CompactBlock, error) {
+	trslist := block.GetTrsList()
+	if len(trslist) < 1 {
+		return nil, fmt.Errorf("not find coinbase tx")
+	}
+	blockhash := block.Hash()
+	compact := &CompactBlock{
+		Block:    block,
+		Coinbase: trslist[0],
+		ShortIds: make([]fields.HashNonceChecker, len(trslist)-1),
+	}
+	exists := make(map[string]bool)
+	for i, tx := range trslist[1:] {
+		sid := CalculateCompactShortId(blockhash, tx.HashWithFee())
+		if exists[string(sid)] {
+			// Must relay the full block
+			return nil, fmt.Errorf("short id <%s> collision in block %d.", sid.ToHex(), block.GetHeight())
+		}
+		exists[string(sid)] = true
+		compact.ShortIds[i] = sid
+	}
+	return compact, nil
+}
+
+func (c *CompactBlock) Size() uint32 {
+	return blockHeadMetaSize(c.Block) + c.Coinbase.Size() + 4 + uint32(len(c.ShortIds))*fields.HashNonceCheckerSize
+}
+
+func (c *CompactBlock) Serialize() ([]byte, error) {
+	var buffer = new(bytes.Buffer)
+	b1, e := c.Block.SerializeExcludeTransactions()
+	if e != nil {
+		return nil, e
+	}
+	b2, e := c.Coinbase.Serialize()
+	if e != nil {
+		return nil, e
+	}
+	b3, _ := fields.VarUint4(len(c.ShortIds)).Serialize()
+	buffer.Write(b1)
+	buffer.Write(b2)
+	buffer.Write(b3)
+	for _, sid := range c.ShortIds {
+		buffer.Write(sid)
+	}
+	return buffer.Bytes(), nil
+}
+
+func (c *CompactBlock) Parse(buf []byte, seek uint32) (uint32, error) {
+	var e error = nil
+	c.Block, seek, e = ParseExcludeTransactions(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	c.Coinbase, seek, e = transactions.ParseTransaction(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	if c.Coinbase.Type() != 0 {
+		return 0, fmt.Errorf("compact block transaction[0] not coinbase tx")
+	}
+	var count fields.VarUint4
+	seek, e = count.Parse(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	if uint32(count)+1 != c.Block.GetTransactionCount() {
+		return 0, fmt.Errorf("compact block short id count %d not match transaction count %d.", count, c.Block.GetTransactionCount())
+	}
+	if uint64(seek)+uint64(count)*fields.HashNonceCheckerSize > uint64(len(buf)) {
+		return 0, fmt.Errorf("[CompactBlock.Parse] %w", fields.ErrSeekOutOfBuf)
+	}
+	c.ShortIds = make([]fields.HashNonceChecker, int(count))
+	for i := 0; i < int(count); i++ {
+		seek, e = c.ShortIds[i].Parse(buf, seek)
+		if e != nil {
+			return 0, e
+		}
+	}
+	return seek, nil
+}
+
+// Rebuild the block from pool transactions
+func (c *CompactBlock) Reconstruct(txpool interfaces.TxPool) *CompactBlockReconstruction {
+	pooltxs := txpool.CopyTxsOrderByFeePurity(c.Block.GetHeight(), math.MaxUint32, math.MaxUint32)
+	return c.ReconstructByTxs(pooltxs)
+}
+
+// Rebuild the block from candidate transactions
+func (c *CompactBlock) ReconstructByTxs(candidates []interfaces.Transaction) *CompactBlockReconstruction {
+	blockhash := c.Block.Hash()
+	// A short id matched by two different txs is a collision, request it from peer
+	collisions := make(map[string]bool)
+	matchs := make(map[string]interfaces.Transaction)
+	for _, tx := range candidates {
+		hxfee := tx.HashWithFee()
+		sid := string(CalculateCompactShortId(blockhash, hxfee))
+		if have, ok := matchs[sid]; ok {
+			if !have.HashWithFee().Equal(hxfee) {
+				collisions[sid] = true
+			}
+			continue
+		}
+		matchs[sid] = tx
+	}
+	rebuild := &CompactBlockReconstruction{
+		compact: c,
+		txs:     make([]interfaces.Transaction, len(c.ShortIds)),
+		missing: make([]uint32, 0),
+	}
+	for i, sid := range c.ShortIds {
+		tx, ok := matchs[string(sid)]
+		if !ok || collisions[string(sid)] {
+			rebuild.missing = append(rebuild.missing, uint32(i))
+			continue
+		}
+		rebuild.txs[i] = tx
+	}
+	return rebuild
+}
+
+// Block being rebuilt, some transactions may be missing
+type CompactBlockReconstruction struct {
+	compact *CompactBlock
+	txs     []interfaces.Transaction
+	missing []uint32 // index of customer transactions
+}
+
+func (r *CompactBlockReconstruction) IsComplete() bool {
+	return len(r.missing) == 0
+}
+
+// Request message for the missing transactions
+func (r *CompactBlockReconstruction) CreateMissingTxsRequest() *CompactBlockTxsRequest {
+	req := &CompactBlockTxsRequest{
+		BlockHash: r.compact.Block.Hash(),
+		Count:     fields.VarUint4(len(r.missing)),
+		Indexes:   make([]fields.VarUint4, len(r.missing)),
+	}
+	for i, idx := range r.missing {
+		req.Indexes[i] = fields.VarUint4(idx)
+	}
+	return req
+}
+
+// Fill the missing transactions by the response of peer
+func (r *CompactBlockReconstruction) FillMissingTxs(resp *CompactBlockTxsResponse) error {
+	if !resp.BlockHash.Equal(r.compact.Block.Hash()) {
+		return fmt.Errorf("compact block txs response block hash not match.")
+	}
+	if len(resp.Txs) != len(r.missing) {
+		return fmt.Errorf("compact block txs response need %d txs but got %d.", len(r.missing), len(resp.Txs))
+	}
+	blockhash := r.compact.Block.Hash()
+	for i, idx := range r.missing {
+		tx := resp.Txs[i]
+		sid := CalculateCompactShortId(blockhash, tx.HashWithFee())
+		if !sid.Equal(r.compact.ShortIds[idx]) {
+			return fmt.Errorf("compact block txs response tx %d short id not match.", idx)
+		}
+	}
+	for i, idx := range r.missing {
+		r.txs[idx] = resp.Txs[i]
+	}
+	r.missing = make([]uint32, 0)
+	return nil
+}
+
+// Create the full block, the mrkl root is checked to detect undiscovered short id collision
+// If it fails, request the full block
+func (r *CompactBlockReconstruction) Block() (interfaces.Block, error) {
+	if !r.IsComplete() {
+		return nil, fmt.Errorf("compact block still missing %d txs.", len(r.missing))
+	}
+	headbts, e := r.compact.Block.SerializeExcludeTransactions()
+	if e != nil {
+		return nil, e
+	}
+	block, _, e := ParseExcludeTransactions(headbts, 0)
+	if e != nil {
+		return nil, e
+	}
+	trslist := append([]interfaces.Transaction{r.compact.Coinbase}, r.txs...)
+	mrklroot := CalculateMrklRoot(trslist)
+	if !mrklroot.Equal(block.GetMrklRoot()) {
+		return nil, fmt.Errorf("compact block rebuild mrkl root need <%s> but got <%s>.", block.GetMrklRoot().ToHex(), mrklroot.ToHex())
+	}
+	block.SetTrsList(trslist)
+	return block, nil
+}
+
+// Request missing transactions of compact block by index
+type CompactBlockTxsRequest struct {
+	BlockHash fields.Hash
+	Count     fields.VarUint4
+	Indexes   []fields.VarUint4
+}
+
+func (m *CompactBlockTxsRequest) Size() uint32 {
+	return fields.HashSize + m.Count.Size() + uint32(len(m.Indexes))*4
+}
+
+func (m *CompactBlockTxsRequest) Serialize() ([]byte, error) {
+	var buffer = new(bytes.Buffer)
+	b1, _ := m.BlockHash.Serialize()
+	b2, _ := m.Count.Serialize()
+	buffer.Write(b1)
+	buffer.Write(b2)
+	for _, idx := range m.Indexes {
+		b, _ := idx.Serialize()
+		buffer.Write(b)
+	}
+	return buffer.Bytes(), nil
+}
+
+func (m *CompactBlockTxsRequest) Parse(buf []byte, seek uint32) (uint32, error) {
+	var e error = nil
+	seek, e = m.BlockHash.Parse(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	seek, e = m.Count.Parse(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	if uint64(seek)+uint64(m.Count)*4 > uint64(len(buf)) {
+		return 0, fmt.Errorf("[CompactBlockTxsRequest.Parse] %w", fields.ErrSeekOutOfBuf)
+	}
+	m.Indexes = make([]fields.VarUint4, int(m.Count))
+	for i := 0; i < int(m.Count); i++ {
+		seek, e = m.Indexes[i].Parse(buf, seek)
+		if e != nil {
+			return 0, e
+		}
+	}
+	return seek, nil
+}
+
+// Create the response by the full block
+func (m *CompactBlockTxsRequest) CreateResponse(block interfaces.Block) (*CompactBlockTxsResponse, error) {
+	if !block.Hash().Equal(m.BlockHash) {
+		return nil, fmt.Errorf("block hash not match.")
+	}
+	trslist := block.GetTrsList()
+	resp := &CompactBlockTxsResponse{
+		BlockHash: m.BlockHash,
+		Count:     m.Count,
+		Txs:       make([]interfaces.Transaction, len(m.Indexes)),
+	}
+	for i, idx := range m.Indexes {
+		if int(idx)+1 >= len(trslist) {
+			return nil, fmt.Errorf("tx index %d overflow.", idx)
+		}
+		resp.Txs[i] = trslist[int(idx)+1]
+	}
+	return resp, nil
+}
+
+// Missing transactions of compact block
+type CompactBlockTxsResponse struct {
+	BlockHash fields.Hash
+	Count     fields.VarUint4
+	Txs       []interfaces.Transaction
+}
+
+func (m *CompactBlockTxsResponse) Size() uint32 {
+	size := fields.HashSize + m.Count.Size()
+	for _, tx := range m.Txs {
+		size += tx.Size()
+	}
+	return size
+}
+
+func (m *CompactBlockTxsResponse) Serialize() ([]byte, error) {
+	var buffer = new(bytes.Buffer)
+	b1, _ := m.BlockHash.Serialize()
+	b2, _ := m.Count.Serialize()
+	buffer.Write(b1)
+	buffer.Write(b2)
+	for _, tx := range m.Txs {
+		b, e := tx.Serialize()
+		if e != nil {
+			return nil, e
+		}
+		buffer.Write(b)
+	}
+	return buffer.Bytes(), nil
+}
+
+func (m *CompactBlockTxsResponse) Parse(buf []byte, seek uint32) (uint32, error) {
+	var e error = nil
+	seek, e = m.BlockHash.Parse(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	seek, e = m.Count.Parse(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	m.Txs = make([]interfaces.Transaction, 0)
+	for i := 0; i < int(m.Count); i++ {
+		var tx interfaces.Transaction
+		tx, seek, e = transactions.ParseTransaction(buf, seek)
+		if e != nil {
+			return 0, e
+		}
+		m.Txs = append(m.Txs, tx)
+	}
+	return seek, nil
+}
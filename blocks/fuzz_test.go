@@ -0,0 +1,114 @@
+package blocks
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/hacash/core/interfaces"
+)
+
+// Mainnet genesis block, the genesis package cannot be imported here
+const fuzzGenesisBlockHex = "010000000000005c57b08c0000000000000000000000000000000000000000000000000000000000000000ad557702fc70afaf70a855e7b8a4400159643cb5a7fc8a89ba2bce6f818a9b0100000001098b344500000000000000000c1aaa4e6007cc58cfb932052ac0ec25ca356183f80101686172646572746f646f62657474657200"
+
+type fuzzWire interface {
+	Serialize() ([]byte, error)
+	Parse([]byte, uint32) (uint32, error)
+}
+
+// Full block with the version prefix
+type fuzzFullBlock struct {
+	block interfaces.Block
+}
+
+func (b *fuzzFullBlock) Serialize() ([]byte, error) { return b.block.Serialize() }
+func (b *fuzzFullBlock) Parse(buf []byte, seek uint32) (uint32, error) {
+	var e error
+	b.block, seek, e = ParseBlock(buf, seek)
+	return seek, e
+}
+
+// Head and meta with the version prefix
+type fuzzBlockHeadMeta struct {
+	block interfaces.Block
+}
+
+func (b *fuzzBlockHeadMeta) Serialize() ([]byte, error) {
+	return b.block.SerializeExcludeTransactions()
+}
+func (b *fuzzBlockHeadMeta) Parse(buf []byte, seek uint32) (uint32, error) {
+	var e error
+	b.block, seek, e = ParseExcludeTransactions(buf, seek)
+	return seek, e
+}
+
+// Blocks and compact block messages come from peers, a parsed one must give back the same bytes to hash and relay
+func fuzzRoundTrip(t *testing.T, obj fuzzWire, obj2 fuzzWire, data []byte) {
+	seek, e := obj.Parse(data, 0)
+	if e != nil {
+		return
+	}
+	if int(seek) > len(data) {
+		t.Fatalf("seek %d out of data len %d", seek, len(data))
+	}
+	bts, e := obj.Serialize()
+	if e != nil {
+		return
+	}
+	seek, e = obj2.Parse(bts, 0)
+	if e != nil {
+		t.Fatalf("parse serialized data error: %v", e)
+	}
+	if int(seek) != len(bts) {
+		t.Fatalf("parse serialized data seek %d but len %d", seek, len(bts))
+	}
+	bts2, _ := obj2.Serialize()
+	if !bytes.Equal(bts, bts2) {
+		t.Fatalf("round trip not match %x != %x", bts, bts2)
+	}
+}
+
+var fuzzWireCreators = []func() fuzzWire{
+	func() fuzzWire { return new(fuzzFullBlock) },
+	func() fuzzWire { return new(fuzzBlockHeadMeta) },
+	func() fuzzWire { return new(BlockHeadExtension) },
+	func() fuzzWire { return new(CompactBlock) },
+	func() fuzzWire { return new(CompactBlockTxsRequest) },
+	func() fuzzWire { return new(CompactBlockTxsResponse) },
+	func() fuzzWire { return new(TransactionInclusionProof) },
+}
+
+// The mainnet genesis block is checked in under testdata/fuzz, the compact block and proof seeds are derived from it
+func FuzzParseBlocks(f *testing.F) {
+	genesis, _ := hex.DecodeString(fuzzGenesisBlockHex)
+	block, _, e := ParseBlock(genesis, 0)
+	if e != nil {
+		f.Fatal(e)
+	}
+	seeds := [][]byte{genesis, {}, {1}, {2}, bytes.Repeat([]byte{0xff}, 160)}
+	if cpt, e := NewCompactBlock(block); e == nil {
+		if bts, e := cpt.Serialize(); e == nil {
+			seeds = append(seeds, bts)
+		}
+	}
+	if proof, e := CreateTransactionInclusionProof(block, 0); e == nil {
+		if bts, e := proof.Serialize(); e == nil {
+			seeds = append(seeds, bts)
+		}
+	}
+	ext := &BlockHeadExtension{}
+	ext.SetStateRoot(bytes.Repeat([]byte{1}, 32))
+	ext.SetChainId(1)
+	if bts, e := ext.Serialize(); e == nil {
+		seeds = append(seeds, bts)
+	}
+	for i := range fuzzWireCreators {
+		for _, seed := range seeds {
+			f.Add(uint8(i), seed)
+		}
+	}
+	f.Fuzz(func(t *testing.T, ty uint8, data []byte) {
+		create := fuzzWireCreators[int(ty)%len(fuzzWireCreators)]
+		fuzzRoundTrip(t, create(), create(), data)
+	})
+}
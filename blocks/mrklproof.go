@@ -0,0 +1,174 @@
+package blocks
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/interfaces"
+)
+
+// Number of merkle tree levels of tx count
+func mrklTreeDepth(count uint32) int {
+	depth := 0
+	for count > 1 {
+		count = (count + 1) / 2
+		depth++
+	}
+	return depth
+}
+
+// Sibling hash of every level from the leaf to the root
+// The last odd node merges with itself, so its sibling is itself
+func BuildMrklBranch(hashsWithFee []fields.Hash, index uint32) ([]fields.Hash, error) {
+	if int(index) >= len(hashsWithFee) {
+		return nil, fmt.Errorf("tx index %d overflow, tx count %d.", index, len(hashsWithFee))
+	}
+	branch := make([]fields.Hash, 0)
+	hashs := hashsWithFee
+	for len(hashs) > 1 {
+		sibling := index ^ 1
+		if int(sibling) >= len(hashs) {
+			sibling = index // repeat
+		}
+		branch = append(branch, hashs[sibling])
+		hashs = hashMerge(hashs)
+		index /= 2
+	}
+	return branch, nil
+}
+
+// Merkle root calculated by tx hash with fee and branch
+func CalculateMrklRootByBranch(txhashwithfee fields.Hash, index uint32, branch []fields.Hash) fields.Hash {
+	root := txhashwithfee
+	for _, sibling := range branch {
+		var buf bytes.Buffer
+		if index%2 == 0 {
+			buf.Write(root)
+			buf.Write(sibling)
+		} else {
+			buf.Write(sibling)
+			buf.Write(root)
+		}
+		root = fields.CalculateHash(buf.Bytes())
+		index /= 2
+	}
+	return root
+}
+
+// Verify tx at index of a block with count txs
+// Branch length and the position of odd tail nodes are checked by count, so a repeated node cannot fake another tx
+func VerifyMrklBranch(txhashwithfee fields.Hash, index uint32, count uint32, branch []fields.Hash, mrklroot fields.Hash) bool {
+	if index >= count || len(branch) != mrklTreeDepth(count) {
+		return false
+	}
+	idx, width := index, count
+	cur := txhashwithfee
+	for _, sibling := range branch {
+		istail := idx%2 == 0 && idx+1 == width
+		if istail && !sibling.Equal(cur) {
+			return false // odd tail must repeat itself
+		}
+		cur = CalculateMrklRootByBranch(cur, idx%2, []fields.Hash{sibling})
+		idx /= 2
+		width = (width + 1) / 2
+	}
+	return cur.Equal(mrklroot)
+}
+
+/**
+ * Transaction inclusion proof for light wallet
+ */
+
+type TransactionInclusionProof struct {
+	BlockHead     interfaces.Block // head and meta only
+	TxHashWithFee fields.Hash
+	TxIndex       fields.VarUint4 // include coinbase
+	BranchCount   fields.VarUint1
+	Branch        []fields.Hash
+}
+
+func CreateTransactionInclusionProof(block interfaces.Block, index uint32) (*TransactionInclusionProof, error) {
+	trslist := block.GetTrsList()
+	hashs := make([]fields.Hash, len(trslist))
+	for i, tx := range trslist {
+		hashs[i] = tx.HashWithFee()
+	}
+	branch, e := BuildMrklBranch(hashs, index)
+	if e != nil {
+		return nil, e
+	}
+	return &TransactionInclusionProof{
+		BlockHead:     block,
+		TxHashWithFee: hashs[index],
+		TxIndex:       fields.VarUint4(index),
+		BranchCount:   fields.VarUint1(len(branch)),
+		Branch:        branch,
+	}, nil
+}
+
+// Check the proof against its block head, the caller must check the block head is in best chain
+func (p *TransactionInclusionProof) Verify() error {
+	if int(p.BranchCount) != len(p.Branch) {
+		return fmt.Errorf("branch count error.")
+	}
+	count := p.BlockHead.GetTransactionCount()
+	if !VerifyMrklBranch(p.TxHashWithFee, uint32(p.TxIndex), count, p.Branch, p.BlockHead.GetMrklRoot()) {
+		return fmt.Errorf("tx <%s> not included in block %d.", p.TxHashWithFee.ToHex(), p.BlockHead.GetHeight())
+	}
+	return nil
+}
+
+func (p *TransactionInclusionProof) Size() uint32 {
+	return blockHeadMetaSize(p.BlockHead) + fields.HashSize + p.TxIndex.Size() + p.BranchCount.Size() + uint32(len(p.Branch))*fields.HashSize
+}
+
+func (p *TransactionInclusionProof) Serialize() ([]byte, error) {
+	var buffer = new(bytes.Buffer)
+	b1, e := p.BlockHead.SerializeExcludeTransactions()
+	if e != nil {
+		return nil, e
+	}
+	b2, _ := p.TxHashWithFee.Serialize()
+	b3, _ := p.TxIndex.Serialize()
+	b4, _ := p.BranchCount.Serialize()
+	buffer.Write(b1)
+	buffer.Write(b2)
+	buffer.Write(b3)
+	buffer.Write(b4)
+	for _, hx := range p.Branch {
+		buffer.Write(hx)
+	}
+	return buffer.Bytes(), nil
+}
+
+func (p *TransactionInclusionProof) Parse(buf []byte, seek uint32) (uint32, error) {
+	var e error = nil
+	p.BlockHead, seek, e = ParseExcludeTransactions(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	seek, e = p.TxHashWithFee.Parse(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	seek, e = p.TxIndex.Parse(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	seek, e = p.BranchCount.Parse(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	if uint64(seek)+uint64(p.BranchCount)*fields.HashSize > uint64(len(buf)) {
+		return 0, fmt.Errorf("[TransactionInclusionProof.Parse] %w", fields.ErrSeekOutOfBuf)
+	}
+	p.Branch = make([]fields.Hash, int(p.BranchCount))
+	for i := 0; i < int(p.BranchCount); i++ {
+		seek, e = p.Branch[i].Parse(buf, seek)
+		if e != nil {
+			return 0, e
+		}
+	}
+	return seek, nil
+}
@@ -205,7 +205,7 @@ func (block *Block_v1) Serialize() ([]byte, error) {
 
 func (block *Block_v1) ParseHead(buf []byte, seek uint32) (uint32, error) {
 	if len(buf) < int(seek)+BlockHeadSize-1 {
-		return 0, fmt.Errorf("buf length error.")
+		return 0, fmt.Errorf("buf length error, %w", fields.ErrSeekOutOfBuf)
 	}
 	//fmt.Println(*buf)
 	//fmt.Println(seek)
@@ -267,6 +267,9 @@ func (block *Block_v1) ParseExcludeTransactions(buf []byte, seek uint32) (uint32
 
 func (block *Block_v1) ParseTransactions(buf []byte, seek uint32) (uint32, error) {
 	length := int(block.TransactionCount)
+	if int(seek)+length > len(buf) {
+		return 0, fmt.Errorf("transaction count %d %w", length, fields.ErrSeekOutOfBuf) // each tx is one byte at least
+	}
 	block.Transactions = make([]interfaces.Transaction, length)
 	for i := 0; i < length; i++ {
 		var trx, sk, err = transactions.ParseTransaction(buf, seek)
@@ -439,10 +442,24 @@ func (block *Block_v1) VerifyNeedSigns() (bool, error) {
 			return ok, e // Validation failed
 		}
 	}
+	// Witness votes of coinbase
+	if len(block.Transactions) > 0 {
+		if coinbase, ok := block.Transactions[0].(*transactions.Transaction_0_Coinbase); ok {
+			return coinbase.VerifyWitnessSigns(block.GetHeight(), uint16(block.WitnessStage), block.PrevHash)
+		}
+	}
 	return true, nil
 }
 
 func (block *Block_v1) WriteInChainState(blockstate interfaces.ChainStateOperation) error {
+	if e := CheckBlockVersionByHeight(block.Version(), block.GetHeight()); e != nil {
+		return e
+	}
+	return block.writeInChainState(blockstate)
+}
+
+// Execute transactions and coinbase, shared by all block versions
+func (block *Block_v1) writeInChainState(blockstate interfaces.ChainStateOperation) error {
 	blkhei := block.GetHeight()
 	txlen := len(block.Transactions)
 	totalfeeuserpay := fields.NewEmptyAmount()
@@ -459,7 +476,7 @@ func (block *Block_v1) WriteInChainState(blockstate interfaces.ChainStateOperati
 		// Problem repair: block 63448 contains the same transaction twice
 		if ishav && blkhei != 63448 {
 			// The transaction has been linked
-			return fmt.Errorf("Tx <%s> is exist, block %d.", txhx.ToHex())
+			return fmt.Errorf("Tx <%s> is exist, block %d.", txhx.ToHex(), blkhei)
 		}
 		// Execute uplink
 		e = blockstate.ContainTxHash(txhx, fields.BlockHeight(blkhei))
@@ -524,7 +541,7 @@ func (block *Block_v1) WriteinChainState(blockstate interfacev2.ChainStateOperat
 		// Problem repair: block 63448 contains the same transaction twice
 		if ishav && blkhei != 63448 {
 			// The transaction has been linked
-			return fmt.Errorf("Tx <%s> is exist, block %d.", txhx.ToHex())
+			return fmt.Errorf("Tx <%s> is exist, block %d.", txhx.ToHex(), blkhei)
 		}
 		// Execute uplink
 		e = blockstate.ContainTxHash(txhx, fields.BlockHeight(blkhei))
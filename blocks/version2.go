@@ -0,0 +1,389 @@
+package blocks
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/interfaces"
+	"github.com/hacash/core/sys"
+)
+
+/**
+ * Block version 2
+ * Head of version 1 followed by a length prefixed extension area:
+ * ExtensionSize VarUint2, then items of Type VarUint1, Length VarUint1 and Value
+ * Items are in ascending unique type order, unknown types are kept as they are
+ */
+
+// Extension item types
+const (
+	BlockHeadExtensionTypeStateRoot         = uint8(1) // 32 bytes
+	BlockHeadExtensionTypeWitnessCommitment = uint8(2) // 32 bytes
+	BlockHeadExtensionTypeChainId           = uint8(3) // 4 bytes
+)
+
+const (
+	BlockHeadExtensionMaxSize = 1024
+)
+
+type BlockHeadExtensionItem struct {
+	Type  fields.VarUint1
+	Value []byte // 255 bytes at most
+}
+
+type BlockHeadExtension struct {
+	Items []*BlockHeadExtensionItem
+}
+
+func (ext *BlockHeadExtension) find(ty uint8) *BlockHeadExtensionItem {
+	for _, v := range ext.Items {
+		if uint8(v.Type) == ty {
+			return v
+		}
+	}
+	return nil
+}
+
+// Set item value, keep the type order
+func (ext *BlockHeadExtension) Set(ty uint8, value []byte) error {
+	if len(value) > 255 {
+		return fmt.Errorf("block head extension item %d value length %d overflow.", ty, len(value))
+	}
+	value = append([]byte{}, value...)
+	if item := ext.find(ty); item != nil {
+		item.Value = value
+		return nil
+	}
+	i := 0
+	for i < len(ext.Items) && uint8(ext.Items[i].Type) < ty {
+		i++
+	}
+	item := &BlockHeadExtensionItem{fields.VarUint1(ty), value}
+	ext.Items = append(ext.Items[:i], append([]*BlockHeadExtensionItem{item}, ext.Items[i:]...)...)
+	return nil
+}
+
+// Item value, nil if not exist
+func (ext *BlockHeadExtension) Get(ty uint8) []byte {
+	if item := ext.find(ty); item != nil {
+		return item.Value
+	}
+	return nil
+}
+
+func (ext *BlockHeadExtension) Delete(ty uint8) {
+	for i, v := range ext.Items {
+		if uint8(v.Type) == ty {
+			ext.Items = append(ext.Items[:i], ext.Items[i+1:]...)
+			return
+		}
+	}
+}
+
+func (ext *BlockHeadExtension) GetStateRoot() fields.Hash {
+	if v := ext.Get(BlockHeadExtensionTypeStateRoot); len(v) == fields.HashSize {
+		return v
+	}
+	return nil
+}
+
+func (ext *BlockHeadExtension) SetStateRoot(root fields.Hash) error {
+	return ext.Set(BlockHeadExtensionTypeStateRoot, root)
+}
+
+func (ext *BlockHeadExtension) GetWitnessCommitment() fields.Hash {
+	if v := ext.Get(BlockHeadExtensionTypeWitnessCommitment); len(v) == fields.HashSize {
+		return v
+	}
+	return nil
+}
+
+func (ext *BlockHeadExtension) SetWitnessCommitment(commit fields.Hash) error {
+	return ext.Set(BlockHeadExtensionTypeWitnessCommitment, commit)
+}
+
+// Return 0 and false if not set
+func (ext *BlockHeadExtension) GetChainId() (uint32, bool) {
+	if v := ext.Get(BlockHeadExtensionTypeChainId); len(v) == 4 {
+		return binary.BigEndian.Uint32(v), true
+	}
+	return 0, false
+}
+
+func (ext *BlockHeadExtension) SetChainId(cid uint32) error {
+	v := make([]byte, 4)
+	binary.BigEndian.PutUint32(v, cid)
+	return ext.Set(BlockHeadExtensionTypeChainId, v)
+}
+
+func (ext *BlockHeadExtension) bodySize() uint32 {
+	size := uint32(0)
+	for _, v := range ext.Items {
+		size += 1 + 1 + uint32(len(v.Value))
+	}
+	return size
+}
+
+func (ext *BlockHeadExtension) Size() uint32 {
+	return 2 + ext.bodySize()
+}
+
+func (ext *BlockHeadExtension) Serialize() ([]byte, error) {
+	bodysize := ext.bodySize()
+	if bodysize > BlockHeadExtensionMaxSize {
+		return nil, fmt.Errorf("block head extension size %d overflow.", bodysize)
+	}
+	var buffer = new(bytes.Buffer)
+	b1, _ := fields.VarUint2(bodysize).Serialize()
+	buffer.Write(b1)
+	for _, v := range ext.Items {
+		buffer.WriteByte(uint8(v.Type))
+		buffer.WriteByte(uint8(len(v.Value)))
+		buffer.Write(v.Value)
+	}
+	return buffer.Bytes(), nil
+}
+
+func (ext *BlockHeadExtension) Parse(buf []byte, seek uint32) (uint32, error) {
+	var bodysize fields.VarUint2
+	seek, e := bodysize.Parse(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	if bodysize > BlockHeadExtensionMaxSize {
+		return 0, fmt.Errorf("block head extension size %d overflow.", bodysize)
+	}
+	end := seek + uint32(bodysize)
+	if int(end) > len(buf) {
+		return 0, fmt.Errorf("[BlockHeadExtension.Parse] %w", fields.ErrSeekOutOfBuf)
+	}
+	ext.Items = make([]*BlockHeadExtensionItem, 0)
+	for seek < end {
+		if seek+2 > end {
+			return 0, fmt.Errorf("block head extension item head out of size.")
+		}
+		ty, length := buf[seek], uint32(buf[seek+1])
+		seek += 2
+		if seek+length > end {
+			return 0, fmt.Errorf("block head extension item %d value out of size.", ty)
+		}
+		if n := len(ext.Items); n > 0 && uint8(ext.Items[n-1].Type) >= ty {
+			return 0, fmt.Errorf("block head extension item %d not in ascending order.", ty)
+		}
+		ext.Items = append(ext.Items, &BlockHeadExtensionItem{
+			Type:  fields.VarUint1(ty),
+			Value: append([]byte{}, buf[seek:seek+length]...),
+		})
+		seek += length
+	}
+	return seek, nil
+}
+
+/**************************** block ****************************/
+
+type Block_v2 struct {
+	Block_v1 // same head fields, meta and body
+
+	// head extension
+	Extension BlockHeadExtension
+}
+
+func NewEmptyBlockVersion2(prevBlockHead interfaces.BlockHeadMetaRead) *Block_v2 {
+	newblock := &Block_v2{
+		Extension: BlockHeadExtension{
+			Items: make([]*BlockHeadExtensionItem, 0),
+		},
+	}
+	copyBlockHeadMeta(&newblock.Block_v1, NewEmptyBlockVersion1(prevBlockHead))
+	newblock.Transactions = make([]interfaces.Transaction, 0)
+	return newblock
+}
+
+// Copy head and meta fields without the lock and cache
+func copyBlockHeadMeta(dst *Block_v1, src *Block_v1) {
+	dst.Height = src.Height
+	dst.Timestamp = src.Timestamp
+	dst.PrevHash = append([]byte{}, src.PrevHash...)
+	dst.MrklRoot = append([]byte{}, src.MrklRoot...)
+	dst.TransactionCount = src.TransactionCount
+	dst.Nonce = src.Nonce
+	dst.Difficulty = src.Difficulty
+	dst.WitnessStage = src.WitnessStage
+}
+
+// Head and meta fields shared by all versions
+func blockHeadFields(block interfaces.Block) *Block_v1 {
+	switch b := block.(type) {
+	case *Block_v1:
+		return b
+	case *Block_v2:
+		return &b.Block_v1
+	}
+	return nil
+}
+
+// Create the next block of the version active at its height
+func NewEmptyBlockByPrev(prevBlockHead interfaces.BlockHeadMetaRead) interfaces.Block {
+	height := uint64(0)
+	if prevBlockHead != nil {
+		height = prevBlockHead.GetHeight() + 1
+	}
+	if sys.IsBlockVersion2Active(height) {
+		return NewEmptyBlockVersion2(prevBlockHead)
+	}
+	return NewEmptyBlockVersion1(prevBlockHead)
+}
+
+// Check the block version by the activation height
+func CheckBlockVersionByHeight(version uint8, height uint64) error {
+	need := uint8(1)
+	if sys.IsBlockVersion2Active(height) {
+		need = 2
+	}
+	if version != need {
+		return fmt.Errorf("block %d version need %d but got %d.", height, need, version)
+	}
+	return nil
+}
+
+func (block *Block_v2) Version() uint8 {
+	return 2
+}
+
+// copy
+func (block *Block_v2) CopyHeadMetaForMining() interfaces.Block {
+	newblock := NewEmptyBlockVersion2(nil)
+	copyBlockHeadMeta(&newblock.Block_v1, &block.Block_v1)
+	bts, _ := block.Extension.Serialize()
+	newblock.Extension.Parse(bts, 0)
+	return newblock
+}
+
+// copy
+func (block *Block_v2) CopyForMining() interfaces.Block {
+	newblock := block.CopyHeadMetaForMining()
+	trs := block.GetTrsList()
+	newtrs := trs
+	if len(trs) > 0 {
+		newtrs = append([]interfaces.Transaction{}, trs[0].Clone())
+		newtrs = append(newtrs, trs[1:]...)
+	}
+	newblock.SetTrsList(newtrs)
+	return newblock
+}
+
+func (block *Block_v2) SerializeHead() ([]byte, error) {
+	var buffer = new(bytes.Buffer)
+	buffer.Write([]byte{block.Version()})
+	b1, _ := block.Height.Serialize()
+	b2, _ := block.Timestamp.Serialize()
+	b3, _ := block.PrevHash.Serialize()
+	b4, _ := block.MrklRoot.Serialize()
+	b5, _ := block.TransactionCount.Serialize()
+	b6, e := block.Extension.Serialize()
+	if e != nil {
+		return nil, e
+	}
+	buffer.Write(b1)
+	buffer.Write(b2)
+	buffer.Write(b3)
+	buffer.Write(b4)
+	buffer.Write(b5)
+	buffer.Write(b6)
+	return buffer.Bytes(), nil
+}
+
+func (block *Block_v2) SerializeExcludeTransactions() ([]byte, error) {
+	var buffer = new(bytes.Buffer)
+	head, e := block.SerializeHead()
+	if e != nil {
+		return nil, e
+	}
+	buffer.Write(head)
+	meta, _ := block.SerializeMeta()
+	buffer.Write(meta)
+	return buffer.Bytes(), nil
+}
+
+func (block *Block_v2) Serialize() ([]byte, error) {
+	var buffer = new(bytes.Buffer)
+	head, e := block.SerializeHead()
+	if e != nil {
+		return nil, e
+	}
+	buffer.Write(head)
+	body, e := block.SerializeBody()
+	if e != nil {
+		return nil, e
+	}
+	buffer.Write(body)
+	return buffer.Bytes(), nil
+}
+
+func (block *Block_v2) ParseHead(buf []byte, seek uint32) (uint32, error) {
+	seek, e := block.Block_v1.ParseHead(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	seek, e = block.Extension.Parse(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	return seek, nil
+}
+
+func (block *Block_v2) ParseExcludeTransactions(buf []byte, seek uint32) (uint32, error) {
+	seek, e := block.ParseHead(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	seek, e = block.ParseMeta(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	return seek, nil
+}
+
+func (block *Block_v2) Parse(buf []byte, seek uint32) (uint32, error) {
+	seek, e := block.ParseHead(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	seek, e = block.ParseBody(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	return seek, nil
+}
+
+func (block *Block_v2) Size() uint32 {
+	return block.Block_v1.Size() + block.Extension.Size()
+}
+
+// HASH
+func (block *Block_v2) Hash() fields.Hash {
+	block.insertLock.Lock()
+	defer block.insertLock.Unlock()
+
+	if block.hash == nil {
+		block.hash = CalculateBlockHash(block)
+	}
+	return block.hash
+}
+
+func (block *Block_v2) HashFresh() fields.Hash {
+	block.insertLock.Lock()
+	defer block.insertLock.Unlock()
+
+	block.hash = CalculateBlockHash(block)
+	return block.hash
+}
+
+func (block *Block_v2) WriteInChainState(blockstate interfaces.ChainStateOperation) error {
+	if e := CheckBlockVersionByHeight(block.Version(), block.GetHeight()); e != nil {
+		return e
+	}
+	return block.writeInChainState(blockstate)
+}
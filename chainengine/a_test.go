@@ -0,0 +1,303 @@
+package chainengine
+
+import (
+	"fmt"
+	"github.com/hacash/core/account"
+	"github.com/hacash/core/actions"
+	"github.com/hacash/core/blocks"
+	"github.com/hacash/core/chainstate"
+	"github.com/hacash/core/coinbase"
+	"github.com/hacash/core/events"
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/interfaces"
+	"github.com/hacash/core/internal/testchain"
+	"github.com/hacash/core/stores"
+	"github.com/hacash/core/sys"
+	"github.com/hacash/core/transactions"
+	"github.com/hacash/core/witness"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+var _ interfaces.ChainEngine = &ChainEngine{}
+var _ interfaces.BlockRollbacker = &ChainEngine{}
+
+// Any hash is valid, difficulty never changes
+type testRules struct{}
+
+func (testRules) CheckProofOfWork(hash fields.Hash, difficulty uint32) bool {
+	return true
+}
+func (testRules) NextDifficulty(prev interfaces.BlockHeadMetaRead, headerByHeight func(uint64) interfaces.BlockHeadMetaRead) (uint32, error) {
+	return prev.GetDifficulty(), nil
+}
+func (testRules) CalculateWork(difficulty uint32) *big.Int {
+	return big.NewInt(1)
+}
+
+// Witness vote of stage 0 for the prev block in the coinbase
+func addTestWitness(blk *blocks.Block_v1, acc *account.Account) {
+	sign, _ := witness.Sign(acc, blk.GetPrevHash(), 0)
+	blk.GetTrsList()[0].(*transactions.Transaction_0_Coinbase).AddWitness(0, sign)
+	blk.SetMrklRoot(blocks.CalculateMrklRoot(blk.GetTrsList()))
+}
+
+func Test_fork_tree(t *testing.T) {
+
+	acc1 := account.CreateAccountByPassword("123456")
+	acc2 := account.CreateAccountByPassword("qwerty")
+
+	newblock := func(prev interfaces.BlockHeadMetaRead, nonce uint32, txs ...interfaces.Transaction) interfaces.Block {
+		blk := testchain.NewBlock(prev, acc1.Address, txs...)
+		blk.Nonce = fields.VarUint4(nonce)
+		return blk
+	}
+
+	genesis := blocks.NewEmptyBlockV1()
+	genesis.Timestamp = 1549250700
+	cnf := NewEmptyChainEngineConfig()
+	cnf.ImmatureBlockCount = 2
+	cnf.Rules = testRules{}
+	engine := NewChainEngine(cnf, chainstate.NewMemoryChainStateImmutable(nil), genesis)
+	engine.ChainStateIinitializeCall(func(state interfaces.ChainStateOperation) {
+		state.BalanceSet(acc1.Address, stores.NewBalanceWithAmount(fields.NewAmountSmall(100, 248)))
+	})
+	if e := engine.Start(); e != nil {
+		t.Fatal(e)
+	}
+	validated := make(chan interfaces.Block, 10)
+	engine.SubscribeValidatedBlockOnInsert(validated)
+	bus := events.NewBus()
+	engine.SetEventBus(bus)
+	blockevs := bus.Subscribe(20, events.FullPolicyDrop, events.FilterTypes(events.EventTypeBlockConnected, events.EventTypeBlockDisconnected))
+
+	// chain a
+	a1 := newblock(genesis, 1)
+	a2 := newblock(a1, 1)
+	a3 := newblock(a2, 1)
+	for _, blk := range []interfaces.Block{a1, a2, a3} {
+		if e := engine.InsertBlock(blk, "sync"); e != nil {
+			t.Fatal(e)
+		}
+	}
+	confirmed, tip, _ := engine.LatestBlock()
+	if confirmed.GetHeight() != 1 || !tip.Hash().Equal(a3.Hash()) {
+		t.Fatal("chain a error", confirmed.GetHeight(), tip.GetHeight())
+	}
+	if engine.InsertBlock(a3, "sync") == nil || engine.InsertBlock(newblock(newblock(a3, 9), 9), "sync") == nil {
+		t.Fatal("must error")
+	}
+
+	// chain b from a1 with a transfer, heavier
+	tx, _ := transactions.NewEmptyTransaction_2_Simple(acc1.Address)
+	tx.Timestamp = 1549250700
+	tx.Fee = *fields.NewAmountSmall(1, 246)
+	tx.AppendAction(actions.NewAction_1_SimpleToTransfer(acc2.Address, fields.NewAmountSmall(10, 248)))
+	tx.FillNeedSigns(map[string][]byte{string(acc1.Address): acc1.PrivateKey}, nil)
+	b2 := newblock(a1, 2)
+	b3 := newblock(b2, 2, tx)
+	b4 := newblock(b3, 2)
+	engine.InsertBlock(b2, "discover")
+	engine.InsertBlock(b3, "discover")
+	_, tip, _ = engine.LatestBlock()
+	if !tip.Hash().Equal(a3.Hash()) {
+		t.Fatal("same work must keep the first seen")
+	}
+	if e := engine.InsertBlock(b4, "discover"); e != nil {
+		t.Fatal(e)
+	}
+	confirmed, tip, _ = engine.LatestBlock()
+	if confirmed.GetHeight() != 2 || !confirmed.Hash().Equal(b2.Hash()) || !tip.Hash().Equal(b4.Hash()) {
+		t.Fatal("reorg error")
+	}
+	if len(engine.nodes) != 2 || len(engine.immutable.GetChilds()) != 1 {
+		t.Fatal("losing branch not pruned")
+	}
+	bls, _ := engine.CurrentState().Balance(acc2.Address)
+	if bls == nil || bls.Hacash.GetValue().Cmp(fields.NewAmountSmall(10, 248).GetValue()) != 0 {
+		t.Fatal("transfer not in current state")
+	}
+	if len(validated) != 6 {
+		t.Fatal("validated block count", len(validated))
+	}
+	// a1 a2 a3 connected, a3 a2 disconnected, b2 b3 b4 connected
+	blockseq := ""
+	for len(blockevs.Chan()) > 0 {
+		switch ev := (<-blockevs.Chan()).(type) {
+		case events.BlockConnected:
+			blockseq += fmt.Sprintf("+%d", ev.Block.GetHeight())
+		case events.BlockDisconnected:
+			blockseq += fmt.Sprintf("-%d", ev.Block.GetHeight())
+		}
+	}
+	if blockseq != "+1+2+3-3-2+2+3+4" {
+		t.Fatal("block events error", blockseq)
+	}
+
+	// rollback to a1
+	height, e := engine.RollbackToBlockHeight(1)
+	confirmed, tip, _ = engine.LatestBlock()
+	bls, _ = engine.CurrentState().Balance(acc2.Address)
+	if e != nil || height != 1 || !tip.Hash().Equal(a1.Hash()) || bls != nil {
+		t.Fatal("rollback error", e)
+	}
+	if len(blockevs.Chan()) != 3 {
+		t.Fatal("rollback must disconnect 3 blocks")
+	}
+	fmt.Println(height, confirmed.GetHeight(), engine.CurrentWork())
+}
+
+func Test_event_order(t *testing.T) {
+
+	acc1 := account.CreateAccountByPassword("123456")
+	genesis := blocks.NewEmptyBlockV1()
+	genesis.Timestamp = 1549250700
+	cnf := NewEmptyChainEngineConfig()
+	cnf.Rules = testRules{}
+	engine := NewChainEngine(cnf, chainstate.NewMemoryChainStateImmutable(nil), genesis)
+	if e := engine.Start(); e != nil {
+		t.Fatal(e)
+	}
+	bus := events.NewBus()
+	engine.SetEventBus(bus)
+	// slow down the publish of odd heights, the next block is inserted meanwhile
+	bus.Subscribe(0, events.FullPolicyDrop, func(ev events.Event) bool {
+		if conn, ok := ev.(events.BlockConnected); ok && conn.Block.GetHeight()%2 == 1 {
+			time.Sleep(5 * time.Millisecond)
+		}
+		return false
+	})
+	connected := bus.Subscribe(0, events.FullPolicyBlock, events.FilterTypes(events.EventTypeBlockConnected))
+
+	// insert all blocks at the same time, each retries until its prev is inserted
+	count := 30
+	chain := make([]interfaces.Block, count)
+	var prev interfaces.BlockHeadMetaRead = genesis
+	for i := range chain {
+		chain[i] = testchain.NewBlock(prev, acc1.Address)
+		prev = chain[i]
+	}
+	for _, blk := range chain {
+		go func(blk interfaces.Block) {
+			for engine.InsertBlock(blk, "sync") != nil {
+				time.Sleep(time.Millisecond)
+			}
+		}(blk)
+	}
+	for i := 1; i <= count; i++ {
+		select {
+		case ev := <-connected.Chan():
+			if height := ev.(events.BlockConnected).Block.GetHeight(); height != uint64(i) {
+				t.Fatal("block connected need", i, "but got", height)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("block connected event not received", i)
+		}
+	}
+}
+
+func Test_witness_tie_break(t *testing.T) {
+
+	defer func(height uint64) {
+		sys.WitnessVerifyActiveHeight = height
+	}(sys.WitnessVerifyActiveHeight)
+	sys.WitnessVerifyActiveHeight = coinbase.ExtendDataVersion1BlockHeight
+
+	acc1 := account.CreateAccountByPassword("123456")
+	acc2 := account.CreateAccountByPassword("qwerty")
+	witness.DefaultRegistry.SetStage(0, []*witness.Witness{{PublicKey: acc2.PublicKey, Weight: 3}})
+	defer witness.DefaultRegistry.SetStage(0, nil)
+
+	newblock := func(prev interfaces.BlockHeadMetaRead, nonce uint32, vote bool) *blocks.Block_v1 {
+		blk := testchain.NewBlock(prev, acc1.Address)
+		blk.Nonce = fields.VarUint4(nonce)
+		if vote {
+			addTestWitness(blk, acc2)
+		}
+		return blk
+	}
+
+	base := blocks.NewEmptyBlockV1()
+	base.Height = fields.BlockHeight(coinbase.ExtendDataVersion1BlockHeight)
+	base.Timestamp = 1549250700
+	state := chainstate.NewMemoryChainStateImmutable(nil)
+	state.SetPending(chainstate.NewPendingStatus(base.GetHeight(), base.Hash(), base))
+	cnf := NewEmptyChainEngineConfig()
+	cnf.Rules = testRules{}
+	engine := NewChainEngine(cnf, state, base)
+	if e := engine.Start(); e != nil {
+		t.Fatal(e)
+	}
+
+	a1 := newblock(base, 1, false)
+	b1 := newblock(base, 2, true)
+	c1 := newblock(base, 3, false)
+	for _, blk := range []interfaces.Block{a1, b1, c1} {
+		if e := engine.InsertBlock(blk, "sync"); e != nil {
+			t.Fatal(e)
+		}
+	}
+	// same work, b1 has the witness weight, c1 has less
+	_, tip, _ := engine.LatestBlock()
+	if !tip.Hash().Equal(b1.Hash()) {
+		t.Fatal("witness weight must break the tie")
+	}
+	// more work wins whatever the weight
+	a2 := newblock(a1, 1, false)
+	if e := engine.InsertBlock(a2, "sync"); e != nil {
+		t.Fatal(e)
+	}
+	if _, tip, _ = engine.LatestBlock(); !tip.Hash().Equal(a2.Hash()) {
+		t.Fatal("more work must win")
+	}
+	// vote signed by an unregistered key is invalid
+	bad := newblock(a1, 9, false)
+	addTestWitness(bad, acc1)
+	if engine.InsertBlock(bad, "sync") == nil {
+		t.Fatal("unregistered witness must be rejected")
+	}
+}
+
+func Test_state_root_commit(t *testing.T) {
+
+	defer func(height uint64) {
+		sys.BlockVersion2ActiveHeight = height
+	}(sys.BlockVersion2ActiveHeight)
+	sys.BlockVersion2ActiveHeight = 1
+
+	acc1 := account.CreateAccountByPassword("123456")
+	genesis := blocks.NewEmptyBlockV1()
+	genesis.Timestamp = 1549250700
+	cnf := NewEmptyChainEngineConfig()
+	cnf.Rules = testRules{}
+	engine := NewChainEngine(cnf, chainstate.NewMemoryChainStateImmutable(nil), genesis)
+	if e := engine.Start(); e != nil {
+		t.Fatal(e)
+	}
+	assemble := func(prev interfaces.BlockHeadMetaRead) *blocks.Block_v2 {
+		assembler := blocks.NewBlockAssembler(engine.CurrentState(), prev, acc1.Address, nil)
+		res, e := assembler.Assemble(nil)
+		if e != nil {
+			t.Fatal(e)
+		}
+		return res.Block.(*blocks.Block_v2)
+	}
+	b1 := assemble(genesis)
+	if b1.Extension.GetStateRoot() == nil {
+		t.Fatal("state root not committed")
+	}
+	if e := engine.InsertBlock(b1, "sync"); e != nil {
+		t.Fatal(e)
+	}
+	if r, _ := engine.CurrentState().(*chainstate.MemoryChainState).StateRoot(); !r.Equal(b1.Extension.GetStateRoot()) {
+		t.Fatal("state root not match")
+	}
+	// wrong root is rejected
+	b2 := assemble(b1)
+	b2.Extension.SetStateRoot(fields.CalculateHash([]byte("wrong")))
+	if e := engine.InsertBlock(b2, "sync"); e == nil || !strings.Contains(e.Error(), "state root") {
+		t.Fatal("wrong state root must be rejected", e)
+	}
+}
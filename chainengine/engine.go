@@ -0,0 +1,647 @@
+package chainengine
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/hacash/core/blocks"
+	"github.com/hacash/core/chainstate"
+	"github.com/hacash/core/difficulty"
+	"github.com/hacash/core/events"
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/genesis"
+	"github.com/hacash/core/interfaces"
+	"github.com/hacash/core/lightclient"
+	"github.com/hacash/core/stores"
+	"github.com/hacash/core/witness"
+)
+
+/**
+ * Reference chain engine
+ * Immature blocks are kept in a tree of fork states, the branch with the most work is the current chain
+ * The block N confirmations under the tip is written into the immutable state, other branches are destroyed
+ */
+
+const (
+	DefaultImmatureBlockCount = 4
+)
+
+type ChainEngineConfig struct {
+	// Count of blocks kept as fork states above the immutable state
+	ImmatureBlockCount uint64
+	// Difficulty rules, nil uses the mainnet rules
+	Rules lightclient.DifficultyRules
+}
+
+func NewEmptyChainEngineConfig() *ChainEngineConfig {
+	return &ChainEngineConfig{
+		ImmatureBlockCount: DefaultImmatureBlockCount,
+		Rules:              nil,
+	}
+}
+
+// State written with undo journal, see chainstate.MemoryChainState
+type journalBlockWriter interface {
+	WriteBlockWithJournal(interfaces.Block) error
+}
+
+// State with the state tree, see chainstate.MemoryChainState
+type stateRootReader interface {
+	StateRoot() (fields.Hash, error)
+}
+
+// Immature block and the fork state after it
+type forkNode struct {
+	block  interfaces.Block
+	state  interfaces.ChainState
+	parent *forkNode // nil means the parent is the immutable state
+	work   *big.Int  // cumulative work
+}
+
+type ChainEngine struct {
+	config *ChainEngineConfig
+	rules  lightclient.DifficultyRules
+
+	immutable     interfaces.ChainStateImmutable
+	immutableHead interfaces.BlockHeadMetaRead // latest confirmed block
+	immutableWork *big.Int
+
+	nodes map[string]*forkNode // immature blocks by hash
+	head  *forkNode            // tip of the current chain, nil means the immutable head
+
+	initializeCalls []func(interfaces.ChainStateOperation)
+
+	validatedBlockChans []chan interfaces.Block
+	diamondCreateChans  []chan *stores.DiamondSmelt
+	eventBus            *events.Bus
+
+	// events are published in the order of insert, out of the lock
+	publishTicket uint64
+	publishTurn   uint64
+	publishCond   *sync.Cond
+
+	mux sync.RWMutex
+}
+
+// The base block is the block of the immutable state, nil means the genesis block or the block in store
+func NewChainEngine(cnf *ChainEngineConfig, state interfaces.ChainStateImmutable, base interfaces.BlockHeadMetaRead) *ChainEngine {
+	if cnf == nil {
+		cnf = NewEmptyChainEngineConfig()
+	}
+	rules := cnf.Rules
+	if rules == nil {
+		rules = difficulty.MainnetRules{}
+	}
+	return &ChainEngine{
+		config:              cnf,
+		rules:               rules,
+		immutable:           state,
+		immutableHead:       base,
+		immutableWork:       big.NewInt(0),
+		nodes:               make(map[string]*forkNode),
+		head:                nil,
+		initializeCalls:     make([]func(interfaces.ChainStateOperation), 0),
+		validatedBlockChans: make([]chan interfaces.Block, 0),
+		diamondCreateChans:  make([]chan *stores.DiamondSmelt, 0),
+		publishCond:         sync.NewCond(&sync.Mutex{}),
+	}
+}
+
+func (c *ChainEngine) Start() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	height := c.immutable.GetPendingBlockHeight()
+	if c.immutableHead == nil {
+		head, e := c.readImmutableBlockHead(height)
+		if e != nil {
+			return e
+		}
+		if head == nil && height == 0 {
+			head = genesis.GetGenesisBlock()
+		}
+		if head == nil {
+			return fmt.Errorf("block %d of immutable state not find.", height)
+		}
+		c.immutableHead = head
+	}
+	if c.immutableHead.GetHeight() != height {
+		return fmt.Errorf("base block height need %d but got %d.", height, c.immutableHead.GetHeight())
+	}
+	if height > 0 {
+		return nil
+	}
+	// initialize the empty state
+	for _, call := range c.initializeCalls {
+		call(c.immutable)
+	}
+	hash := c.immutableHead.Hash()
+	if e := c.immutable.SetPending(chainstate.NewPendingStatus(0, hash, c.immutableHead)); e != nil {
+		return e
+	}
+	if block, ok := c.immutableHead.(interfaces.Block); ok {
+		if e := c.immutable.BlockStore().SaveBlock(block); e != nil {
+			return e
+		}
+	}
+	return c.immutable.BlockStore().UpdateSetBlockHashReferToHeight(0, hash)
+}
+
+func (c *ChainEngine) Close() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.pruneAllImmature()
+	c.immutable.Close()
+	return nil
+}
+
+// Called on the empty immutable state when start
+func (c *ChainEngine) ChainStateIinitializeCall(call func(interfaces.ChainStateOperation)) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.initializeCalls = append(c.initializeCalls, call)
+}
+
+/**************************** insert ****************************/
+
+// Check and execute the block in a fork of its prev block, then update the current chain
+func (c *ChainEngine) InsertBlock(block interfaces.Block, origin string) error {
+	block.SetOriginMark(origin)
+	c.mux.Lock()
+	diamond, evs, e := c.insertBlockUnsafe(block)
+	validatedChans := c.validatedBlockChans
+	diamondChans := c.diamondCreateChans
+	bus := c.eventBus
+	if e != nil {
+		c.mux.Unlock()
+		return e
+	}
+	ticket := c.takePublishTicketUnsafe()
+	c.mux.Unlock()
+	// publish out of lock, after the events of the calls before
+	c.waitPublishTurn(ticket)
+	defer c.donePublishTurn()
+	if bus != nil && len(evs) > 0 {
+		bus.Publish(evs...)
+	}
+	for _, ch := range validatedChans {
+		ch <- block
+	}
+	if diamond != nil {
+		for _, ch := range diamondChans {
+			ch <- diamond
+		}
+	}
+	return nil
+}
+
+func (c *ChainEngine) takePublishTicketUnsafe() uint64 {
+	ticket := c.publishTicket
+	c.publishTicket++
+	return ticket
+}
+
+func (c *ChainEngine) waitPublishTurn(ticket uint64) {
+	c.publishCond.L.Lock()
+	defer c.publishCond.L.Unlock()
+	for c.publishTurn != ticket {
+		c.publishCond.Wait()
+	}
+}
+
+func (c *ChainEngine) donePublishTurn() {
+	c.publishCond.L.Lock()
+	c.publishTurn++
+	c.publishCond.L.Unlock()
+	c.publishCond.Broadcast()
+}
+
+// Return the diamond created by the block if it becomes the tip, and the events of the chain switch
+func (c *ChainEngine) insertBlockUnsafe(block interfaces.Block) (*stores.DiamondSmelt, []events.Event, error) {
+	height := block.GetHeight()
+	hash := block.HashFresh()
+	if _, ok := c.nodes[string(hash)]; ok || hash.Equal(c.immutableHead.Hash()) {
+		return nil, nil, fmt.Errorf("block %d <%s> already exist.", height, hash.ToHex())
+	}
+	// prev block
+	var parent *forkNode = nil
+	var prevState interfaces.ChainState = c.immutable
+	var prev interfaces.BlockHeadMetaRead = c.immutableHead
+	var prevWork = c.immutableWork
+	if !block.GetPrevHash().Equal(c.immutableHead.Hash()) {
+		ok := false
+		if parent, ok = c.nodes[string(block.GetPrevHash())]; !ok {
+			return nil, nil, fmt.Errorf("block %d prev hash <%s> not find.", height, block.GetPrevHash().ToHex())
+		}
+		prevState, prev, prevWork = parent.state, parent.block, parent.work
+	}
+	if e := c.checkBlockHead(block, hash, parent, prev); e != nil {
+		return nil, nil, e
+	}
+	// execute
+	state, e := prevState.ForkNextBlock(height, hash, block)
+	if e != nil {
+		return nil, nil, e
+	}
+	if writer, ok := state.(journalBlockWriter); ok {
+		e = writer.WriteBlockWithJournal(block)
+	} else {
+		e = block.WriteInChainState(state)
+	}
+	if e == nil {
+		e = checkStateRoot(block, state)
+	}
+	if e != nil {
+		state.Destory()
+		return nil, nil, e
+	}
+	node := &forkNode{
+		block:  block,
+		state:  state,
+		parent: parent,
+		work:   new(big.Int).Add(prevWork, c.rules.CalculateWork(block.GetDifficulty())),
+	}
+	c.nodes[string(hash)] = node
+	if cmp := node.work.Cmp(c.currentWork()); cmp < 0 || (cmp == 0 && !c.winsWorkTie(node)) {
+		return nil, nil, nil // side chain, keep the first seen
+	}
+	var evs []events.Event = nil
+	if c.eventBus != nil {
+		if evs, e = c.switchEvents(c.head, node); e != nil {
+			return nil, nil, e
+		}
+	}
+	c.head = node
+	// confirm
+	if e := c.confirmImmatureBlocks(); e != nil {
+		return nil, nil, e
+	}
+	return state.GetPending().GetWaitingSubmitDiamond(), evs, nil
+}
+
+// Disconnect the blocks of the old tip down to the fork point, then connect the blocks up to the new tip
+func (c *ChainEngine) switchEvents(oldtip, newtip *forkNode) ([]events.Event, error) {
+	onNew := make(map[*forkNode]bool)
+	for n := newtip; n != nil; n = n.parent {
+		onNew[n] = true
+	}
+	evs := make([]events.Event, 0)
+	var fork *forkNode = nil
+	for n := oldtip; n != nil; n = n.parent {
+		if onNew[n] {
+			fork = n
+			break
+		}
+		evs = append(evs, events.BlockDisconnected{Block: n.block})
+	}
+	connects := make([]*forkNode, 0)
+	for n := newtip; n != fork; n = n.parent {
+		connects = append(connects, n)
+	}
+	for i := len(connects) - 1; i >= 0; i-- {
+		n := connects[i]
+		evs = append(evs, events.BlockConnected{Block: n.block})
+		acts, e := events.BlockActionEvents(n.block, n.state)
+		if e != nil {
+			return nil, e
+		}
+		evs = append(evs, acts...)
+	}
+	return evs, nil
+}
+
+func (c *ChainEngine) checkBlockHead(block interfaces.Block, hash fields.Hash, parent *forkNode, prev interfaces.BlockHeadMetaRead) error {
+	height := block.GetHeight()
+	if height != prev.GetHeight()+1 {
+		return fmt.Errorf("block height need %d but got %d.", prev.GetHeight()+1, height)
+	}
+	if block.GetTimestamp() <= prev.GetTimestamp() {
+		return fmt.Errorf("block %d timestamp %d must be after prev %d.", height, block.GetTimestamp(), prev.GetTimestamp())
+	}
+	trslist := block.GetTrsList()
+	if uint32(len(trslist)) != block.GetTransactionCount() {
+		return fmt.Errorf("block %d transaction count need %d but got %d.", height, block.GetTransactionCount(), len(trslist))
+	}
+	mrklroot := blocks.CalculateMrklRoot(trslist)
+	if !mrklroot.Equal(block.GetMrklRoot()) {
+		return fmt.Errorf("block %d mrkl root need <%s> but got <%s>.", height, mrklroot.ToHex(), block.GetMrklRoot().ToHex())
+	}
+	// difficulty
+	needdiff, e := c.rules.NextDifficulty(prev, func(hei uint64) interfaces.BlockHeadMetaRead {
+		return c.ancestor(parent, hei)
+	})
+	if e != nil {
+		return e
+	}
+	if block.GetDifficulty() != needdiff {
+		return fmt.Errorf("block %d difficulty need %d but got %d.", height, needdiff, block.GetDifficulty())
+	}
+	if !c.rules.CheckProofOfWork(hash, block.GetDifficulty()) {
+		return fmt.Errorf("block %d hash <%s> not meet difficulty %d.", height, hash.ToHex(), block.GetDifficulty())
+	}
+	ok, e := block.VerifyNeedSigns()
+	if e != nil {
+		return e
+	}
+	if !ok {
+		return fmt.Errorf("block %d verify signatures fail.", height)
+	}
+	return nil
+}
+
+// The state root committed in the head extension must match the state after the block
+func checkStateRoot(block interfaces.Block, state interfaces.ChainState) error {
+	b2, ok := block.(*blocks.Block_v2)
+	if !ok {
+		return nil
+	}
+	root := b2.Extension.GetStateRoot()
+	reader, ok := state.(stateRootReader)
+	if root == nil || !ok {
+		return nil
+	}
+	after, e := reader.StateRoot()
+	if e != nil {
+		return e
+	}
+	if !root.Equal(after) {
+		return fmt.Errorf("block %d state root need <%s> but got <%s>.", block.GetHeight(), after.ToHex(), root.ToHex())
+	}
+	return nil
+}
+
+// Block at height of the branch, read from block store under the immutable head
+func (c *ChainEngine) ancestor(node *forkNode, height uint64) interfaces.BlockHeadMetaRead {
+	for ; node != nil; node = node.parent {
+		if node.block.GetHeight() == height {
+			return node.block
+		}
+	}
+	if height == c.immutableHead.GetHeight() {
+		return c.immutableHead
+	}
+	if height > c.immutableHead.GetHeight() {
+		return nil
+	}
+	head, _ := c.readImmutableBlockHead(height)
+	return head
+}
+
+// Read the head alone if the store keeps it, the body may be pruned or never saved after the sync from snapshot
+func (c *ChainEngine) readImmutableBlockHead(height uint64) (interfaces.BlockHeadMetaRead, error) {
+	store := c.immutable.BlockStoreRead()
+	if heads, ok := store.(chainstate.BlockHeadStore); ok {
+		_, head, e := heads.ReadBlockHeadBytesByHeight(height)
+		if e != nil {
+			return nil, e
+		}
+		if head != nil {
+			block, _, e := blocks.ParseExcludeTransactions(head, 0)
+			if e != nil {
+				return nil, e
+			}
+			return block, nil
+		}
+	}
+	_, body, e := store.ReadBlockBytesByHeight(height)
+	if e != nil || body == nil {
+		return nil, e
+	}
+	head, _, e := blocks.ParseExcludeTransactions(body, 0)
+	if e != nil {
+		return nil, e
+	}
+	return head, nil
+}
+
+// With the same work, the block with more witness weight than the tip becomes the tip
+// The weight is counted by witness.DefaultRegistry, the same one checking the votes of blocks
+func (c *ChainEngine) winsWorkTie(node *forkNode) bool {
+	if c.head == nil {
+		return false // the tip is the immutable block
+	}
+	return witness.DefaultRegistry.CompareFork(node.block, c.head.block) > 0
+}
+
+func (c *ChainEngine) currentWork() *big.Int {
+	if c.head == nil {
+		return c.immutableWork
+	}
+	return c.head.work
+}
+
+/**************************** confirm ****************************/
+
+// Write the blocks deeper than the immature count into the immutable state
+func (c *ChainEngine) confirmImmatureBlocks() error {
+	for c.head != nil && c.head.block.GetHeight()-c.immutableHead.GetHeight() > c.config.ImmatureBlockCount {
+		node := c.head
+		for node.parent != nil {
+			node = node.parent
+		}
+		if e := c.confirmBlock(node); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// The node must be a child of the immutable state
+func (c *ChainEngine) confirmBlock(node *forkNode) error {
+	hash := node.block.Hash()
+	// prune the other branches
+	for k, n := range c.nodes {
+		if n.parent == nil && n != node {
+			n.state.Destory()
+			c.dropBranch(k, n)
+		}
+	}
+	store := c.immutable.BlockStore()
+	if e := store.SaveBlock(node.block); e != nil {
+		return e
+	}
+	if e := store.UpdateSetBlockHashReferToHeight(node.block.GetHeight(), hash); e != nil {
+		return e
+	}
+	immutable, e := node.state.ImmutableWriteToDisk()
+	if e != nil {
+		return e
+	}
+	c.immutable = immutable
+	c.immutableHead = node.block
+	c.immutableWork = node.work
+	delete(c.nodes, string(hash))
+	for _, n := range c.nodes {
+		if n.parent == node {
+			n.parent = nil
+		}
+	}
+	if c.head == node {
+		c.head = nil
+	}
+	return nil
+}
+
+// Remove the node and all its descendants from the tree
+func (c *ChainEngine) dropBranch(hash string, node *forkNode) {
+	delete(c.nodes, hash)
+	for k, n := range c.nodes {
+		if n.parent == node {
+			c.dropBranch(k, n)
+		}
+	}
+}
+
+func (c *ChainEngine) pruneAllImmature() {
+	for _, n := range c.nodes {
+		if n.parent == nil {
+			n.state.Destory()
+		}
+	}
+	c.nodes = make(map[string]*forkNode)
+	c.head = nil
+}
+
+/**************************** read ****************************/
+
+func (c *ChainEngine) StateRead() interfaces.ChainStateOperationRead {
+	return c.CurrentState()
+}
+
+// State after the tip block
+func (c *ChainEngine) CurrentState() interfaces.ChainState {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+
+	if c.head == nil {
+		return c.immutable
+	}
+	return c.head.state
+}
+
+// Latest confirmed block and the tip block
+func (c *ChainEngine) LatestBlock() (interfaces.BlockHeadMetaRead, interfaces.BlockHeadMetaRead, error) {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+
+	if c.immutableHead == nil {
+		return nil, nil, fmt.Errorf("chain engine not start.")
+	}
+	if c.head == nil {
+		return c.immutableHead, c.immutableHead, nil
+	}
+	return c.immutableHead, c.head.block, nil
+}
+
+func (c *ChainEngine) LatestDiamond() (*stores.DiamondSmelt, error) {
+	status, e := c.CurrentState().LatestStatusRead()
+	if e != nil {
+		return nil, e
+	}
+	return status.ReadLastestDiamond(), nil
+}
+
+// Cumulative work of the current chain from the base block
+func (c *ChainEngine) CurrentWork() *big.Int {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+
+	return new(big.Int).Set(c.currentWork())
+}
+
+/**************************** subscribe ****************************/
+
+// Every validated block, include side chains
+// Sent in InsertBlock, a slow reader blocks the insert
+func (c *ChainEngine) SubscribeValidatedBlockOnInsert(ch chan interfaces.Block) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.validatedBlockChans = append(c.validatedBlockChans, ch)
+}
+
+// Diamond of the block that becomes the tip
+func (c *ChainEngine) SubscribeDiamondOnCreate(ch chan *stores.DiamondSmelt) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.diamondCreateChans = append(c.diamondCreateChans, ch)
+}
+
+// Publish the typed events of blocks connected to or disconnected from the current chain
+func (c *ChainEngine) SetEventBus(bus *events.Bus) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.eventBus = bus
+}
+
+/**************************** rollback ****************************/
+
+// Drop all immature blocks and undo the immutable state down to the height
+func (c *ChainEngine) RollbackToBlockHeight(height uint64) (uint64, error) {
+	c.mux.Lock()
+	evs := make([]events.Event, 0)
+	for n := c.head; n != nil; n = n.parent {
+		evs = append(evs, events.BlockDisconnected{Block: n.block})
+	}
+	current, e := c.rollbackToBlockHeightUnsafe(height, &evs)
+	bus := c.eventBus
+	ticket := c.takePublishTicketUnsafe()
+	c.mux.Unlock()
+	c.waitPublishTurn(ticket)
+	defer c.donePublishTurn()
+	if bus != nil {
+		bus.Publish(evs...)
+	}
+	return current, e
+}
+
+func (c *ChainEngine) rollbackToBlockHeightUnsafe(height uint64, evs *[]events.Event) (uint64, error) {
+	c.pruneAllImmature()
+	// blocks to undo, read before rollback
+	undos := make([]interfaces.Block, 0)
+	if c.eventBus != nil {
+		store := c.immutable.BlockStoreRead()
+		for hei := c.immutableHead.GetHeight(); hei > height; hei-- {
+			_, body, e := store.ReadBlockBytesByHeight(hei)
+			if e != nil {
+				return c.immutableHead.GetHeight(), e
+			}
+			if body == nil {
+				break
+			}
+			block, _, e := blocks.ParseBlock(body, 0)
+			if e != nil {
+				return c.immutableHead.GetHeight(), e
+			}
+			undos = append(undos, block)
+		}
+	}
+	rollbacker, ok := c.immutable.(interfaces.BlockRollbacker)
+	if !ok {
+		return c.immutableHead.GetHeight(), fmt.Errorf("immutable state not support rollback.")
+	}
+	current, e := rollbacker.RollbackToBlockHeight(height)
+	for _, block := range undos {
+		if block.GetHeight() > current {
+			*evs = append(*evs, events.BlockDisconnected{Block: block})
+		}
+	}
+	if current != c.immutableHead.GetHeight() {
+		head, e2 := c.readImmutableBlockHead(current)
+		if e2 != nil {
+			return current, e2
+		}
+		if head == nil {
+			return current, fmt.Errorf("block %d not find in block store.", current)
+		}
+		c.immutableHead = head
+		// work from the base block is not known any more
+		c.immutableWork = big.NewInt(0)
+	}
+	return current, e
+}
@@ -0,0 +1,886 @@
+package chainstate
+
+import (
+	"fmt"
+	"github.com/hacash/core/account"
+	"github.com/hacash/core/actions"
+	"github.com/hacash/core/blocks"
+	"github.com/hacash/core/channel"
+	"github.com/hacash/core/crypto/btcec"
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/interfaces"
+	"github.com/hacash/core/interfacev2"
+	"github.com/hacash/core/internal/testchain"
+	"github.com/hacash/core/kvdb"
+	"github.com/hacash/core/statetree"
+	"github.com/hacash/core/stores"
+	"github.com/hacash/core/sys"
+	"github.com/hacash/core/transactions"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var _ interfaces.ChainStateImmutable = &MemoryChainState{}
+var _ interfaces.BlockStore = &MemoryBlockStore{}
+var _ interfaces.BlockStore = &KVBlockStore{}
+var _ interfaces.BlockStore = &FlatBlockStore{}
+
+func Test_simulate_transfer(t *testing.T) {
+
+	acc1 := account.CreateAccountByPassword("123456")
+	acc2 := account.CreateAccountByPassword("qwerty")
+
+	base := NewMemoryChainStateImmutable(nil)
+	state, _ := base.ForkNextBlock(300001, nil, nil)
+	state.BalanceSet(acc1.Address, stores.NewBalanceWithAmount(fields.NewAmountSmall(100, 248)))
+
+	tx, _ := transactions.NewEmptyTransaction_2_Simple(acc1.Address)
+	tx.Fee = *fields.NewAmountSmall(1, 246)
+	tx.AppendAction(actions.NewAction_1_SimpleToTransfer(acc2.Address, fields.NewAmountSmall(12, 248)))
+
+	diff, e := SimulateTransaction(state, tx)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(diff.Balances) != 2 {
+		t.Fatalf("balance diff count %d", len(diff.Balances))
+	}
+	d1 := diff.FindBalance(acc1.Address)
+	d2 := diff.FindBalance(acc2.Address)
+	fmt.Println(d1.HacashBefore.ToFinString(), d1.HacashAfter.ToFinString(), d1.HacashDelta.ToFinString())
+	fmt.Println(d2.HacashBefore.ToFinString(), d2.HacashAfter.ToFinString(), d2.HacashDelta.ToFinString())
+	if !d2.HacashDelta.Equal(fields.NewAmountSmall(12, 248)) || !d1.HacashDelta.IsNegative() {
+		t.Fatal("balance delta error")
+	}
+
+	// base state not change
+	bls1, _ := state.Balance(acc1.Address)
+	bls2, _ := state.Balance(acc2.Address)
+	if !bls1.Hacash.Equal(fields.NewAmountSmall(100, 248)) || bls2 != nil {
+		t.Fatal("simulate changed the base state")
+	}
+	if len(state.GetChilds()) != 0 {
+		t.Fatal("simulate fork not destoryed")
+	}
+
+	// insufficient balance
+	tx.Actions = nil
+	tx.ActionCount = 0
+	tx.AppendAction(actions.NewAction_1_SimpleToTransfer(acc2.Address, fields.NewAmountSmall(200, 248)))
+	_, e = SimulateTransaction(state, tx)
+	fmt.Println(e)
+	if e == nil {
+		t.Fatal("must error")
+	}
+}
+
+func Test_fork_write_to_disk(t *testing.T) {
+
+	acc1 := account.CreateAccountByPassword("123456")
+	base := NewMemoryChainStateImmutable(nil)
+
+	hx1 := fields.CalculateHash([]byte("block1"))
+	hx2 := fields.CalculateHash([]byte("block2"))
+	s1, _ := base.ForkNextBlock(1, hx1, nil)
+	s1.BalanceSet(acc1.Address, stores.NewBalanceWithAmount(fields.NewAmountSmall(1, 248)))
+	s2, _ := s1.ForkNextBlock(2, hx2, nil)
+	s2.BalanceDel(acc1.Address)
+	s1b, _ := base.ForkNextBlock(1, fields.CalculateHash([]byte("block1b")), nil)
+
+	fd, _ := base.SearchBaseStateByBlockHash(hx2)
+	if fd != s2 {
+		t.Fatal("search state error")
+	}
+	hxs, _ := base.SeekImmatureBlockHashs()
+	if len(hxs) != 3 {
+		t.Fatal("immature hashs count error")
+	}
+
+	newbase, e := s1.ImmutableWriteToDisk()
+	if e != nil {
+		t.Fatal(e)
+	}
+	bls, _ := newbase.Balance(acc1.Address)
+	if bls == nil || newbase.GetPendingBlockHeight() != 1 {
+		t.Fatal("write to disk error")
+	}
+	childs := newbase.GetChilds()
+	if len(childs) != 1 || s2.GetParent() != newbase || len(s1b.GetChilds()) != 0 {
+		t.Fatal("childs error")
+	}
+	bls, _ = s2.Balance(acc1.Address)
+	if bls != nil {
+		t.Fatal("balance must be deleted")
+	}
+	fmt.Println(newbase.GetTotalNonEmptyAccountStatistics())
+}
+
+func Test_state_tree(t *testing.T) {
+
+	acc1 := account.CreateAccountByPassword("123456")
+	acc2 := account.CreateAccountByPassword("qwerty")
+	acc3 := account.CreateAccountByPassword("asdfgh")
+
+	base := NewMemoryChainStateImmutable(nil)
+	s1, _ := base.ForkNextBlock(1, nil, nil)
+	s1.BalanceSet(acc1.Address, stores.NewBalanceWithAmount(fields.NewAmountSmall(100, 248)))
+	tree := BuildStateTree(s1.(*MemoryChainState))
+	root1 := tree.Root()
+
+	s2, _ := s1.ForkNextBlock(2, nil, nil)
+	recorder := NewStateRecorder(s2)
+	tx, _ := transactions.NewEmptyTransaction_2_Simple(acc1.Address)
+	tx.Fee = *fields.NewAmountSmall(1, 246)
+	tx.AppendAction(actions.NewAction_1_SimpleToTransfer(acc2.Address, fields.NewAmountSmall(12, 248)))
+	if e := tx.WriteInChainState(recorder); e != nil {
+		t.Fatal(e)
+	}
+	tree2 := tree.Copy()
+	if e := UpdateStateTree(tree2, s2, recorder.GetRecordItems()); e != nil {
+		t.Fatal(e)
+	}
+	root2 := tree2.Root()
+	if root2.Equal(root1) || !root2.Equal(BuildStateTree(s2.(*MemoryChainState)).Root()) {
+		t.Fatal("state root error")
+	}
+	fmt.Println("state root", root2.ToHex())
+
+	// balance proof
+	k2 := StateTreeKey(KeyPrefixBalance, acc2.Address)
+	v2, _ := ReadStateTreeValue(s2, KeyPrefixBalance, acc2.Address)
+	if !statetree.VerifyProof(root2, k2, v2, tree2.Prove(k2)) {
+		t.Fatal("balance proof fail")
+	}
+	k3 := StateTreeKey(KeyPrefixBalance, acc3.Address)
+	if !statetree.VerifyProof(root2, k3, nil, tree2.Prove(k3)) {
+		t.Fatal("absence proof fail")
+	}
+
+	// tree kept by the states
+	if r, _ := s2.(*MemoryChainState).StateRoot(); !r.Equal(root2) {
+		t.Fatal("fork state root error")
+	}
+	if r, _ := s1.(*MemoryChainState).StateRoot(); !r.Equal(root1) {
+		t.Fatal("parent state root changed")
+	}
+	immutable, e := s2.ImmutableWriteToDisk()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if r, _ := immutable.(*MemoryChainState).StateRoot(); !r.Equal(root2) {
+		t.Fatal("immutable state root error")
+	}
+}
+
+func Test_journal_rollback(t *testing.T) {
+
+	acc1 := account.CreateAccountByPassword("123456")
+	acc2 := account.CreateAccountByPassword("qwerty")
+	base := NewMemoryChainStateImmutable(nil)
+	base.BalanceSet(acc1.Address, stores.NewBalanceWithAmount(fields.NewAmountSmall(100, 248)))
+
+	// two blocks of transfer
+	var state interfaces.ChainState = base
+	hashs := []fields.Hash{base.GetPendingBlockHash()}
+	roots := []fields.Hash{base.StateTree().Root()}
+	txs := make([]interfaces.Transaction, 0)
+	for i := uint64(1); i <= 2; i++ {
+		tx := testchain.NewTx(i*10, acc1.Address, nil, actions.NewAction_1_SimpleToTransfer(acc2.Address, fields.NewAmountSmall(1, 248)))
+		blk := testchain.NewBlockAtHeight(i, acc1.Address, tx)
+		fork, _ := state.ForkNextBlock(i, blk.Hash(), blk)
+		if e := fork.(*MemoryChainState).WriteBlockWithJournal(blk); e != nil {
+			t.Fatal(e)
+		}
+		root, _ := fork.(*MemoryChainState).StateRoot()
+		base.BlockStore().SaveBlock(blk)
+		base.BlockStore().UpdateSetBlockHashReferToHeight(i, blk.Hash())
+		hashs = append(hashs, blk.Hash())
+		roots = append(roots, root)
+		txs = append(txs, tx)
+		state = fork
+	}
+	immutable, e := state.ImmutableWriteToDisk()
+	if e != nil {
+		t.Fatal(e)
+	}
+	bls2, _ := immutable.Balance(acc2.Address)
+	if immutable.GetPendingBlockHeight() != 2 || bls2.Hacash.GetValue().Cmp(fields.NewAmountSmall(2, 248).GetValue()) != 0 {
+		t.Fatal("write blocks error")
+	}
+	for i, tx := range txs {
+		if h, _ := immutable.ReadTxBelongHeightByHash(tx.Hash()); uint64(h) != uint64(i+1) {
+			t.Fatal("tx index error", i, h)
+		}
+	}
+
+	// undo record round trip
+	body, _ := base.BlockStore().(UndoRecordStore).ReadUndoRecord(2)
+	record := &UndoRecord{}
+	if _, e := record.Parse(body, 0); e != nil {
+		t.Fatal(e)
+	}
+	body2, _ := record.Serialize()
+	if len(body) != int(record.Size()) || string(body) != string(body2) {
+		t.Fatal("undo record serialize error")
+	}
+
+	// every block undone restores the balance, tx index, pending status and state root
+	mem := immutable.(*MemoryChainState)
+	rollbacker := immutable.(interfaces.BlockRollbacker)
+	for target := 1; target >= 0; target-- {
+		height, e := rollbacker.RollbackToBlockHeight(uint64(target))
+		if e != nil || height != uint64(target) {
+			t.Fatal("rollback error", target, e)
+		}
+		if hash, _ := mem.BlockStore().ReadBlockHashByHeight(uint64(target) + 1); hash != nil {
+			t.Fatal("block hash of the height rolled back is kept", target+1)
+		}
+		bls2, _ = immutable.Balance(acc2.Address)
+		if target == 0 && bls2 != nil {
+			t.Fatal("balance must be deleted")
+		}
+		if target == 1 && bls2.Hacash.GetValue().Cmp(fields.NewAmountSmall(1, 248).GetValue()) != 0 {
+			t.Fatal("balance not restored")
+		}
+		for i, tx := range txs {
+			ok, _ := immutable.CheckTxHash(tx.Hash())
+			if ok != (i < target) {
+				t.Fatal("tx index not restored", target, i)
+			}
+		}
+		if immutable.GetPendingBlockHeight() != uint64(target) || !immutable.GetPendingBlockHash().Equal(hashs[target]) {
+			t.Fatal("pending status not restored", target)
+		}
+		if root, _ := mem.StateRoot(); !root.Equal(roots[target]) || !root.Equal(BuildStateTree(mem).Root()) {
+			t.Fatal("state root not restored", target)
+		}
+	}
+	bls1, _ := immutable.Balance(acc1.Address)
+	if bls1.Hacash.GetValue().Cmp(fields.NewAmountSmall(100, 248).GetValue()) != 0 {
+		t.Fatal("rollback to 0 error")
+	}
+	// no more undo record
+	if _, e := rollbacker.RollbackToBlockHeight(0); e != nil {
+		t.Fatal(e)
+	}
+}
+
+func Test_kv_state(t *testing.T) {
+
+	acc1 := account.CreateAccountByPassword("123456")
+	acc2 := account.CreateAccountByPassword("qwerty")
+	db := kvdb.NewMemoryDB()
+	base, e := OpenKVChainStateImmutable(db, NewKVBlockStore(db))
+	if e != nil {
+		t.Fatal(e)
+	}
+	fork, _ := base.ForkNextBlock(1, fields.CalculateHash([]byte{1}), nil)
+	fork.BalanceSet(acc1.Address, stores.NewBalanceWithAmount(fields.NewAmountSmall(100, 248)))
+	var state interfaces.ChainState = fork
+	for i := uint64(2); i <= 3; i++ {
+		next, _ := state.ForkNextBlock(i, fields.CalculateHash([]byte{byte(i)}), nil)
+		journal := NewStateJournal(next)
+		tx, _ := transactions.NewEmptyTransaction_2_Simple(acc1.Address)
+		tx.Timestamp = fields.BlockTxTimestamp(i)
+		tx.AppendAction(actions.NewAction_1_SimpleToTransfer(acc2.Address, fields.NewAmountSmall(10, 248)))
+		if e := tx.WriteInChainState(journal); e != nil {
+			t.Fatal(e)
+		}
+		next.(*MemoryChainState).undoItems = journal.GetJournalItems()
+		state = next
+	}
+	if _, e := state.ImmutableWriteToDisk(); e != nil {
+		t.Fatal(e)
+	}
+
+	// reopen from the database
+	reopen, e := OpenKVChainStateImmutable(db, NewKVBlockStore(db))
+	if e != nil {
+		t.Fatal(e)
+	}
+	bls2, _ := reopen.Balance(acc2.Address)
+	if reopen.GetPendingBlockHeight() != 3 || bls2 == nil || bls2.Hacash.GetValue().Cmp(fields.NewAmountSmall(20, 248).GetValue()) != 0 {
+		t.Fatal("reopen error")
+	}
+	if len(reopen.datas) != 0 {
+		t.Fatal("state items are loaded into memory")
+	}
+	balances := 0
+	reopen.TraversalItems(KeyPrefixBalance, func(key []byte, body []byte) bool {
+		balances++
+		return true
+	})
+	if balances != 2 {
+		t.Fatal("traversal items count need 2 but got", balances)
+	}
+
+	// btc move log page count is kept under its own key
+	store := NewKVBlockStore(db)
+	for _, page := range []int{1, 2, 2} {
+		if e := store.SaveBTCMoveLogPageData(page, []*stores.SatoshiGenesis{}); e != nil {
+			t.Fatal(e)
+		}
+	}
+	if n, _ := NewKVBlockStore(db).GetBTCMoveLogTotalPage(); n != 2 {
+		t.Fatal("btc move log total page need 2 but got", n)
+	}
+
+	// rollback is saved too
+	if _, e := reopen.RollbackToBlockHeight(2); e != nil {
+		t.Fatal(e)
+	}
+	reopen, _ = OpenKVChainStateImmutable(db, NewKVBlockStore(db))
+	bls2, _ = reopen.Balance(acc2.Address)
+	if reopen.GetPendingBlockHeight() != 2 || bls2.Hacash.GetValue().Cmp(fields.NewAmountSmall(10, 248).GetValue()) != 0 {
+		t.Fatal("rollback not saved")
+	}
+}
+
+func Test_flat_block_store(t *testing.T) {
+
+	acc1 := account.CreateAccountByPassword("123456")
+	acc2 := account.CreateAccountByPassword("qwerty")
+	dir, _ := ioutil.TempDir("", "flatblock")
+	defer os.RemoveAll(dir)
+
+	store, e := OpenFlatBlockStore(dir, kvdb.NewMemoryDB())
+	if e != nil {
+		t.Fatal(e)
+	}
+	store.SetNoSync(true)
+	store.SetSegmentSize(400) // two blocks a segment
+	newblock := func(height uint64, nonce uint32) (*blocks.Block_v1, interfaces.Transaction) {
+		tx := testchain.NewTx(height*10+uint64(nonce), acc1.Address, nil, actions.NewAction_1_SimpleToTransfer(acc2.Address, fields.NewAmountSmall(1, 248)))
+		blk := testchain.NewBlockAtHeight(height, acc1.Address, tx)
+		blk.Nonce = fields.VarUint4(nonce)
+		return blk, tx
+	}
+	txs := make([]interfaces.Transaction, 0)
+	for i := uint64(1); i <= 5; i++ {
+		blk, tx := newblock(i, 0)
+		if e := store.SaveBlock(blk); e != nil {
+			t.Fatal(e)
+		}
+		store.UpdateSetBlockHashReferToHeight(i, blk.Hash())
+		txs = append(txs, tx)
+	}
+	segments, _ := filepath.Glob(filepath.Join(dir, "blk*.dat"))
+	if len(segments) < 2 {
+		t.Fatal("segment not rotated", len(segments))
+	}
+
+	// reorg the height 5
+	fork, forktx := newblock(5, 1)
+	store.SaveBlock(fork)
+	if e := store.UpdateSetBlockHashReferToHeight(5, fork.Hash()); e != nil {
+		t.Fatal(e)
+	}
+	if _, txbody, _ := store.ReadTransactionBytesByHash(txs[4].Hash()); txbody != nil {
+		t.Fatal("tx of the replaced block is still indexed")
+	}
+	maintxs := []interfaces.Transaction{txs[0], txs[1], txs[2], txs[3], forktx}
+	if e := store.UpdateSetBlockHashReferToHeight(6, fields.CalculateHash([]byte{6})); e == nil {
+		t.Fatal("refer to a block not saved")
+	}
+	store.Close()
+
+	// reopen and cut the unfinished tail
+	segments, _ = filepath.Glob(filepath.Join(dir, "blk*.dat"))
+	last := segments[len(segments)-1]
+	body, _ := ioutil.ReadFile(last)
+	ioutil.WriteFile(last, append(body, 'H', 'B', 'L', 'K', 0, 0, 1), 0666)
+	store, e = OpenFlatBlockStore(dir, kvdb.NewMemoryDB())
+	if e != nil {
+		t.Fatal(e)
+	}
+	if store.RepairedBytes() != 7 {
+		t.Fatal("tail not cut", store.RepairedBytes())
+	}
+	checkFlatBlockStore(t, store, fork, maintxs, txs[4])
+	store.Close()
+
+	// block index lost, rebuilt by scanning the segments
+	index := filepath.Join(dir, "block.idx")
+	stat, _ := os.Stat(index)
+	os.Remove(index)
+	store, e = OpenFlatBlockStore(dir, kvdb.NewMemoryDB())
+	if e != nil {
+		t.Fatal(e)
+	}
+	if restat, _ := os.Stat(index); restat == nil || restat.Size() != stat.Size() {
+		t.Fatal("block index not rebuilt")
+	}
+	checkFlatBlockStore(t, store, fork, maintxs, txs[4])
+	store.Close()
+
+	// broken record in the block index is found on read
+	first := filepath.Join(dir, "blk00000.dat")
+	body, _ = ioutil.ReadFile(first)
+	body[len(body)-1] ^= 0xff
+	ioutil.WriteFile(first, body, 0666)
+	store, e = OpenFlatBlockStore(dir, kvdb.NewMemoryDB())
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer store.Close()
+	hash1, _ := store.ReadBlockHashByHeight(1)
+	if _, e := store.ReadBlockBytesByHash(hash1); e == nil {
+		t.Fatal("broken record must fail on read")
+	}
+	if _, _, e := store.ReadTransactionBytesByHash(maintxs[0].Hash()); e == nil {
+		t.Fatal("tx of the broken record must fail on read")
+	}
+
+	// rolled back height is cut off the height index
+	if e := store.DeleteBlockHashReferToHeight(5); e != nil {
+		t.Fatal(e)
+	}
+	if hash, _ := store.ReadBlockHashByHeight(5); hash != nil || len(store.heights) != 5 {
+		t.Fatal("height 5 not deleted")
+	}
+}
+
+// Main chain of the heights 1 to 5, the block at 5 is the fork
+func checkFlatBlockStore(t *testing.T, store *FlatBlockStore, fork interfaces.Block, txs []interfaces.Transaction, droptx interfaces.Transaction) {
+	hash, body, e := store.ReadBlockBytesByHeight(5)
+	if e != nil || !hash.Equal(fork.Hash()) {
+		t.Fatal("reorg not saved", e)
+	}
+	blk, _, e := blocks.ParseBlock(body, 0)
+	if e != nil || !blk.Hash().Equal(fork.Hash()) {
+		t.Fatal("block body error", e)
+	}
+	hash3, _ := store.ReadBlockHashByHeight(3)
+	body3, _ := store.ReadBlockBytesByHash(hash3)
+	if blk, _, e := blocks.ParseBlock(body3, 0); e != nil || blk.GetHeight() != 3 {
+		t.Fatal("read by hash error", e)
+	}
+	if hash, _ := store.ReadBlockHashByHeight(9); hash != nil {
+		t.Fatal("height 9 must be empty")
+	}
+	if hash, _ := store.ReadBlockHashByHeight(0); hash != nil {
+		t.Fatal("height 0 not set")
+	}
+	for i, tx := range txs {
+		height, txbody, e := store.ReadTransactionBytesByHash(tx.Hash())
+		if e != nil || height != uint64(i)+1 {
+			t.Fatal("tx index error", e)
+		}
+		txsrc, _ := tx.Serialize()
+		if string(txbody) != string(txsrc) {
+			t.Fatal("tx body error")
+		}
+	}
+	if _, txbody, _ := store.ReadTransactionBytesByHash(droptx.Hash()); txbody != nil {
+		t.Fatal("tx of the replaced block is still indexed")
+	}
+}
+
+func Test_flat_block_prune(t *testing.T) {
+
+	acc1 := account.CreateAccountByPassword("123456")
+	dir, _ := ioutil.TempDir("", "flatprune")
+	defer os.RemoveAll(dir)
+
+	meta := kvdb.NewMemoryDB()
+	store, e := OpenFlatBlockStore(dir, meta)
+	if e != nil {
+		t.Fatal(e)
+	}
+	store.SetNoSync(true)
+	store.SetSegmentSize(400)
+	diamond := &stores.DiamondSmelt{Diamond: fields.DiamondName("WTYUIA")}
+	store.SaveDiamond(diamond)
+	store.SetPruneKeepBlocks(3)
+	hashs := make([]fields.Hash, 11)
+	for i := uint64(1); i <= 10; i++ {
+		blk := testchain.NewBlockAtHeight(i, acc1.Address)
+		store.SaveBlock(blk)
+		store.SaveUndoRecord(i, []byte{byte(i)})
+		if e := store.UpdateSetBlockHashReferToHeight(i, blk.Hash()); e != nil {
+			t.Fatal(e)
+		}
+		hashs[i] = blk.Hash()
+	}
+	pruned := store.GetPrunedHeight()
+	fmt.Println("pruned height", pruned)
+	if pruned < 2 || pruned > 8 {
+		t.Fatal("prune height error", pruned)
+	}
+
+	check := func(store *FlatBlockStore) {
+		if _, _, e := store.ReadBlockBytesByHeight(1); !IsBlockPrunedError(e) {
+			t.Fatal("height 1 must be pruned", e)
+		}
+		if _, e := store.ReadBlockBytesByHash(hashs[1]); !IsBlockPrunedError(e) {
+			t.Fatal("hash of height 1 must be pruned", e)
+		}
+		hash, head, e := store.ReadBlockHeadBytesByHeight(1)
+		if e != nil || !hash.Equal(hashs[1]) {
+			t.Fatal("head of height 1 lost", e)
+		}
+		blk, _, e := blocks.ParseExcludeTransactions(head, 0)
+		if e != nil || blk.GetHeight() != 1 {
+			t.Fatal("head body error", e)
+		}
+		for i := uint64(8); i <= 10; i++ {
+			if hash, body, e := store.ReadBlockBytesByHeight(i); e != nil || body == nil || !hash.Equal(hashs[i]) {
+				t.Fatal("last blocks must be kept", i, e)
+			}
+		}
+		if undo, _ := store.ReadUndoRecord(1); undo != nil {
+			t.Fatal("old undo record not pruned")
+		}
+		if undo, _ := store.ReadUndoRecord(10); undo == nil {
+			t.Fatal("last undo record pruned")
+		}
+		if d, _ := store.ReadDiamond(diamond.Diamond); d == nil {
+			t.Fatal("diamond must be kept")
+		}
+	}
+	check(store)
+
+	// pruned heights are kept after reopen
+	reopen := kvdb.NewMemoryDB()
+	meta.Iterate(nil, func(key []byte, value []byte) bool {
+		reopen.Put(key, value)
+		return true
+	})
+	store.Close()
+	store, e = OpenFlatBlockStore(dir, reopen)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer store.Close()
+	if store.GetPrunedHeight() != pruned {
+		t.Fatal("pruned height changed", store.GetPrunedHeight())
+	}
+	check(store)
+}
+
+// Replace S of the signature by N-S, still verifies the same hash
+func makeHighSSign(sign *fields.Sign) {
+	sig, _ := btcec.ParseSignatureByte64(sign.Signature)
+	s := new(big.Int).Sub(btcec.S256().N, sig.S)
+	body := make([]byte, 64)
+	copy(body, sign.Signature[:32])
+	sb := s.Bytes()
+	copy(body[64-len(sb):], sb)
+	sign.Signature = body
+}
+
+func Test_canonical_sign_activation(t *testing.T) {
+
+	defer func(height uint64, mark bool) {
+		sys.SignatureCanonicalCheckHeight = height
+		sys.TestDebugLocalDevelopmentMark = mark
+	}(sys.SignatureCanonicalCheckHeight, sys.TestDebugLocalDevelopmentMark)
+	sys.SignatureCanonicalCheckHeight = 300010
+	sys.TestDebugLocalDevelopmentMark = true // action 25
+
+	acc1 := account.CreateAccountByPassword("123456")
+	acc2 := account.CreateAccountByPassword("qwerty")
+	cid := fields.ChannelId([]byte("0123456789abcdef"))
+	base := NewMemoryChainStateImmutable(nil)
+	newtx := func(act interfacev2.Action) *transactions.Transaction_2_Simple {
+		tx, _ := transactions.NewEmptyTransaction_2_Simple(acc1.Address)
+		tx.Timestamp = 1618839281
+		tx.Fee = *fields.NewAmountSmall(1, 246)
+		tx.AppendAction(act)
+		return tx
+	}
+	write := func(height uint64, tx interfaces.Transaction) error {
+		fork, _ := base.ForkNextBlock(height, nil, nil)
+		defer fork.Destory()
+		fork.BalanceSet(acc1.Address, stores.NewBalanceWithAmount(fields.NewAmountSmall(100, 248)))
+		paychan := stores.CreateEmptyChannel()
+		paychan.ArbitrationLockBlock = 5000
+		paychan.LeftAddress = acc1.Address
+		paychan.LeftAmount = *fields.NewAmountSmall(10, 248)
+		paychan.RightAddress = acc2.Address
+		paychan.RightAmount = *fields.NewAmountSmall(10, 248)
+		paychan.Status = stores.ChannelStatusOpening
+		fork.ChannelCreate(cid, paychan)
+		return tx.WriteInChainState(fork)
+	}
+
+	// type 2 transaction signature
+	tx2 := newtx(actions.NewAction_1_SimpleToTransfer(acc2.Address, fields.NewAmountSmall(12, 248)))
+	tx2.FillNeedSigns(map[string][]byte{string(acc1.Address): acc1.PrivateKey}, nil)
+	makeHighSSign(&tx2.Signs[0])
+	if ok, _ := tx2.VerifyAllNeedSigns(); !ok {
+		t.Fatal("high S signature of tx not verify")
+	}
+
+	// action 23 reconciliation signatures
+	act23 := &actions.Action_23_UnilateralCloseOrRespondChallengePaymentChannelByRealtimeReconciliation{
+		AssertAddress: acc1.Address,
+		Reconciliation: channel.OnChainArbitrationBasisReconciliation{
+			ChannelId:      cid,
+			ReuseVersion:   1,
+			BillAutoNumber: 1,
+			LeftBalance:    *fields.NewAmountSmall(12, 248),
+			RightBalance:   *fields.NewAmountSmall(8, 248),
+			LeftSatoshi:    fields.NewEmptySatoshiVariation(),
+			RightSatoshi:   fields.NewEmptySatoshiVariation(),
+		},
+	}
+	act23.Reconciliation.FillSigns(acc1, acc2)
+	makeHighSSign(&act23.Reconciliation.LeftSign)
+	makeHighSSign(&act23.Reconciliation.RightSign)
+
+	// action 24 channel chain transfer signatures
+	body := channel.CreateEmptyProveBody(cid)
+	body.ReuseVersion = 1
+	body.BillAutoNumber = 1
+	body.PayDirection = 1
+	body.PayAmount = *fields.NewAmountSmall(2, 248)
+	body.LeftBalance = *fields.NewAmountSmall(8, 248)
+	body.RightBalance = *fields.NewAmountSmall(12, 248)
+	body.LeftAddress = acc1.Address
+	body.RightAddress = acc2.Address
+	act24 := &actions.Action_24_UnilateralCloseOrRespondChallengePaymentChannelByChannelChainTransferBody{
+		AssertAddress: acc1.Address,
+		ChannelChainTransferData: channel.OffChainFormPaymentChannelTransfer{
+			Timestamp:                            1618839281,
+			OrderNoteHashHalfChecker:             fields.HashHalfChecker(make([]byte, 16)),
+			MustSignCount:                        2,
+			MustSignAddresses:                    []fields.Address{acc1.Address, acc2.Address},
+			ChannelCount:                         1,
+			ChannelTransferProveHashHalfCheckers: []fields.HashHalfChecker{body.GetSignStuffHashHalfChecker()},
+			MustSigns:                            make([]fields.Sign, 2),
+		},
+		ChannelChainTransferTargetProveBody: *body,
+	}
+	for i, acc := range []*account.Account{acc1, acc2} {
+		act24.ChannelChainTransferData.DoSignFillPosition(acc)
+		makeHighSSign(&act24.ChannelChainTransferData.MustSigns[i])
+	}
+
+	// action 25 exchange evidence signatures
+	act25 := &actions.Action_25_PaymantChannelAndOnchainAtomicExchange{
+		ExchangeEvidence: actions.ChannelAmountAndOnChainAmountTransferEachOtherByAtomicExchange{
+			ChannelTranferProveBodyHashChecker:      fields.HashHalfChecker([]byte("fedcba9876543210")),
+			OnChainTranferToAddress:                 acc2.Address,
+			OnChainTranferAmount:                    *fields.NewAmountSmall(3, 248),
+			AddressCount:                            2,
+			OnchainTransferFromAndMustSignAddresses: []fields.Address{acc1.Address, acc2.Address},
+		},
+	}
+	hash25, _ := act25.ExchangeEvidence.SignStuffHash()
+	for _, acc := range []*account.Account{acc1, acc2} {
+		signature, _ := acc.Private.Sign(hash25)
+		sign := fields.Sign{PublicKey: acc.PublicKey, Signature: signature.Serialize64()}
+		makeHighSSign(&sign)
+		act25.ExchangeEvidence.MustSigns = append(act25.ExchangeEvidence.MustSigns, sign)
+	}
+
+	// accepted below the height, rejected at and above it
+	for i, tx := range []interfaces.Transaction{tx2, newtx(act23), newtx(act24), newtx(act25)} {
+		if e := write(sys.SignatureCanonicalCheckHeight-1, tx); e != nil {
+			t.Fatal(i, "high S signature rejected before the check height:", e)
+		}
+		for _, height := range []uint64{sys.SignatureCanonicalCheckHeight, sys.SignatureCanonicalCheckHeight + 1} {
+			if e := write(height, tx); e == nil || !strings.Contains(e.Error(), "not canonical") {
+				t.Fatal(i, "high S signature accepted at height", height, e)
+			}
+		}
+	}
+}
+
+// Two round musig of the accounts in order
+func newTestAggregatedSign(t *testing.T, hash fields.Hash, accs ...*account.Account) fields.AggregatedSign {
+	pubkeys := make([][]byte, len(accs))
+	for i, acc := range accs {
+		pubkeys[i] = acc.PublicKey
+	}
+	keyagg, _ := btcec.MuSigAggregateKeys(pubkeys)
+	secnonces, pubnonces := make([]*btcec.MuSigSecNonce, len(accs)), make([][]byte, len(accs))
+	for i, acc := range accs {
+		rand := fields.CalculateHash([]byte(fmt.Sprintf("nonce rand of account %d", i)))
+		secnonces[i], pubnonces[i], _ = btcec.MuSigNonceGen(acc.Private, keyagg, hash, rand)
+	}
+	aggnonce, _ := btcec.MuSigNonceAgg(pubnonces)
+	session, _ := btcec.NewMuSigSession(keyagg, aggnonce, hash)
+	partials := make([][]byte, len(accs))
+	for i, acc := range accs {
+		partials[i], _ = btcec.MuSigPartialSign(secnonces[i], acc.Private, session)
+	}
+	sig, _ := btcec.MuSigPartialSigAgg(partials, session)
+	aggsign, e := fields.NewAggregatedSign(pubkeys, sig)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return *aggsign
+}
+
+func Test_aggregated_sign_exchange(t *testing.T) {
+
+	defer func(height uint64, mark bool) {
+		sys.AggregatedSignActiveHeight = height
+		sys.TestDebugLocalDevelopmentMark = mark
+	}(sys.AggregatedSignActiveHeight, sys.TestDebugLocalDevelopmentMark)
+	sys.AggregatedSignActiveHeight = 300010
+	sys.TestDebugLocalDevelopmentMark = true
+
+	acc1 := account.CreateAccountByPassword("123456")
+	acc2 := account.CreateAccountByPassword("qwerty")
+	act := &actions.Action_32_PaymantChannelAndOnchainAtomicExchangeByAggregatedSign{
+		ExchangeEvidence: actions.ChannelAmountAndOnChainAmountTransferEachOtherByAtomicExchange{
+			ChannelTranferProveBodyHashChecker:      fields.HashHalfChecker([]byte("fedcba9876543210")),
+			OnChainTranferToAddress:                 acc2.Address,
+			OnChainTranferAmount:                    *fields.NewAmountSmall(3, 248),
+			AddressCount:                            2,
+			OnchainTransferFromAndMustSignAddresses: []fields.Address{acc1.Address, acc2.Address},
+		},
+	}
+	hash, _ := act.ExchangeEvidence.SignStuffHash()
+	act.AggregatedSign = newTestAggregatedSign(t, hash, acc1, acc2)
+
+	// binary
+	body, _ := act.Serialize()
+	act2, seek, e := actions.ParseAction(body, 0)
+	if e != nil || int(seek) != len(body) || act2.Size() != uint32(len(body)) {
+		t.Fatal("parse action 32 error", e)
+	}
+	fmt.Println(len(body), "bytes of action 32")
+
+	tx, _ := transactions.NewEmptyTransaction_2_Simple(acc1.Address)
+	tx.Timestamp = 1618839281
+	tx.Fee = *fields.NewAmountSmall(1, 246)
+	tx.AppendAction(act2.(interfacev2.Action))
+	base := NewMemoryChainStateImmutable(nil)
+	write := func(height uint64) (interfaces.ChainState, error) {
+		fork, _ := base.ForkNextBlock(height, nil, nil)
+		fork.BalanceSet(acc1.Address, stores.NewBalanceWithAmount(fields.NewAmountSmall(100, 248)))
+		return fork, tx.WriteInChainState(fork)
+	}
+	if _, e := write(sys.AggregatedSignActiveHeight - 1); e == nil {
+		t.Fatal("action 32 accepted before the active height")
+	}
+	fork, e := write(sys.AggregatedSignActiveHeight)
+	if e != nil {
+		t.Fatal(e)
+	}
+	bls2, _ := fork.Balance(acc2.Address)
+	chaswap, _ := fork.Chaswap(act.ExchangeEvidence.ChannelTranferProveBodyHashChecker)
+	if bls2 == nil || !bls2.Hacash.Equal(fields.NewAmountSmall(3, 248)) || chaswap == nil {
+		t.Fatal("action 32 write state error")
+	}
+
+	// wrong order of the signed addresses
+	act.ExchangeEvidence.OnchainTransferFromAndMustSignAddresses = []fields.Address{acc2.Address, acc1.Address}
+	if act.CheckMustAddressAndSign() == nil {
+		t.Fatal("aggregated sign of other address order verified")
+	}
+}
+
+func Test_aggregated_sign_channel_and_lending(t *testing.T) {
+
+	defer func(height uint64, mark bool) {
+		sys.AggregatedSignActiveHeight = height
+		sys.TestDebugLocalDevelopmentMark = mark
+	}(sys.AggregatedSignActiveHeight, sys.TestDebugLocalDevelopmentMark)
+	sys.AggregatedSignActiveHeight = 300010
+	sys.TestDebugLocalDevelopmentMark = true // action 19
+
+	acc1 := account.CreateAccountByPassword("123456")
+	acc2 := account.CreateAccountByPassword("qwerty")
+	cid := fields.ChannelId([]byte("0123456789abcdef"))
+	base := NewMemoryChainStateImmutable(nil)
+	write := func(height uint64, act interfacev2.Action) (interfaces.ChainState, error) {
+		body, _ := act.Serialize()
+		act2, seek, e := actions.ParseAction(body, 0)
+		if e != nil || int(seek) != len(body) || act2.Size() != uint32(len(body)) {
+			t.Fatal("parse action error", act.Kind(), e)
+		}
+		tx, _ := transactions.NewEmptyTransaction_2_Simple(acc1.Address)
+		tx.Timestamp = 1618839281
+		tx.Fee = *fields.NewAmountSmall(1, 246)
+		tx.AppendAction(act2.(interfacev2.Action))
+		fork, _ := base.ForkNextBlock(height, nil, nil)
+		bls := stores.NewBalanceWithAmount(fields.NewAmountSmall(100, 248))
+		bls.Satoshi = 50000
+		fork.BalanceSet(acc1.Address, bls)
+		fork.BalanceSet(acc2.Address, stores.NewBalanceWithAmount(fields.NewAmountSmall(100, 248)))
+		paychan := stores.CreateEmptyChannel()
+		paychan.ArbitrationLockBlock = 5000
+		paychan.LeftAddress = acc1.Address
+		paychan.LeftAmount = *fields.NewAmountSmall(10, 248)
+		paychan.RightAddress = acc2.Address
+		paychan.RightAmount = *fields.NewAmountSmall(10, 248)
+		paychan.Status = stores.ChannelStatusOpening
+		fork.ChannelCreate(cid, paychan)
+		return fork, tx.WriteInChainState(fork)
+	}
+
+	// action 33 channel chain transfer
+	body := channel.CreateEmptyProveBody(cid)
+	body.ReuseVersion = 1
+	body.BillAutoNumber = 1
+	body.PayDirection = 1
+	body.PayAmount = *fields.NewAmountSmall(2, 248)
+	body.LeftBalance = *fields.NewAmountSmall(8, 248)
+	body.RightBalance = *fields.NewAmountSmall(12, 248)
+	body.LeftAddress = acc1.Address
+	body.RightAddress = acc2.Address
+	act33 := &actions.Action_33_UnilateralCloseOrRespondChallengePaymentChannelByChannelChainTransferBodyAggregatedSign{
+		AssertAddress: acc1.Address,
+		ChannelChainTransferData: channel.OffChainFormPaymentChannelTransfer{
+			Timestamp:                            1618839281,
+			OrderNoteHashHalfChecker:             fields.HashHalfChecker(make([]byte, 16)),
+			MustSignCount:                        2,
+			MustSignAddresses:                    []fields.Address{acc2.Address, acc1.Address},
+			ChannelCount:                         1,
+			ChannelTransferProveHashHalfCheckers: []fields.HashHalfChecker{body.GetSignStuffHashHalfChecker()},
+		},
+		ChannelChainTransferTargetProveBody: *body,
+	}
+	act33.AggregatedSign = newTestAggregatedSign(t, act33.ChannelChainTransferData.GetSignStuffHash(), acc2, acc1)
+
+	// action 34 user lending
+	act34 := &actions.Action_34_UsersLendingCreateByAggregatedSign{
+		Lending: actions.Action_19_UsersLendingCreate{
+			LendingID:                fields.UserLendingId([]byte("0123456789abcdefg")),
+			IsRedemptionOvertime:     fields.CreateBool(false),
+			IsPublicRedeemable:       fields.CreateBool(false),
+			AgreedExpireBlockHeight:  fields.BlockHeight(sys.AggregatedSignActiveHeight + 1000),
+			MortgagorAddress:         acc1.Address,
+			LenderAddress:            acc2.Address,
+			MortgageBitcoin:          fields.Satoshi(10000).GetSatoshiVariation(),
+			MortgageDiamondList:      *fields.NewEmptyDiamondListMaxLen200(),
+			LoanTotalAmount:          *fields.NewAmountSmall(20, 248),
+			AgreedRedemptionAmount:   *fields.NewAmountSmall(21, 248),
+			PreBurningInterestAmount: *fields.NewAmountSmall(2, 247),
+		},
+	}
+	act34.AggregatedSign = newTestAggregatedSign(t, act34.SignStuffHash(), acc1, acc2)
+
+	for _, act := range []interfacev2.Action{act33, act34} {
+		if _, e := write(sys.AggregatedSignActiveHeight-1, act); e == nil {
+			t.Fatal("action", act.Kind(), "accepted before the active height")
+		}
+		if _, e := write(sys.AggregatedSignActiveHeight, act); e != nil {
+			t.Fatal(act.Kind(), e)
+		}
+	}
+	fork, _ := write(sys.AggregatedSignActiveHeight, act34)
+	lending, _ := fork.UserLending(act34.Lending.LendingID)
+	bls1, _ := fork.Balance(acc1.Address)
+	if lending == nil || bls1.Satoshi != 40000 {
+		t.Fatal("action 34 write state error")
+	}
+
+	// signed by other parties or in another order
+	act33.AggregatedSign = newTestAggregatedSign(t, act33.ChannelChainTransferData.GetSignStuffHash(), acc1, acc2)
+	act34.Lending.LoanTotalAmount = *fields.NewAmountSmall(30, 248)
+	for _, act := range []interfacev2.Action{act33, act34} {
+		if _, e := write(sys.AggregatedSignActiveHeight, act); e == nil {
+			t.Fatal("action", act.Kind(), "accepted with a wrong aggregated sign")
+		}
+	}
+}
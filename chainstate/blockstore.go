@@ -0,0 +1,248 @@
+package chainstate
+
+import (
+	"fmt"
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/interfaces"
+	"github.com/hacash/core/stores"
+	"sync"
+)
+
+// Read transaction content from block store
+type TransactionBytesReader interface {
+	ReadTransactionBytesByHash(fields.Hash) (uint64, []byte, error)
+}
+
+// Block heads kept without the bodies, for pruned nodes and the sync from state snapshot
+type BlockHeadStore interface {
+	ReadBlockHeadBytesByHeight(uint64) (fields.Hash, []byte, error)
+	SaveBlockHead(height uint64, hash fields.Hash, head []byte) error
+}
+
+type memoryTxItem struct {
+	height uint64
+	body   []byte
+}
+
+// Block store that keeps everything in memory
+type MemoryBlockStore struct {
+	blocks        map[string][]byte // block hash => block body
+	heightToHash  map[uint64]fields.Hash
+	transactions  map[string]*memoryTxItem // tx hash => height and body
+	diamonds      map[string]*stores.DiamondSmelt
+	diamondNumber map[uint32]fields.DiamondName
+	btcMoveLogs   map[int][]*stores.SatoshiGenesis
+	undoRecords   map[uint64][]byte
+
+	mux sync.RWMutex
+}
+
+func NewMemoryBlockStore() *MemoryBlockStore {
+	return &MemoryBlockStore{
+		blocks:        make(map[string][]byte),
+		heightToHash:  make(map[uint64]fields.Hash),
+		transactions:  make(map[string]*memoryTxItem),
+		diamonds:      make(map[string]*stores.DiamondSmelt),
+		diamondNumber: make(map[uint32]fields.DiamondName),
+		btcMoveLogs:   make(map[int][]*stores.SatoshiGenesis),
+		undoRecords:   make(map[uint64][]byte),
+	}
+}
+
+func (m *MemoryBlockStore) Close() {}
+
+// save block and index all transactions
+func (m *MemoryBlockStore) SaveBlock(block interfaces.Block) error {
+	body, e := block.Serialize()
+	if e != nil {
+		return e
+	}
+	height := block.GetHeight()
+	txs := make(map[string]*memoryTxItem)
+	for i, tx := range block.GetTrsList() {
+		if i == 0 {
+			continue // drop coinbase
+		}
+		txbody, e := tx.Serialize()
+		if e != nil {
+			return e
+		}
+		txs[string(tx.Hash())] = &memoryTxItem{height, txbody}
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.blocks[string(block.Hash())] = body
+	for k, v := range txs {
+		m.transactions[k] = v
+	}
+	return nil
+}
+
+func (m *MemoryBlockStore) UpdateSetBlockHashReferToHeight(height uint64, hash fields.Hash) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.heightToHash[height] = append([]byte{}, hash...)
+	return nil
+}
+
+func (m *MemoryBlockStore) DeleteBlockHashReferToHeight(height uint64) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	delete(m.heightToHash, height)
+	return nil
+}
+
+func (m *MemoryBlockStore) ReadBlockBytesByHash(hash fields.Hash) ([]byte, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	body, ok := m.blocks[string(hash)]
+	if !ok {
+		return nil, nil // not find
+	}
+	return body, nil
+}
+
+func (m *MemoryBlockStore) ReadBlockBytesByHeight(height uint64) (fields.Hash, []byte, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	hash, ok := m.heightToHash[height]
+	if !ok {
+		return nil, nil, nil // not find
+	}
+	return hash, m.blocks[string(hash)], nil
+}
+
+func (m *MemoryBlockStore) ReadBlockHashByHeight(height uint64) (fields.Hash, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	hash, ok := m.heightToHash[height]
+	if !ok {
+		return nil, nil // not find
+	}
+	return hash, nil
+}
+
+func (m *MemoryBlockStore) ReadTransactionBytesByHash(hash fields.Hash) (uint64, []byte, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	item, ok := m.transactions[string(hash)]
+	if !ok {
+		return 0, nil, nil // not find
+	}
+	return item.height, item.body, nil
+}
+
+func (m *MemoryBlockStore) SaveDiamond(diamond *stores.DiamondSmelt) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.diamonds[string(diamond.Diamond)] = diamond
+	return nil
+}
+
+func (m *MemoryBlockStore) UpdateSetDiamondNameReferToNumber(number uint32, name fields.DiamondName) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.diamondNumber[number] = name
+	return nil
+}
+
+func (m *MemoryBlockStore) ReadDiamond(name fields.DiamondName) (*stores.DiamondSmelt, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	diamond, ok := m.diamonds[string(name)]
+	if !ok {
+		return nil, nil // not find
+	}
+	return diamond, nil
+}
+
+func (m *MemoryBlockStore) ReadDiamondByNumber(number uint32) (*stores.DiamondSmelt, error) {
+	name, e := m.ReadDiamondNameByNumber(number)
+	if e != nil || name == nil {
+		return nil, e
+	}
+	return m.ReadDiamond(name)
+}
+
+func (m *MemoryBlockStore) ReadDiamondNameByNumber(number uint32) (fields.DiamondName, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	name, ok := m.diamondNumber[number]
+	if !ok {
+		return nil, nil // not find
+	}
+	return name, nil
+}
+
+// btc move log
+
+func (m *MemoryBlockStore) RunDownLoadBTCMoveLog() {}
+
+func (m *MemoryBlockStore) GetBTCMoveLogTotalPage() (int, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	return len(m.btcMoveLogs), nil
+}
+
+func (m *MemoryBlockStore) GetBTCMoveLogPageData(page int) ([]*stores.SatoshiGenesis, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	data, ok := m.btcMoveLogs[page]
+	if !ok {
+		return nil, fmt.Errorf("btc move log page %d not find", page)
+	}
+	return data, nil
+}
+
+func (m *MemoryBlockStore) SaveBTCMoveLogPageData(page int, data []*stores.SatoshiGenesis) error {
+	if page < 1 {
+		return fmt.Errorf("btc move log page number must start from 1")
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.btcMoveLogs[page] = data
+	return nil
+}
+
+// Verification is required only after any log page has been saved
+func (m *MemoryBlockStore) LoadValidatedSatoshiGenesis(trsno int64) (*stores.SatoshiGenesis, bool) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	if len(m.btcMoveLogs) == 0 || trsno < 1 {
+		return nil, false
+	}
+	limit := int64(stores.SatoshiGenesisLogStorePageLimit)
+	page := int((trsno-1)/limit) + 1
+	idx := int((trsno - 1) % limit)
+	data, ok := m.btcMoveLogs[page]
+	if !ok || idx >= len(data) {
+		return nil, true
+	}
+	return data[idx], true
+}
+
+/**************************** undo ****************************/
+
+func (m *MemoryBlockStore) SaveUndoRecord(height uint64, body []byte) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.undoRecords[height] = append([]byte{}, body...)
+	return nil
+}
+
+func (m *MemoryBlockStore) ReadUndoRecord(height uint64) ([]byte, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	body, ok := m.undoRecords[height]
+	if !ok {
+		return nil, nil // not find
+	}
+	return body, nil
+}
+
+func (m *MemoryBlockStore) DeleteUndoRecord(height uint64) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	delete(m.undoRecords, height)
+	return nil
+}
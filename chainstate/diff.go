@@ -0,0 +1,288 @@
+package chainstate
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/interfaces"
+	"github.com/hacash/core/stores"
+)
+
+// Kind of store item change
+const (
+	StateChangeCreate uint8 = 1
+	StateChangeUpdate uint8 = 2
+	StateChangeDelete uint8 = 3
+)
+
+// Balance change of one address
+type BalanceDiff struct {
+	Address fields.Address
+
+	HacashBefore *fields.Amount
+	HacashAfter  *fields.Amount
+	HacashDelta  *fields.Amount // may be negative
+
+	SatoshiBefore uint64
+	SatoshiAfter  uint64
+	SatoshiDelta  int64
+
+	DiamondBefore uint32
+	DiamondAfter  uint32
+	DiamondDelta  int64
+}
+
+// Diamond owner or status change
+type DiamondDiff struct {
+	Name   fields.DiamondName
+	Kind   uint8
+	Before *stores.Diamond // nil when created
+	After  *stores.Diamond // nil when deleted
+}
+
+type ChannelDiff struct {
+	Id     fields.ChannelId
+	Kind   uint8
+	Before *stores.Channel
+	After  *stores.Channel
+}
+
+type LockblsDiff struct {
+	Id     fields.LockblsId
+	Kind   uint8
+	Before *stores.Lockbls
+	After  *stores.Lockbls
+}
+
+type DiamondLendingDiff struct {
+	Id     fields.DiamondSyslendId
+	Kind   uint8
+	Before *stores.DiamondSystemLending
+	After  *stores.DiamondSystemLending
+}
+
+type BitcoinLendingDiff struct {
+	Id     fields.BitcoinSyslendId
+	Kind   uint8
+	Before *stores.BitcoinSystemLending
+	After  *stores.BitcoinSystemLending
+}
+
+type UserLendingDiff struct {
+	Id     fields.UserLendingId
+	Kind   uint8
+	Before *stores.UserLending
+	After  *stores.UserLending
+}
+
+type ChaswapDiff struct {
+	Id     fields.HashHalfChecker
+	Kind   uint8
+	Before *stores.Chaswap
+	After  *stores.Chaswap
+}
+
+// Change of one total supply counter
+type TotalSupplyDiff struct {
+	Type   uint8
+	Before float64
+	After  float64
+	Delta  float64
+}
+
+// All state changes of a transaction, in the order of first change
+type StateDiff struct {
+	Balances        []*BalanceDiff
+	Diamonds        []*DiamondDiff
+	Channels        []*ChannelDiff
+	Lockbls         []*LockblsDiff
+	DiamondLendings []*DiamondLendingDiff
+	BitcoinLendings []*BitcoinLendingDiff
+	UserLendings    []*UserLendingDiff
+	Chaswaps        []*ChaswapDiff
+	TotalSupply     []*TotalSupplyDiff
+}
+
+func (d *StateDiff) IsEmpty() bool {
+	return len(d.Balances) == 0 &&
+		len(d.Diamonds) == 0 &&
+		len(d.Channels) == 0 &&
+		len(d.Lockbls) == 0 &&
+		len(d.DiamondLendings) == 0 &&
+		len(d.BitcoinLendings) == 0 &&
+		len(d.UserLendings) == 0 &&
+		len(d.Chaswaps) == 0 &&
+		len(d.TotalSupply) == 0
+}
+
+// Find balance change by address, nil means not change
+func (d *StateDiff) FindBalance(addr fields.Address) *BalanceDiff {
+	for _, v := range d.Balances {
+		if v.Address.Equal(addr) {
+			return v
+		}
+	}
+	return nil
+}
+
+func newEmptyStoreItem(prefix byte) storeItem {
+	switch prefix {
+	case KeyPrefixBalance:
+		return stores.NewEmptyBalance()
+	case KeyPrefixDiamond:
+		return &stores.Diamond{}
+	case KeyPrefixChannel:
+		return stores.CreateEmptyChannel()
+	case KeyPrefixLockbls:
+		return &stores.Lockbls{}
+	case KeyPrefixDiamondLending:
+		return &stores.DiamondSystemLending{}
+	case KeyPrefixBitcoinLending:
+		return &stores.BitcoinSystemLending{}
+	case KeyPrefixUserLending:
+		return &stores.UserLending{}
+	case KeyPrefixChaswap:
+		return &stores.Chaswap{}
+	case KeyPrefixTotalSupply:
+		return stores.NewTotalSupplyStoreData()
+	}
+	return nil
+}
+
+func changeKind(before, after storeItem) uint8 {
+	if before == nil {
+		return StateChangeCreate
+	}
+	if after == nil {
+		return StateChangeDelete
+	}
+	return StateChangeUpdate
+}
+
+// Compare recorded items with current state
+func BuildStateDiff(state interfaces.ChainStateOperationRead, records []*RecordItem) (*StateDiff, error) {
+	diff := &StateDiff{}
+	for _, rcd := range records {
+		var before storeItem = nil
+		if rcd.Before != nil {
+			before = newEmptyStoreItem(rcd.Prefix)
+			if before == nil {
+				return nil, fmt.Errorf("store item prefix %d not support", rcd.Prefix)
+			}
+			if _, e := before.Parse(rcd.Before, 0); e != nil {
+				return nil, e
+			}
+		}
+		after, e := readStoreItem(state, rcd.Prefix, rcd.Key)
+		if e != nil {
+			return nil, e
+		}
+		if before == nil && after == nil {
+			continue
+		}
+		if before != nil && after != nil {
+			afterbts, e := after.Serialize()
+			if e != nil {
+				return nil, e
+			}
+			if bytes.Equal(rcd.Before, afterbts) {
+				continue // not change
+			}
+		}
+		kind := changeKind(before, after)
+		switch rcd.Prefix {
+		case KeyPrefixBalance:
+			diff.Balances = append(diff.Balances, newBalanceDiff(rcd.Key, before, after))
+		case KeyPrefixDiamond:
+			item := &DiamondDiff{Name: rcd.Key, Kind: kind}
+			item.Before, _ = before.(*stores.Diamond)
+			item.After, _ = after.(*stores.Diamond)
+			diff.Diamonds = append(diff.Diamonds, item)
+		case KeyPrefixChannel:
+			item := &ChannelDiff{Id: rcd.Key, Kind: kind}
+			item.Before, _ = before.(*stores.Channel)
+			item.After, _ = after.(*stores.Channel)
+			diff.Channels = append(diff.Channels, item)
+		case KeyPrefixLockbls:
+			item := &LockblsDiff{Id: rcd.Key, Kind: kind}
+			item.Before, _ = before.(*stores.Lockbls)
+			item.After, _ = after.(*stores.Lockbls)
+			diff.Lockbls = append(diff.Lockbls, item)
+		case KeyPrefixDiamondLending:
+			item := &DiamondLendingDiff{Id: rcd.Key, Kind: kind}
+			item.Before, _ = before.(*stores.DiamondSystemLending)
+			item.After, _ = after.(*stores.DiamondSystemLending)
+			diff.DiamondLendings = append(diff.DiamondLendings, item)
+		case KeyPrefixBitcoinLending:
+			item := &BitcoinLendingDiff{Id: rcd.Key, Kind: kind}
+			item.Before, _ = before.(*stores.BitcoinSystemLending)
+			item.After, _ = after.(*stores.BitcoinSystemLending)
+			diff.BitcoinLendings = append(diff.BitcoinLendings, item)
+		case KeyPrefixUserLending:
+			item := &UserLendingDiff{Id: rcd.Key, Kind: kind}
+			item.Before, _ = before.(*stores.UserLending)
+			item.After, _ = after.(*stores.UserLending)
+			diff.UserLendings = append(diff.UserLendings, item)
+		case KeyPrefixChaswap:
+			item := &ChaswapDiff{Id: rcd.Key, Kind: kind}
+			item.Before, _ = before.(*stores.Chaswap)
+			item.After, _ = after.(*stores.Chaswap)
+			diff.Chaswaps = append(diff.Chaswaps, item)
+		case KeyPrefixTotalSupply:
+			diff.TotalSupply = newTotalSupplyDiffs(before, after)
+		}
+	}
+	return diff, nil
+}
+
+func newBalanceDiff(addr fields.Address, before, after storeItem) *BalanceDiff {
+	bfr := stores.NewEmptyBalance()
+	aft := stores.NewEmptyBalance()
+	if before != nil {
+		bfr = before.(*stores.Balance)
+	}
+	if after != nil {
+		aft = after.(*stores.Balance)
+	}
+	delta, e := aft.Hacash.Sub(&bfr.Hacash)
+	if e != nil {
+		delta = fields.NewEmptyAmount()
+	}
+	return &BalanceDiff{
+		Address:       addr,
+		HacashBefore:  bfr.Hacash.Copy(),
+		HacashAfter:   aft.Hacash.Copy(),
+		HacashDelta:   delta,
+		SatoshiBefore: uint64(bfr.Satoshi),
+		SatoshiAfter:  uint64(aft.Satoshi),
+		SatoshiDelta:  int64(aft.Satoshi) - int64(bfr.Satoshi),
+		DiamondBefore: uint32(bfr.Diamond),
+		DiamondAfter:  uint32(aft.Diamond),
+		DiamondDelta:  int64(aft.Diamond) - int64(bfr.Diamond),
+	}
+}
+
+func newTotalSupplyDiffs(before, after storeItem) []*TotalSupplyDiff {
+	bfr := stores.NewTotalSupplyStoreData()
+	aft := stores.NewTotalSupplyStoreData()
+	if before != nil {
+		bfr = before.(*stores.TotalSupply)
+	}
+	if after != nil {
+		aft = after.(*stores.TotalSupply)
+	}
+	diffs := make([]*TotalSupplyDiff, 0)
+	for i := stores.TotalSupplyStoreTypeOfDiamond; i <= stores.TotalSupplyStoreTypeOfUsersLendingBurningOnePercentInterestHacAmount; i++ {
+		b, a := bfr.Get(i), aft.Get(i)
+		if a == b {
+			continue
+		}
+		diffs = append(diffs, &TotalSupplyDiff{
+			Type:   i,
+			Before: b,
+			After:  a,
+			Delta:  a - b,
+		})
+	}
+	return diffs
+}
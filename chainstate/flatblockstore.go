@@ -0,0 +1,807 @@
+package chainstate
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/interfaces"
+	"github.com/hacash/core/kvdb"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+/**
+ * Block store that appends blocks to rotating segment files
+ * segment "blk00000.dat": records one after another
+ * record:  magic (4) + payload size (4) + crc32 of payload (4) + payload
+ * payload: height (8) + block hash (32) + tx count (4) + tx items + block body
+ * tx item: tx hash (32) + offset in block body (4) + size (4)
+ * "height.idx": fixed entries of segment + 1 (4) + record offset (4), the entry of height N is at N * 8
+ * "block.idx": entries of the records in order, segment (4) + record offset (4) + payload size (4) + payload head + tx items
+ * Hash and tx indexes are loaded from block.idx on open, only the records after the last entry are scanned and checked
+ * The records loaded from block.idx are checked on the first read of the block or its txs
+ * The broken tail of the last segment is cut, the txs of the blocks replaced on the main chain are not indexed
+ * Block heads, diamonds, btc move logs and undo records are saved in the key value database
+ */
+
+const (
+	FlatBlockSegmentSize = int64(128 * 1024 * 1024)
+
+	flatBlockSegmentPrefix   = "blk"
+	flatBlockSegmentSuffix   = ".dat"
+	flatBlockHeightIndexName = "height.idx"
+	flatBlockIndexName       = "block.idx"
+
+	flatBlockRecordHeadSize  = 12
+	flatBlockPayloadHeadSize = 8 + 32 + 4
+	flatBlockTxItemSize      = 32 + 4 + 4
+	flatBlockHeightEntrySize = 8
+	flatBlockIndexHeadSize   = 4 + 4 + 4
+)
+
+var flatBlockRecordMagic = []byte("HBLK")
+
+type flatLocation struct {
+	segment uint32
+	offset  uint32 // record start in the segment
+}
+
+// Entry of the height index, segment + 1 (4) + offset (4), 0 is empty
+func (l flatLocation) entry() uint64 {
+	return uint64(l.segment+1)<<32 | uint64(l.offset)
+}
+
+func flatLocationByEntry(entry uint64) (flatLocation, bool) {
+	if entry == 0 {
+		return flatLocation{}, false
+	}
+	return flatLocation{uint32(entry>>32) - 1, uint32(entry)}, true
+}
+
+type flatBlockRef struct {
+	location   flatLocation
+	height     uint64
+	hash       fields.Hash
+	bodyOffset uint32 // in the segment
+	bodySize   uint32
+	txs        []*flatTxRef
+	checked    uint32 // 1 if the record crc is checked, atomic
+}
+
+type flatTxRef struct {
+	hash   string
+	block  *flatBlockRef
+	offset uint32 // in the segment
+	size   uint32
+}
+
+type FlatBlockStore struct {
+	*KVBlockStore
+
+	dir            string
+	segments       map[uint32]*os.File
+	segmentTop     map[uint32]uint64 // highest block in the segment
+	current        uint32
+	currentSize    int64
+	segmentSize    int64
+	heightIndex    *os.File
+	blockIndex     *os.File
+	blockIndexSize int64
+
+	heights      []uint64 // entries of the height index
+	blocks       map[flatLocation]*flatBlockRef
+	hashToBlock  map[string]*flatBlockRef
+	transactions map[string]*flatTxRef
+
+	cut    int64 // bytes of the broken tail cut when open
+	noSync bool
+
+	pruneKeep     uint64 // 0 is not prune
+	prunedHeight  uint64 // bodies of the heights below are deleted
+	undoPrunedTop uint64 // undo records below are deleted, 0 is not checked yet
+
+	mux sync.RWMutex
+}
+
+// Open or create the block store in the dir, the meta database keeps the other items of the block store
+func OpenFlatBlockStore(dir string, meta kvdb.Database) (*FlatBlockStore, error) {
+	if e := os.MkdirAll(dir, 0777); e != nil {
+		return nil, e
+	}
+	s := &FlatBlockStore{
+		KVBlockStore: NewKVBlockStore(meta),
+		dir:          dir,
+		segments:     make(map[uint32]*os.File),
+		segmentTop:   make(map[uint32]uint64),
+		segmentSize:  FlatBlockSegmentSize,
+		blocks:       make(map[flatLocation]*flatBlockRef),
+		hashToBlock:  make(map[string]*flatBlockRef),
+		transactions: make(map[string]*flatTxRef),
+	}
+	if e := s.open(); e != nil {
+		s.closeFiles()
+		return nil, e
+	}
+	return s, nil
+}
+
+// Size limit of one segment file, a block larger than it takes a segment alone
+func (s *FlatBlockStore) SetSegmentSize(size int64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.segmentSize = size
+}
+
+// Do not fsync after each write, faster but the latest blocks may lost on power off
+func (s *FlatBlockStore) SetNoSync(set bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.noSync = set
+}
+
+// Bytes of the broken tail cut when open
+func (s *FlatBlockStore) RepairedBytes() int64 {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.cut
+}
+
+func (s *FlatBlockStore) segmentPath(segment uint32) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%s%05d%s", flatBlockSegmentPrefix, segment, flatBlockSegmentSuffix))
+}
+
+// Segment numbers in the dir in order
+func (s *FlatBlockStore) listSegments() ([]uint32, error) {
+	files, e := ioutil.ReadDir(s.dir)
+	if e != nil {
+		return nil, e
+	}
+	numbers := make([]uint32, 0)
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasPrefix(name, flatBlockSegmentPrefix) || !strings.HasSuffix(name, flatBlockSegmentSuffix) {
+			continue
+		}
+		num, e := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, flatBlockSegmentPrefix), flatBlockSegmentSuffix), 10, 32)
+		if e != nil {
+			continue
+		}
+		numbers = append(numbers, uint32(num))
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+	return numbers, nil
+}
+
+func (s *FlatBlockStore) open() error {
+	numbers, e := s.listSegments()
+	if e != nil {
+		return e
+	}
+	if len(numbers) == 0 {
+		numbers = append(numbers, 0)
+	}
+	for _, num := range numbers {
+		file, e := os.OpenFile(s.segmentPath(num), os.O_RDWR|os.O_CREATE, 0666)
+		if e != nil {
+			return e
+		}
+		s.segments[num] = file
+	}
+	segment, size, e := s.loadBlockIndex(numbers[0])
+	if e != nil {
+		return e
+	}
+	// scan the records not in the block index
+	for i, num := range numbers {
+		if num < segment {
+			continue
+		}
+		start := int64(0)
+		if num == segment {
+			start = size
+		}
+		file := s.segments[num]
+		size, e := s.scanSegment(num, file, start)
+		if e != nil {
+			if i < len(numbers)-1 {
+				return e // only the last segment can have an unfinished write
+			}
+			stat, _ := file.Stat()
+			s.cut = stat.Size() - size
+			if e := file.Truncate(size); e != nil {
+				return e
+			}
+		}
+		s.current = num
+		s.currentSize = size
+	}
+	if e := s.loadHeightIndex(); e != nil {
+		return e
+	}
+	// only the txs of the main chain blocks are indexed
+	for _, block := range s.blocks {
+		if s.isReplacedBlock(block) {
+			s.dropBlockTxs(block)
+		}
+	}
+	for _, block := range s.blocks {
+		if !s.isReplacedBlock(block) {
+			s.setBlockTxs(block)
+		}
+	}
+	return nil
+}
+
+// Load the records in the block index, return the end of the last one
+func (s *FlatBlockStore) loadBlockIndex(first uint32) (uint32, int64, error) {
+	file, e := os.OpenFile(filepath.Join(s.dir, flatBlockIndexName), os.O_RDWR|os.O_CREATE, 0666)
+	if e != nil {
+		return 0, 0, e
+	}
+	s.blockIndex = file
+	body, e := ioutil.ReadAll(file)
+	if e != nil {
+		return 0, 0, e
+	}
+	segment, size := first, int64(0)
+	sizes := make(map[uint32]int64)
+	fileSize := func(segment uint32) (int64, error) {
+		if _, ok := sizes[segment]; !ok {
+			stat, e := s.segments[segment].Stat()
+			if e != nil {
+				return 0, e
+			}
+			sizes[segment] = stat.Size()
+		}
+		return sizes[segment], nil
+	}
+	seek := 0
+	for seek+flatBlockIndexHeadSize+flatBlockPayloadHeadSize <= len(body) {
+		entry := body[seek:]
+		location := flatLocation{binary.BigEndian.Uint32(entry[0:4]), binary.BigEndian.Uint32(entry[4:8])}
+		paysize := int64(binary.BigEndian.Uint32(entry[8:12]))
+		txcount := int(binary.BigEndian.Uint32(entry[flatBlockIndexHeadSize+40:]))
+		entrysize := flatBlockIndexHeadSize + flatBlockPayloadHeadSize + txcount*flatBlockTxItemSize
+		if seek+entrysize > len(body) {
+			break // unfinished entry
+		}
+		if location.segment < first {
+			seek += entrysize
+			continue // pruned
+		}
+		if _, ok := s.segments[location.segment]; !ok {
+			break
+		}
+		// records are in order, stop at the first one not saved in the segment
+		if location.segment == segment {
+			if int64(location.offset) != size {
+				break
+			}
+		} else if location.segment < segment || location.offset != 0 {
+			break
+		} else if top, e := fileSize(segment); e != nil {
+			return 0, 0, e
+		} else if size != top {
+			break // records of the last segment not indexed
+		}
+		top, e := fileSize(location.segment)
+		if e != nil {
+			return 0, 0, e
+		}
+		end := int64(location.offset) + flatBlockRecordHeadSize + paysize
+		if end > top {
+			break
+		}
+		if _, e := s.indexRecord(location, entry[flatBlockIndexHeadSize:entrysize], paysize); e != nil {
+			break
+		}
+		segment, size = location.segment, end
+		seek += entrysize
+	}
+	// drop the entries not checked, the records are scanned again
+	if seek < len(body) {
+		if e := file.Truncate(int64(seek)); e != nil {
+			return 0, 0, e
+		}
+	}
+	s.blockIndexSize = int64(seek)
+	return segment, size, nil
+}
+
+// Entry of the block index, the payload head and tx items are built from the block
+func (block *flatBlockRef) indexEntry() []byte {
+	paysize := flatBlockPayloadHeadSize + len(block.txs)*flatBlockTxItemSize
+	entry := make([]byte, flatBlockIndexHeadSize+paysize)
+	binary.BigEndian.PutUint32(entry[0:4], block.location.segment)
+	binary.BigEndian.PutUint32(entry[4:8], block.location.offset)
+	binary.BigEndian.PutUint32(entry[8:12], uint32(paysize)+block.bodySize)
+	head := entry[flatBlockIndexHeadSize:]
+	binary.BigEndian.PutUint64(head[0:8], block.height)
+	copy(head[8:40], block.hash)
+	binary.BigEndian.PutUint32(head[40:44], uint32(len(block.txs)))
+	for i, tx := range block.txs {
+		item := head[flatBlockPayloadHeadSize+i*flatBlockTxItemSize:]
+		copy(item[0:32], tx.hash)
+		binary.BigEndian.PutUint32(item[32:36], tx.offset-block.bodyOffset)
+		binary.BigEndian.PutUint32(item[36:40], tx.size)
+	}
+	return entry
+}
+
+func (s *FlatBlockStore) appendBlockIndex(block *flatBlockRef) error {
+	entry := block.indexEntry()
+	if _, e := s.blockIndex.WriteAt(entry, s.blockIndexSize); e != nil {
+		s.blockIndex.Truncate(s.blockIndexSize)
+		return e
+	}
+	if !s.noSync {
+		if e := s.blockIndex.Sync(); e != nil {
+			return e
+		}
+	}
+	s.blockIndexSize += int64(len(entry))
+	return nil
+}
+
+// Write the block index again with the records of the segments not pruned
+func (s *FlatBlockStore) rewriteBlockIndex() error {
+	blocks := make([]*flatBlockRef, 0, len(s.blocks))
+	for _, block := range s.blocks {
+		blocks = append(blocks, block)
+	}
+	sort.Slice(blocks, func(i, j int) bool {
+		a, b := blocks[i].location, blocks[j].location
+		return a.segment < b.segment || (a.segment == b.segment && a.offset < b.offset)
+	})
+	body := make([]byte, 0)
+	for _, block := range blocks {
+		body = append(body, block.indexEntry()...)
+	}
+	path := filepath.Join(s.dir, flatBlockIndexName)
+	if e := ioutil.WriteFile(path+".tmp", body, 0666); e != nil {
+		return e
+	}
+	if e := os.Rename(path+".tmp", path); e != nil {
+		return e
+	}
+	file, e := os.OpenFile(path, os.O_RDWR, 0666)
+	if e != nil {
+		return e
+	}
+	s.blockIndex.Close()
+	s.blockIndex = file
+	s.blockIndexSize = int64(len(body))
+	return nil
+}
+
+// Check and index the records of the segment from the start, return the end of the valid records
+func (s *FlatBlockStore) scanSegment(segment uint32, file *os.File, start int64) (int64, error) {
+	stat, e := file.Stat()
+	if e != nil {
+		return 0, e
+	}
+	size := start
+	head := make([]byte, flatBlockRecordHeadSize)
+	for size < stat.Size() {
+		if _, e := file.ReadAt(head, size); e != nil {
+			return size, fmt.Errorf("flat block segment %d record head broken at %d.", segment, size)
+		}
+		if !bytes.Equal(head[0:4], flatBlockRecordMagic) {
+			return size, fmt.Errorf("flat block segment %d record magic error at %d.", segment, size)
+		}
+		paysize := int64(binary.BigEndian.Uint32(head[4:8]))
+		if size+flatBlockRecordHeadSize+paysize > stat.Size() {
+			return size, fmt.Errorf("flat block segment %d record unfinished at %d.", segment, size)
+		}
+		payload := make([]byte, paysize)
+		if _, e := file.ReadAt(payload, size+flatBlockRecordHeadSize); e != nil {
+			return size, e
+		}
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(head[8:12]) {
+			return size, fmt.Errorf("flat block segment %d record checksum error at %d.", segment, size)
+		}
+		block, e := s.indexRecord(flatLocation{segment, uint32(size)}, payload, paysize)
+		if e != nil {
+			return size, e
+		}
+		block.checked = 1
+		if e := s.appendBlockIndex(block); e != nil {
+			return size, e
+		}
+		size += flatBlockRecordHeadSize + paysize
+	}
+	return size, nil
+}
+
+// Add the block and its txs to the indexes, the payload can be only the head and tx items, paysize is the whole
+func (s *FlatBlockStore) indexRecord(location flatLocation, payload []byte, paysize int64) (*flatBlockRef, error) {
+	if len(payload) < flatBlockPayloadHeadSize {
+		return nil, fmt.Errorf("flat block record size %d too short.", len(payload))
+	}
+	txcount := int(binary.BigEndian.Uint32(payload[40:44]))
+	bodystart := flatBlockPayloadHeadSize + txcount*flatBlockTxItemSize
+	if bodystart > len(payload) || int64(bodystart) > paysize {
+		return nil, fmt.Errorf("flat block record tx count %d out of range.", txcount)
+	}
+	block := &flatBlockRef{
+		location:   location,
+		height:     binary.BigEndian.Uint64(payload[0:8]),
+		hash:       fields.Hash(append([]byte{}, payload[8:40]...)),
+		bodyOffset: location.offset + flatBlockRecordHeadSize + uint32(bodystart),
+		bodySize:   uint32(paysize - int64(bodystart)),
+		txs:        make([]*flatTxRef, txcount),
+	}
+	for i := 0; i < txcount; i++ {
+		item := payload[flatBlockPayloadHeadSize+i*flatBlockTxItemSize:]
+		offset := binary.BigEndian.Uint32(item[32:36])
+		size := binary.BigEndian.Uint32(item[36:40])
+		if uint64(offset)+uint64(size) > uint64(block.bodySize) {
+			return nil, fmt.Errorf("flat block <%s> tx %d out of range.", block.hash.ToHex(), i)
+		}
+		block.txs[i] = &flatTxRef{string(item[0:32]), block, block.bodyOffset + offset, size}
+	}
+	if block.height > s.segmentTop[location.segment] {
+		s.segmentTop[location.segment] = block.height
+	}
+	s.blocks[location] = block
+	s.hashToBlock[string(block.hash)] = block
+	for _, tx := range block.txs {
+		if _, ok := s.transactions[tx.hash]; !ok {
+			s.transactions[tx.hash] = tx // the main chain block is set on refer to height
+		}
+	}
+	return block, nil
+}
+
+// Another block is set at the height of the block
+func (s *FlatBlockStore) isReplacedBlock(block *flatBlockRef) bool {
+	if block.height >= uint64(len(s.heights)) {
+		return false
+	}
+	entry := s.heights[block.height]
+	return entry != 0 && entry != block.location.entry()
+}
+
+func (s *FlatBlockStore) setBlockTxs(block *flatBlockRef) {
+	for _, tx := range block.txs {
+		s.transactions[tx.hash] = tx
+	}
+}
+
+func (s *FlatBlockStore) dropBlockTxs(block *flatBlockRef) {
+	for _, tx := range block.txs {
+		if s.transactions[tx.hash] == tx {
+			delete(s.transactions, tx.hash)
+		}
+	}
+}
+
+func (s *FlatBlockStore) loadHeightIndex() error {
+	file, e := os.OpenFile(filepath.Join(s.dir, flatBlockHeightIndexName), os.O_RDWR|os.O_CREATE, 0666)
+	if e != nil {
+		return e
+	}
+	s.heightIndex = file
+	body, e := ioutil.ReadAll(file)
+	if e != nil {
+		return e
+	}
+	if tail := len(body) % flatBlockHeightEntrySize; tail > 0 {
+		body = body[:len(body)-tail] // unfinished entry
+		if e := file.Truncate(int64(len(body))); e != nil {
+			return e
+		}
+	}
+	first := s.firstSegment()
+	s.heights = make([]uint64, len(body)/flatBlockHeightEntrySize)
+	for i := range s.heights {
+		entry := binary.BigEndian.Uint64(body[i*flatBlockHeightEntrySize:])
+		location, ok := flatLocationByEntry(entry)
+		if !ok {
+			continue // empty
+		}
+		if location.segment < first {
+			s.heights[i] = entry // pruned
+			s.prunedHeight = uint64(i) + 1
+		} else if _, ok := s.blocks[location]; ok {
+			s.heights[i] = entry
+		} // the block record was cut, leave the entry empty
+	}
+	return nil
+}
+
+/**************************** write ****************************/
+
+// append the block to the current segment and index all transactions
+func (s *FlatBlockStore) SaveBlock(block interfaces.Block) error {
+	body, e := block.Serialize()
+	if e != nil {
+		return e
+	}
+	hash := block.Hash()
+	txs := block.GetTrsList()
+	txbodys := make([][]byte, len(txs))
+	txtotal := 0
+	for i, tx := range txs {
+		if txbodys[i], e = tx.Serialize(); e != nil {
+			return e
+		}
+		txtotal += len(txbodys[i])
+	}
+	// transactions are at the end of the block body
+	offset := len(body) - txtotal
+	if offset < 0 {
+		return fmt.Errorf("block <%s> transactions size error.", hash.ToHex())
+	}
+	payload := make([]byte, flatBlockPayloadHeadSize, flatBlockPayloadHeadSize+len(txs)*flatBlockTxItemSize+len(body))
+	binary.BigEndian.PutUint64(payload[0:8], block.GetHeight())
+	copy(payload[8:40], hash)
+	txcount := 0
+	for i, tx := range txs {
+		txbody := txbodys[i]
+		if !bytes.Equal(body[offset:offset+len(txbody)], txbody) {
+			return fmt.Errorf("block <%s> tx %d not find in the block body.", hash.ToHex(), i)
+		}
+		if i > 0 { // drop coinbase
+			item := make([]byte, flatBlockTxItemSize)
+			copy(item[0:32], tx.Hash())
+			binary.BigEndian.PutUint32(item[32:36], uint32(offset))
+			binary.BigEndian.PutUint32(item[36:40], uint32(len(txbody)))
+			payload = append(payload, item...)
+			txcount++
+		}
+		offset += len(txbody)
+	}
+	binary.BigEndian.PutUint32(payload[40:44], uint32(txcount))
+	head := body[0 : len(body)-txtotal]
+	payload = append(payload, body...)
+	record := make([]byte, flatBlockRecordHeadSize, flatBlockRecordHeadSize+len(payload))
+	copy(record[0:4], flatBlockRecordMagic)
+	binary.BigEndian.PutUint32(record[4:8], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[8:12], crc32.ChecksumIEEE(payload))
+	record = append(record, payload...)
+	if int64(len(record)) > int64(^uint32(0)) {
+		return fmt.Errorf("block <%s> size %d too large.", hash.ToHex(), len(record))
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if _, ok := s.hashToBlock[string(hash)]; ok {
+		return nil // already saved
+	}
+	if s.currentSize > 0 && s.currentSize+int64(len(record)) > s.segmentSize {
+		if e := s.rotateSegment(); e != nil {
+			return e
+		}
+	}
+	file := s.segments[s.current]
+	if _, e := file.WriteAt(record, s.currentSize); e != nil {
+		file.Truncate(s.currentSize) // drop the part written
+		return e
+	}
+	if !s.noSync {
+		if e := file.Sync(); e != nil {
+			return e
+		}
+	}
+	location := flatLocation{s.current, uint32(s.currentSize)}
+	ref, e := s.indexRecord(location, payload, int64(len(payload)))
+	if e != nil {
+		return e
+	}
+	ref.checked = 1
+	s.currentSize += int64(len(record))
+	if e := s.appendBlockIndex(ref); e != nil {
+		return e // scanned again on open
+	}
+	return s.heads.Put(hash, head)
+}
+
+func (s *FlatBlockStore) rotateSegment() error {
+	next := s.current + 1
+	file, e := os.OpenFile(s.segmentPath(next), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if e != nil {
+		return e
+	}
+	s.segments[next] = file
+	s.current = next
+	s.currentSize = 0
+	return nil
+}
+
+// The block must be saved before, set again on reorg
+func (s *FlatBlockStore) UpdateSetBlockHashReferToHeight(height uint64, hash fields.Hash) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	block, ok := s.hashToBlock[string(hash)]
+	if !ok {
+		return fmt.Errorf("block <%s> not find in the flat block store.", hash.ToHex())
+	}
+	entry := uint64Key(block.location.entry())
+	if _, e := s.heightIndex.WriteAt(entry, int64(height)*flatBlockHeightEntrySize); e != nil {
+		return e
+	}
+	if !s.noSync {
+		if e := s.heightIndex.Sync(); e != nil {
+			return e
+		}
+	}
+	for uint64(len(s.heights)) <= height {
+		s.heights = append(s.heights, 0)
+	}
+	if old := s.blockAtHeight(height); old != nil && old != block {
+		s.dropBlockTxs(old)
+	}
+	s.heights[height] = block.location.entry()
+	s.setBlockTxs(block)
+	if s.pruneKeep > 0 {
+		return s.prune()
+	}
+	return nil
+}
+
+// The block of the height is rolled back, the top height entry is cut off the height index
+func (s *FlatBlockStore) DeleteBlockHashReferToHeight(height uint64) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if e := s.KVBlockStore.DeleteBlockHashReferToHeight(height); e != nil {
+		return e // pruned height
+	}
+	if height >= uint64(len(s.heights)) {
+		return nil
+	}
+	if height == uint64(len(s.heights))-1 {
+		if e := s.heightIndex.Truncate(int64(height) * flatBlockHeightEntrySize); e != nil {
+			return e
+		}
+		s.heights = s.heights[:height]
+	} else {
+		if _, e := s.heightIndex.WriteAt(make([]byte, flatBlockHeightEntrySize), int64(height)*flatBlockHeightEntrySize); e != nil {
+			return e
+		}
+		s.heights[height] = 0
+	}
+	if !s.noSync {
+		return s.heightIndex.Sync()
+	}
+	return nil
+}
+
+/**************************** read ****************************/
+
+func (s *FlatBlockStore) readAt(segment uint32, offset uint32, size uint32) ([]byte, error) {
+	file, ok := s.segments[segment]
+	if !ok {
+		return nil, fmt.Errorf("flat block segment %d not find.", segment)
+	}
+	buf := make([]byte, size)
+	if _, e := file.ReadAt(buf, int64(offset)); e != nil {
+		return nil, e
+	}
+	return buf, nil
+}
+
+// Check the record crc on the first read, return the block body
+func (s *FlatBlockStore) readBlockBody(block *flatBlockRef) ([]byte, error) {
+	if atomic.LoadUint32(&block.checked) == 1 {
+		return s.readAt(block.location.segment, block.bodyOffset, block.bodySize)
+	}
+	paysize := block.bodyOffset - block.location.offset - flatBlockRecordHeadSize + block.bodySize
+	record, e := s.readAt(block.location.segment, block.location.offset, flatBlockRecordHeadSize+paysize)
+	if e != nil {
+		return nil, e
+	}
+	payload := record[flatBlockRecordHeadSize:]
+	if !bytes.Equal(record[0:4], flatBlockRecordMagic) || binary.BigEndian.Uint32(record[4:8]) != paysize ||
+		crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(record[8:12]) {
+		return nil, fmt.Errorf("flat block <%s> record checksum error.", block.hash.ToHex())
+	}
+	atomic.StoreUint32(&block.checked, 1)
+	return payload[paysize-block.bodySize:], nil
+}
+
+// Nil if the height is not set or pruned
+func (s *FlatBlockStore) blockAtHeight(height uint64) *flatBlockRef {
+	if height >= uint64(len(s.heights)) {
+		return nil
+	}
+	location, ok := flatLocationByEntry(s.heights[height])
+	if !ok {
+		return nil
+	}
+	return s.blocks[location]
+}
+
+func (s *FlatBlockStore) ReadBlockBytesByHash(hash fields.Hash) ([]byte, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	block, ok := s.hashToBlock[string(hash)]
+	if !ok {
+		if s.prunedHeight > 0 {
+			if head, _ := s.heads.Get(hash); head != nil {
+				return nil, &BlockPrunedError{Hash: hash}
+			}
+		}
+		return nil, nil // not find
+	}
+	return s.readBlockBody(block)
+}
+
+func (s *FlatBlockStore) ReadBlockBytesByHeight(height uint64) (fields.Hash, []byte, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	if s.isPrunedHeight(height) {
+		return nil, nil, &BlockPrunedError{Height: height}
+	}
+	block := s.blockAtHeight(height)
+	if block == nil {
+		if hash, _ := s.KVBlockStore.ReadBlockHashByHeight(height); hash != nil {
+			return nil, nil, &BlockPrunedError{Height: height} // only the head saved
+		}
+		return nil, nil, nil // not find
+	}
+	body, e := s.readBlockBody(block)
+	if e != nil {
+		return nil, nil, e
+	}
+	return block.hash, body, nil
+}
+
+func (s *FlatBlockStore) ReadBlockHashByHeight(height uint64) (fields.Hash, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	block := s.blockAtHeight(height)
+	if block == nil {
+		return s.KVBlockStore.ReadBlockHashByHeight(height) // pruned or only the head saved
+	}
+	return block.hash, nil
+}
+
+func (s *FlatBlockStore) ReadTransactionBytesByHash(hash fields.Hash) (uint64, []byte, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	tx, ok := s.transactions[string(hash)]
+	if !ok {
+		return 0, nil, nil // not find
+	}
+	if atomic.LoadUint32(&tx.block.checked) == 0 {
+		if _, e := s.readBlockBody(tx.block); e != nil {
+			return 0, nil, e
+		}
+	}
+	body, e := s.readAt(tx.block.location.segment, tx.offset, tx.size)
+	if e != nil {
+		return 0, nil, e
+	}
+	return tx.block.height, body, nil
+}
+
+func (s *FlatBlockStore) closeFiles() {
+	for _, file := range s.segments {
+		file.Close()
+	}
+	s.segments = make(map[uint32]*os.File)
+	if s.heightIndex != nil {
+		s.heightIndex.Close()
+		s.heightIndex = nil
+	}
+	if s.blockIndex != nil {
+		s.blockIndex.Close()
+		s.blockIndex = nil
+	}
+}
+
+func (s *FlatBlockStore) Close() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.closeFiles()
+	s.KVBlockStore.Close()
+}
@@ -0,0 +1,83 @@
+package chainstate
+
+import (
+	"fmt"
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/stores"
+)
+
+/**
+ * Typed iterators over all store items of the state
+ * Items come in key order, so a walk of the same state is always the same
+ * Use them on the immutable state, a fork state merges every parent on each call
+ */
+
+// State that can walk all its store items
+type StateIterator interface {
+	GetPendingBlockHeight() uint64
+	GetPendingBlockHash() fields.Hash
+
+	IterateBalances(fn func(addr fields.Address, bls *stores.Balance) bool) error
+	IterateDiamonds(fn func(name fields.DiamondName, dia *stores.Diamond) bool) error
+	IterateChannels(fn func(id fields.ChannelId, paychan *stores.Channel) bool) error
+	IterateLockbls(fn func(id fields.LockblsId, lock *stores.Lockbls) bool) error
+	IterateDiamondSystemLendings(fn func(id fields.DiamondSyslendId, lend *stores.DiamondSystemLending) bool) error
+	IterateBitcoinSystemLendings(fn func(id fields.BitcoinSyslendId, lend *stores.BitcoinSystemLending) bool) error
+	IterateUserLendings(fn func(id fields.UserLendingId, lend *stores.UserLending) bool) error
+}
+
+// Parse each item with prefix, stop at the first broken item
+func (cs *MemoryChainState) iterateItems(prefix byte, create func() storeItem, fn func(key []byte, item storeItem) bool) error {
+	var err error = nil
+	cs.TraversalItems(prefix, func(key []byte, body []byte) bool {
+		item := create()
+		if _, e := item.Parse(body, 0); e != nil {
+			err = fmt.Errorf("parse store item <%d:%x> error: %s", prefix, key, e.Error())
+			return false
+		}
+		return fn(key, item)
+	})
+	return err
+}
+
+func (cs *MemoryChainState) IterateBalances(fn func(addr fields.Address, bls *stores.Balance) bool) error {
+	return cs.iterateItems(KeyPrefixBalance, func() storeItem { return stores.NewEmptyBalance() }, func(key []byte, item storeItem) bool {
+		return fn(fields.Address(key), item.(*stores.Balance))
+	})
+}
+
+func (cs *MemoryChainState) IterateDiamonds(fn func(name fields.DiamondName, dia *stores.Diamond) bool) error {
+	return cs.iterateItems(KeyPrefixDiamond, func() storeItem { return &stores.Diamond{} }, func(key []byte, item storeItem) bool {
+		return fn(fields.DiamondName(key), item.(*stores.Diamond))
+	})
+}
+
+func (cs *MemoryChainState) IterateChannels(fn func(id fields.ChannelId, paychan *stores.Channel) bool) error {
+	return cs.iterateItems(KeyPrefixChannel, func() storeItem { return stores.CreateEmptyChannel() }, func(key []byte, item storeItem) bool {
+		return fn(fields.ChannelId(key), item.(*stores.Channel))
+	})
+}
+
+func (cs *MemoryChainState) IterateLockbls(fn func(id fields.LockblsId, lock *stores.Lockbls) bool) error {
+	return cs.iterateItems(KeyPrefixLockbls, func() storeItem { return &stores.Lockbls{} }, func(key []byte, item storeItem) bool {
+		return fn(fields.LockblsId(key), item.(*stores.Lockbls))
+	})
+}
+
+func (cs *MemoryChainState) IterateDiamondSystemLendings(fn func(id fields.DiamondSyslendId, lend *stores.DiamondSystemLending) bool) error {
+	return cs.iterateItems(KeyPrefixDiamondLending, func() storeItem { return &stores.DiamondSystemLending{} }, func(key []byte, item storeItem) bool {
+		return fn(fields.DiamondSyslendId(key), item.(*stores.DiamondSystemLending))
+	})
+}
+
+func (cs *MemoryChainState) IterateBitcoinSystemLendings(fn func(id fields.BitcoinSyslendId, lend *stores.BitcoinSystemLending) bool) error {
+	return cs.iterateItems(KeyPrefixBitcoinLending, func() storeItem { return &stores.BitcoinSystemLending{} }, func(key []byte, item storeItem) bool {
+		return fn(fields.BitcoinSyslendId(key), item.(*stores.BitcoinSystemLending))
+	})
+}
+
+func (cs *MemoryChainState) IterateUserLendings(fn func(id fields.UserLendingId, lend *stores.UserLending) bool) error {
+	return cs.iterateItems(KeyPrefixUserLending, func() storeItem { return &stores.UserLending{} }, func(key []byte, item storeItem) bool {
+		return fn(fields.UserLendingId(key), item.(*stores.UserLending))
+	})
+}
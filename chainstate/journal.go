@@ -0,0 +1,337 @@
+package chainstate
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/interfaces"
+)
+
+/**
+ * Undo journal
+ * The data before a block of every key it writes, saved when the block becomes immutable
+ * Rollback applies the records from the latest block down to the target height
+ */
+
+// Block store that keeps undo records by block height
+type UndoRecordStore interface {
+	SaveUndoRecord(height uint64, body []byte) error
+	ReadUndoRecord(height uint64) ([]byte, error) // nil means not find
+	DeleteUndoRecord(height uint64) error
+	DeleteBlockHashReferToHeight(height uint64) error // the block of the height is rolled back
+}
+
+// Record store items like StateRecorder, and the tx hash index, move btc index and latest status too
+type StateJournal struct {
+	*StateRecorder
+
+	touched map[string]bool
+	indexs  []*RecordItem
+}
+
+func NewStateJournal(state interfaces.ChainStateOperation) *StateJournal {
+	return &StateJournal{
+		StateRecorder: NewStateRecorder(state),
+		touched:       make(map[string]bool),
+		indexs:        make([]*RecordItem, 0),
+	}
+}
+
+// Store items and index items
+func (j *StateJournal) GetJournalItems() []*RecordItem {
+	items := append([]*RecordItem{}, j.GetRecordItems()...)
+	return append(items, j.indexs...)
+}
+
+func (j *StateJournal) touchIndex(prefix byte, key []byte, read func() ([]byte, error)) error {
+	k := StoreKey(prefix, key)
+	if j.touched[k] {
+		return nil
+	}
+	before, e := read()
+	if e != nil {
+		return e
+	}
+	j.touched[k] = true
+	j.indexs = append(j.indexs, &RecordItem{
+		Prefix: prefix,
+		Key:    append([]byte{}, key...),
+		Before: before,
+	})
+	return nil
+}
+
+func (j *StateJournal) recordTxHash(hx fields.Hash) error {
+	return j.touchIndex(KeyPrefixTxHash, hx, func() ([]byte, error) {
+		ok, e := j.ChainStateOperation.CheckTxHash(hx)
+		if e != nil || !ok {
+			return nil, e
+		}
+		height, e := j.ChainStateOperation.ReadTxBelongHeightByHash(hx)
+		if e != nil {
+			return nil, e
+		}
+		return height.Serialize()
+	})
+}
+
+func (j *StateJournal) ContainTxHash(hx fields.Hash, height fields.BlockHeight) error {
+	if e := j.recordTxHash(hx); e != nil {
+		return e
+	}
+	return j.ChainStateOperation.ContainTxHash(hx, height)
+}
+
+func (j *StateJournal) RemoveTxHash(hx fields.Hash) error {
+	if e := j.recordTxHash(hx); e != nil {
+		return e
+	}
+	return j.ChainStateOperation.RemoveTxHash(hx)
+}
+
+func (j *StateJournal) SaveMoveBTCBelongTxHash(trsno uint32, txhash []byte) error {
+	key, _ := fields.VarUint4(trsno).Serialize()
+	e := j.touchIndex(KeyPrefixMoveBTCTxHash, key, func() ([]byte, error) {
+		return j.ChainStateOperation.ReadMoveBTCTxHashByTrsNo(trsno)
+	})
+	if e != nil {
+		return e
+	}
+	return j.ChainStateOperation.SaveMoveBTCBelongTxHash(trsno, txhash)
+}
+
+func (j *StateJournal) LatestStatusSet(status interfaces.LatestStatus) error {
+	e := j.touchIndex(KeyPrefixLatestStatus, nil, func() ([]byte, error) {
+		status, e := j.ChainStateOperation.LatestStatusRead()
+		if e != nil {
+			return nil, e
+		}
+		return status.Serialize()
+	})
+	if e != nil {
+		return e
+	}
+	return j.ChainStateOperation.LatestStatusSet(status)
+}
+
+/**************************** record ****************************/
+
+// Undo data of one block
+type UndoRecord struct {
+	BlockHeight fields.BlockHeight
+	BlockHash   fields.Hash
+	PrevPending *PendingStatus // pending status of the state before the block
+	Count       fields.VarUint4
+	Items       []*RecordItem
+}
+
+func NewUndoRecord(pending *PendingStatus, prev *PendingStatus, items []*RecordItem) *UndoRecord {
+	return &UndoRecord{
+		BlockHeight: fields.BlockHeight(pending.GetPendingBlockHeight()),
+		BlockHash:   pending.GetPendingBlockHash(),
+		PrevPending: prev.Clone(),
+		Count:       fields.VarUint4(len(items)),
+		Items:       items,
+	}
+}
+
+func recordItemSize(item *RecordItem) uint32 {
+	size := uint32(1 + 1 + len(item.Key) + 1)
+	if item.Before != nil {
+		size += 4 + uint32(len(item.Before))
+	}
+	return size
+}
+
+func (u *UndoRecord) Size() uint32 {
+	size := u.BlockHeight.Size() + fields.HashSize + u.PrevPending.Size() + u.Count.Size()
+	for _, item := range u.Items {
+		size += recordItemSize(item)
+	}
+	return size
+}
+
+func (u *UndoRecord) Serialize() ([]byte, error) {
+	if int(u.Count) != len(u.Items) {
+		return nil, fmt.Errorf("undo record items count error.")
+	}
+	var buffer = new(bytes.Buffer)
+	b1, _ := u.BlockHeight.Serialize()
+	b2, _ := u.BlockHash.Serialize()
+	b3, e := u.PrevPending.Serialize()
+	if e != nil {
+		return nil, e
+	}
+	b4, _ := u.Count.Serialize()
+	buffer.Write(b1)
+	buffer.Write(b2)
+	buffer.Write(b3)
+	buffer.Write(b4)
+	for _, item := range u.Items {
+		if len(item.Key) > 255 {
+			return nil, fmt.Errorf("undo record item key length %d overflow.", len(item.Key))
+		}
+		buffer.WriteByte(item.Prefix)
+		buffer.WriteByte(uint8(len(item.Key)))
+		buffer.Write(item.Key)
+		if item.Before == nil {
+			buffer.WriteByte(0)
+			continue
+		}
+		buffer.WriteByte(1)
+		b5, _ := fields.VarUint4(len(item.Before)).Serialize()
+		buffer.Write(b5)
+		buffer.Write(item.Before)
+	}
+	return buffer.Bytes(), nil
+}
+
+func (u *UndoRecord) Parse(buf []byte, seek uint32) (uint32, error) {
+	var e error
+	seek, e = u.BlockHeight.Parse(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	seek, e = u.BlockHash.Parse(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	u.PrevPending = &PendingStatus{}
+	seek, e = u.PrevPending.Parse(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	seek, e = u.Count.Parse(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	if uint64(seek)+uint64(u.Count)*3 > uint64(len(buf)) {
+		return 0, fmt.Errorf("[UndoRecord.Parse] seek out of buf len.")
+	}
+	u.Items = make([]*RecordItem, int(u.Count))
+	for i := 0; i < int(u.Count); i++ {
+		if int(seek)+2 > len(buf) {
+			return 0, fmt.Errorf("[UndoRecord.Parse] seek out of buf len.")
+		}
+		item := &RecordItem{Prefix: buf[seek]}
+		keylen := uint32(buf[seek+1])
+		seek += 2
+		if int(seek+keylen)+1 > len(buf) {
+			return 0, fmt.Errorf("[UndoRecord.Parse] seek out of buf len.")
+		}
+		item.Key = append([]byte{}, buf[seek:seek+keylen]...)
+		seek += keylen
+		exist := buf[seek]
+		seek++
+		if exist == 1 {
+			var length fields.VarUint4
+			seek, e = length.Parse(buf, seek)
+			if e != nil {
+				return 0, e
+			}
+			if uint64(seek)+uint64(length) > uint64(len(buf)) {
+				return 0, fmt.Errorf("[UndoRecord.Parse] seek out of buf len.")
+			}
+			item.Before = append([]byte{}, buf[seek:seek+uint32(length)]...)
+			seek += uint32(length)
+		}
+		u.Items[i] = item
+	}
+	return seek, nil
+}
+
+/**************************** memory state ****************************/
+
+// Write the block into this fork state and keep the undo items
+// The undo record is saved to the block store when the fork is merged into the immutable state
+func (cs *MemoryChainState) WriteBlockWithJournal(block interfaces.Block) error {
+	journal := NewStateJournal(cs)
+	if e := block.WriteInChainState(journal); e != nil {
+		return e
+	}
+	cs.mux.Lock()
+	cs.undoItems = journal.GetJournalItems()
+	cs.mux.Unlock()
+	return nil
+}
+
+func (cs *MemoryChainState) undoRecordStore() UndoRecordStore {
+	store, _ := cs.BlockStore().(UndoRecordStore)
+	return store
+}
+
+// Save the undo record of the fork before it is copied into base
+func (cs *MemoryChainState) saveUndoRecord(base *MemoryChainState) error {
+	cs.mux.RLock()
+	items := cs.undoItems
+	cs.mux.RUnlock()
+	store := base.undoRecordStore()
+	if items == nil || store == nil {
+		return nil
+	}
+	record := NewUndoRecord(cs.pending, base.pending, items)
+	body, e := record.Serialize()
+	if e != nil {
+		return e
+	}
+	return store.SaveUndoRecord(uint64(record.BlockHeight), body)
+}
+
+// Undo the blocks of the immutable state down to the target height, return the height after rollback
+// All sub states are destroyed, block bodies are kept in the block store
+func (cs *MemoryChainState) RollbackToBlockHeight(target uint64) (uint64, error) {
+	if !cs.isImmutable {
+		return 0, fmt.Errorf("only the immutable state can rollback.")
+	}
+	current := cs.GetPendingBlockHeight()
+	if target >= current {
+		return current, nil
+	}
+	store := cs.undoRecordStore()
+	if store == nil {
+		return current, fmt.Errorf("block store not support undo record.")
+	}
+	for _, child := range cs.GetChilds() {
+		child.Destory()
+	}
+	tree := cs.StateTree()
+	for current > target {
+		body, e := store.ReadUndoRecord(current)
+		if e != nil {
+			return current, e
+		}
+		if body == nil {
+			return current, fmt.Errorf("undo record of block %d not find.", current)
+		}
+		record := &UndoRecord{}
+		if _, e := record.Parse(body, 0); e != nil {
+			return current, e
+		}
+		if uint64(record.BlockHeight) != current || !record.BlockHash.Equal(cs.GetPendingBlockHash()) {
+			return current, fmt.Errorf("undo record of block %d not match the state.", current)
+		}
+		changes := make(map[string][]byte)
+		for i := len(record.Items) - 1; i >= 0; i-- {
+			item := record.Items[i]
+			key := StoreKey(item.Prefix, item.Key)
+			cs.setBytes(key, item.Before)
+			changes[key] = item.Before
+		}
+		applyStateTreeChanges(tree, changes)
+		cs.pending = record.PrevPending
+		if e := cs.persist(changes); e != nil {
+			return current, e
+		}
+		if e := store.DeleteUndoRecord(current); e != nil {
+			return current, e
+		}
+		if e := store.DeleteBlockHashReferToHeight(current); e != nil {
+			return current, e
+		}
+		current = cs.GetPendingBlockHeight()
+	}
+	cs.mux.Lock()
+	cs.tree = tree
+	cs.mux.Unlock()
+	return current, nil
+}
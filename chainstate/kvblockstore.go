@@ -0,0 +1,305 @@
+package chainstate
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/interfaces"
+	"github.com/hacash/core/kvdb"
+	"github.com/hacash/core/stores"
+)
+
+/**
+ * Block store saved in a key value database
+ * Schema of the tables:
+ * "b" + block hash => block body
+ * "h" + height (8) => block hash
+ * "e" + block hash => block head and meta
+ * "t" + tx hash    => block height (8) + tx body
+ * "d" + diamond    => diamond smelt
+ * "n" + number (4) => diamond name
+ * "g" + page (4)   => btc move log page
+ * "c" + "btcmovelog" => btc move log page count (4)
+ * "u" + height (8) => undo record
+ */
+
+const (
+	KVTableBlock          = "b"
+	KVTableBlockHeight    = "h"
+	KVTableBlockHead      = "e"
+	KVTableTransaction    = "t"
+	KVTableDiamond        = "d"
+	KVTableDiamondNumber  = "n"
+	KVTableBTCMoveLogPage = "g"
+	KVTableCount          = "c"
+	KVTableUndoRecord     = "u"
+)
+
+var kvBTCMoveLogCountKey = []byte("btcmovelog")
+
+type KVBlockStore struct {
+	db            kvdb.Database
+	blocks        *kvdb.Table
+	heightToHash  *kvdb.Table
+	heads         *kvdb.Table
+	transactions  *kvdb.Table
+	diamonds      *kvdb.Table
+	diamondNumber *kvdb.Table
+	btcMoveLogs   *kvdb.Table
+	counts        *kvdb.Table
+	undoRecords   *kvdb.Table
+}
+
+func NewKVBlockStore(db kvdb.Database) *KVBlockStore {
+	return &KVBlockStore{
+		db:            db,
+		blocks:        kvdb.NewTable(db, KVTableBlock),
+		heightToHash:  kvdb.NewTable(db, KVTableBlockHeight),
+		heads:         kvdb.NewTable(db, KVTableBlockHead),
+		transactions:  kvdb.NewTable(db, KVTableTransaction),
+		diamonds:      kvdb.NewTable(db, KVTableDiamond),
+		diamondNumber: kvdb.NewTable(db, KVTableDiamondNumber),
+		btcMoveLogs:   kvdb.NewTable(db, KVTableBTCMoveLogPage),
+		counts:        kvdb.NewTable(db, KVTableCount),
+		undoRecords:   kvdb.NewTable(db, KVTableUndoRecord),
+	}
+}
+
+func uint64Key(num uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, num)
+	return key
+}
+
+func uint32Key(num uint32) []byte {
+	key := make([]byte, 4)
+	binary.BigEndian.PutUint32(key, num)
+	return key
+}
+
+func (s *KVBlockStore) Close() {
+	s.db.Close()
+}
+
+// save block and index all transactions in one batch
+func (s *KVBlockStore) SaveBlock(block interfaces.Block) error {
+	body, e := block.Serialize()
+	if e != nil {
+		return e
+	}
+	head, e := block.SerializeExcludeTransactions()
+	if e != nil {
+		return e
+	}
+	batch := kvdb.NewBatch()
+	s.blocks.BatchPut(batch, block.Hash(), body)
+	s.heads.BatchPut(batch, block.Hash(), head)
+	height := uint64Key(block.GetHeight())
+	for i, tx := range block.GetTrsList() {
+		if i == 0 {
+			continue // drop coinbase
+		}
+		txbody, e := tx.Serialize()
+		if e != nil {
+			return e
+		}
+		s.transactions.BatchPut(batch, tx.Hash(), append(append([]byte{}, height...), txbody...))
+	}
+	return s.db.Write(batch)
+}
+
+func (s *KVBlockStore) UpdateSetBlockHashReferToHeight(height uint64, hash fields.Hash) error {
+	return s.heightToHash.Put(uint64Key(height), hash)
+}
+
+func (s *KVBlockStore) DeleteBlockHashReferToHeight(height uint64) error {
+	return s.heightToHash.Delete(uint64Key(height))
+}
+
+func (s *KVBlockStore) ReadBlockBytesByHash(hash fields.Hash) ([]byte, error) {
+	return s.blocks.Get(hash)
+}
+
+func (s *KVBlockStore) ReadBlockBytesByHeight(height uint64) (fields.Hash, []byte, error) {
+	hash, e := s.ReadBlockHashByHeight(height)
+	if e != nil || hash == nil {
+		return nil, nil, e
+	}
+	body, e := s.blocks.Get(hash)
+	if e != nil {
+		return nil, nil, e
+	}
+	return hash, body, nil
+}
+
+func (s *KVBlockStore) ReadBlockHashByHeight(height uint64) (fields.Hash, error) {
+	hash, e := s.heightToHash.Get(uint64Key(height))
+	if e != nil || hash == nil {
+		return nil, e
+	}
+	return hash, nil
+}
+
+// Head and meta of the block, kept after the body is pruned
+func (s *KVBlockStore) ReadBlockHeadBytesByHash(hash fields.Hash) ([]byte, error) {
+	return s.heads.Get(hash)
+}
+
+func (s *KVBlockStore) ReadBlockHeadBytesByHeight(height uint64) (fields.Hash, []byte, error) {
+	hash, e := s.ReadBlockHashByHeight(height)
+	if e != nil || hash == nil {
+		return nil, nil, e
+	}
+	head, e := s.heads.Get(hash)
+	if e != nil || head == nil {
+		return nil, nil, e
+	}
+	return hash, head, nil
+}
+
+// Save the head of the main chain block whose body is not kept
+func (s *KVBlockStore) SaveBlockHead(height uint64, hash fields.Hash, head []byte) error {
+	batch := kvdb.NewBatch()
+	s.heads.BatchPut(batch, hash, head)
+	s.heightToHash.BatchPut(batch, uint64Key(height), hash)
+	return s.db.Write(batch)
+}
+
+func (s *KVBlockStore) ReadTransactionBytesByHash(hash fields.Hash) (uint64, []byte, error) {
+	value, e := s.transactions.Get(hash)
+	if e != nil || value == nil {
+		return 0, nil, e
+	}
+	if len(value) < 8 {
+		return 0, nil, fmt.Errorf("transaction <%s> index broken.", hash.ToHex())
+	}
+	return binary.BigEndian.Uint64(value[0:8]), value[8:], nil
+}
+
+/**************************** diamond ****************************/
+
+func (s *KVBlockStore) SaveDiamond(diamond *stores.DiamondSmelt) error {
+	body, e := diamond.Serialize()
+	if e != nil {
+		return e
+	}
+	return s.diamonds.Put(diamond.Diamond, body)
+}
+
+func (s *KVBlockStore) UpdateSetDiamondNameReferToNumber(number uint32, name fields.DiamondName) error {
+	return s.diamondNumber.Put(uint32Key(number), name)
+}
+
+func (s *KVBlockStore) ReadDiamond(name fields.DiamondName) (*stores.DiamondSmelt, error) {
+	body, e := s.diamonds.Get(name)
+	if e != nil || body == nil {
+		return nil, e
+	}
+	diamond := &stores.DiamondSmelt{}
+	if _, e := diamond.Parse(body, 0); e != nil {
+		return nil, e
+	}
+	return diamond, nil
+}
+
+func (s *KVBlockStore) ReadDiamondByNumber(number uint32) (*stores.DiamondSmelt, error) {
+	name, e := s.ReadDiamondNameByNumber(number)
+	if e != nil || name == nil {
+		return nil, e
+	}
+	return s.ReadDiamond(name)
+}
+
+func (s *KVBlockStore) ReadDiamondNameByNumber(number uint32) (fields.DiamondName, error) {
+	name, e := s.diamondNumber.Get(uint32Key(number))
+	if e != nil || name == nil {
+		return nil, e
+	}
+	return name, nil
+}
+
+/**************************** btc move log ****************************/
+
+func (s *KVBlockStore) RunDownLoadBTCMoveLog() {}
+
+// Count of saved pages, the database saved before the count key counts the pages once
+func (s *KVBlockStore) GetBTCMoveLogTotalPage() (int, error) {
+	body, e := s.counts.Get(kvBTCMoveLogCountKey)
+	if e != nil {
+		return 0, e
+	}
+	if len(body) == 4 {
+		return int(binary.BigEndian.Uint32(body)), nil
+	}
+	count := 0
+	e = s.btcMoveLogs.Iterate(nil, func(key []byte, value []byte) bool {
+		count++
+		return true
+	})
+	if e != nil {
+		return 0, e
+	}
+	return count, s.counts.Put(kvBTCMoveLogCountKey, uint32Key(uint32(count)))
+}
+
+func (s *KVBlockStore) GetBTCMoveLogPageData(page int) ([]*stores.SatoshiGenesis, error) {
+	body, e := s.btcMoveLogs.Get(uint32Key(uint32(page)))
+	if e != nil {
+		return nil, e
+	}
+	if body == nil {
+		return nil, fmt.Errorf("btc move log page %d not find", page)
+	}
+	return stores.SatoshiGenesisPageParse(body, 0), nil
+}
+
+func (s *KVBlockStore) SaveBTCMoveLogPageData(page int, data []*stores.SatoshiGenesis) error {
+	if page < 1 {
+		return fmt.Errorf("btc move log page number must start from 1")
+	}
+	count, e := s.GetBTCMoveLogTotalPage()
+	if e != nil {
+		return e
+	}
+	exist, e := s.btcMoveLogs.Get(uint32Key(uint32(page)))
+	if e != nil {
+		return e
+	}
+	if exist == nil {
+		count++
+	}
+	batch := kvdb.NewBatch()
+	s.btcMoveLogs.BatchPut(batch, uint32Key(uint32(page)), stores.SatoshiGenesisPageSerialize(data))
+	s.counts.BatchPut(batch, kvBTCMoveLogCountKey, uint32Key(uint32(count)))
+	return s.db.Write(batch)
+}
+
+// Verification is required only after any log page has been saved
+func (s *KVBlockStore) LoadValidatedSatoshiGenesis(trsno int64) (*stores.SatoshiGenesis, bool) {
+	total, e := s.GetBTCMoveLogTotalPage()
+	if e != nil || total == 0 || trsno < 1 {
+		return nil, false
+	}
+	limit := int64(stores.SatoshiGenesisLogStorePageLimit)
+	page := int((trsno-1)/limit) + 1
+	idx := int((trsno - 1) % limit)
+	data, e := s.GetBTCMoveLogPageData(page)
+	if e != nil || idx >= len(data) {
+		return nil, true
+	}
+	return data[idx], true
+}
+
+/**************************** undo ****************************/
+
+func (s *KVBlockStore) SaveUndoRecord(height uint64, body []byte) error {
+	return s.undoRecords.Put(uint64Key(height), body)
+}
+
+func (s *KVBlockStore) ReadUndoRecord(height uint64) ([]byte, error) {
+	return s.undoRecords.Get(uint64Key(height))
+}
+
+func (s *KVBlockStore) DeleteUndoRecord(height uint64) error {
+	return s.undoRecords.Delete(uint64Key(height))
+}
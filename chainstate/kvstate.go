@@ -0,0 +1,90 @@
+package chainstate
+
+import (
+	"github.com/hacash/core/interfaces"
+	"github.com/hacash/core/kvdb"
+)
+
+/**
+ * Immutable state saved in a key value database
+ * Items are read from the database when not in memory, the changes merged into the immutable state
+ * are written in one batch and then dropped from memory
+ * Schema: "s" + StoreKey(prefix, key) => item body, "m" + "pending" => pending status
+ */
+
+const (
+	KVTableState     = "s"
+	KVTableStateMeta = "m"
+)
+
+var kvStatePendingKey = []byte("pending")
+
+// Open the immutable state saved in the database, empty database starts from height 0
+// The pending block head is not saved, read it from the block store if needed
+func OpenKVChainStateImmutable(db kvdb.Database, blockstore interfaces.BlockStore) (*MemoryChainState, error) {
+	cs := NewMemoryChainStateImmutable(blockstore)
+	cs.db = kvdb.NewTable(db, KVTableState)
+	meta := kvdb.NewTable(db, KVTableStateMeta)
+	body, e := meta.Get(kvStatePendingKey)
+	if e != nil {
+		return nil, e
+	}
+	if body != nil {
+		pending := &PendingStatus{}
+		if _, e := pending.Parse(body, 0); e != nil {
+			return nil, e
+		}
+		cs.pending = pending
+	}
+	return cs, nil
+}
+
+// Write the changed items and the pending status, nil value is deleted
+func (cs *MemoryChainState) persist(changes map[string][]byte) error {
+	if cs.db == nil {
+		return nil
+	}
+	batch := kvdb.NewBatch()
+	for k, v := range changes {
+		if v == nil {
+			cs.db.BatchDelete(batch, []byte(k))
+		} else {
+			cs.db.BatchPut(batch, []byte(k), v)
+		}
+	}
+	pending, e := cs.pending.Serialize()
+	if e != nil {
+		return e
+	}
+	kvdb.NewTable(cs.db.Database(), KVTableStateMeta).BatchPut(batch, kvStatePendingKey, pending)
+	if e := cs.db.Database().Write(batch); e != nil {
+		return e
+	}
+	// saved, read them from the database
+	cs.mux.Lock()
+	defer cs.mux.Unlock()
+	for k := range changes {
+		delete(cs.datas, k)
+	}
+	return nil
+}
+
+func (cs *MemoryChainState) isEmptyDatabase() bool {
+	empty := true
+	if cs.db != nil {
+		cs.db.Iterate(nil, func(key []byte, value []byte) bool {
+			empty = false
+			return false
+		})
+	}
+	return empty
+}
+
+// Collect the changes of this fork into the map
+func (cs *MemoryChainState) collectChanges(changes map[string][]byte) {
+	cs.mux.RLock()
+	defer cs.mux.RUnlock()
+	for k, v := range cs.datas {
+		changes[k] = v
+	}
+}
@@ -0,0 +1,684 @@
+package chainstate
+
+import (
+	"fmt"
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/interfaces"
+	"github.com/hacash/core/kvdb"
+	"github.com/hacash/core/statetree"
+	"github.com/hacash/core/stores"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Key prefix of each store type
+const (
+	KeyPrefixBalance        byte = 1
+	KeyPrefixDiamond        byte = 2
+	KeyPrefixChannel        byte = 3
+	KeyPrefixLockbls        byte = 4
+	KeyPrefixDiamondLending byte = 5
+	KeyPrefixBitcoinLending byte = 6
+	KeyPrefixUserLending    byte = 7
+	KeyPrefixChaswap        byte = 8
+	KeyPrefixTxHash         byte = 9
+	KeyPrefixMoveBTCTxHash  byte = 10
+	KeyPrefixTotalSupply    byte = 11
+	KeyPrefixLatestStatus   byte = 12
+)
+
+func StoreKey(prefix byte, key []byte) string {
+	return string(append([]byte{prefix}, key...))
+}
+
+// Store data item
+type storeItem interface {
+	Serialize() ([]byte, error)
+	Parse([]byte, uint32) (uint32, error)
+}
+
+var forkStateSerialNumber uint64 = 0
+
+// Chain state that keeps all data in memory
+// Fork states only save their own changes and read through the parent
+type MemoryChainState struct {
+	serial uint64
+
+	parent *MemoryChainState
+	childs map[uint64]*MemoryChainState
+
+	// nil value means deleted
+	datas map[string][]byte
+
+	pending    *PendingStatus
+	blockstore interfaces.BlockStore
+	db         *kvdb.Table // immutable state saved in database, nil keeps in memory only
+
+	// data before the block, nil if not written with journal
+	undoItems []*RecordItem
+
+	// state tree after the pending block, nil means not built or changed
+	tree *statetree.Tree
+
+	isImmutable bool
+	isInTxPool  bool
+	isRebuild   bool
+	isDestoryed bool
+
+	mux sync.RWMutex
+}
+
+func newMemoryChainState(parent *MemoryChainState) *MemoryChainState {
+	return &MemoryChainState{
+		serial: atomic.AddUint64(&forkStateSerialNumber, 1),
+		parent: parent,
+		childs: make(map[uint64]*MemoryChainState),
+		datas:  make(map[string][]byte),
+	}
+}
+
+// Create the base immutable state
+func NewMemoryChainStateImmutable(blockstore interfaces.BlockStore) *MemoryChainState {
+	if blockstore == nil {
+		blockstore = NewMemoryBlockStore()
+	}
+	cs := newMemoryChainState(nil)
+	cs.blockstore = blockstore
+	cs.isImmutable = true
+	cs.pending = NewPendingStatus(0, nil, nil)
+	return cs
+}
+
+/**************************** data ****************************/
+
+// Read through the forks to the base state, and its database if not cached
+func (cs *MemoryChainState) getBytes(key string) ([]byte, bool, error) {
+	for s := cs; s != nil; s = s.parent {
+		s.mux.RLock()
+		v, ok := s.datas[key]
+		s.mux.RUnlock()
+		if ok {
+			return v, v != nil, nil
+		}
+		if s.db != nil {
+			v, e := s.db.Get([]byte(key))
+			if e != nil {
+				return nil, false, e
+			}
+			return v, v != nil, nil
+		}
+	}
+	return nil, false, nil
+}
+
+func (cs *MemoryChainState) setBytes(key string, value []byte) {
+	cs.mux.Lock()
+	defer cs.mux.Unlock()
+	cs.datas[key] = value
+	cs.tree = nil
+}
+
+func (cs *MemoryChainState) setItem(prefix byte, key []byte, item storeItem) error {
+	if item == nil {
+		return fmt.Errorf("set store item cannot be nil")
+	}
+	body, e := item.Serialize()
+	if e != nil {
+		return e
+	}
+	cs.setBytes(StoreKey(prefix, key), body)
+	return nil
+}
+
+func (cs *MemoryChainState) getItem(prefix byte, key []byte, item storeItem) (bool, error) {
+	body, ok, e := cs.getBytes(StoreKey(prefix, key))
+	if !ok || e != nil {
+		return false, e
+	}
+	_, e = item.Parse(body, 0)
+	if e != nil {
+		return false, e
+	}
+	return true, nil
+}
+
+func (cs *MemoryChainState) delItem(prefix byte, key []byte) error {
+	cs.setBytes(StoreKey(prefix, key), nil)
+	return nil
+}
+
+// All data with prefix, merged from the base state to this fork, in key order
+func (cs *MemoryChainState) TraversalItems(prefix byte, fn func(key []byte, body []byte) bool) {
+	path := make([]*MemoryChainState, 0)
+	for s := cs; s != nil; s = s.parent {
+		path = append(path, s)
+	}
+	merged := make(map[string][]byte)
+	if db := path[len(path)-1].db; db != nil {
+		db.Iterate([]byte{prefix}, func(key []byte, value []byte) bool {
+			merged[string(key)] = value
+			return true
+		})
+	}
+	for i := len(path) - 1; i >= 0; i-- {
+		s := path[i]
+		s.mux.RLock()
+		for k, v := range s.datas {
+			if len(k) > 0 && k[0] == prefix {
+				merged[k] = v
+			}
+		}
+		s.mux.RUnlock()
+	}
+	keys := make([]string, 0, len(merged))
+	for k, v := range merged {
+		if v != nil { // nil is deleted
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !fn([]byte(k[1:]), merged[k]) {
+			return
+		}
+	}
+}
+
+/**************************** status ****************************/
+
+func (cs *MemoryChainState) IsDatabaseVersionRebuildMode() bool {
+	return cs.isRebuild
+}
+func (cs *MemoryChainState) SetDatabaseVersionRebuildMode(set bool) {
+	cs.isRebuild = set
+}
+func (cs *MemoryChainState) IsInTxPool() bool {
+	return cs.isInTxPool
+}
+func (cs *MemoryChainState) SetInTxPool(set bool) {
+	cs.isInTxPool = set
+}
+
+func (cs *MemoryChainState) GetPending() interfaces.PendingStatus {
+	return cs.pending
+}
+func (cs *MemoryChainState) SetPending(pd interfaces.PendingStatus) error {
+	pending, ok := pd.(*PendingStatus)
+	if !ok {
+		return fmt.Errorf("pending status type not support")
+	}
+	cs.pending = pending
+	return nil
+}
+func (cs *MemoryChainState) GetPendingBlockHeight() uint64 {
+	return cs.pending.GetPendingBlockHeight()
+}
+func (cs *MemoryChainState) GetPendingBlockHash() fields.Hash {
+	return cs.pending.GetPendingBlockHash()
+}
+
+func (cs *MemoryChainState) LatestStatusRead() (interfaces.LatestStatus, error) {
+	status := NewLatestStatus()
+	_, e := cs.getItem(KeyPrefixLatestStatus, nil, status)
+	if e != nil {
+		return nil, e
+	}
+	return status, nil
+}
+func (cs *MemoryChainState) LatestStatusSet(status interfaces.LatestStatus) error {
+	return cs.setItem(KeyPrefixLatestStatus, nil, status)
+}
+func (cs *MemoryChainState) ReadLastestDiamond() (*stores.DiamondSmelt, error) {
+	status, e := cs.LatestStatusRead()
+	if e != nil {
+		return nil, e
+	}
+	return status.ReadLastestDiamond(), nil
+}
+
+func (cs *MemoryChainState) ReadTotalSupply() (*stores.TotalSupply, error) {
+	total := stores.NewTotalSupplyStoreData()
+	_, e := cs.getItem(KeyPrefixTotalSupply, nil, total)
+	if e != nil {
+		return nil, e
+	}
+	return total, nil
+}
+func (cs *MemoryChainState) UpdateSetTotalSupply(total *stores.TotalSupply) error {
+	return cs.setItem(KeyPrefixTotalSupply, nil, total)
+}
+
+/**************************** store ****************************/
+
+func (cs *MemoryChainState) BlockStore() interfaces.BlockStore {
+	for s := cs; s != nil; s = s.parent {
+		if s.blockstore != nil {
+			return s.blockstore
+		}
+	}
+	return nil
+}
+func (cs *MemoryChainState) BlockStoreRead() interfaces.BlockStoreRead {
+	return cs.BlockStore()
+}
+
+/**************************** tx hash ****************************/
+
+func (cs *MemoryChainState) ContainTxHash(hx fields.Hash, height fields.BlockHeight) error {
+	return cs.setItem(KeyPrefixTxHash, hx, &height)
+}
+func (cs *MemoryChainState) RemoveTxHash(hx fields.Hash) error {
+	return cs.delItem(KeyPrefixTxHash, hx)
+}
+func (cs *MemoryChainState) CheckTxHash(hx fields.Hash) (bool, error) {
+	_, ok, e := cs.getBytes(StoreKey(KeyPrefixTxHash, hx))
+	return ok, e
+}
+func (cs *MemoryChainState) ReadTxBelongHeightByHash(hx fields.Hash) (fields.BlockHeight, error) {
+	var height fields.BlockHeight
+	_, e := cs.getItem(KeyPrefixTxHash, hx, &height)
+	if e != nil {
+		return 0, e
+	}
+	return height, nil
+}
+func (cs *MemoryChainState) ReadTransactionBytesByHash(hx fields.Hash) (fields.BlockHeight, []byte, error) {
+	height, e := cs.ReadTxBelongHeightByHash(hx)
+	if e != nil || height == 0 {
+		return 0, nil, e
+	}
+	reader, ok := cs.BlockStore().(TransactionBytesReader)
+	if !ok {
+		return height, nil, nil
+	}
+	_, body, e := reader.ReadTransactionBytesByHash(hx)
+	if e != nil {
+		return 0, nil, e
+	}
+	return height, body, nil
+}
+
+/**************************** query ****************************/
+
+func (cs *MemoryChainState) Balance(addr fields.Address) (*stores.Balance, error) {
+	item := stores.NewEmptyBalance()
+	ok, e := cs.getItem(KeyPrefixBalance, addr, item)
+	if !ok || e != nil {
+		return nil, e
+	}
+	return item, nil
+}
+func (cs *MemoryChainState) Lockbls(id fields.LockblsId) (*stores.Lockbls, error) {
+	item := &stores.Lockbls{}
+	ok, e := cs.getItem(KeyPrefixLockbls, id, item)
+	if !ok || e != nil {
+		return nil, e
+	}
+	return item, nil
+}
+func (cs *MemoryChainState) Channel(id fields.ChannelId) (*stores.Channel, error) {
+	item := stores.CreateEmptyChannel()
+	ok, e := cs.getItem(KeyPrefixChannel, id, item)
+	if !ok || e != nil {
+		return nil, e
+	}
+	return item, nil
+}
+func (cs *MemoryChainState) Diamond(name fields.DiamondName) (*stores.Diamond, error) {
+	item := &stores.Diamond{}
+	ok, e := cs.getItem(KeyPrefixDiamond, name, item)
+	if !ok || e != nil {
+		return nil, e
+	}
+	return item, nil
+}
+func (cs *MemoryChainState) DiamondSystemLending(id fields.DiamondSyslendId) (*stores.DiamondSystemLending, error) {
+	item := &stores.DiamondSystemLending{}
+	ok, e := cs.getItem(KeyPrefixDiamondLending, id, item)
+	if !ok || e != nil {
+		return nil, e
+	}
+	return item, nil
+}
+func (cs *MemoryChainState) BitcoinSystemLending(id fields.BitcoinSyslendId) (*stores.BitcoinSystemLending, error) {
+	item := &stores.BitcoinSystemLending{}
+	ok, e := cs.getItem(KeyPrefixBitcoinLending, id, item)
+	if !ok || e != nil {
+		return nil, e
+	}
+	return item, nil
+}
+func (cs *MemoryChainState) UserLending(id fields.UserLendingId) (*stores.UserLending, error) {
+	item := &stores.UserLending{}
+	ok, e := cs.getItem(KeyPrefixUserLending, id, item)
+	if !ok || e != nil {
+		return nil, e
+	}
+	return item, nil
+}
+func (cs *MemoryChainState) Chaswap(id fields.HashHalfChecker) (*stores.Chaswap, error) {
+	item := &stores.Chaswap{}
+	ok, e := cs.getItem(KeyPrefixChaswap, id, item)
+	if !ok || e != nil {
+		return nil, e
+	}
+	return item, nil
+}
+
+/**************************** operate ****************************/
+
+func (cs *MemoryChainState) BalanceSet(addr fields.Address, item *stores.Balance) error {
+	return cs.setItem(KeyPrefixBalance, addr, item)
+}
+func (cs *MemoryChainState) BalanceDel(addr fields.Address) error {
+	return cs.delItem(KeyPrefixBalance, addr)
+}
+
+func (cs *MemoryChainState) LockblsCreate(id fields.LockblsId, item *stores.Lockbls) error {
+	return cs.setItem(KeyPrefixLockbls, id, item)
+}
+func (cs *MemoryChainState) LockblsUpdate(id fields.LockblsId, item *stores.Lockbls) error {
+	return cs.setItem(KeyPrefixLockbls, id, item)
+}
+func (cs *MemoryChainState) LockblsDelete(id fields.LockblsId) error {
+	return cs.delItem(KeyPrefixLockbls, id)
+}
+
+func (cs *MemoryChainState) ChannelCreate(id fields.ChannelId, item *stores.Channel) error {
+	return cs.setItem(KeyPrefixChannel, id, item)
+}
+func (cs *MemoryChainState) ChannelUpdate(id fields.ChannelId, item *stores.Channel) error {
+	return cs.setItem(KeyPrefixChannel, id, item)
+}
+func (cs *MemoryChainState) ChannelDelete(id fields.ChannelId) error {
+	return cs.delItem(KeyPrefixChannel, id)
+}
+
+func (cs *MemoryChainState) DiamondSet(name fields.DiamondName, item *stores.Diamond) error {
+	return cs.setItem(KeyPrefixDiamond, name, item)
+}
+func (cs *MemoryChainState) DiamondDel(name fields.DiamondName) error {
+	return cs.delItem(KeyPrefixDiamond, name)
+}
+
+func (cs *MemoryChainState) DiamondLendingCreate(id fields.DiamondSyslendId, item *stores.DiamondSystemLending) error {
+	return cs.setItem(KeyPrefixDiamondLending, id, item)
+}
+func (cs *MemoryChainState) DiamondLendingUpdate(id fields.DiamondSyslendId, item *stores.DiamondSystemLending) error {
+	return cs.setItem(KeyPrefixDiamondLending, id, item)
+}
+func (cs *MemoryChainState) DiamondLendingDelete(id fields.DiamondSyslendId) error {
+	return cs.delItem(KeyPrefixDiamondLending, id)
+}
+
+func (cs *MemoryChainState) BitcoinLendingCreate(id fields.BitcoinSyslendId, item *stores.BitcoinSystemLending) error {
+	return cs.setItem(KeyPrefixBitcoinLending, id, item)
+}
+func (cs *MemoryChainState) BitcoinLendingUpdate(id fields.BitcoinSyslendId, item *stores.BitcoinSystemLending) error {
+	return cs.setItem(KeyPrefixBitcoinLending, id, item)
+}
+func (cs *MemoryChainState) BitcoinLendingDelete(id fields.BitcoinSyslendId) error {
+	return cs.delItem(KeyPrefixBitcoinLending, id)
+}
+
+func (cs *MemoryChainState) UserLendingCreate(id fields.UserLendingId, item *stores.UserLending) error {
+	return cs.setItem(KeyPrefixUserLending, id, item)
+}
+func (cs *MemoryChainState) UserLendingUpdate(id fields.UserLendingId, item *stores.UserLending) error {
+	return cs.setItem(KeyPrefixUserLending, id, item)
+}
+func (cs *MemoryChainState) UserLendingDelete(id fields.UserLendingId) error {
+	return cs.delItem(KeyPrefixUserLending, id)
+}
+
+func (cs *MemoryChainState) ChaswapCreate(id fields.HashHalfChecker, item *stores.Chaswap) error {
+	return cs.setItem(KeyPrefixChaswap, id, item)
+}
+func (cs *MemoryChainState) ChaswapUpdate(id fields.HashHalfChecker, item *stores.Chaswap) error {
+	return cs.setItem(KeyPrefixChaswap, id, item)
+}
+func (cs *MemoryChainState) ChaswapDelete(id fields.HashHalfChecker) error {
+	return cs.delItem(KeyPrefixChaswap, id)
+}
+
+// movebtc
+
+func (cs *MemoryChainState) SaveMoveBTCBelongTxHash(trsno uint32, txhash []byte) error {
+	key, _ := fields.VarUint4(trsno).Serialize()
+	cs.setBytes(StoreKey(KeyPrefixMoveBTCTxHash, key), append([]byte{}, txhash...))
+	return nil
+}
+func (cs *MemoryChainState) ReadMoveBTCTxHashByTrsNo(trsno uint32) ([]byte, error) {
+	key, _ := fields.VarUint4(trsno).Serialize()
+	txhash, ok, e := cs.getBytes(StoreKey(KeyPrefixMoveBTCTxHash, key))
+	if !ok || e != nil {
+		return nil, e
+	}
+	return txhash, nil
+}
+
+/**************************** fork ****************************/
+
+func (cs *MemoryChainState) GetParent() interfaces.ChainState {
+	if cs.parent == nil {
+		return nil
+	}
+	return cs.parent
+}
+
+func (cs *MemoryChainState) GetChilds() map[uint64]interfaces.ChainState {
+	cs.mux.RLock()
+	defer cs.mux.RUnlock()
+	childs := make(map[uint64]interfaces.ChainState, len(cs.childs))
+	for k, v := range cs.childs {
+		childs[k] = v
+	}
+	return childs
+}
+
+func (cs *MemoryChainState) fork(pending *PendingStatus) (*MemoryChainState, error) {
+	cs.mux.Lock()
+	defer cs.mux.Unlock()
+	if cs.isDestoryed {
+		return nil, fmt.Errorf("cannot fork from a destoryed state")
+	}
+	child := newMemoryChainState(cs)
+	child.pending = pending
+	child.isInTxPool = cs.isInTxPool
+	child.isRebuild = cs.isRebuild
+	cs.childs[child.serial] = child
+	return child, nil
+}
+
+// Start a sub state for next block
+func (cs *MemoryChainState) ForkNextBlock(height uint64, hash fields.Hash, block interfaces.Block) (interfaces.ChainState, error) {
+	var head interfaces.BlockHeadMetaRead = nil
+	if block != nil {
+		head = block
+	}
+	return cs.fork(NewPendingStatus(height, hash, head))
+}
+
+// Start a sub state with same pending block
+func (cs *MemoryChainState) ForkSubChild() (interfaces.ChainState, error) {
+	return cs.fork(cs.pending.Clone())
+}
+
+// Copy all changes of the sub state into this state
+func (cs *MemoryChainState) TraversalCopy(src interfaces.ChainState) error {
+	mem, ok := src.(*MemoryChainState)
+	if !ok {
+		return fmt.Errorf("TraversalCopy only support MemoryChainState")
+	}
+	mem.mux.RLock()
+	defer mem.mux.RUnlock()
+	cs.mux.Lock()
+	defer cs.mux.Unlock()
+	for k, v := range mem.datas {
+		cs.datas[k] = v
+	}
+	cs.tree = nil
+	if mem.pending != nil {
+		cs.pending = mem.pending.Clone()
+	}
+	return nil
+}
+
+func (cs *MemoryChainState) SearchBaseStateByBlockHash(hash fields.Hash) (interfaces.ChainState, error) {
+	if cs.pending != nil && cs.pending.GetPendingBlockHash().Equal(hash) {
+		return cs, nil
+	}
+	for _, child := range cs.GetChilds() {
+		res, e := child.SearchBaseStateByBlockHash(hash)
+		if e != nil {
+			return nil, e
+		}
+		if res != nil {
+			return res, nil
+		}
+	}
+	return nil, nil // not find
+}
+
+// Destroy, including all sub states
+func (cs *MemoryChainState) Destory() {
+	for _, child := range cs.GetChilds() {
+		child.Destory()
+	}
+	if cs.parent != nil {
+		cs.parent.mux.Lock()
+		delete(cs.parent.childs, cs.serial)
+		cs.parent.mux.Unlock()
+	}
+	cs.mux.Lock()
+	defer cs.mux.Unlock()
+	cs.childs = make(map[uint64]*MemoryChainState)
+	cs.datas = make(map[string][]byte)
+	cs.isDestoryed = true
+}
+
+func (cs *MemoryChainState) IsImmutable() bool {
+	return cs.isImmutable
+}
+
+// Merge this fork and all its parents into the base immutable state
+// The sub states of this fork become the childs of the base state, others are destroyed
+func (cs *MemoryChainState) ImmutableWriteToDisk() (interfaces.ChainStateImmutable, error) {
+	if cs.isImmutable {
+		return cs, nil
+	}
+	path := make([]*MemoryChainState, 0)
+	var base *MemoryChainState = nil
+	for s := cs; s != nil; s = s.parent {
+		if s.isImmutable {
+			base = s
+			break
+		}
+		path = append(path, s)
+	}
+	if base == nil {
+		return nil, fmt.Errorf("cannot find immutable base state")
+	}
+	tree := cs.StateTree()
+	changes := make(map[string][]byte)
+	for i := len(path) - 1; i >= 0; i-- {
+		if e := path[i].saveUndoRecord(base); e != nil {
+			return nil, e
+		}
+		if e := base.TraversalCopy(path[i]); e != nil {
+			return nil, e
+		}
+		if base.db != nil {
+			path[i].collectChanges(changes)
+		}
+	}
+	if e := base.persist(changes); e != nil {
+		return nil, e
+	}
+	base.mux.Lock()
+	base.tree = tree
+	base.mux.Unlock()
+	// move sub states
+	cs.mux.Lock()
+	keeps := cs.childs
+	cs.childs = make(map[uint64]*MemoryChainState)
+	cs.mux.Unlock()
+	base.mux.Lock()
+	drops := base.childs
+	base.childs = keeps
+	for _, child := range keeps {
+		child.parent = base
+	}
+	base.mux.Unlock()
+	for _, child := range drops {
+		child.parent = nil
+		child.Destory()
+	}
+	return base, nil
+}
+
+// Fill the empty immutable state with the items of a trusted snapshot, keys are made by StoreKey
+func (cs *MemoryChainState) RestoreImmutableState(items map[string][]byte, pending *PendingStatus) error {
+	if !cs.isImmutable {
+		return fmt.Errorf("only the immutable state can restore.")
+	}
+	cs.mux.Lock()
+	if len(cs.datas) > 0 || len(cs.childs) > 0 || cs.GetPendingBlockHeight() > 0 || !cs.isEmptyDatabase() {
+		cs.mux.Unlock()
+		return fmt.Errorf("state to restore must be empty.")
+	}
+	changes := make(map[string][]byte, len(items))
+	for k, v := range items {
+		if v == nil {
+			continue
+		}
+		cs.datas[k] = v
+		changes[k] = v
+	}
+	cs.tree = nil
+	cs.pending = pending
+	cs.mux.Unlock()
+	return cs.persist(changes)
+}
+
+// Count of non empty HAC, SAT and HACD accounts
+func (cs *MemoryChainState) GetTotalNonEmptyAccountStatistics() []int64 {
+	var hac, sat, hacd int64 = 0, 0, 0
+	cs.TraversalItems(KeyPrefixBalance, func(key []byte, body []byte) bool {
+		bls := stores.NewEmptyBalance()
+		if _, e := bls.Parse(body, 0); e != nil {
+			return true
+		}
+		if bls.Hacash.IsPositive() {
+			hac++
+		}
+		if bls.Satoshi > 0 {
+			sat++
+		}
+		if bls.Diamond > 0 {
+			hacd++
+		}
+		return true
+	})
+	return []int64{hac, sat, hacd}
+}
+
+/**************************** immutable ****************************/
+
+// Pending block hash of all sub states
+func (cs *MemoryChainState) SeekImmatureBlockHashs() ([]fields.Hash, error) {
+	hashs := make([]fields.Hash, 0)
+	for _, child := range cs.GetChilds() {
+		mem := child.(*MemoryChainState)
+		hashs = append(hashs, mem.GetPendingBlockHash())
+		subs, e := mem.SeekImmatureBlockHashs()
+		if e != nil {
+			return nil, e
+		}
+		hashs = append(hashs, subs...)
+	}
+	return hashs, nil
+}
+
+func (cs *MemoryChainState) Close() {}
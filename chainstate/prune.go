@@ -0,0 +1,192 @@
+package chainstate
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/kvdb"
+	"os"
+)
+
+/**
+ * Pruned node mode of the flat block store
+ * Keep the heads of all blocks, the last N full blocks and the undo records of them
+ * A segment is deleted when all blocks in it are older than the last N, the hash of the main chain height is moved to the key value database
+ * Diamond smelt records and btc move logs are never pruned, the consensus depends on them
+ * The immutable state keeps only the current items, it can not rollback deeper than the undo records kept
+ */
+
+// Body of the block is deleted by pruning
+type BlockPrunedError struct {
+	Height uint64
+	Hash   fields.Hash
+}
+
+func (e *BlockPrunedError) Error() string {
+	if e.Hash != nil {
+		return fmt.Sprintf("block <%s> is pruned.", e.Hash.ToHex())
+	}
+	return fmt.Sprintf("block %d is pruned.", e.Height)
+}
+
+func IsBlockPrunedError(e error) bool {
+	_, ok := e.(*BlockPrunedError)
+	return ok
+}
+
+// Keep the last N full blocks, prune the older when the main chain grows, 0 is not prune
+func (s *FlatBlockStore) SetPruneKeepBlocks(keep uint64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.pruneKeep = keep
+}
+
+// Bodies of the blocks below the height are deleted
+func (s *FlatBlockStore) GetPrunedHeight() uint64 {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.prunedHeight
+}
+
+func (s *FlatBlockStore) Prune() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.pruneKeep == 0 {
+		return fmt.Errorf("prune mode not open.")
+	}
+	return s.prune()
+}
+
+func (s *FlatBlockStore) firstSegment() uint32 {
+	first := s.current
+	for num := range s.segments {
+		if num < first {
+			first = num
+		}
+	}
+	return first
+}
+
+func (s *FlatBlockStore) isPrunedHeight(height uint64) bool {
+	if height >= uint64(len(s.heights)) {
+		return false
+	}
+	location, ok := flatLocationByEntry(s.heights[height])
+	return ok && location.segment < s.firstSegment()
+}
+
+// Delete the old segments and undo records out of the last N blocks
+func (s *FlatBlockStore) prune() error {
+	count := uint64(len(s.heights))
+	if count <= s.pruneKeep {
+		return nil
+	}
+	limit := count - s.pruneKeep // heights below are pruned
+	deleted := false
+	for {
+		first := s.firstSegment()
+		if first == s.current || s.segmentTop[first] >= limit {
+			break
+		}
+		if e := s.deleteSegment(first); e != nil {
+			return e
+		}
+		deleted = true
+	}
+	if deleted {
+		if e := s.rewriteBlockIndex(); e != nil {
+			return e
+		}
+	}
+	return s.pruneUndoRecords(limit)
+}
+
+func (s *FlatBlockStore) deleteSegment(segment uint32) error {
+	drops := make([]*flatBlockRef, 0)
+	for location, block := range s.blocks {
+		if location.segment == segment {
+			drops = append(drops, block)
+		}
+	}
+	// move the main chain hashes into the database and drop the heads of the side blocks
+	batch := kvdb.NewBatch()
+	for _, block := range drops {
+		if block.height < uint64(len(s.heights)) && s.heights[block.height] == block.location.entry() {
+			head, e := s.heads.Get(block.hash)
+			if e != nil {
+				return e
+			}
+			if head == nil {
+				return fmt.Errorf("head of block %d not saved, can not prune.", block.height)
+			}
+			s.heightToHash.BatchPut(batch, uint64Key(block.height), block.hash)
+		} else {
+			s.heads.BatchDelete(batch, block.hash)
+		}
+	}
+	if e := s.db.Write(batch); e != nil {
+		return e
+	}
+	s.segments[segment].Close()
+	delete(s.segments, segment)
+	if e := os.Remove(s.segmentPath(segment)); e != nil {
+		return e
+	}
+	for _, block := range drops {
+		delete(s.blocks, block.location)
+		delete(s.hashToBlock, string(block.hash))
+	}
+	for k, tx := range s.transactions {
+		if tx.block.location.segment == segment {
+			delete(s.transactions, k)
+		}
+	}
+	if top := s.segmentTop[segment] + 1; top > s.prunedHeight {
+		s.prunedHeight = top
+	}
+	delete(s.segmentTop, segment)
+	return nil
+}
+
+func (s *FlatBlockStore) pruneUndoRecords(limit uint64) error {
+	if s.undoPrunedTop == 0 {
+		// find the lowest undo record once
+		s.undoPrunedTop = limit
+		e := s.undoRecords.Iterate(nil, func(key []byte, value []byte) bool {
+			if len(key) == 8 {
+				if height := binary.BigEndian.Uint64(key); height < limit {
+					s.undoPrunedTop = height
+				}
+			}
+			return false
+		})
+		if e != nil {
+			return e
+		}
+	}
+	if s.undoPrunedTop >= limit {
+		return nil
+	}
+	batch := kvdb.NewBatch()
+	for height := s.undoPrunedTop; height < limit; height++ {
+		s.undoRecords.BatchDelete(batch, uint64Key(height))
+	}
+	if e := s.db.Write(batch); e != nil {
+		return e
+	}
+	s.undoPrunedTop = limit
+	return nil
+}
+
+// Head and meta of the main chain block at the height, kept after the body is pruned or saved alone
+func (s *FlatBlockStore) ReadBlockHeadBytesByHeight(height uint64) (fields.Hash, []byte, error) {
+	hash, e := s.ReadBlockHashByHeight(height)
+	if e != nil || hash == nil {
+		return nil, nil, e
+	}
+	head, e := s.heads.Get(hash)
+	if e != nil {
+		return nil, nil, e
+	}
+	return hash, head, nil
+}
@@ -0,0 +1,279 @@
+package chainstate
+
+import (
+	"bytes"
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/interfaces"
+	"github.com/hacash/core/stores"
+)
+
+// Touched store item and its data before the first change
+type RecordItem struct {
+	Prefix byte
+	Key    []byte
+	Before []byte // nil means not exist
+}
+
+// Wrap a state operation and record the original data of every item changed through it
+type StateRecorder struct {
+	interfaces.ChainStateOperation
+
+	touched map[string]bool
+	items   []*RecordItem
+}
+
+func NewStateRecorder(state interfaces.ChainStateOperation) *StateRecorder {
+	return &StateRecorder{
+		ChainStateOperation: state,
+		touched:             make(map[string]bool),
+		items:               make([]*RecordItem, 0),
+	}
+}
+
+// In the order of first change
+func (r *StateRecorder) GetRecordItems() []*RecordItem {
+	return r.items
+}
+
+func isEmptyTotalSupply(total *stores.TotalSupply) bool {
+	body, e1 := total.Serialize()
+	empty, e2 := stores.NewTotalSupplyStoreData().Serialize()
+	return e1 == nil && e2 == nil && bytes.Equal(body, empty)
+}
+
+func (r *StateRecorder) touch(prefix byte, key []byte, read func() (storeItem, error)) error {
+	k := StoreKey(prefix, key)
+	if r.touched[k] {
+		return nil
+	}
+	item, e := read()
+	if e != nil {
+		return e
+	}
+	var before []byte = nil
+	if item != nil {
+		before, e = item.Serialize()
+		if e != nil {
+			return e
+		}
+	}
+	r.touched[k] = true
+	r.items = append(r.items, &RecordItem{
+		Prefix: prefix,
+		Key:    append([]byte{}, key...),
+		Before: before,
+	})
+	return nil
+}
+
+// Read current store item by prefix and key, nil means not exist
+func readStoreItem(state interfaces.ChainStateOperationRead, prefix byte, key []byte) (storeItem, error) {
+	var item storeItem = nil
+	var e error = nil
+	switch prefix {
+	case KeyPrefixBalance:
+		var obj *stores.Balance
+		if obj, e = state.Balance(key); obj != nil {
+			item = obj
+		}
+	case KeyPrefixDiamond:
+		var obj *stores.Diamond
+		if obj, e = state.Diamond(key); obj != nil {
+			item = obj
+		}
+	case KeyPrefixChannel:
+		var obj *stores.Channel
+		if obj, e = state.Channel(key); obj != nil {
+			item = obj
+		}
+	case KeyPrefixLockbls:
+		var obj *stores.Lockbls
+		if obj, e = state.Lockbls(key); obj != nil {
+			item = obj
+		}
+	case KeyPrefixDiamondLending:
+		var obj *stores.DiamondSystemLending
+		if obj, e = state.DiamondSystemLending(key); obj != nil {
+			item = obj
+		}
+	case KeyPrefixBitcoinLending:
+		var obj *stores.BitcoinSystemLending
+		if obj, e = state.BitcoinSystemLending(key); obj != nil {
+			item = obj
+		}
+	case KeyPrefixUserLending:
+		var obj *stores.UserLending
+		if obj, e = state.UserLending(key); obj != nil {
+			item = obj
+		}
+	case KeyPrefixChaswap:
+		var obj *stores.Chaswap
+		if obj, e = state.Chaswap(key); obj != nil {
+			item = obj
+		}
+	case KeyPrefixTotalSupply:
+		var obj *stores.TotalSupply
+		if obj, e = state.ReadTotalSupply(); obj != nil && !isEmptyTotalSupply(obj) {
+			item = obj // empty one is returned when not stored
+		}
+	}
+	if e != nil {
+		return nil, e
+	}
+	return item, nil
+}
+
+func (r *StateRecorder) record(prefix byte, key []byte) error {
+	return r.touch(prefix, key, func() (storeItem, error) {
+		return readStoreItem(r.ChainStateOperation, prefix, key)
+	})
+}
+
+/**************************** operate ****************************/
+
+func (r *StateRecorder) UpdateSetTotalSupply(total *stores.TotalSupply) error {
+	if e := r.record(KeyPrefixTotalSupply, nil); e != nil {
+		return e
+	}
+	return r.ChainStateOperation.UpdateSetTotalSupply(total)
+}
+
+func (r *StateRecorder) BalanceSet(addr fields.Address, item *stores.Balance) error {
+	if e := r.record(KeyPrefixBalance, addr); e != nil {
+		return e
+	}
+	return r.ChainStateOperation.BalanceSet(addr, item)
+}
+func (r *StateRecorder) BalanceDel(addr fields.Address) error {
+	if e := r.record(KeyPrefixBalance, addr); e != nil {
+		return e
+	}
+	return r.ChainStateOperation.BalanceDel(addr)
+}
+
+func (r *StateRecorder) LockblsCreate(id fields.LockblsId, item *stores.Lockbls) error {
+	if e := r.record(KeyPrefixLockbls, id); e != nil {
+		return e
+	}
+	return r.ChainStateOperation.LockblsCreate(id, item)
+}
+func (r *StateRecorder) LockblsUpdate(id fields.LockblsId, item *stores.Lockbls) error {
+	if e := r.record(KeyPrefixLockbls, id); e != nil {
+		return e
+	}
+	return r.ChainStateOperation.LockblsUpdate(id, item)
+}
+func (r *StateRecorder) LockblsDelete(id fields.LockblsId) error {
+	if e := r.record(KeyPrefixLockbls, id); e != nil {
+		return e
+	}
+	return r.ChainStateOperation.LockblsDelete(id)
+}
+
+func (r *StateRecorder) ChannelCreate(id fields.ChannelId, item *stores.Channel) error {
+	if e := r.record(KeyPrefixChannel, id); e != nil {
+		return e
+	}
+	return r.ChainStateOperation.ChannelCreate(id, item)
+}
+func (r *StateRecorder) ChannelUpdate(id fields.ChannelId, item *stores.Channel) error {
+	if e := r.record(KeyPrefixChannel, id); e != nil {
+		return e
+	}
+	return r.ChainStateOperation.ChannelUpdate(id, item)
+}
+func (r *StateRecorder) ChannelDelete(id fields.ChannelId) error {
+	if e := r.record(KeyPrefixChannel, id); e != nil {
+		return e
+	}
+	return r.ChainStateOperation.ChannelDelete(id)
+}
+
+func (r *StateRecorder) DiamondSet(name fields.DiamondName, item *stores.Diamond) error {
+	if e := r.record(KeyPrefixDiamond, name); e != nil {
+		return e
+	}
+	return r.ChainStateOperation.DiamondSet(name, item)
+}
+func (r *StateRecorder) DiamondDel(name fields.DiamondName) error {
+	if e := r.record(KeyPrefixDiamond, name); e != nil {
+		return e
+	}
+	return r.ChainStateOperation.DiamondDel(name)
+}
+
+func (r *StateRecorder) DiamondLendingCreate(id fields.DiamondSyslendId, item *stores.DiamondSystemLending) error {
+	if e := r.record(KeyPrefixDiamondLending, id); e != nil {
+		return e
+	}
+	return r.ChainStateOperation.DiamondLendingCreate(id, item)
+}
+func (r *StateRecorder) DiamondLendingUpdate(id fields.DiamondSyslendId, item *stores.DiamondSystemLending) error {
+	if e := r.record(KeyPrefixDiamondLending, id); e != nil {
+		return e
+	}
+	return r.ChainStateOperation.DiamondLendingUpdate(id, item)
+}
+func (r *StateRecorder) DiamondLendingDelete(id fields.DiamondSyslendId) error {
+	if e := r.record(KeyPrefixDiamondLending, id); e != nil {
+		return e
+	}
+	return r.ChainStateOperation.DiamondLendingDelete(id)
+}
+
+func (r *StateRecorder) BitcoinLendingCreate(id fields.BitcoinSyslendId, item *stores.BitcoinSystemLending) error {
+	if e := r.record(KeyPrefixBitcoinLending, id); e != nil {
+		return e
+	}
+	return r.ChainStateOperation.BitcoinLendingCreate(id, item)
+}
+func (r *StateRecorder) BitcoinLendingUpdate(id fields.BitcoinSyslendId, item *stores.BitcoinSystemLending) error {
+	if e := r.record(KeyPrefixBitcoinLending, id); e != nil {
+		return e
+	}
+	return r.ChainStateOperation.BitcoinLendingUpdate(id, item)
+}
+func (r *StateRecorder) BitcoinLendingDelete(id fields.BitcoinSyslendId) error {
+	if e := r.record(KeyPrefixBitcoinLending, id); e != nil {
+		return e
+	}
+	return r.ChainStateOperation.BitcoinLendingDelete(id)
+}
+
+func (r *StateRecorder) UserLendingCreate(id fields.UserLendingId, item *stores.UserLending) error {
+	if e := r.record(KeyPrefixUserLending, id); e != nil {
+		return e
+	}
+	return r.ChainStateOperation.UserLendingCreate(id, item)
+}
+func (r *StateRecorder) UserLendingUpdate(id fields.UserLendingId, item *stores.UserLending) error {
+	if e := r.record(KeyPrefixUserLending, id); e != nil {
+		return e
+	}
+	return r.ChainStateOperation.UserLendingUpdate(id, item)
+}
+func (r *StateRecorder) UserLendingDelete(id fields.UserLendingId) error {
+	if e := r.record(KeyPrefixUserLending, id); e != nil {
+		return e
+	}
+	return r.ChainStateOperation.UserLendingDelete(id)
+}
+
+func (r *StateRecorder) ChaswapCreate(id fields.HashHalfChecker, item *stores.Chaswap) error {
+	if e := r.record(KeyPrefixChaswap, id); e != nil {
+		return e
+	}
+	return r.ChainStateOperation.ChaswapCreate(id, item)
+}
+func (r *StateRecorder) ChaswapUpdate(id fields.HashHalfChecker, item *stores.Chaswap) error {
+	if e := r.record(KeyPrefixChaswap, id); e != nil {
+		return e
+	}
+	return r.ChainStateOperation.ChaswapUpdate(id, item)
+}
+func (r *StateRecorder) ChaswapDelete(id fields.HashHalfChecker) error {
+	if e := r.record(KeyPrefixChaswap, id); e != nil {
+		return e
+	}
+	return r.ChainStateOperation.ChaswapDelete(id)
+}
@@ -0,0 +1,21 @@
+package chainstate
+
+import (
+	"github.com/hacash/core/interfaces"
+)
+
+// Dry run a transaction on a sub state of base and return all the state changes
+// The sub state is destroyed after execution, base is never modified
+func SimulateTransaction(base interfaces.ChainState, tx interfaces.Transaction) (*StateDiff, error) {
+	fork, e := base.ForkSubChild()
+	if e != nil {
+		return nil, e
+	}
+	defer fork.Destory()
+	recorder := NewStateRecorder(fork)
+	e = tx.WriteInChainState(recorder)
+	if e != nil {
+		return nil, e
+	}
+	return BuildStateDiff(fork, recorder.GetRecordItems())
+}
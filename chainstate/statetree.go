@@ -0,0 +1,103 @@
+package chainstate
+
+import (
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/interfaces"
+	"github.com/hacash/core/statetree"
+)
+
+// Store items committed by the state root, tx hash index is not state
+var stateTreePrefixs = []byte{
+	KeyPrefixBalance,
+	KeyPrefixDiamond,
+	KeyPrefixChannel,
+	KeyPrefixLockbls,
+	KeyPrefixDiamondLending,
+	KeyPrefixBitcoinLending,
+	KeyPrefixUserLending,
+	KeyPrefixChaswap,
+	KeyPrefixTotalSupply,
+}
+
+// Key hash of store item in state tree, such as KeyPrefixBalance and address
+func StateTreeKey(prefix byte, key []byte) fields.Hash {
+	return statetree.KeyHash([]byte(StoreKey(prefix, key)))
+}
+
+// Current serialized data of store item, nil means not exist
+func ReadStateTreeValue(state interfaces.ChainStateOperationRead, prefix byte, key []byte) ([]byte, error) {
+	item, e := readStoreItem(state, prefix, key)
+	if e != nil || item == nil {
+		return nil, e
+	}
+	return item.Serialize()
+}
+
+// Apply the items changed in a block to the tree of prev block
+func UpdateStateTree(tree *statetree.Tree, state interfaces.ChainStateOperationRead, records []*RecordItem) error {
+	for _, rcd := range records {
+		value, e := ReadStateTreeValue(state, rcd.Prefix, rcd.Key)
+		if e != nil {
+			return e
+		}
+		tree.Set(StateTreeKey(rcd.Prefix, rcd.Key), value)
+	}
+	return nil
+}
+
+func isStateTreePrefix(prefix byte) bool {
+	for _, p := range stateTreePrefixs {
+		if p == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply the changed items of a fork, keys are made by StoreKey and nil means deleted
+func applyStateTreeChanges(tree *statetree.Tree, datas map[string][]byte) {
+	for k, v := range datas {
+		if len(k) > 0 && isStateTreePrefix(k[0]) {
+			tree.Set(statetree.KeyHash([]byte(k)), v)
+		}
+	}
+}
+
+// Build the tree of all data in the state
+func BuildStateTree(cs *MemoryChainState) *statetree.Tree {
+	tree := statetree.NewTree()
+	for _, prefix := range stateTreePrefixs {
+		cs.TraversalItems(prefix, func(key []byte, body []byte) bool {
+			tree.Set(StateTreeKey(prefix, key), body)
+			return true
+		})
+	}
+	return tree
+}
+
+// State tree after the pending block, changes of the returned copy do not affect the state
+// The base state builds it once, a fork copies the tree of parent and applies its own changes
+func (cs *MemoryChainState) StateTree() *statetree.Tree {
+	cs.mux.RLock()
+	tree := cs.tree
+	cs.mux.RUnlock()
+	if tree == nil {
+		if cs.parent == nil {
+			tree = BuildStateTree(cs)
+		} else {
+			tree = cs.parent.StateTree()
+			cs.mux.RLock()
+			applyStateTreeChanges(tree, cs.datas)
+			cs.mux.RUnlock()
+		}
+		cs.mux.Lock()
+		cs.tree = tree
+		cs.mux.Unlock()
+	}
+	return tree.Copy()
+}
+
+// Root of the state tree after the pending block, see blocks.BlockHeadExtension.GetStateRoot
+func (cs *MemoryChainState) StateRoot() (fields.Hash, error) {
+	return cs.StateTree().Root(), nil
+}
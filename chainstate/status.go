@@ -0,0 +1,167 @@
+package chainstate
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/interfaces"
+	"github.com/hacash/core/stores"
+)
+
+/**
+ * 区块状态
+ */
+
+// Pending block status
+type PendingStatus struct {
+	pendingBlockHeight fields.BlockHeight
+	pendingBlockHash   fields.Hash
+	pendingBlockHead   interfaces.BlockHeadMetaRead
+
+	waitingSubmitDiamond *stores.DiamondSmelt
+}
+
+func NewPendingStatus(height uint64, hash fields.Hash, head interfaces.BlockHeadMetaRead) *PendingStatus {
+	if hash == nil {
+		hash = fields.EmptyZeroBytes32
+	}
+	return &PendingStatus{
+		pendingBlockHeight: fields.BlockHeight(height),
+		pendingBlockHash:   hash,
+		pendingBlockHead:   head,
+	}
+}
+
+func (p *PendingStatus) GetPendingBlockHead() interfaces.BlockHeadMetaRead {
+	return p.pendingBlockHead
+}
+func (p *PendingStatus) GetPendingBlockHeight() uint64 {
+	return uint64(p.pendingBlockHeight)
+}
+func (p *PendingStatus) GetPendingBlockHash() fields.Hash {
+	return p.pendingBlockHash
+}
+func (p *PendingStatus) GetWaitingSubmitDiamond() *stores.DiamondSmelt {
+	return p.waitingSubmitDiamond
+}
+func (p *PendingStatus) SetWaitingSubmitDiamond(diamond *stores.DiamondSmelt) {
+	p.waitingSubmitDiamond = diamond
+}
+func (p *PendingStatus) ClearWaitingSubmitDiamond() {
+	p.waitingSubmitDiamond = nil
+}
+
+// copy
+func (p *PendingStatus) Clone() *PendingStatus {
+	return &PendingStatus{
+		pendingBlockHeight:   p.pendingBlockHeight,
+		pendingBlockHash:     append([]byte{}, p.pendingBlockHash...),
+		pendingBlockHead:     p.pendingBlockHead,
+		waitingSubmitDiamond: p.waitingSubmitDiamond,
+	}
+}
+
+func (p *PendingStatus) Size() uint32 {
+	size := p.pendingBlockHeight.Size() + fields.HashSize + 1
+	if p.waitingSubmitDiamond != nil {
+		size += p.waitingSubmitDiamond.Size()
+	}
+	return size
+}
+
+func (p *PendingStatus) Serialize() ([]byte, error) {
+	var buf = bytes.NewBuffer(nil)
+	b1, _ := p.pendingBlockHeight.Serialize()
+	b2, _ := p.pendingBlockHash.Serialize()
+	buf.Write(b1)
+	buf.Write(b2)
+	if p.waitingSubmitDiamond == nil {
+		buf.WriteByte(0)
+		return buf.Bytes(), nil
+	}
+	buf.WriteByte(1)
+	b3, e := p.waitingSubmitDiamond.Serialize()
+	if e != nil {
+		return nil, e
+	}
+	buf.Write(b3)
+	return buf.Bytes(), nil
+}
+
+func (p *PendingStatus) Parse(buf []byte, seek uint32) (uint32, error) {
+	var e error
+	seek, e = p.pendingBlockHeight.Parse(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	seek, e = p.pendingBlockHash.Parse(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	if int(seek) >= len(buf) {
+		return 0, fmt.Errorf("[PendingStatus.Parse] seek out of buf len.")
+	}
+	hasdiamond := buf[seek]
+	seek++
+	p.waitingSubmitDiamond = nil
+	if hasdiamond == 1 {
+		p.waitingSubmitDiamond = &stores.DiamondSmelt{}
+		seek, e = p.waitingSubmitDiamond.Parse(buf, seek)
+		if e != nil {
+			return 0, e
+		}
+	}
+	return seek, nil
+}
+
+/**
+ * 最新状态
+ */
+
+// Latest status, include latest diamond
+type LatestStatus struct {
+	latestDiamond *stores.DiamondSmelt
+}
+
+func NewLatestStatus() *LatestStatus {
+	return &LatestStatus{}
+}
+
+func (l *LatestStatus) SetLastestDiamond(diamond *stores.DiamondSmelt) {
+	l.latestDiamond = diamond
+}
+func (l *LatestStatus) ReadLastestDiamond() *stores.DiamondSmelt {
+	return l.latestDiamond
+}
+
+func (l *LatestStatus) Size() uint32 {
+	if l.latestDiamond == nil {
+		return 1
+	}
+	return 1 + l.latestDiamond.Size()
+}
+
+func (l *LatestStatus) Serialize() ([]byte, error) {
+	if l.latestDiamond == nil {
+		return []byte{0}, nil
+	}
+	bts, e := l.latestDiamond.Serialize()
+	if e != nil {
+		return nil, e
+	}
+	return append([]byte{1}, bts...), nil
+}
+
+func (l *LatestStatus) Parse(buf []byte, seek uint32) (uint32, error) {
+	if int(seek) >= len(buf) {
+		return 0, fmt.Errorf("[LatestStatus.Parse] seek out of buf len.")
+	}
+	hasdiamond := buf[seek]
+	seek++
+	l.latestDiamond = nil
+	if hasdiamond == 1 {
+		l.latestDiamond = &stores.DiamondSmelt{}
+		return l.latestDiamond.Parse(buf, seek)
+	}
+	return seek, nil
+}
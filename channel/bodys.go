@@ -14,6 +14,11 @@ const (
 	ChannelTransferDirectionSatoshiRightToLeft uint8 = 4
 )
 
+const (
+	// Fixed fields, empty amounts and satoshi variations
+	ChannelChainTransferProveBodyInfoMinSize = 16 + 4 + 8 + 1 + 3*2 + 3*1 + 2*fields.AddressSize
+)
+
 // Channel transfer, data body
 type ChannelChainTransferProveBodyInfo struct {
 	ChannelId fields.ChannelId // Channel ID
@@ -237,6 +242,9 @@ func (c *ChannelPayProveBodyList) Parse(buf []byte, seek uint32) (uint32, error)
 		return 0, e
 	}
 	ccn := int(c.Count)
+	if int(seek)+ccn*ChannelChainTransferProveBodyInfoMinSize > len(buf) {
+		return 0, fmt.Errorf("[ChannelPayProveBodyList.Parse] %w", fields.ErrSeekOutOfBuf)
+	}
 	c.ProveBodys = make([]*ChannelChainTransferProveBodyInfo, ccn)
 	for i := 0; i < ccn; i++ {
 		c.ProveBodys[i] = &ChannelChainTransferProveBodyInfo{}
@@ -326,6 +334,30 @@ func (elm *OffChainFormPaymentChannelTransfer) Serialize() ([]byte, error) {
 }
 
 func (elm *OffChainFormPaymentChannelTransfer) Parse(buf []byte, seek uint32) (uint32, error) {
+	var e error
+	seek, e = elm.ParseNoSign(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	// autograph
+	scn := int(elm.MustSignCount)
+	if int(seek)+scn*int(fields.SignSize) > len(buf) {
+		return 0, fmt.Errorf("[OffChainFormPaymentChannelTransfer.Parse] %w", fields.ErrSeekOutOfBuf)
+	}
+	elm.MustSigns = make([]fields.Sign, scn)
+	for i := 0; i < scn; i++ {
+		elm.MustSigns[i] = fields.CreateEmptySign()
+		seek, e = elm.MustSigns[i].Parse(buf, seek)
+		if e != nil {
+			return 0, e
+		}
+	}
+	// complete
+	return seek, nil
+}
+
+// Parse the data body without the signatures
+func (elm *OffChainFormPaymentChannelTransfer) ParseNoSign(buf []byte, seek uint32) (uint32, error) {
 	var e error
 	seek, e = elm.Timestamp.Parse(buf, seek)
 	if e != nil {
@@ -341,6 +373,9 @@ func (elm *OffChainFormPaymentChannelTransfer) Parse(buf []byte, seek uint32) (u
 		return 0, e
 	}
 	scn := int(elm.MustSignCount)
+	if int(seek)+scn*fields.AddressSize > len(buf) {
+		return 0, fmt.Errorf("[OffChainFormPaymentChannelTransfer.Parse] %w", fields.ErrSeekOutOfBuf)
+	}
 	elm.MustSignAddresses = make([]fields.Address, scn)
 	for i := 0; i < scn; i++ {
 		elm.MustSignAddresses[i] = fields.Address{}
@@ -355,6 +390,9 @@ func (elm *OffChainFormPaymentChannelTransfer) Parse(buf []byte, seek uint32) (u
 		return 0, e
 	}
 	ccn := int(elm.ChannelCount)
+	if int(seek)+ccn*fields.HashHalfCheckerSize > len(buf) {
+		return 0, fmt.Errorf("[OffChainFormPaymentChannelTransfer.Parse] %w", fields.ErrSeekOutOfBuf)
+	}
 	elm.ChannelTransferProveHashHalfCheckers = make([]fields.HashHalfChecker, ccn)
 	for i := 0; i < ccn; i++ {
 		elm.ChannelTransferProveHashHalfCheckers[i] = fields.HashHalfChecker{}
@@ -363,16 +401,6 @@ func (elm *OffChainFormPaymentChannelTransfer) Parse(buf []byte, seek uint32) (u
 			return 0, e
 		}
 	}
-	// autograph
-	elm.MustSigns = make([]fields.Sign, scn)
-	for i := 0; i < scn; i++ {
-		elm.MustSigns[i] = fields.CreateEmptySign()
-		seek, e = elm.MustSigns[i].Parse(buf, seek)
-		if e != nil {
-			return 0, e
-		}
-	}
-	// complete
 	return seek, nil
 }
 
@@ -489,3 +517,15 @@ func (elm *OffChainFormPaymentChannelTransfer) CheckMustAddressAndSigns() error
 	// All signatures verified successfully
 	return nil
 }
+
+// Check the addresses and one aggregated signature of all addresses in order, the MustSigns are not used
+func (elm *OffChainFormPaymentChannelTransfer) CheckMustAddressAndAggregatedSign(sign *fields.AggregatedSign) error {
+	sn := int(elm.MustSignCount)
+	if sn < 2 || sn > 200 {
+		return fmt.Errorf("MustSignCount error.")
+	}
+	if sn != len(elm.MustSignAddresses) {
+		return fmt.Errorf("Addresses length error.")
+	}
+	return sign.CheckMustAddressAndSign(elm.GetSignStuffHash(), elm.MustSignAddresses)
+}
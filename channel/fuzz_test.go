@@ -0,0 +1,69 @@
+package channel
+
+import (
+	"bytes"
+	"testing"
+)
+
+type fuzzBill interface {
+	Serialize() ([]byte, error)
+	Parse([]byte, uint32) (uint32, error)
+}
+
+// Bills are exchanged off the chain and the signatures are over their bytes, a parsed bill must serialize back byte for byte
+func fuzzRoundTrip(t *testing.T, obj fuzzBill, obj2 fuzzBill, data []byte) {
+	seek, e := obj.Parse(data, 0)
+	if e != nil {
+		return
+	}
+	if int(seek) > len(data) {
+		t.Fatalf("seek %d out of data len %d", seek, len(data))
+	}
+	bts, e := obj.Serialize()
+	if e != nil {
+		return
+	}
+	seek, e = obj2.Parse(bts, 0)
+	if e != nil {
+		t.Fatalf("parse serialized data error: %v", e)
+	}
+	if int(seek) != len(bts) {
+		t.Fatalf("parse serialized data seek %d but len %d", seek, len(bts))
+	}
+	bts2, _ := obj2.Serialize()
+	if !bytes.Equal(bts, bts2) {
+		t.Fatalf("round trip not match %x != %x", bts, bts2)
+	}
+}
+
+var fuzzBillCreators = []func() fuzzBill{
+	func() fuzzBill { return new(ChannelChainTransferProveBodyInfo) },
+	func() fuzzBill { return new(ChannelPayProveBodyList) },
+	func() fuzzBill { return new(OffChainFormPaymentChannelTransfer) },
+	func() fuzzBill { return new(ChannelPayCompleteDocuments) },
+	func() fuzzBill { return new(OffChainFormPaymentChannelRealtimeReconciliation) },
+	func() fuzzBill { return new(OnChainArbitrationBasisReconciliation) },
+	func() fuzzBill { return new(OffChainCrossNodeSimplePaymentReconciliationBill) },
+}
+
+// The bill of Test1 is checked in under testdata/fuzz, with and without the prefix type code
+func FuzzParseChannelBills(f *testing.F) {
+	seeds := [][]byte{
+		{},
+		{0},
+		bytes.Repeat([]byte{1}, 200),
+	}
+	for i := range fuzzBillCreators {
+		for _, seed := range seeds {
+			f.Add(uint8(i), seed)
+		}
+	}
+	f.Fuzz(func(t *testing.T, ty uint8, data []byte) {
+		create := fuzzBillCreators[int(ty)%len(fuzzBillCreators)]
+		fuzzRoundTrip(t, create(), create(), data)
+		// with prefix type code
+		if b, _, e := ParseReconciliationBalanceBillByPrefixTypeCode(data, 0); e == nil {
+			SerializeReconciliationBalanceBillWithPrefixTypeCode(b)
+		}
+	})
+}
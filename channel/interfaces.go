@@ -93,6 +93,9 @@ func SerializeReconciliationBalanceBillWithPrefixTypeCode(bill ReconciliationBal
 
 // Deserialization
 func ParseReconciliationBalanceBillByPrefixTypeCode(buf []byte, seek uint32) (ReconciliationBalanceBill, uint32, error) {
+	if int(seek) >= len(buf) {
+		return nil, 0, fmt.Errorf("[ParseReconciliationBalanceBillByPrefixTypeCode] %w", fields.ErrSeekOutOfBuf)
+	}
 	ty := buf[seek]
 	var bill ReconciliationBalanceBill = nil
 
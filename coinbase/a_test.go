@@ -49,3 +49,45 @@ func Test2(t *testing.T) {
 	}
 
 }
+
+type testCoinbase struct {
+	reward  fields.Amount
+	version uint8
+}
+
+func (c *testCoinbase) GetReward() *fields.Amount   { return &c.reward }
+func (c *testCoinbase) GetExtendDataVersion() uint8 { return c.version }
+
+func Test_reward(t *testing.T) {
+
+	vectors := map[uint64]uint8{
+		0: 1, 1: 1, 99999: 1, 100000: 1, 200000: 2, 300001: 3, 400000: 5, 599999: 8,
+		600000: 8, 1600000: 5, 6599999: 1, 6600000: 1, 100000000: 1,
+	}
+	for hei, num := range vectors {
+		if BlockCoinBaseRewardNumber(hei) != num {
+			t.Fatalf("height %d reward need %d but got %d", hei, num, BlockCoinBaseRewardNumber(hei))
+		}
+	}
+
+	cb := &testCoinbase{
+		reward:  *fields.NewAmountNumSmallCoin(3),
+		version: 1,
+	}
+	if e := CheckCoinbase(cb, 300001); e != nil {
+		t.Fatal(e)
+	}
+	if e := CheckCoinbase(cb, 200000); e == nil {
+		t.Fatal("reward must be checked")
+	} else {
+		fmt.Println(e)
+	}
+	cb.reward = *fields.NewAmountNumSmallCoin(1)
+	if e := CheckCoinbase(cb, 150000); e == nil {
+		t.Fatal("version must be checked")
+	}
+	cb.version = 0
+	if e := CheckCoinbase(cb, 150000); e != nil {
+		t.Fatal(e)
+	}
+}
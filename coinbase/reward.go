@@ -0,0 +1,67 @@
+package coinbase
+
+import (
+	"fmt"
+	"github.com/hacash/core/fields"
+)
+
+const (
+	// Coinbase extend data version change to 1 from this height
+	ExtendDataVersion1BlockHeight = 220000
+)
+
+var (
+	rewardPart1 = []uint8{1, 1, 2, 3, 5, 8} // each for 100000 blocks
+	rewardPart2 = []uint8{8, 5, 3, 2, 1, 1} // each for 1000000 blocks
+	rewardPart3 = uint8(1)                  // forever
+
+	rewardPart1Blocks = uint64(10000 * 10)
+	rewardPart2Blocks = uint64(10000 * 100)
+)
+
+// Block reward of height in HAC
+// 1,1,2,3,5,8 each for 100000 blocks, then 8,5,3,2,1,1 each for 1000000 blocks, then 1 forever
+func BlockCoinBaseRewardNumber(height uint64) uint8 {
+	span1 := uint64(len(rewardPart1)) * rewardPart1Blocks
+	span2 := uint64(len(rewardPart2)) * rewardPart2Blocks
+	if height < span1 {
+		return rewardPart1[height/rewardPart1Blocks]
+	}
+	if height < span1+span2 {
+		return rewardPart2[(height-span1)/rewardPart2Blocks]
+	}
+	return rewardPart3
+}
+
+func BlockCoinBaseReward(height uint64) *fields.Amount {
+	return fields.NewAmountNumSmallCoin(BlockCoinBaseRewardNumber(height))
+}
+
+// Coinbase extend data version of height
+func ExtendDataVersion(height uint64) uint8 {
+	if height >= ExtendDataVersion1BlockHeight {
+		return 1
+	}
+	return 0
+}
+
+// Coinbase fields to check, the fee totals are not carried in the block but summed from the txs
+type CoinbaseRead interface {
+	GetReward() *fields.Amount
+	GetExtendDataVersion() uint8
+}
+
+// Check the coinbase of block height before writing it into state
+func CheckCoinbase(cbtx CoinbaseRead, height uint64) error {
+	// reward
+	reward := BlockCoinBaseReward(height)
+	if cbtx.GetReward().NotEqual(reward) {
+		return fmt.Errorf("block %d coinbase reward need %s but got %s.", height, reward.ToFinString(), cbtx.GetReward().ToFinString())
+	}
+	// version
+	version := ExtendDataVersion(height)
+	if cbtx.GetExtendDataVersion() != version {
+		return fmt.Errorf("block %d coinbase extend data version need %d but got %d.", height, version, cbtx.GetExtendDataVersion())
+	}
+	return nil
+}
@@ -0,0 +1,317 @@
+package btcec
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+)
+
+// MuSig2 style two round multi-signature.  All signers aggregate their
+// public keys into one x-only key, exchange two public nonces each, and
+// produce partial signatures which sum up to a single BIP340 signature that
+// verifies with SchnorrVerify against the aggregated key.
+//
+// The key aggregation and signing equations follow BIP327 without tweaks.
+
+// MuSigPubNonceLen is the length of a public nonce, two compressed points.
+const MuSigPubNonceLen = 66
+
+// MuSigPartialSigLen is the length of a partial signature.
+const MuSigPartialSigLen = 32
+
+// MuSigKeyAgg holds the aggregated public key and the key coefficients.
+type MuSigKeyAgg struct {
+	pubKeys   [][]byte
+	listHash  []byte
+	secondKey []byte
+	qx, qy    *big.Int
+}
+
+// MuSigAggregateKeys aggregates the 33 bytes compressed public keys.  The
+// order of the keys matters, all signers must use the same order.
+func MuSigAggregateKeys(pubKeys [][]byte) (*MuSigKeyAgg, error) {
+	curve := S256()
+	if len(pubKeys) == 0 {
+		return nil, errors.New("public key list is empty")
+	}
+	ctx := &MuSigKeyAgg{
+		pubKeys: make([][]byte, len(pubKeys)),
+	}
+	for i, pk := range pubKeys {
+		if len(pk) != PubKeyBytesLenCompressed {
+			return nil, errors.New("public key must be 33 bytes compressed")
+		}
+		ctx.pubKeys[i] = append([]byte{}, pk...)
+	}
+	ctx.listHash = taggedHash("KeyAgg list", ctx.pubKeys...)
+	for _, pk := range ctx.pubKeys[1:] {
+		if !bytes.Equal(pk, ctx.pubKeys[0]) {
+			ctx.secondKey = pk
+			break
+		}
+	}
+	qx, qy := new(big.Int), new(big.Int)
+	for _, pk := range ctx.pubKeys {
+		p, err := ParsePubKey(pk, curve)
+		if err != nil {
+			return nil, err
+		}
+		ax, ay := curve.ScalarMult(p.X, p.Y, bytes32(ctx.coefficient(pk)))
+		qx, qy = curve.Add(qx, qy, ax, ay)
+	}
+	if isInfinity(qx, qy) {
+		return nil, errors.New("aggregated public key is infinity")
+	}
+	ctx.qx, ctx.qy = qx, qy
+	return ctx, nil
+}
+
+// coefficient returns the key aggregation coefficient of the public key.
+func (ctx *MuSigKeyAgg) coefficient(pubKey []byte) *big.Int {
+	if ctx.secondKey != nil && bytes.Equal(pubKey, ctx.secondKey) {
+		return big.NewInt(1)
+	}
+	a := new(big.Int).SetBytes(taggedHash("KeyAgg coefficient", ctx.listHash, pubKey))
+	return a.Mod(a, S256().N)
+}
+
+// hasKey returns whether the public key takes part in the aggregation.
+func (ctx *MuSigKeyAgg) hasKey(pubKey []byte) bool {
+	for _, pk := range ctx.pubKeys {
+		if bytes.Equal(pk, pubKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// PubKeys returns the public keys in aggregation order.
+func (ctx *MuSigKeyAgg) PubKeys() [][]byte {
+	return ctx.pubKeys
+}
+
+// SchnorrPubKey returns the 32 bytes x-only aggregated public key.
+func (ctx *MuSigKeyAgg) SchnorrPubKey() []byte {
+	return bytes32(ctx.qx)
+}
+
+// MuSigSecNonce is the secret nonce pair of one signer.  It must be used
+// for only one partial signature and is cleared after signing.
+type MuSigSecNonce struct {
+	k1, k2 *big.Int
+	pubKey []byte
+}
+
+// MuSigNonceGen creates a secret and public nonce pair for the signer.  The
+// rand must be 32 bytes of fresh randomness for every signing session, the
+// private key, aggregated key and hash are mixed in as extra protection.
+func MuSigNonceGen(privKey *PrivateKey, ctx *MuSigKeyAgg, hash []byte, rand []byte) (*MuSigSecNonce, []byte, error) {
+	curve := S256()
+	if len(rand) != 32 {
+		return nil, nil, errors.New("nonce rand must be 32 bytes")
+	}
+	pubKey := privKey.PubKey().SerializeCompressed()
+	if !ctx.hasKey(pubKey) {
+		return nil, nil, errors.New("private key not in aggregated key list")
+	}
+	ks := make([]*big.Int, 2)
+	for i := range ks {
+		k := new(big.Int).SetBytes(taggedHash("MuSig/nonce", rand, bytes32(privKey.D),
+			pubKey, ctx.SchnorrPubKey(), hash, []byte{byte(i)}))
+		k.Mod(k, curve.N)
+		if k.Sign() == 0 {
+			return nil, nil, errors.New("calculated nonce is zero")
+		}
+		ks[i] = k
+	}
+	r1x, r1y := curve.ScalarBaseMult(bytes32(ks[0]))
+	r2x, r2y := curve.ScalarBaseMult(bytes32(ks[1]))
+	pubNonce := append(compressPoint(r1x, r1y), compressPoint(r2x, r2y)...)
+	return &MuSigSecNonce{k1: ks[0], k2: ks[1], pubKey: pubKey}, pubNonce, nil
+}
+
+// compressPoint returns the 33 bytes compressed encoding, all zero bytes
+// for the point at infinity.
+func compressPoint(x, y *big.Int) []byte {
+	if isInfinity(x, y) {
+		return make([]byte, PubKeyBytesLenCompressed)
+	}
+	return (&PublicKey{Curve: S256(), X: x, Y: y}).SerializeCompressed()
+}
+
+// decompressPointBytes parses compressPoint output.
+func decompressPointBytes(b []byte) (*big.Int, *big.Int, error) {
+	if bytes.Equal(b, make([]byte, PubKeyBytesLenCompressed)) {
+		return new(big.Int), new(big.Int), nil
+	}
+	p, err := ParsePubKey(b, S256())
+	if err != nil {
+		return nil, nil, err
+	}
+	return p.X, p.Y, nil
+}
+
+// MuSigNonceAgg sums up the public nonces of all signers.
+func MuSigNonceAgg(pubNonces [][]byte) ([]byte, error) {
+	curve := S256()
+	agg := make([]byte, 0, MuSigPubNonceLen)
+	for j := 0; j < 2; j++ {
+		rx, ry := new(big.Int), new(big.Int)
+		for _, nonce := range pubNonces {
+			if len(nonce) != MuSigPubNonceLen {
+				return nil, errors.New("public nonce must be 66 bytes")
+			}
+			p, err := ParsePubKey(nonce[j*33:j*33+33], curve)
+			if err != nil {
+				return nil, err
+			}
+			rx, ry = curve.Add(rx, ry, p.X, p.Y)
+		}
+		agg = append(agg, compressPoint(rx, ry)...)
+	}
+	return agg, nil
+}
+
+// MuSigSession holds the values shared by all signers of one hash.
+type MuSigSession struct {
+	keyAgg   *MuSigKeyAgg
+	hash     []byte
+	b        *big.Int
+	e        *big.Int
+	rx, ry   *big.Int
+	evenQ    bool
+	aggNonce []byte
+}
+
+// NewMuSigSession starts a signing session of the 32 bytes hash with the
+// aggregated nonce.
+func NewMuSigSession(ctx *MuSigKeyAgg, aggNonce []byte, hash []byte) (*MuSigSession, error) {
+	curve := S256()
+	if len(hash) != 32 {
+		return nil, errors.New("hash must be 32 bytes")
+	}
+	if len(aggNonce) != MuSigPubNonceLen {
+		return nil, errors.New("aggregated nonce must be 66 bytes")
+	}
+	r1x, r1y, err := decompressPointBytes(aggNonce[:33])
+	if err != nil {
+		return nil, err
+	}
+	r2x, r2y, err := decompressPointBytes(aggNonce[33:])
+	if err != nil {
+		return nil, err
+	}
+	b := new(big.Int).SetBytes(taggedHash("MuSig/noncecoef", aggNonce, ctx.SchnorrPubKey(), hash))
+	b.Mod(b, curve.N)
+	bx, by := curve.ScalarMult(r2x, r2y, bytes32(b))
+	rx, ry := curve.Add(r1x, r1y, bx, by)
+	if isInfinity(rx, ry) {
+		rx, ry = curve.Gx, curve.Gy
+	}
+	return &MuSigSession{
+		keyAgg:   ctx,
+		hash:     append([]byte{}, hash...),
+		b:        b,
+		e:        schnorrChallenge(bytes32(rx), ctx.SchnorrPubKey(), hash),
+		rx:       rx,
+		ry:       ry,
+		evenQ:    !isOdd(ctx.qy),
+		aggNonce: append([]byte{}, aggNonce...),
+	}, nil
+}
+
+// MuSigPartialSign creates the partial signature of one signer.  The secret
+// nonce is cleared so it can never be reused.
+func MuSigPartialSign(secNonce *MuSigSecNonce, privKey *PrivateKey, session *MuSigSession) ([]byte, error) {
+	N := S256().N
+	if secNonce.k1 == nil || secNonce.k2 == nil {
+		return nil, errors.New("secret nonce already used")
+	}
+	pubKey := privKey.PubKey().SerializeCompressed()
+	if !bytes.Equal(pubKey, secNonce.pubKey) {
+		return nil, errors.New("secret nonce not belong to private key")
+	}
+	k1, k2 := secNonce.k1, secNonce.k2
+	secNonce.k1, secNonce.k2 = nil, nil
+	if isOdd(session.ry) {
+		k1 = new(big.Int).Sub(N, k1)
+		k2 = new(big.Int).Sub(N, k2)
+	}
+	d := new(big.Int).Set(privKey.D)
+	if !session.evenQ {
+		d.Sub(N, d)
+	}
+	a := session.keyAgg.coefficient(pubKey)
+	// s = k1 + b*k2 + e*a*d
+	s := new(big.Int).Mul(session.b, k2)
+	s.Add(s, k1)
+	ead := new(big.Int).Mul(session.e, a)
+	ead.Mul(ead, d)
+	s.Add(s, ead)
+	s.Mod(s, N)
+	return bytes32(s), nil
+}
+
+// MuSigPartialSigVerify verifies the partial signature of one signer with
+// its public nonce and 33 bytes public key.
+func MuSigPartialSigVerify(partialSig []byte, pubNonce []byte, pubKey []byte, session *MuSigSession) bool {
+	curve := S256()
+	if len(partialSig) != MuSigPartialSigLen || len(pubNonce) != MuSigPubNonceLen {
+		return false
+	}
+	if !session.keyAgg.hasKey(pubKey) {
+		return false
+	}
+	s := new(big.Int).SetBytes(partialSig)
+	if s.Cmp(curve.N) >= 0 {
+		return false
+	}
+	p, err := ParsePubKey(pubKey, curve)
+	if err != nil {
+		return false
+	}
+	r1, err := ParsePubKey(pubNonce[:33], curve)
+	if err != nil {
+		return false
+	}
+	r2, err := ParsePubKey(pubNonce[33:], curve)
+	if err != nil {
+		return false
+	}
+	// R = R1 + b*R2, negated if the final nonce has odd y
+	bx, by := curve.ScalarMult(r2.X, r2.Y, bytes32(session.b))
+	rx, ry := curve.Add(r1.X, r1.Y, bx, by)
+	if isOdd(session.ry) {
+		rx, ry = negatePoint(rx, ry)
+	}
+	// e*a*g*P
+	ea := new(big.Int).Mul(session.e, session.keyAgg.coefficient(pubKey))
+	ea.Mod(ea, curve.N)
+	px, py := p.X, p.Y
+	if !session.evenQ {
+		px, py = negatePoint(px, py)
+	}
+	ex, ey := curve.ScalarMult(px, py, bytes32(ea))
+	wx, wy := curve.Add(rx, ry, ex, ey)
+	sx, sy := curve.ScalarBaseMult(bytes32(s))
+	return sx.Cmp(wx) == 0 && sy.Cmp(wy) == 0
+}
+
+// MuSigPartialSigAgg sums up all partial signatures into a 64 bytes BIP340
+// signature of the aggregated public key.
+func MuSigPartialSigAgg(partialSigs [][]byte, session *MuSigSession) ([]byte, error) {
+	N := S256().N
+	s := new(big.Int)
+	for _, ps := range partialSigs {
+		if len(ps) != MuSigPartialSigLen {
+			return nil, errors.New("partial signature must be 32 bytes")
+		}
+		v := new(big.Int).SetBytes(ps)
+		if v.Cmp(N) >= 0 {
+			return nil, errors.New("partial signature out of range")
+		}
+		s.Add(s, v)
+	}
+	s.Mod(s, N)
+	return append(bytes32(session.rx), bytes32(s)...), nil
+}
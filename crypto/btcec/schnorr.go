@@ -0,0 +1,172 @@
+package btcec
+
+import (
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+// SchnorrPubKeyLen is the length of a BIP340 x-only public key.
+const SchnorrPubKeyLen = 32
+
+// SchnorrSignatureLen is the length of a BIP340 signature.
+const SchnorrSignatureLen = 64
+
+var (
+	errSchnorrPubKeyLen    = errors.New("schnorr public key must be 32 bytes")
+	errSchnorrSignatureLen = errors.New("schnorr signature must be 64 bytes")
+)
+
+// taggedHash returns the BIP340 tagged hash
+// sha256(sha256(tag) || sha256(tag) || msgs...).
+func taggedHash(tag string, msgs ...[]byte) []byte {
+	tagHash := sha256.Sum256([]byte(tag))
+	h := sha256.New()
+	h.Write(tagHash[:])
+	h.Write(tagHash[:])
+	for _, m := range msgs {
+		h.Write(m)
+	}
+	return h.Sum(nil)
+}
+
+// bytes32 returns the 32 bytes big-endian encoding of a scalar or a field
+// element.
+func bytes32(n *big.Int) []byte {
+	return paddedAppend(32, nil, n.Bytes())
+}
+
+// isInfinity returns whether the point is the point at infinity.
+func isInfinity(x, y *big.Int) bool {
+	return x.Sign() == 0 && y.Sign() == 0
+}
+
+// negatePoint returns the point with the y coordinate negated.
+func negatePoint(x, y *big.Int) (*big.Int, *big.Int) {
+	if isInfinity(x, y) {
+		return x, y
+	}
+	return new(big.Int).Set(x), new(big.Int).Sub(S256().P, y)
+}
+
+// liftX returns the point with the given x coordinate and an even y
+// coordinate as defined by BIP340.
+func liftX(x *big.Int) (*big.Int, *big.Int, error) {
+	if x.Sign() <= 0 || x.Cmp(S256().P) >= 0 {
+		return nil, nil, errors.New("x coordinate out of range")
+	}
+	y, err := decompressPoint(S256(), x, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	return x, y, nil
+}
+
+// ParseSchnorrPubKey parses a 32 bytes x-only public key.
+func ParseSchnorrPubKey(pubKey []byte) (*PublicKey, error) {
+	if len(pubKey) != SchnorrPubKeyLen {
+		return nil, errSchnorrPubKeyLen
+	}
+	x, y, err := liftX(new(big.Int).SetBytes(pubKey))
+	if err != nil {
+		return nil, err
+	}
+	return &PublicKey{Curve: S256(), X: x, Y: y}, nil
+}
+
+// SerializeSchnorr returns the 32 bytes x-only encoding of the public key.
+func (p *PublicKey) SerializeSchnorr() []byte {
+	return bytes32(p.X)
+}
+
+// SchnorrSign creates a BIP340 signature of the 32 bytes hash.  The auxRand
+// is 32 bytes of fresh randomness, nil is treated as all zero bytes which
+// still gives a safe deterministic signature.
+func SchnorrSign(privKey *PrivateKey, hash []byte, auxRand []byte) ([]byte, error) {
+	curve := S256()
+	N := curve.N
+	if len(hash) != 32 {
+		return nil, errors.New("hash must be 32 bytes")
+	}
+	if auxRand == nil {
+		auxRand = make([]byte, 32)
+	}
+	if len(auxRand) != 32 {
+		return nil, errors.New("aux rand must be 32 bytes")
+	}
+	d := new(big.Int).Set(privKey.D)
+	if d.Sign() <= 0 || d.Cmp(N) >= 0 {
+		return nil, errors.New("private key out of range")
+	}
+	px, py := curve.ScalarBaseMult(bytes32(d))
+	if isOdd(py) {
+		d.Sub(N, d)
+	}
+	pBytes := bytes32(px)
+
+	t := bytes32(d)
+	auxHash := taggedHash("BIP0340/aux", auxRand)
+	for i := range t {
+		t[i] ^= auxHash[i]
+	}
+	rand := taggedHash("BIP0340/nonce", t, pBytes, hash)
+	k := new(big.Int).SetBytes(rand)
+	k.Mod(k, N)
+	if k.Sign() == 0 {
+		return nil, errors.New("calculated nonce is zero")
+	}
+	rx, ry := curve.ScalarBaseMult(bytes32(k))
+	if isOdd(ry) {
+		k.Sub(N, k)
+	}
+	rBytes := bytes32(rx)
+
+	e := schnorrChallenge(rBytes, pBytes, hash)
+	s := new(big.Int).Mul(e, d)
+	s.Add(s, k)
+	s.Mod(s, N)
+
+	sig := append(rBytes, bytes32(s)...)
+	if !SchnorrVerify(pBytes, hash, sig) {
+		return nil, errors.New("created signature does not verify")
+	}
+	return sig, nil
+}
+
+// schnorrChallenge returns e = int(hash_challenge(R || P || m)) mod n.
+func schnorrChallenge(rBytes, pBytes, hash []byte) *big.Int {
+	e := new(big.Int).SetBytes(taggedHash("BIP0340/challenge", rBytes, pBytes, hash))
+	return e.Mod(e, S256().N)
+}
+
+// SchnorrVerify verifies a BIP340 signature of the 32 bytes hash by the
+// x-only public key.
+func SchnorrVerify(pubKey []byte, hash []byte, sig []byte) bool {
+	curve := S256()
+	if len(pubKey) != SchnorrPubKeyLen || len(hash) != 32 || len(sig) != SchnorrSignatureLen {
+		return false
+	}
+	px, py, err := liftX(new(big.Int).SetBytes(pubKey))
+	if err != nil {
+		return false
+	}
+	r := new(big.Int).SetBytes(sig[:32])
+	if r.Cmp(curve.P) >= 0 {
+		return false
+	}
+	s := new(big.Int).SetBytes(sig[32:])
+	if s.Cmp(curve.N) >= 0 {
+		return false
+	}
+	e := schnorrChallenge(sig[:32], pubKey, hash)
+
+	// R = s*G - e*P
+	sgx, sgy := curve.ScalarBaseMult(bytes32(s))
+	epx, epy := curve.ScalarMult(px, py, bytes32(e))
+	epx, epy = negatePoint(epx, epy)
+	rx, ry := curve.Add(sgx, sgy, epx, epy)
+	if isInfinity(rx, ry) || isOdd(ry) {
+		return false
+	}
+	return rx.Cmp(r) == 0
+}
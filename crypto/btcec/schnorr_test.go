@@ -0,0 +1,136 @@
+package btcec
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+// BIP340 test vectors.
+var schnorrSignTests = []struct {
+	secKey  string
+	pubKey  string
+	auxRand string
+	msg     string
+	sig     string
+}{
+	{
+		secKey:  "0000000000000000000000000000000000000000000000000000000000000003",
+		pubKey:  "F9308A019258C31049344F85F89D5229B531C845836F99B08601F113BCE036F9",
+		auxRand: "0000000000000000000000000000000000000000000000000000000000000000",
+		msg:     "0000000000000000000000000000000000000000000000000000000000000000",
+		sig: "E907831F80848D1069A5371B402410364BDF1C5F8307B0084C55F1CE2DCA8215" +
+			"25F66A4A85EA8B71E482A74F382D2CE5EBEEE8FDB2172F477DF4900D310536C0",
+	},
+	{
+		secKey:  "B7E151628AED2A6ABF7158809CF4F3C762E7160F38B4DA56A784D9045190CFEF",
+		pubKey:  "DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		auxRand: "0000000000000000000000000000000000000000000000000000000000000001",
+		msg:     "243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		sig: "6896BD60EEAE296DB48A229FF71DFE071BDE413E6D43F917DC8DCF8C78DE3341" +
+			"8906D11AC976ABCCB20B091292BFF4EA897EFCB639EA871CFA95F6DE339E4B0A",
+	},
+}
+
+func TestSchnorrSign(t *testing.T) {
+	for i, test := range schnorrSignTests {
+		privKey, _ := PrivKeyFromBytes(S256(), decodeHex(test.secKey))
+		pubKey := privKey.PubKey().SerializeSchnorr()
+		if !bytes.Equal(pubKey, decodeHex(test.pubKey)) {
+			t.Fatalf("#%d: public key %X, want %s", i, pubKey, test.pubKey)
+		}
+		msg := decodeHex(test.msg)
+		sig, err := SchnorrSign(privKey, msg, decodeHex(test.auxRand))
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if !bytes.Equal(sig, decodeHex(test.sig)) {
+			t.Fatalf("#%d: signature %X, want %s", i, sig, test.sig)
+		}
+		if !SchnorrVerify(pubKey, msg, sig) {
+			t.Fatalf("#%d: signature does not verify", i)
+		}
+		// Any changed byte must fail.
+		for _, pos := range []int{0, 31, 32, 63} {
+			bad := append([]byte{}, sig...)
+			bad[pos] ^= 0x01
+			if SchnorrVerify(pubKey, msg, bad) {
+				t.Fatalf("#%d: changed signature byte %d verifies", i, pos)
+			}
+		}
+		badMsg := append([]byte{}, msg...)
+		badMsg[0] ^= 0x01
+		if SchnorrVerify(pubKey, badMsg, sig) {
+			t.Fatalf("#%d: changed message verifies", i)
+		}
+	}
+
+	// Public key not on the curve.
+	if _, err := ParseSchnorrPubKey(decodeHex("EEFDEA4CDB677750A420FEE807EACF21EB9898AE79B9768766E4FAA04A2D4A34")); err == nil {
+		t.Fatalf("public key not on curve is parsed")
+	}
+}
+
+func TestMuSig(t *testing.T) {
+	for _, n := range []int{1, 2, 3} {
+		privKeys := make([]*PrivateKey, n)
+		pubKeys := make([][]byte, n)
+		for i := 0; i < n; i++ {
+			seed := sha256.Sum256([]byte{byte(n), byte(i)})
+			privKeys[i], _ = PrivKeyFromBytes(S256(), seed[:])
+			pubKeys[i] = privKeys[i].PubKey().SerializeCompressed()
+		}
+		keyAgg, err := MuSigAggregateKeys(pubKeys)
+		if err != nil {
+			t.Fatalf("%d signers: %v", n, err)
+		}
+		hash := sha256.Sum256([]byte("channel bill"))
+
+		// Round 1: exchange public nonces.
+		secNonces := make([]*MuSigSecNonce, n)
+		pubNonces := make([][]byte, n)
+		for i := 0; i < n; i++ {
+			rand := sha256.Sum256([]byte{byte(n), byte(i), 0xff})
+			secNonces[i], pubNonces[i], err = MuSigNonceGen(privKeys[i], keyAgg, hash[:], rand[:])
+			if err != nil {
+				t.Fatalf("%d signers: %v", n, err)
+			}
+		}
+		aggNonce, err := MuSigNonceAgg(pubNonces)
+		if err != nil {
+			t.Fatalf("%d signers: %v", n, err)
+		}
+
+		// Round 2: exchange partial signatures.
+		session, err := NewMuSigSession(keyAgg, aggNonce, hash[:])
+		if err != nil {
+			t.Fatalf("%d signers: %v", n, err)
+		}
+		partialSigs := make([][]byte, n)
+		for i := 0; i < n; i++ {
+			partialSigs[i], err = MuSigPartialSign(secNonces[i], privKeys[i], session)
+			if err != nil {
+				t.Fatalf("%d signers: %v", n, err)
+			}
+			if !MuSigPartialSigVerify(partialSigs[i], pubNonces[i], pubKeys[i], session) {
+				t.Fatalf("%d signers: partial signature %d does not verify", n, i)
+			}
+			if _, err := MuSigPartialSign(secNonces[i], privKeys[i], session); err == nil {
+				t.Fatalf("%d signers: secret nonce reused", n)
+			}
+		}
+		if n > 1 && MuSigPartialSigVerify(partialSigs[0], pubNonces[1], pubKeys[1], session) {
+			t.Fatalf("%d signers: wrong partial signature verifies", n)
+		}
+		sig, err := MuSigPartialSigAgg(partialSigs, session)
+		if err != nil {
+			t.Fatalf("%d signers: %v", n, err)
+		}
+		if !SchnorrVerify(keyAgg.SchnorrPubKey(), hash[:], sig) {
+			t.Fatalf("%d signers: aggregated signature does not verify", n)
+		}
+		if n > 1 && SchnorrVerify(privKeys[0].PubKey().SerializeSchnorr(), hash[:], sig) {
+			t.Fatalf("%d signers: aggregated signature verifies with single key", n)
+		}
+	}
+}
@@ -97,6 +97,24 @@ func ParseSignatureByte64(stuff []byte) (*Signature, error) {
 	return sig, nil
 }
 
+// IsLowS returns whether S is not greater than halforder.  The high S
+// value N-S verifies the same hash, so only low S signatures are canonical.
+func (sig *Signature) IsLowS() bool {
+	return sig.S.Cmp(S256().halfOrder) != 1
+}
+
+// IsCanonical returns whether R and S are both in [1, N-1] and S is low.
+func (sig *Signature) IsCanonical() bool {
+	N := S256().N
+	if sig.R.Sign() <= 0 || sig.R.Cmp(N) >= 0 {
+		return false
+	}
+	if sig.S.Sign() <= 0 {
+		return false
+	}
+	return sig.IsLowS()
+}
+
 // Verify calls ecdsa.Verify to verify the signature of hash using the public
 // key.  It returns true if the signature is valid, false otherwise.
 func (sig *Signature) Verify(hash []byte, pubKey *PublicKey) bool {
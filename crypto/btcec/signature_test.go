@@ -693,3 +693,37 @@ func TestSignatureIsEqual(t *testing.T) {
 			"equal to %v", sig1, sig2)
 	}
 }
+
+// TestSignatureLowS ensures signing only produces canonical low S signatures
+// and the high S variant is detected.
+func TestSignatureLowS(t *testing.T) {
+	privKey, _ := PrivKeyFromBytes(S256(), decodeHex("a11b0a4e1a132305652ee7a8eb7848f6ad"+
+		"5ea381e3ce20a2c086a2e388230811"))
+	for i := 0; i < 32; i++ {
+		hash := sha256.Sum256([]byte{byte(i)})
+		sig, err := privKey.Sign(hash[:])
+		if err != nil {
+			t.Fatalf("Sign #%d: %v", i, err)
+		}
+		if !sig.IsLowS() || !sig.IsCanonical() {
+			t.Fatalf("Sign #%d: signature is not low S", i)
+		}
+
+		// Malleated high S signature still verifies but is not canonical.
+		highSig := &Signature{R: sig.R, S: new(big.Int).Sub(S256().N, sig.S)}
+		if !highSig.Verify(hash[:], privKey.PubKey()) {
+			t.Fatalf("Sign #%d: high S signature does not verify", i)
+		}
+		if highSig.IsLowS() || highSig.IsCanonical() {
+			t.Fatalf("Sign #%d: high S signature is canonical", i)
+		}
+		parsed, err := ParseSignatureByte64(highSig.Serialize64())
+		if err != nil || parsed.IsCanonical() {
+			t.Fatalf("Sign #%d: high S lost in Serialize64", i)
+		}
+	}
+	zeroSig := &Signature{R: big.NewInt(0), S: big.NewInt(1)}
+	if zeroSig.IsCanonical() {
+		t.Fatalf("zero R signature is canonical")
+	}
+}
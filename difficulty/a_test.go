@@ -0,0 +1,135 @@
+package difficulty
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+/**
+ * Vectors of the compact format and the retarget equations are derived by hand
+ * Mainnet vectors are read from testdata/mainnet_retarget.txt, see the format in it
+ */
+
+func Test_compact(t *testing.T) {
+
+	vectors := []struct {
+		diff   uint32
+		target string
+	}{
+		{0xffffffff, "ffffff0000000000000000000000000000000000000000000000000000000000"},
+		{0xfc077791, "0000000777910000000000000000000000000000000000000000000000000000"},
+		{0xf8123456, "0000000000000012345600000000000000000000000000000000000000000000"},
+		{0xe1abcdef, "000000000000000000000000000000000000000000000000000000000000abcd"}, // last byte truncated,
+	}
+	for _, v := range vectors {
+		target := hex.EncodeToString(DifficultyUint32ToHash(v.diff))
+		if target != v.target {
+			t.Fatalf("%x target need %s but got %s", v.diff, v.target, target)
+		}
+	}
+	for _, v := range vectors[:3] {
+		bts, _ := hex.DecodeString(v.target)
+		if d := DifficultyHashToUint32(bts); d != v.diff {
+			t.Fatalf("target %s need %x but got %x", v.target, v.diff, d)
+		}
+		if d := BigToDifficultyUint32(DifficultyUint32ToBig(v.diff)); d != v.diff {
+			t.Fatalf("big %x but got %x", v.diff, d)
+		}
+	}
+
+	// hash on the target is valid, one more is not
+	target := DifficultyUint32ToHash(0xfc077791)
+	if !CheckHashDifficulty(target, 0xfc077791) || CheckHashDifficulty(target, 0xfc077790) {
+		t.Fatal("hash difficulty check error")
+	}
+
+	if CalculateWork(0xffffffff).Int64() != 1 {
+		t.Fatal("lowest work error", CalculateWork(0xffffffff))
+	}
+	if CalculateCumulativeWork([]uint32{0xfeffffff, 0xffffffff}).Int64() != 256+1 {
+		t.Fatal("cumulative work error")
+	}
+}
+
+func Test_retarget(t *testing.T) {
+
+	diff := uint32(0xfc123456)
+	span := uint64(EachBlockRequiredTargetTime * AdjustTargetDifficultyNumberOfBlocks)
+	start := uint64(1549250700)
+
+	// not retarget height
+	if CalculateNextDifficulty(diff, 289, start, start+1) != diff {
+		t.Fatal("must keep difficulty")
+	}
+	// genesis
+	if CalculateNextDifficulty(0, 1, 0, 0) != LowestDifficultyUint32 {
+		t.Fatal("genesis next difficulty error")
+	}
+	vectors := []struct {
+		actual uint64
+		next   uint32
+	}{
+		{span, 0xfc123456},         // on time
+		{span * 2, 0xfc2468ac},     // twice slower, target doubled
+		{span / 2, 0xfc091a2b},     // twice faster, target halved
+		{span * 100, 0xfc48d158},   // clamp to four times
+		{1, 0xfc048d15},            // clamp to a quarter
+		{span * 16, 0xfc48d158},    // clamp to four times
+		{span * 3 / 2, 0xfc1b4e81}, // 1.5
+	}
+	for _, v := range vectors {
+		next := CalculateNextDifficulty(diff, 288*10, start, start+v.actual)
+		if next != v.next {
+			t.Fatalf("actual %d need %x but got %x", v.actual, v.next, next)
+		}
+	}
+	// never easier than the lowest difficulty
+	if CalculateNextDifficulty(0xfffffff0, 288, start, start+span*4) != LowestDifficultyUint32 {
+		t.Fatal("lowest difficulty limit error")
+	}
+}
+
+func Test_mainnet_retarget(t *testing.T) {
+
+	file, e := os.Open("testdata/mainnet_retarget.txt")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer file.Close()
+	count := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		items := strings.Fields(line)
+		if len(items) != 5 {
+			t.Fatalf("vector line error: %s", line)
+		}
+		nums := make([]uint64, 5)
+		for i, item := range items {
+			base := 10
+			if i == 1 || i == 4 {
+				base = 16
+			}
+			if nums[i], e = strconv.ParseUint(item, base, 64); e != nil {
+				t.Fatalf("vector line error: %s", line)
+			}
+		}
+		next := CalculateNextDifficulty(uint32(nums[1]), nums[0], nums[2], nums[3])
+		if next != uint32(nums[4]) {
+			t.Fatalf("height %d difficulty need %x but got %x", nums[0], nums[4], next)
+		}
+		count++
+	}
+	if count == 0 {
+		t.Fatal("no mainnet retarget vectors in testdata, add them from the heads of a synced mainnet node")
+	}
+	fmt.Println(count, "mainnet retarget vectors")
+}
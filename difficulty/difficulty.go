@@ -0,0 +1,101 @@
+package difficulty
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+)
+
+/**
+ * Compact difficulty value of block head
+ * byte[0] is 255 minus the count of leading zero bytes of the target
+ * byte[1:4] are the first three bytes after the zeros, the rest of the target is zero
+ * A bigger value means an easier target
+ */
+
+const (
+	// The easiest target ffffff0000...
+	LowestDifficultyUint32 = uint32(0xffffffff)
+)
+
+var (
+	maxTargetBig = DifficultyUint32ToBig(LowestDifficultyUint32)
+	// 2^256
+	twoPow256 = new(big.Int).Lsh(big.NewInt(1), 256)
+)
+
+// 32 bytes target hash of the compact difficulty
+func DifficultyUint32ToHash(diff uint32) []byte {
+	diffbts := make([]byte, 4)
+	binary.BigEndian.PutUint32(diffbts, diff)
+	target := make([]byte, 32)
+	zeros := 255 - int(diffbts[0])
+	for i := 0; i < 3; i++ {
+		if zeros+i < 32 {
+			target[zeros+i] = diffbts[1+i]
+		}
+	}
+	return target
+}
+
+// Compact difficulty of the 32 bytes target hash, lower bytes are truncated
+func DifficultyHashToUint32(hash []byte) uint32 {
+	target := make([]byte, 32)
+	copy(target[32-minInt(len(hash), 32):], hash)
+	zeros := 0
+	for zeros < 32 && target[zeros] == 0 {
+		zeros++
+	}
+	diffbts := make([]byte, 4)
+	diffbts[0] = byte(255 - zeros)
+	for i := 0; i < 3; i++ {
+		if zeros+i < 32 {
+			diffbts[1+i] = target[zeros+i]
+		}
+	}
+	return binary.BigEndian.Uint32(diffbts)
+}
+
+func DifficultyUint32ToBig(diff uint32) *big.Int {
+	return new(big.Int).SetBytes(DifficultyUint32ToHash(diff))
+}
+
+func BigToDifficultyUint32(target *big.Int) uint32 {
+	if target.Sign() <= 0 {
+		return DifficultyHashToUint32(nil)
+	}
+	if target.Cmp(maxTargetBig) > 0 {
+		return LowestDifficultyUint32
+	}
+	return DifficultyHashToUint32(target.Bytes())
+}
+
+// Whether the block hash is not bigger than the target of difficulty
+func CheckHashDifficulty(hash []byte, diff uint32) bool {
+	if len(hash) != 32 {
+		return false
+	}
+	return bytes.Compare(hash, DifficultyUint32ToHash(diff)) <= 0
+}
+
+// Expected hash count to meet the difficulty, 2^256 / (target + 1)
+func CalculateWork(diff uint32) *big.Int {
+	target := DifficultyUint32ToBig(diff)
+	return new(big.Int).Div(twoPow256, target.Add(target, big.NewInt(1)))
+}
+
+// Sum work of the difficulty list
+func CalculateCumulativeWork(diffs []uint32) *big.Int {
+	total := big.NewInt(0)
+	for _, d := range diffs {
+		total.Add(total, CalculateWork(d))
+	}
+	return total
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,71 @@
+package difficulty
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/interfaces"
+)
+
+const (
+	// Retarget every 288 blocks, about one day
+	AdjustTargetDifficultyNumberOfBlocks = 288
+	// Target seconds of each block
+	EachBlockRequiredTargetTime = 300
+	// A retarget changes the difficulty four times at most
+	MaxRetargetMultiple = 4
+)
+
+// Difficulty of the block at height
+// prevDiff is the difficulty of prev block, prev288Timestamp is the timestamp of block height-288
+func CalculateNextDifficulty(prevDiff uint32, height uint64, prev288Timestamp uint64, prevTimestamp uint64) uint32 {
+	if prevDiff == 0 {
+		return LowestDifficultyUint32 // genesis has no difficulty
+	}
+	if height < AdjustTargetDifficultyNumberOfBlocks || height%AdjustTargetDifficultyNumberOfBlocks != 0 {
+		return prevDiff
+	}
+	targetspan := uint64(EachBlockRequiredTargetTime * AdjustTargetDifficultyNumberOfBlocks)
+	actualspan := uint64(0)
+	if prevTimestamp > prev288Timestamp {
+		actualspan = prevTimestamp - prev288Timestamp
+	}
+	if actualspan < targetspan/MaxRetargetMultiple {
+		actualspan = targetspan / MaxRetargetMultiple
+	}
+	if actualspan > targetspan*MaxRetargetMultiple {
+		actualspan = targetspan * MaxRetargetMultiple
+	}
+	// new target = old target * actual / target
+	target := DifficultyUint32ToBig(prevDiff)
+	target.Mul(target, new(big.Int).SetUint64(actualspan))
+	target.Div(target, new(big.Int).SetUint64(targetspan))
+	return BigToDifficultyUint32(target)
+}
+
+/**
+ * Mainnet rules for light clients and validators
+ */
+
+type MainnetRules struct{}
+
+func (MainnetRules) CheckProofOfWork(hash fields.Hash, difficulty uint32) bool {
+	return CheckHashDifficulty(hash, difficulty)
+}
+
+func (MainnetRules) NextDifficulty(prev interfaces.BlockHeadMetaRead, headerByHeight func(uint64) interfaces.BlockHeadMetaRead) (uint32, error) {
+	height := prev.GetHeight() + 1
+	if prev.GetDifficulty() == 0 || height%AdjustTargetDifficultyNumberOfBlocks != 0 {
+		return CalculateNextDifficulty(prev.GetDifficulty(), height, 0, 0), nil
+	}
+	first := headerByHeight(height - AdjustTargetDifficultyNumberOfBlocks)
+	if first == nil {
+		return 0, fmt.Errorf("cannot find block %d for difficulty retarget.", height-AdjustTargetDifficultyNumberOfBlocks)
+	}
+	return CalculateNextDifficulty(prev.GetDifficulty(), height, first.GetTimestamp(), prev.GetTimestamp()), nil
+}
+
+func (MainnetRules) CalculateWork(difficulty uint32) *big.Int {
+	return CalculateWork(difficulty)
+}
@@ -0,0 +1,70 @@
+package events
+
+import (
+	"fmt"
+	"github.com/hacash/core/fields"
+	"testing"
+	"time"
+)
+
+func Test_bus(t *testing.T) {
+
+	bus := NewBus()
+	all := bus.Subscribe(10, FullPolicyDrop, nil)
+	drop := bus.Subscribe(1, FullPolicyDrop, FilterTypes(EventTypeChannelOpened))
+	block := bus.Subscribe(0, FullPolicyBlock, nil)
+
+	// blocking subscriber reads in another goroutine
+	got := make(chan int)
+	go func() {
+		n := 0
+		for range block.Chan() {
+			n++
+		}
+		got <- n
+	}()
+
+	bus.Publish(
+		ChannelOpened{ChannelId: fields.ChannelId("c1")},
+		ChannelOpened{ChannelId: fields.ChannelId("c2")},
+		LockblsReleased{},
+	)
+	if len(all.Chan()) != 3 || len(drop.Chan()) != 1 || drop.Dropped() != 1 {
+		t.Fatal("deliver error", len(all.Chan()), len(drop.Chan()), drop.Dropped())
+	}
+	ev := (<-drop.Chan()).(ChannelOpened)
+	if string(ev.ChannelId) != "c1" {
+		t.Fatal("order error")
+	}
+
+	// unsubscribe closes the channel
+	block.Unsubscribe()
+	block.Unsubscribe()
+	select {
+	case n := <-got:
+		fmt.Println("blocking subscriber got", n)
+		if n != 3 {
+			t.Fatal("blocking subscriber must get all")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("unsubscribe not close channel")
+	}
+	if bus.SubscriberCount() != 2 {
+		t.Fatal("subscriber count error")
+	}
+
+	// unsubscribe wakes up the blocked publisher
+	stuck := bus.Subscribe(0, FullPolicyBlock, nil)
+	done := make(chan bool)
+	go func() {
+		bus.Publish(BlockConnected{})
+		done <- true
+	}()
+	time.Sleep(10 * time.Millisecond)
+	stuck.Unsubscribe()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publisher still blocked")
+	}
+}
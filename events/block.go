@@ -0,0 +1,120 @@
+package events
+
+import (
+	"github.com/hacash/core/actions"
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/interfaces"
+)
+
+// Events of the actions in a connected block
+// The state is the state after the block, it decides whether a unilateral close starts a challenge or closes the channel
+func BlockActionEvents(block interfaces.Block, state interfaces.ChainStateOperationRead) ([]Event, error) {
+	evs := make([]Event, 0)
+	height := block.GetHeight()
+	blockhash := block.Hash()
+	for _, tx := range block.GetTrsList() {
+		if tx.Type() == 0 {
+			continue // coinbase
+		}
+		pos := ActionPosition{
+			BlockHeight: height,
+			BlockHash:   blockhash,
+			TxHash:      tx.Hash(),
+		}
+		for _, act := range tx.GetActionList() {
+			ev, e := actionEvent(pos, tx, act, state)
+			if e != nil {
+				return nil, e
+			}
+			if ev != nil {
+				evs = append(evs, ev)
+			}
+		}
+	}
+	return evs, nil
+}
+
+func actionEvent(pos ActionPosition, tx interfaces.Transaction, act interfaces.Action, state interfaces.ChainStateOperationRead) (Event, error) {
+	switch a := act.(type) {
+	// diamond
+	case *actions.Action_4_DiamondCreate:
+		diamond, e := state.ReadLastestDiamond()
+		if e != nil {
+			return nil, e
+		}
+		if diamond == nil || string(diamond.Diamond) != string(a.Diamond) {
+			diamond, e = state.BlockStoreRead().ReadDiamond(a.Diamond)
+			if e != nil || diamond == nil {
+				return nil, e
+			}
+		}
+		return DiamondCreated{pos, diamond}, nil
+	case *actions.Action_5_DiamondTransfer:
+		return DiamondTransferred{pos, []fields.DiamondName{a.Diamond}, tx.GetAddress(), a.ToAddress}, nil
+	case *actions.Action_6_OutfeeQuantityDiamondTransfer:
+		return DiamondTransferred{pos, a.DiamondList.Diamonds, a.FromAddress, a.ToAddress}, nil
+	// channel
+	case *actions.Action_2_OpenPaymentChannel:
+		return ChannelOpened{pos, a.ChannelId, a.LeftAddress, a.RightAddress}, nil
+	case *actions.Action_31_OpenPaymentChannelWithSatoshi:
+		return ChannelOpened{pos, a.ChannelId, a.LeftAddress, a.RightAddress}, nil
+	// lending
+	case *actions.Action_15_DiamondsSystemLendingCreate:
+		return LendingCreated{pos, LendingKindDiamondSystem, a.LendingID}, nil
+	case *actions.Action_16_DiamondsSystemLendingRansom:
+		return LendingRedeemed{pos, LendingKindDiamondSystem, a.LendingID, a.RansomAmount}, nil
+	case *actions.Action_17_BitcoinsSystemLendingCreate:
+		return LendingCreated{pos, LendingKindBitcoinSystem, a.LendingID}, nil
+	case *actions.Action_18_BitcoinsSystemLendingRansom:
+		return LendingRedeemed{pos, LendingKindBitcoinSystem, a.LendingID, a.RansomAmount}, nil
+	case *actions.Action_19_UsersLendingCreate:
+		return LendingCreated{pos, LendingKindUser, a.LendingID}, nil
+	case *actions.Action_20_UsersLendingRansom:
+		return LendingRedeemed{pos, LendingKindUser, a.LendingID, a.RansomAmount}, nil
+	// lockbls
+	case *actions.Action_10_LockblsRelease:
+		return LockblsReleased{pos, a.LockblsId, a.ReleaseAmount}, nil
+	}
+	if id, ok := ActionCloseChannelId(act); ok {
+		return channelStatusEvent(pos, id, state)
+	}
+	return nil, nil
+}
+
+// Channel of the action that closes it or starts a challenge
+func ActionCloseChannelId(act interfaces.Action) (fields.ChannelId, bool) {
+	switch a := act.(type) {
+	case *actions.Action_3_ClosePaymentChannel:
+		return a.ChannelId, true
+	case *actions.Action_12_ClosePaymentChannelBySetupAmount:
+		return a.ChannelId, true
+	case *actions.Action_21_ClosePaymentChannelBySetupOnlyLeftAmount:
+		return a.ChannelId, true
+	case *actions.Action_22_UnilateralClosePaymentChannelByNothing:
+		return a.ChannelId, true
+	case *actions.Action_23_UnilateralCloseOrRespondChallengePaymentChannelByRealtimeReconciliation:
+		return a.Reconciliation.GetChannelId(), true
+	case *actions.Action_24_UnilateralCloseOrRespondChallengePaymentChannelByChannelChainTransferBody:
+		return a.ChannelChainTransferTargetProveBody.ChannelId, true
+	case *actions.Action_26_UnilateralCloseOrRespondChallengePaymentChannelByChannelOnchainAtomicExchange:
+		return a.ChannelChainTransferTargetProveBody.ChannelId, true
+	case *actions.Action_27_ClosePaymentChannelByClaimDistribution:
+		return a.ChannelId, true
+	}
+	return nil, false
+}
+
+// Challenged or closed by the channel status after the block
+func channelStatusEvent(pos ActionPosition, id fields.ChannelId, state interfaces.ChainStateOperationRead) (Event, error) {
+	paychan, e := state.Channel(id)
+	if e != nil || paychan == nil {
+		return nil, e
+	}
+	if paychan.IsChallenging() {
+		return ChannelChallenged{pos, id}, nil
+	}
+	if paychan.IsClosed() {
+		return ChannelClosed{pos, id, paychan.IsFinalDistributionClosed()}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,139 @@
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+/**
+ * Event bus
+ * Every subscriber has its own buffer, a full buffer drops the event or blocks the publisher
+ */
+
+type FullPolicy uint8
+
+const (
+	FullPolicyDrop  FullPolicy = 0 // drop the event and count it
+	FullPolicyBlock FullPolicy = 1 // wait until the subscriber reads or unsubscribes
+)
+
+// Return true to receive the event
+type Filter func(Event) bool
+
+// Receive only the types
+func FilterTypes(types ...EventType) Filter {
+	set := make(map[EventType]bool, len(types))
+	for _, ty := range types {
+		set[ty] = true
+	}
+	return func(ev Event) bool {
+		return set[ev.Type()]
+	}
+}
+
+type Subscription struct {
+	bus    *Bus
+	filter Filter
+	policy FullPolicy
+
+	ch      chan Event
+	quit    chan struct{}
+	once    sync.Once
+	dropped uint64
+}
+
+// Channel closed after unsubscribe
+func (s *Subscription) Chan() <-chan Event {
+	return s.ch
+}
+
+// Count of events dropped because the buffer was full
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+func (s *Subscription) Unsubscribe() {
+	s.once.Do(func() {
+		close(s.quit) // wake up the blocking publishers
+		s.bus.remove(s)
+		close(s.ch)
+	})
+}
+
+func (s *Subscription) deliver(ev Event) {
+	if s.filter != nil && !s.filter(ev) {
+		return
+	}
+	if s.policy == FullPolicyBlock {
+		select {
+		case s.ch <- ev:
+		case <-s.quit:
+		}
+		return
+	}
+	select {
+	case s.ch <- ev:
+	case <-s.quit:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+type Bus struct {
+	subs []*Subscription // in the order they subscribed
+
+	mux sync.RWMutex
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		subs: make([]*Subscription, 0),
+	}
+}
+
+// Nil filter receives all events
+func (b *Bus) Subscribe(buffer int, policy FullPolicy, filter Filter) *Subscription {
+	if buffer < 0 {
+		buffer = 0
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	sub := &Subscription{
+		bus:    b,
+		filter: filter,
+		policy: policy,
+		ch:     make(chan Event, buffer),
+		quit:   make(chan struct{}),
+	}
+	b.subs = append(b.subs, sub)
+	return sub
+}
+
+func (b *Bus) remove(sub *Subscription) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	subs := make([]*Subscription, 0, len(b.subs))
+	for _, s := range b.subs {
+		if s != sub {
+			subs = append(subs, s)
+		}
+	}
+	b.subs = subs
+}
+
+// Deliver to subscribers in the order they subscribed
+func (b *Bus) Publish(evs ...Event) {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	for _, sub := range b.subs {
+		for _, ev := range evs {
+			sub.deliver(ev)
+		}
+	}
+}
+
+func (b *Bus) SubscriberCount() int {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return len(b.subs)
+}
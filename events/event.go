@@ -0,0 +1,126 @@
+package events
+
+import (
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/interfaces"
+	"github.com/hacash/core/stores"
+)
+
+type EventType uint8
+
+const (
+	EventTypeBlockConnected     EventType = 1
+	EventTypeBlockDisconnected  EventType = 2
+	EventTypeDiamondCreated     EventType = 3
+	EventTypeDiamondTransferred EventType = 4
+	EventTypeChannelOpened      EventType = 5
+	EventTypeChannelChallenged  EventType = 6
+	EventTypeChannelClosed      EventType = 7
+	EventTypeLendingCreated     EventType = 8
+	EventTypeLendingRedeemed    EventType = 9
+	EventTypeLockblsReleased    EventType = 10
+)
+
+type Event interface {
+	Type() EventType
+}
+
+// Position of the action that made the event
+type ActionPosition struct {
+	BlockHeight uint64
+	BlockHash   fields.Hash
+	TxHash      fields.Hash
+}
+
+/**************************** block ****************************/
+
+// Block joined the current chain
+type BlockConnected struct {
+	Block interfaces.Block
+}
+
+// Block left the current chain by reorg or rollback
+type BlockDisconnected struct {
+	Block interfaces.Block
+}
+
+func (BlockConnected) Type() EventType    { return EventTypeBlockConnected }
+func (BlockDisconnected) Type() EventType { return EventTypeBlockDisconnected }
+
+/**************************** diamond ****************************/
+
+type DiamondCreated struct {
+	ActionPosition
+	Diamond *stores.DiamondSmelt
+}
+
+type DiamondTransferred struct {
+	ActionPosition
+	Diamonds    []fields.DiamondName
+	FromAddress fields.Address
+	ToAddress   fields.Address
+}
+
+func (DiamondCreated) Type() EventType     { return EventTypeDiamondCreated }
+func (DiamondTransferred) Type() EventType { return EventTypeDiamondTransferred }
+
+/**************************** channel ****************************/
+
+type ChannelOpened struct {
+	ActionPosition
+	ChannelId    fields.ChannelId
+	LeftAddress  fields.Address
+	RightAddress fields.Address
+}
+
+// Unilateral close proposed, the channel is in the challenge period
+type ChannelChallenged struct {
+	ActionPosition
+	ChannelId fields.ChannelId
+}
+
+type ChannelClosed struct {
+	ActionPosition
+	ChannelId fields.ChannelId
+	Final     bool // closed by arbitration, never reusable
+}
+
+func (ChannelOpened) Type() EventType     { return EventTypeChannelOpened }
+func (ChannelChallenged) Type() EventType { return EventTypeChannelChallenged }
+func (ChannelClosed) Type() EventType     { return EventTypeChannelClosed }
+
+/**************************** lending ****************************/
+
+type LendingKind uint8
+
+const (
+	LendingKindDiamondSystem LendingKind = 1
+	LendingKindBitcoinSystem LendingKind = 2
+	LendingKindUser          LendingKind = 3
+)
+
+type LendingCreated struct {
+	ActionPosition
+	Kind      LendingKind
+	LendingId []byte
+}
+
+type LendingRedeemed struct {
+	ActionPosition
+	Kind         LendingKind
+	LendingId    []byte
+	RansomAmount fields.Amount
+}
+
+func (LendingCreated) Type() EventType  { return EventTypeLendingCreated }
+func (LendingRedeemed) Type() EventType { return EventTypeLendingRedeemed }
+
+/**************************** lockbls ****************************/
+
+type LockblsReleased struct {
+	ActionPosition
+	LockblsId     fields.LockblsId
+	ReleaseAmount fields.Amount
+}
+
+func (LockblsReleased) Type() EventType { return EventTypeLockblsReleased }
@@ -3,6 +3,8 @@ package fields
 import (
 	"bytes"
 	"fmt"
+	"github.com/hacash/core/account"
+	"github.com/hacash/core/crypto/btcec"
 	"math/big"
 	"testing"
 )
@@ -66,3 +68,44 @@ func Test_t3(t *testing.T) {
 	fmt.Println(trimStringSerialize("abcdef", 16))
 
 }
+
+func Test_aggregated_sign(t *testing.T) {
+
+	acc1 := account.CreateAccountByPassword("123456")
+	acc2 := account.CreateAccountByPassword("qwerty")
+	hash := CalculateHash([]byte("channel bill"))
+
+	pubkeys := [][]byte{acc1.PublicKey, acc2.PublicKey}
+	keyagg, _ := btcec.MuSigAggregateKeys(pubkeys)
+	sn1, pn1, _ := btcec.MuSigNonceGen(acc1.Private, keyagg, hash, bytes.Repeat([]byte{1}, 32))
+	sn2, pn2, _ := btcec.MuSigNonceGen(acc2.Private, keyagg, hash, bytes.Repeat([]byte{2}, 32))
+	aggnonce, _ := btcec.MuSigNonceAgg([][]byte{pn1, pn2})
+	session, _ := btcec.NewMuSigSession(keyagg, aggnonce, hash)
+	ps1, _ := btcec.MuSigPartialSign(sn1, acc1.Private, session)
+	ps2, _ := btcec.MuSigPartialSign(sn2, acc2.Private, session)
+	sig, _ := btcec.MuSigPartialSigAgg([][]byte{ps1, ps2}, session)
+
+	aggsign, e := NewAggregatedSign(pubkeys, sig)
+	if e != nil {
+		t.Fatal(e)
+	}
+	bts, _ := aggsign.Serialize()
+	fmt.Println(len(bts), "bytes instead of", 2*SignSize)
+	sign2 := AggregatedSign{}
+	seek, e := sign2.Parse(bts, 0)
+	if e != nil || seek != aggsign.Size() {
+		t.Fatal("parse error")
+	}
+	e = sign2.CheckMustAddressAndSign(hash, []Address{acc1.Address, acc2.Address})
+	if e != nil {
+		t.Fatal(e)
+	}
+	e = sign2.CheckMustAddressAndSign(hash, []Address{acc2.Address, acc1.Address})
+	if e == nil {
+		t.Fatal("address order must be checked")
+	}
+	ok, _ := sign2.VerifyByHash32(CalculateHash([]byte("other bill")))
+	if ok {
+		t.Fatal("verify must fail")
+	}
+}
@@ -143,7 +143,7 @@ func (bill Amount) Serialize() ([]byte, error) {
 
 func (bill *Amount) Parse(buf []byte, seek uint32) (uint32, error) {
 	if uint32(len(buf)) < seek+2 {
-		return 0, fmt.Errorf("buf length not less than 2.")
+		return 0, fmt.Errorf("buf length not less than 2, %w", ErrSeekOutOfBuf)
 	}
 	bill.Unit = uint8(buf[seek])
 	bill.Dist = int8(buf[seek+1])
@@ -153,7 +153,7 @@ func (bill *Amount) Parse(buf []byte, seek uint32) (uint32, error) {
 	}
 	var tail = seek + 2 + uint32(numCount)
 	if uint32(len(buf)) < tail {
-		return 0, fmt.Errorf("buf length error.")
+		return 0, fmt.Errorf("buf length error, %w", ErrSeekOutOfBuf)
 	}
 	var nnnold = buf[seek+2 : tail]
 	bill.Numeral = make([]byte, len(nnnold))
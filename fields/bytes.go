@@ -5,9 +5,13 @@ import (
 	//"unsafe"
 	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
 )
 
+// Parse reached the end of buf, more data may complete the object
+var ErrSeekOutOfBuf = errors.New("seek out of buf len.")
+
 var EmptyZeroBytes32 = bytes.Repeat([]byte{0}, 32)
 var EmptyZeroBytes512 = bytes.Repeat([]byte{0}, 512)
 
@@ -156,7 +160,7 @@ func bytesParse(elm interface{}, buf []byte, seek uint32, maxlen uint32) (uint32
 	//fmt.Println(seek+maxlen)
 	//fmt.Println("----------")
 	if seek+maxlen > uint32(len(buf)) {
-		return 0, fmt.Errorf("[bytesParse] seek out of buf len.")
+		return 0, fmt.Errorf("[bytesParse] %w", ErrSeekOutOfBuf)
 	}
 	var nnnold = buf[seek : seek+maxlen]
 	var addrbytes = make([]byte, len(nnnold))
@@ -39,7 +39,7 @@ func (elm DiamondListMaxLen200) Serialize() ([]byte, error) {
 func (elm *DiamondListMaxLen200) Parse(buf []byte, seek uint32) (uint32, error) {
 	var e error = nil
 	if seek >= uint32(len(buf)) {
-		return 0, fmt.Errorf("[DiamondListMaxLen200.Parse] seek out of buf len.")
+		return 0, fmt.Errorf("[DiamondListMaxLen200.Parse] %w", ErrSeekOutOfBuf)
 	}
 	elm.Count = VarUint1(0)
 	seek, e = elm.Count.Parse(buf, seek)
@@ -49,6 +49,9 @@ func (elm *DiamondListMaxLen200) Parse(buf []byte, seek uint32) (uint32, error)
 	if elm.Count == 0 {
 		return seek, nil // List is empty
 	}
+	if int(seek)+int(elm.Count)*DiamondNameSize > len(buf) {
+		return 0, fmt.Errorf("[DiamondListMaxLen200.Parse] %w", ErrSeekOutOfBuf)
+	}
 	elm.Diamonds = make([]DiamondName, int(elm.Count))
 	for i := 0; i < int(elm.Count); i++ {
 		elm.Diamonds[i] = DiamondName{}
@@ -0,0 +1,137 @@
+package fields
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+type fuzzField interface {
+	Serialize() ([]byte, error)
+	Parse([]byte, uint32) (uint32, error)
+}
+
+// Fields are the parts of every tx and store, one parsed ok must take and give back exactly the same bytes
+func fuzzRoundTrip(t *testing.T, obj fuzzField, obj2 fuzzField, data []byte) {
+	seek, e := obj.Parse(data, 0)
+	if e != nil {
+		return
+	}
+	if int(seek) > len(data) {
+		t.Fatalf("seek %d out of data len %d", seek, len(data))
+	}
+	bts, e := obj.Serialize()
+	if e != nil {
+		return
+	}
+	seek, e = obj2.Parse(bts, 0)
+	if e != nil {
+		t.Fatalf("parse serialized data error: %v", e)
+	}
+	if int(seek) != len(bts) {
+		t.Fatalf("parse serialized data seek %d but len %d", seek, len(bts))
+	}
+	bts2, _ := obj2.Serialize()
+	if !bytes.Equal(bts, bts2) {
+		t.Fatalf("round trip not match %x != %x", bts, bts2)
+	}
+}
+
+var fuzzFieldCreators = []func() fuzzField{
+	func() fuzzField { return new(TrimString16) },
+	func() fuzzField { return new(TrimString34) },
+	func() fuzzField { return new(TrimString64) },
+	func() fuzzField { return new(Sign) },
+	func() fuzzField { return new(SignListMax255) },
+	func() fuzzField { return new(SignListMax65535) },
+	func() fuzzField { return new(OptionalAddress) },
+	func() fuzzField { return new(SatoshiVariation) },
+	func() fuzzField { return new(DiamondListMaxLen200) },
+	func() fuzzField { return new(Bytes2) },
+	func() fuzzField { return new(Bytes21) },
+	func() fuzzField { return new(Bytes32) },
+	func() fuzzField { return new(Bytes33) },
+	func() fuzzField { return new(Bytes64) },
+	func() fuzzField { return new(Bool) },
+	func() fuzzField { return new(VarUint1) },
+	func() fuzzField { return new(VarUint2) },
+	func() fuzzField { return new(VarUint3) },
+	func() fuzzField { return new(VarUint4) },
+	func() fuzzField { return new(VarUint5) },
+	func() fuzzField { return new(VarUint8) },
+	func() fuzzField { return new(HashListMax65535) },
+	func() fuzzField { return new(StringMax255) },
+	func() fuzzField { return new(StringMax65535) },
+	func() fuzzField { return new(StringMax16777215) },
+	func() fuzzField { return new(ExtendMessageMaxLen255) },
+	func() fuzzField { return new(AggregatedSign) },
+	func() fuzzField { return new(SignCheckData) },
+	func() fuzzField { return new(Amount) },
+}
+
+// The address, reward and message of the genesis coinbase are checked in under testdata/fuzz
+func FuzzParseFields(f *testing.F) {
+	amt, _ := NewAmountFromFinString("HAC1234:244")
+	amtbts, _ := amt.Serialize()
+	seeds := [][]byte{
+		{},
+		{0},
+		{1, 2, 3},
+		amtbts,
+		{0, 2, 1, 0xff, 0xff},
+		bytes.Repeat([]byte{0xff}, 140),
+		append([]byte{3}, []byte("abc")...),
+	}
+	for i := range fuzzFieldCreators {
+		for _, seed := range seeds {
+			f.Add(uint8(i), seed)
+		}
+	}
+	f.Fuzz(func(t *testing.T, ty uint8, data []byte) {
+		create := fuzzFieldCreators[int(ty)%len(fuzzFieldCreators)]
+		fuzzRoundTrip(t, create(), create(), data)
+		// legacy encoding does not round trip, see Test_multisign_legacy
+		ms := &Multisign{}
+		if seek, e := ms.Parse(data, 0); e == nil && int(seek) > len(data) {
+			t.Fatalf("multisign seek %d out of data len %d", seek, len(data))
+		}
+	})
+}
+
+// Wire format of the multisign in mainnet txs must not change
+func Test_multisign_legacy(t *testing.T) {
+	pk1 := Bytes33(bytes.Repeat([]byte{2}, 33))
+	pk2 := Bytes33(bytes.Repeat([]byte{3}, 33))
+	ms := &Multisign{
+		CondElem:      1,
+		CondBase:      2,
+		PublicKeyList: []Bytes33{pk1, pk2},
+		SignatureInds: []uint8{1},
+		SignatureList: []Bytes64{bytes.Repeat([]byte{9}, 64)},
+	}
+	bts, e := ms.Serialize()
+	if e != nil {
+		t.Fatal(e)
+	}
+	// cond elem twice, base public keys, signature inds, then the first public keys again
+	need := append([]byte{1, 1}, pk1...)
+	need = append(append(need, pk2...), 1)
+	need = append(need, pk1...)
+	if !bytes.Equal(bts, need) || ms.Size() != 2+2*(33+1+64) {
+		t.Fatalf("multisign legacy encoding changed %x", bts)
+	}
+	if _, e := (&Multisign{CondElem: 2, CondBase: 1, PublicKeyList: []Bytes33{pk1}, SignatureInds: []uint8{0, 1}}).Serialize(); e == nil {
+		t.Fatal("list shorter than cond elem must fail")
+	}
+	// parse skips the size of each item again after it
+	buf := append(append([]byte{1, 1}, bytes.Repeat([]byte{7}, 66)...), 0)
+	buf = append(buf, bytes.Repeat([]byte{8}, 128)...)
+	ms2 := &Multisign{}
+	seek, e := ms2.Parse(buf, 0)
+	if e != nil || int(seek) != len(buf) || ms2.SignatureInds[0] != 0 {
+		t.Fatal("multisign legacy parse error", seek, e)
+	}
+	if _, e := ms2.Parse(buf[:len(buf)-1], 0); !errors.Is(e, ErrSeekOutOfBuf) {
+		t.Fatal("cut multisign must be out of buf", e)
+	}
+}
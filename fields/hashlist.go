@@ -39,7 +39,7 @@ func (this *HashListMax65535) Serialize() ([]byte, error) {
 func (this *HashListMax65535) Parse(buf []byte, seek uint32) (uint32, error) {
 	var e error = nil
 	if seek >= uint32(len(buf)) {
-		return 0, fmt.Errorf("[Sign.Parse] seek out of buf len.")
+		return 0, fmt.Errorf("[Sign.Parse] %w", ErrSeekOutOfBuf)
 	}
 	this.Count = VarUint2(0)
 	seek, e = this.Count.Parse(buf, seek)
@@ -49,6 +49,9 @@ func (this *HashListMax65535) Parse(buf []byte, seek uint32) (uint32, error) {
 	if this.Count == 0 {
 		return seek, nil // List is empty
 	}
+	if int(seek)+int(this.Count)*HashSize > len(buf) {
+		return 0, fmt.Errorf("[Sign.Parse] %w", ErrSeekOutOfBuf)
+	}
 	this.Hashs = make([]Hash, int(this.Count))
 	for i := 0; i < int(this.Count); i++ {
 		this.Hashs[i] = make([]byte, 32)
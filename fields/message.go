@@ -20,14 +20,14 @@ func (e ExtendMessageMaxLen255) Serialize() ([]byte, error) {
 
 func (e *ExtendMessageMaxLen255) Parse(buf []byte, seek uint32) (uint32, error) {
 	if seek >= uint32(len(buf)) {
-		return 0, fmt.Errorf("[ExtendMessageMaxLen255.Parse] seek out of buf len.")
+		return 0, fmt.Errorf("[ExtendMessageMaxLen255.Parse] %w", ErrSeekOutOfBuf)
 	}
 	e.Count = VarUint1(buf[int(seek)])
 	seek++
 	start := seek
 	end := start + uint32(e.Count)
-	if len(buf) < int(e.Count)-1 {
-		return 0, fmt.Errorf("buf is too short.")
+	if int(end) > len(buf) {
+		return 0, fmt.Errorf("buf is too short, %w", ErrSeekOutOfBuf)
 	}
 	e.Message = buf[start:end]
 	return end, nil
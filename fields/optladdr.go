@@ -41,7 +41,7 @@ func (elm OptionalAddress) Serialize() ([]byte, error) {
 func (elm *OptionalAddress) Parse(buf []byte, seek uint32) (uint32, error) {
 	var e error = nil
 	if seek >= uint32(len(buf)) {
-		return 0, fmt.Errorf("[OptionalAddress.Parse] seek out of buf len.")
+		return 0, fmt.Errorf("[OptionalAddress.Parse] %w", ErrSeekOutOfBuf)
 	}
 	seek, e = elm.Exist.Parse(buf, seek)
 	if e != nil {
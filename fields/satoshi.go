@@ -52,7 +52,7 @@ func (elm SatoshiVariation) Serialize() ([]byte, error) {
 func (elm *SatoshiVariation) Parse(buf []byte, seek uint32) (uint32, error) {
 	var e error = nil
 	if seek >= uint32(len(buf)) {
-		return 0, fmt.Errorf("[SatoshiVariation.Parse] seek out of buf len.")
+		return 0, fmt.Errorf("[SatoshiVariation.Parse] %w", ErrSeekOutOfBuf)
 	}
 	seek, e = elm.NotEmpty.Parse(buf, seek)
 	if e != nil {
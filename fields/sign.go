@@ -25,8 +25,15 @@ func (this *Sign) Serialize() ([]byte, error) {
 }
 
 func (this *Sign) Parse(buf []byte, seek uint32) (uint32, error) {
-	seek, _ = this.PublicKey.Parse(buf, seek)
-	seek, _ = this.Signature.Parse(buf, seek)
+	var e error = nil
+	seek, e = this.PublicKey.Parse(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	seek, e = this.Signature.Parse(buf, seek)
+	if e != nil {
+		return 0, e
+	}
 	return seek, nil
 }
 
@@ -38,6 +45,21 @@ func (this *Sign) GetAddress() Address {
 	return account.NewAddressFromPublicKeyV0(this.PublicKey)
 }
 
+// Low S canonical signature
+func (this *Sign) IsCanonical() bool {
+	return account.IsCanonicalSignatureBytes64(this.Signature)
+}
+
+// Check all signatures are canonical
+func CheckCanonicalSigns(signs []Sign) error {
+	for i := 0; i < len(signs); i++ {
+		if !signs[i].IsCanonical() {
+			return fmt.Errorf("signature of address %s is not canonical.", signs[i].GetAddress().ToReadable())
+		}
+	}
+	return nil
+}
+
 func CreateEmptySign() Sign {
 	b1 := bytes.Repeat([]byte{0}, 33)
 	b2 := bytes.Repeat([]byte{0}, 64)
@@ -80,7 +102,7 @@ func (this *SignListMax255) Serialize() ([]byte, error) {
 func (this *SignListMax255) Parse(buf []byte, seek uint32) (uint32, error) {
 	var e error = nil
 	if seek >= uint32(len(buf)) {
-		return 0, fmt.Errorf("[Sign.Parse] seek out of buf len.")
+		return 0, fmt.Errorf("[Sign.Parse] %w", ErrSeekOutOfBuf)
 	}
 	this.Count = VarUint1(0)
 	seek, e = this.Count.Parse(buf, seek)
@@ -90,6 +112,9 @@ func (this *SignListMax255) Parse(buf []byte, seek uint32) (uint32, error) {
 	if this.Count == 0 {
 		return seek, nil // List is empty
 	}
+	if int(seek)+int(this.Count)*int(SignSize) > len(buf) {
+		return 0, fmt.Errorf("[Sign.Parse] %w", ErrSeekOutOfBuf)
+	}
 	this.Signs = make([]Sign, int(this.Count))
 	for i := 0; i < int(this.Count); i++ {
 		this.Signs[i] = Sign{}
@@ -128,7 +153,7 @@ func (this *SignListMax65535) Serialize() ([]byte, error) {
 func (this *SignListMax65535) Parse(buf []byte, seek uint32) (uint32, error) {
 	var e error = nil
 	if seek >= uint32(len(buf)) {
-		return 0, fmt.Errorf("[Sign.Parse] seek out of buf len.")
+		return 0, fmt.Errorf("[Sign.Parse] %w", ErrSeekOutOfBuf)
 	}
 	this.Count = VarUint2(0)
 	seek, e = this.Count.Parse(buf, seek)
@@ -138,6 +163,9 @@ func (this *SignListMax65535) Parse(buf []byte, seek uint32) (uint32, error) {
 	if this.Count == 0 {
 		return seek, nil // List is empty
 	}
+	if int(seek)+int(this.Count)*int(SignSize) > len(buf) {
+		return 0, fmt.Errorf("[Sign.Parse] %w", ErrSeekOutOfBuf)
+	}
 	this.Signs = make([]Sign, int(this.Count))
 	for i := 0; i < int(this.Count); i++ {
 		this.Signs[i] = Sign{}
@@ -179,6 +207,9 @@ func (this *Multisign) Serialize() ([]byte, error) {
 	buffer.Write([]byte{this.CondElem, this.CondElem})
 	length1 := int(this.CondElem)
 	length2 := int(this.CondBase)
+	if len(this.PublicKeyList) < length1 || len(this.PublicKeyList) < length2 || len(this.SignatureInds) < length1 {
+		return nil, fmt.Errorf("multisign list length not match cond elem %d and base %d.", length1, length2)
+	}
 	for i := 0; i < length2; i++ {
 		buffer.Write(this.PublicKeyList[i])
 	}
@@ -193,13 +224,16 @@ func (this *Multisign) Serialize() ([]byte, error) {
 
 func (this *Multisign) Parse(buf []byte, seek uint32) (uint32, error) {
 	if int(seek)+2 > len(buf) {
-		return 0, fmt.Errorf("buf len too short.")
+		return 0, fmt.Errorf("buf len too short, %w", ErrSeekOutOfBuf)
 	}
 	this.CondElem = buf[seek]
 	this.CondBase = buf[seek+1]
 	seek = seek + 2
 	length1 := int(this.CondElem)
 	length2 := int(this.CondBase)
+	if int(seek)+length2*33+length1*(1+64) > len(buf) {
+		return 0, fmt.Errorf("buf len too short, %w", ErrSeekOutOfBuf)
+	}
 	this.PublicKeyList = make([]Bytes33, length2)
 	this.SignatureInds = make([]uint8, length1)
 	this.SignatureList = make([]Bytes64, length1)
@@ -215,7 +249,7 @@ func (this *Multisign) Parse(buf []byte, seek uint32) (uint32, error) {
 	}
 	for i := 0; i < length1; i++ {
 		if int(seek) >= len(buf) {
-			return 0, fmt.Errorf("buf len too short.")
+			return 0, fmt.Errorf("buf len too short, %w", ErrSeekOutOfBuf)
 		}
 		this.SignatureInds[i] = buf[seek]
 		seek += 1
@@ -229,6 +263,9 @@ func (this *Multisign) Parse(buf []byte, seek uint32) (uint32, error) {
 		this.SignatureList[i] = b
 		seek += b.Size()
 	}
+	if int(seek) > len(buf) {
+		return 0, fmt.Errorf("buf len too short, %w", ErrSeekOutOfBuf)
+	}
 	return seek, nil
 }
 
@@ -0,0 +1,130 @@
+package fields
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/hacash/core/account"
+)
+
+//////////////////////////////////////////////////////////////////
+
+// Aggregated schnorr signature, one 64 bytes signature for all public keys
+// Replace one Sign per party in multi-signer bills
+type AggregatedSign struct {
+	Count      VarUint1
+	PublicKeys []Bytes33 // keys order is the aggregation order
+	Signature  Bytes64
+}
+
+func CreateEmptyAggregatedSign() AggregatedSign {
+	return AggregatedSign{
+		Count:      0,
+		PublicKeys: make([]Bytes33, 0),
+		Signature:  bytes.Repeat([]byte{0}, 64),
+	}
+}
+
+func NewAggregatedSign(pubkeys [][]byte, signature []byte) (*AggregatedSign, error) {
+	if len(pubkeys) == 0 || len(pubkeys) > 255 {
+		return nil, fmt.Errorf("public keys count error, need 1~255 but got %d.", len(pubkeys))
+	}
+	if len(signature) != 64 {
+		return nil, fmt.Errorf("signature length error.")
+	}
+	sign := &AggregatedSign{
+		Count:      VarUint1(len(pubkeys)),
+		PublicKeys: make([]Bytes33, len(pubkeys)),
+		Signature:  append([]byte{}, signature...),
+	}
+	for i, pk := range pubkeys {
+		if len(pk) != 33 {
+			return nil, fmt.Errorf("public key length error.")
+		}
+		sign.PublicKeys[i] = append([]byte{}, pk...)
+	}
+	return sign, nil
+}
+
+func (this *AggregatedSign) Size() uint32 {
+	return this.Count.Size() + uint32(len(this.PublicKeys))*33 + this.Signature.Size()
+}
+
+func (this *AggregatedSign) Serialize() ([]byte, error) {
+	if int(this.Count) != len(this.PublicKeys) {
+		return nil, fmt.Errorf("AggregatedSign public keys count error.")
+	}
+	var buffer bytes.Buffer
+	bt, _ := this.Count.Serialize()
+	buffer.Write(bt)
+	for i := 0; i < int(this.Count); i++ {
+		buffer.Write(this.PublicKeys[i])
+	}
+	buffer.Write(this.Signature)
+	return buffer.Bytes(), nil
+}
+
+func (this *AggregatedSign) Parse(buf []byte, seek uint32) (uint32, error) {
+	var e error = nil
+	seek, e = this.Count.Parse(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	if int(seek)+int(this.Count)*33 > len(buf) {
+		return 0, fmt.Errorf("[AggregatedSign.Parse] %w", ErrSeekOutOfBuf)
+	}
+	this.PublicKeys = make([]Bytes33, int(this.Count))
+	for i := 0; i < int(this.Count); i++ {
+		seek, e = this.PublicKeys[i].Parse(buf, seek)
+		if e != nil {
+			return 0, e
+		}
+	}
+	seek, e = this.Signature.Parse(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	return seek, nil
+}
+
+// Addresses of all public keys
+func (this *AggregatedSign) GetAddresses() []Address {
+	addrs := make([]Address, len(this.PublicKeys))
+	for i, pk := range this.PublicKeys {
+		addrs[i] = account.NewAddressFromPublicKeyV0(pk)
+	}
+	return addrs
+}
+
+// Verify signature of hash
+func (this *AggregatedSign) VerifyByHash32(hash []byte) (bool, error) {
+	if int(this.Count) == 0 || int(this.Count) != len(this.PublicKeys) {
+		return false, fmt.Errorf("AggregatedSign public keys count error.")
+	}
+	pubkeys := make([][]byte, len(this.PublicKeys))
+	for i, pk := range this.PublicKeys {
+		pubkeys[i] = pk
+	}
+	return account.CheckAggregatedSignByHash32(hash, pubkeys, this.Signature)
+}
+
+// Check that the signed addresses are exactly the must sign addresses in order, and verify the signature
+func (this *AggregatedSign) CheckMustAddressAndSign(hash []byte, mustaddrs []Address) error {
+	addrs := this.GetAddresses()
+	if len(addrs) != len(mustaddrs) {
+		return fmt.Errorf("Aggregated sign addresses count error, need %d but got %d.", len(mustaddrs), len(addrs))
+	}
+	for i := 0; i < len(addrs); i++ {
+		if addrs[i].NotEqual(mustaddrs[i]) {
+			return fmt.Errorf("Address not match, need %s nut got %s.",
+				mustaddrs[i].ToReadable(), addrs[i].ToReadable())
+		}
+	}
+	ok, e := this.VerifyByHash32(hash)
+	if e != nil {
+		return e
+	}
+	if !ok {
+		return fmt.Errorf("Aggregated sign verify fail.")
+	}
+	return nil
+}
@@ -54,7 +54,7 @@ func (elm SignCheckData) Serialize() ([]byte, error) {
 func (elm *SignCheckData) Parse(buf []byte, seek uint32) (uint32, error) {
 	var e error = nil
 	if seek >= uint32(len(buf)) {
-		return 0, fmt.Errorf("[SignCheckData.Parse] seek out of buf len.")
+		return 0, fmt.Errorf("[SignCheckData.Parse] %w", ErrSeekOutOfBuf)
 	}
 	seek, e = elm.Signdata.Parse(buf, seek)
 	if e != nil {
@@ -46,7 +46,7 @@ func (elm StringMax255) Serialize() ([]byte, error) {
 func (elm *StringMax255) Parse(buf []byte, seek uint32) (uint32, error) {
 	var e error = nil
 	if seek >= uint32(len(buf)) {
-		return 0, fmt.Errorf("[StringMax255.Parse] seek out of buf len.")
+		return 0, fmt.Errorf("[StringMax255.Parse] %w", ErrSeekOutOfBuf)
 	}
 	seek, e = elm.Len.Parse(buf, seek)
 	if e != nil {
@@ -55,7 +55,7 @@ func (elm *StringMax255) Parse(buf []byte, seek uint32) (uint32, error) {
 	if elm.Len > 0 {
 		end := seek + uint32(elm.Len)
 		if len(buf) < int(end) {
-			return 0, fmt.Errorf("Str lenght error")
+			return 0, fmt.Errorf("Str lenght error, %w", ErrSeekOutOfBuf)
 		}
 		elm.Str = string(buf[seek:end])
 		seek = end
@@ -104,7 +104,7 @@ func (elm StringMax65535) Serialize() ([]byte, error) {
 func (elm *StringMax65535) Parse(buf []byte, seek uint32) (uint32, error) {
 	var e error = nil
 	if seek >= uint32(len(buf)) {
-		return 0, fmt.Errorf("[StringMax65535.Parse] seek out of buf len.")
+		return 0, fmt.Errorf("[StringMax65535.Parse] %w", ErrSeekOutOfBuf)
 	}
 	seek, e = elm.Len.Parse(buf, seek)
 	if e != nil {
@@ -113,7 +113,7 @@ func (elm *StringMax65535) Parse(buf []byte, seek uint32) (uint32, error) {
 	if elm.Len > 0 {
 		end := seek + uint32(elm.Len)
 		if len(buf) < int(end) {
-			return 0, fmt.Errorf("Str length error")
+			return 0, fmt.Errorf("Str length error, %w", ErrSeekOutOfBuf)
 		}
 		elm.Str = string(buf[seek:end])
 		seek = end
@@ -162,7 +162,7 @@ func (elm StringMax16777215) Serialize() ([]byte, error) {
 func (elm *StringMax16777215) Parse(buf []byte, seek uint32) (uint32, error) {
 	var e error = nil
 	if seek >= uint32(len(buf)) {
-		return 0, fmt.Errorf("[StringMax16777215.Parse] seek out of buf len.")
+		return 0, fmt.Errorf("[StringMax16777215.Parse] %w", ErrSeekOutOfBuf)
 	}
 	seek, e = elm.Len.Parse(buf, seek)
 	if e != nil {
@@ -171,7 +171,7 @@ func (elm *StringMax16777215) Parse(buf []byte, seek uint32) (uint32, error) {
 	if elm.Len > 0 {
 		end := seek + uint32(elm.Len)
 		if len(buf) < int(end) {
-			return 0, fmt.Errorf("Str lenght error")
+			return 0, fmt.Errorf("Str lenght error, %w", ErrSeekOutOfBuf)
 		}
 		elm.Str = string(buf[seek:end])
 		seek = end
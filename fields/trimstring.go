@@ -53,7 +53,7 @@ func valueShow(str string) string {
 
 func trimStringParse(elm interface{}, buf []byte, seek uint32, maxlen uint32) (uint32, error) {
 	if seek+maxlen > uint32(len(buf)) {
-		return 0, fmt.Errorf("[trimStringParse] seek out of buf len.")
+		return 0, fmt.Errorf("[trimStringParse] %w", ErrSeekOutOfBuf)
 	}
 	var nnnold = buf[seek : seek+maxlen]
 	var addrbytes = make([]byte, len(nnnold))
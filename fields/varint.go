@@ -113,7 +113,7 @@ func varIntSerialize(val uint64, maxlen uint32) ([]byte, error) {
 func varIntParse(elm interface{}, buf []byte, seek uint32, maxlen uint32) (uint32, error) {
 	// fmt.Println("xxx",*buf)
 	if seek+maxlen > uint32(len(buf)) {
-		return 0, fmt.Errorf("[varIntParse] seek out of buf len.")
+		return 0, fmt.Errorf("[varIntParse] %w", ErrSeekOutOfBuf)
 	}
 	nnnold := buf[seek : seek+maxlen]
 	var intbytes = make([]byte, len(nnnold))
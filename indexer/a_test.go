@@ -0,0 +1,123 @@
+package indexer
+
+import (
+	"fmt"
+	"github.com/hacash/core/account"
+	"github.com/hacash/core/actions"
+	"github.com/hacash/core/blocks"
+	"github.com/hacash/core/events"
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/interfaces"
+	"github.com/hacash/core/internal/testchain"
+	"github.com/hacash/core/stores"
+	"testing"
+)
+
+func Test_address_history(t *testing.T) {
+
+	miner := account.CreateAccountByPassword("miner")
+	acc1 := account.CreateAccountByPassword("123456")
+	acc2 := account.CreateAccountByPassword("qwerty")
+	acc3 := account.CreateAccountByPassword("asdfgh")
+
+	newblock := func(prev interfaces.BlockHeadMetaRead, txs ...interfaces.Transaction) interfaces.Block {
+		return testchain.NewBlock(prev, miner.Address, txs...)
+	}
+
+	genesis := blocks.NewEmptyBlockV1()
+	b1 := newblock(genesis,
+		testchain.NewTx(1, acc1.Address, nil, actions.NewAction_1_SimpleToTransfer(acc2.Address, fields.NewAmountSmall(1, 248))),
+		testchain.NewTx(2, acc1.Address, nil, actions.NewAction_1_SimpleToTransfer(acc3.Address, fields.NewAmountSmall(1, 248))),
+	)
+	b2 := newblock(b1,
+		testchain.NewTx(3, acc2.Address, nil, &actions.Action_14_FromToTransfer{
+			FromAddress: acc3.Address,
+			ToAddress:   acc1.Address,
+			Amount:      *fields.NewAmountSmall(1, 248),
+		}),
+	)
+
+	x := NewAddressHistoryIndexer(nil)
+	for _, ev := range []events.Event{events.BlockConnected{Block: b1}, events.BlockConnected{Block: b2}} {
+		if e := x.HandleEvent(ev); e != nil {
+			t.Fatal(e)
+		}
+	}
+
+	// acc1: b1 tx1, b1 tx2, b2 tx1
+	if x.GetAddressTxCount(acc1.Address) != 3 || x.GetAddressTxCount(acc3.Address) != 2 {
+		t.Fatal("history count error")
+	}
+	page1 := x.GetAddressHistory(acc1.Address, 1, 2)
+	page2 := x.GetAddressHistory(acc1.Address, 2, 2)
+	if len(page1) != 2 || len(page2) != 1 || page1[0].BlockHeight != 2 || page2[0].TxIndex != 1 {
+		t.Fatal("history page error")
+	}
+	fmt.Println(page1[0].TxHash.ToHex(), page2[0].BlockHeight)
+
+	// reorg
+	if x.DisconnectBlock(b1) == nil {
+		t.Fatal("must disconnect the tip first")
+	}
+	if e := x.DisconnectBlock(b2); e != nil {
+		t.Fatal(e)
+	}
+	if x.GetAddressTxCount(acc1.Address) != 2 || x.GetAddressTxCount(acc3.Address) != 1 || len(x.GetAddressHistory(acc2.Address, 1, 10)) != 1 {
+		t.Fatal("disconnect error")
+	}
+}
+
+func Test_diamond_history(t *testing.T) {
+
+	acc1 := account.CreateAccountByPassword("123456")
+	acc2 := account.CreateAccountByPassword("qwerty")
+
+	newblock := func(prev interfaces.BlockHeadMetaRead, txs ...interfaces.Transaction) interfaces.Block {
+		return testchain.NewBlock(prev, nil, txs...)
+	}
+
+	dia := fields.DiamondName("WTYUIA")
+	b1 := newblock(blocks.NewEmptyBlockV1(), testchain.NewTx(1, acc1.Address, nil, &actions.Action_4_DiamondCreate{
+		Diamond: dia,
+		Number:  1,
+		Address: acc1.Address,
+	}))
+	b2 := newblock(b1, testchain.NewTx(2, acc1.Address, nil, &actions.Action_5_DiamondTransfer{
+		Diamond:   dia,
+		ToAddress: acc2.Address,
+	}))
+	b3 := newblock(b2, testchain.NewTx(3, acc2.Address, nil, &actions.Action_15_DiamondsSystemLendingCreate{
+		LendingID:           make([]byte, 14),
+		MortgageDiamondList: fields.DiamondListMaxLen200{Count: 1, Diamonds: []fields.DiamondName{dia}},
+	}))
+
+	x := NewDiamondHistoryIndexer(nil)
+	for _, blk := range []interfaces.Block{b1, b2, b3} {
+		if e := x.ConnectBlock(blk); e != nil {
+			t.Fatal(e)
+		}
+	}
+	name, list := x.GetDiamondHistoryByNumber(1)
+	if string(name) != string(dia) || len(list) != 3 {
+		t.Fatal("diamond history error")
+	}
+	last := list[2]
+	fmt.Println(last.Kind, last.BlockHeight, last.Status, last.ToAddress.ToReadable())
+	if last.Kind != DiamondHistoryKindMortgage || last.Status != stores.DiamondStatusLendingSystem || !last.ToAddress.Equal(acc2.Address) {
+		t.Fatal("mortgage entry error")
+	}
+	if len(x.GetDiamondsByOwner(acc1.Address)) != 0 || len(x.GetDiamondsByOwner(acc2.Address)) != 1 {
+		t.Fatal("owner error")
+	}
+
+	// unwind to the mint
+	x.DisconnectBlock(b3)
+	x.DisconnectBlock(b2)
+	if len(x.GetDiamondHistory(dia)) != 1 || len(x.GetDiamondsByOwner(acc1.Address)) != 1 || len(x.GetDiamondsByOwner(acc2.Address)) != 0 {
+		t.Fatal("disconnect transfer error")
+	}
+	x.DisconnectBlock(b1)
+	if name, _ := x.GetDiamondHistoryByNumber(1); name != nil || len(x.GetDiamondsByOwner(acc1.Address)) != 0 {
+		t.Fatal("disconnect mint error")
+	}
+}
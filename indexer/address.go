@@ -0,0 +1,172 @@
+package indexer
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/hacash/core/events"
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/interfaces"
+)
+
+/**
+ * Address transaction history
+ * Optional index built from the connected blocks, the blocks disconnected by reorg are removed from the tail
+ */
+
+type AddressTxEntry struct {
+	BlockHeight uint64
+	BlockHash   fields.Hash
+	TxHash      fields.Hash
+	TxIndex     uint32 // 0 is coinbase
+}
+
+type AddressHistoryIndexer struct {
+	state StateReader // nil skips the parties kept only in the state
+
+	history map[string][]*AddressTxEntry // address => entries in chain order
+	blocks  map[string][]string          // block hash => addresses indexed by the block
+	tip     fields.Hash
+
+	mux sync.RWMutex
+}
+
+func NewAddressHistoryIndexer(state StateReader) *AddressHistoryIndexer {
+	return &AddressHistoryIndexer{
+		state:   state,
+		history: make(map[string][]*AddressTxEntry),
+		blocks:  make(map[string][]string),
+	}
+}
+
+func (x *AddressHistoryIndexer) stateRead() interfaces.ChainStateOperationRead {
+	if x.state == nil {
+		return nil
+	}
+	return x.state.StateRead()
+}
+
+// Index every address touched by each transaction of the block
+func (x *AddressHistoryIndexer) ConnectBlock(block interfaces.Block) error {
+	x.mux.Lock()
+	defer x.mux.Unlock()
+
+	hash := block.Hash()
+	if _, ok := x.blocks[string(hash)]; ok {
+		return fmt.Errorf("block %d <%s> already indexed.", block.GetHeight(), hash.ToHex())
+	}
+	if x.tip != nil && !block.GetPrevHash().Equal(x.tip) {
+		return fmt.Errorf("block %d prev hash <%s> not the indexed tip <%s>.", block.GetHeight(), block.GetPrevHash().ToHex(), x.tip.ToHex())
+	}
+	state := x.stateRead()
+	touched := make([]string, 0)
+	for i, tx := range block.GetTrsList() {
+		addrs, e := TransactionAddresses(tx, state)
+		if e != nil {
+			return e
+		}
+		entry := &AddressTxEntry{
+			BlockHeight: block.GetHeight(),
+			BlockHash:   hash,
+			TxHash:      tx.Hash(),
+			TxIndex:     uint32(i),
+		}
+		for _, addr := range addrs {
+			k := string(addr)
+			x.history[k] = append(x.history[k], entry)
+			touched = append(touched, k)
+		}
+	}
+	x.blocks[string(hash)] = touched
+	x.tip = hash
+	return nil
+}
+
+// Remove the entries of the block, it must be the latest indexed block
+func (x *AddressHistoryIndexer) DisconnectBlock(block interfaces.Block) error {
+	x.mux.Lock()
+	defer x.mux.Unlock()
+
+	hash := block.Hash()
+	touched, ok := x.blocks[string(hash)]
+	if !ok {
+		return fmt.Errorf("block %d <%s> not indexed.", block.GetHeight(), hash.ToHex())
+	}
+	if !hash.Equal(x.tip) {
+		return fmt.Errorf("block %d <%s> not the indexed tip.", block.GetHeight(), hash.ToHex())
+	}
+	for i := len(touched) - 1; i >= 0; i-- {
+		k := touched[i]
+		list := x.history[k]
+		last := len(list) - 1
+		if last < 0 || !list[last].BlockHash.Equal(hash) {
+			return fmt.Errorf("address history of block %d broken.", block.GetHeight())
+		}
+		if last == 0 {
+			delete(x.history, k)
+		} else {
+			x.history[k] = list[:last]
+		}
+	}
+	delete(x.blocks, string(hash))
+	x.tip = block.GetPrevHash()
+	return nil
+}
+
+// Entry count of the address
+func (x *AddressHistoryIndexer) GetAddressTxCount(addr fields.Address) int {
+	x.mux.RLock()
+	defer x.mux.RUnlock()
+
+	return len(x.history[string(addr)])
+}
+
+// Newest first, page starts from 1
+func (x *AddressHistoryIndexer) GetAddressHistory(addr fields.Address, page int, limit int) []*AddressTxEntry {
+	x.mux.RLock()
+	defer x.mux.RUnlock()
+
+	list := x.history[string(addr)]
+	if page < 1 || limit < 1 {
+		return []*AddressTxEntry{}
+	}
+	start := (page - 1) * limit
+	if start >= len(list) {
+		return []*AddressTxEntry{}
+	}
+	end := start + limit
+	if end > len(list) {
+		end = len(list)
+	}
+	res := make([]*AddressTxEntry, 0, end-start)
+	for i := start; i < end; i++ {
+		res = append(res, list[len(list)-1-i])
+	}
+	return res
+}
+
+/**************************** events ****************************/
+
+func (x *AddressHistoryIndexer) HandleEvent(ev events.Event) error {
+	switch e := ev.(type) {
+	case events.BlockConnected:
+		return x.ConnectBlock(e.Block)
+	case events.BlockDisconnected:
+		return x.DisconnectBlock(e.Block)
+	}
+	return nil
+}
+
+// Subscribe the block events of the bus and index them, until unsubscribe
+// The subscription blocks the publisher, so no block is missed
+func (x *AddressHistoryIndexer) Run(bus *events.Bus, onerr func(error)) *events.Subscription {
+	sub := bus.Subscribe(64, events.FullPolicyBlock, events.FilterTypes(events.EventTypeBlockConnected, events.EventTypeBlockDisconnected))
+	go func() {
+		for ev := range sub.Chan() {
+			if e := x.HandleEvent(ev); e != nil && onerr != nil {
+				onerr(e)
+			}
+		}
+	}()
+	return sub
+}
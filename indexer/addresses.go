@@ -0,0 +1,123 @@
+package indexer
+
+import (
+	"github.com/hacash/core/actions"
+	"github.com/hacash/core/events"
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/interfaces"
+)
+
+// Read the state for the parties of channel, lending and lockbls, such as the chain engine
+type StateReader interface {
+	StateRead() interfaces.ChainStateOperationRead
+}
+
+// All addresses the transaction touches, without repeat
+// Parties kept only in the state are read from the state, nil state skips them
+func TransactionAddresses(tx interfaces.Transaction, state interfaces.ChainStateOperationRead) ([]fields.Address, error) {
+	addrs := make([]fields.Address, 0)
+	exists := make(map[string]bool)
+	add := func(list ...fields.Address) {
+		for _, addr := range list {
+			if len(addr) != fields.AddressSize || exists[string(addr)] {
+				continue
+			}
+			exists[string(addr)] = true
+			addrs = append(addrs, addr)
+		}
+	}
+	add(tx.GetAddress())
+	for _, act := range tx.GetActionList() {
+		add(act.RequestSignAddresses()...)
+		parties, e := actionParties(act, state)
+		if e != nil {
+			return nil, e
+		}
+		add(parties...)
+	}
+	return addrs, nil
+}
+
+// Addresses of the action that do not need to sign
+func actionParties(act interfaces.Action, state interfaces.ChainStateOperationRead) ([]fields.Address, error) {
+	switch a := act.(type) {
+	// transfer
+	case *actions.Action_1_SimpleToTransfer:
+		return []fields.Address{a.ToAddress}, nil
+	case *actions.Action_13_FromTransfer:
+		return []fields.Address{a.FromAddress}, nil
+	case *actions.Action_14_FromToTransfer:
+		return []fields.Address{a.FromAddress, a.ToAddress}, nil
+	case *actions.Action_7_SatoshiGenesis:
+		return []fields.Address{a.OriginAddress}, nil
+	case *actions.Action_8_SimpleSatoshiTransfer:
+		return []fields.Address{a.ToAddress}, nil
+	case *actions.Action_11_FromToSatoshiTransfer:
+		return []fields.Address{a.FromAddress, a.ToAddress}, nil
+	case *actions.Action_28_FromSatoshiTransfer:
+		return []fields.Address{a.FromAddress}, nil
+	// diamond
+	case *actions.Action_4_DiamondCreate:
+		return []fields.Address{a.Address}, nil
+	case *actions.Action_5_DiamondTransfer:
+		return []fields.Address{a.ToAddress}, nil
+	case *actions.Action_6_OutfeeQuantityDiamondTransfer:
+		return []fields.Address{a.FromAddress, a.ToAddress}, nil
+	// channel
+	case *actions.Action_2_OpenPaymentChannel:
+		return []fields.Address{a.LeftAddress, a.RightAddress}, nil
+	case *actions.Action_31_OpenPaymentChannelWithSatoshi:
+		return []fields.Address{a.LeftAddress, a.RightAddress}, nil
+	// lockbls
+	case *actions.Action_9_LockblsCreate:
+		return []fields.Address{a.PaymentAddress, a.MasterAddress}, nil
+	case *actions.Action_10_LockblsRelease:
+		if state == nil {
+			return nil, nil
+		}
+		lock, e := state.Lockbls(a.LockblsId)
+		if e != nil || lock == nil {
+			return nil, e
+		}
+		return []fields.Address{lock.MasterAddress}, nil
+	// lending
+	case *actions.Action_19_UsersLendingCreate:
+		return []fields.Address{a.MortgagorAddress, a.LenderAddress}, nil
+	case *actions.Action_16_DiamondsSystemLendingRansom:
+		if state == nil {
+			return nil, nil
+		}
+		lend, e := state.DiamondSystemLending(a.LendingID)
+		if e != nil || lend == nil {
+			return nil, e
+		}
+		return []fields.Address{lend.MainAddress}, nil
+	case *actions.Action_18_BitcoinsSystemLendingRansom:
+		if state == nil {
+			return nil, nil
+		}
+		lend, e := state.BitcoinSystemLending(a.LendingID)
+		if e != nil || lend == nil {
+			return nil, e
+		}
+		return []fields.Address{lend.MainAddress}, nil
+	case *actions.Action_20_UsersLendingRansom:
+		if state == nil {
+			return nil, nil
+		}
+		lend, e := state.UserLending(a.LendingID)
+		if e != nil || lend == nil {
+			return nil, e
+		}
+		return []fields.Address{lend.MortgagorAddress, lend.LenderAddress}, nil
+	}
+	// channel close and challenge
+	if id, ok := events.ActionCloseChannelId(act); ok && state != nil {
+		paychan, e := state.Channel(id)
+		if e != nil || paychan == nil {
+			return nil, e
+		}
+		return []fields.Address{paychan.LeftAddress, paychan.RightAddress}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,326 @@
+package indexer
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/hacash/core/actions"
+	"github.com/hacash/core/events"
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/interfaces"
+	"github.com/hacash/core/stores"
+)
+
+/**
+ * Diamond provenance
+ * Mint, transfer, mortgage and redemption of every HACD in chain order
+ * No channel action moves diamonds, so channels never appear in the history
+ */
+
+type DiamondHistoryKind uint8
+
+const (
+	DiamondHistoryKindMint     DiamondHistoryKind = 1
+	DiamondHistoryKindTransfer DiamondHistoryKind = 2
+	DiamondHistoryKindMortgage DiamondHistoryKind = 3 // to system or user lending
+	DiamondHistoryKindRedeem   DiamondHistoryKind = 4 // released from lending
+)
+
+type DiamondHistoryEntry struct {
+	Kind        DiamondHistoryKind
+	BlockHeight uint64
+	BlockHash   fields.Hash
+	TxHash      fields.Hash
+	FromAddress fields.Address  // nil when mint
+	ToAddress   fields.Address  // owner after the action
+	Status      fields.VarUint1 // status after the action, see stores.DiamondStatusNormal
+	LendingId   []byte          // mortgage and redeem
+}
+
+type diamondEntryItem struct {
+	name  fields.DiamondName
+	entry *DiamondHistoryEntry
+}
+
+type DiamondHistoryIndexer struct {
+	state StateReader // lending diamond list and redemption, nil skips them
+
+	history map[string][]*DiamondHistoryEntry // diamond name => entries in chain order
+	numbers map[uint32]fields.DiamondName     // diamond number => name
+	owners  map[string]map[string]bool        // address => diamond names
+	blocks  map[string][]fields.DiamondName   // block hash => diamonds changed by the block
+	mints   map[string]map[uint32]bool        // block hash => diamond numbers minted
+	tip     fields.Hash
+
+	mux sync.RWMutex
+}
+
+func NewDiamondHistoryIndexer(state StateReader) *DiamondHistoryIndexer {
+	return &DiamondHistoryIndexer{
+		state:   state,
+		history: make(map[string][]*DiamondHistoryEntry),
+		numbers: make(map[uint32]fields.DiamondName),
+		owners:  make(map[string]map[string]bool),
+		blocks:  make(map[string][]fields.DiamondName),
+		mints:   make(map[string]map[uint32]bool),
+	}
+}
+
+// Entries of the diamond actions in the block
+func (x *DiamondHistoryIndexer) blockEntries(block interfaces.Block) ([]*diamondEntryItem, map[uint32]fields.DiamondName, error) {
+	var state interfaces.ChainStateOperationRead = nil
+	if x.state != nil {
+		state = x.state.StateRead()
+	}
+	items := make([]*diamondEntryItem, 0)
+	mints := make(map[uint32]fields.DiamondName)
+	add := func(names []fields.DiamondName, tmpl DiamondHistoryEntry) {
+		for _, name := range names {
+			entry := tmpl
+			items = append(items, &diamondEntryItem{name, &entry})
+		}
+	}
+	height := block.GetHeight()
+	hash := block.Hash()
+	for _, tx := range block.GetTrsList() {
+		if tx.Type() == 0 {
+			continue // coinbase
+		}
+		base := DiamondHistoryEntry{
+			BlockHeight: height,
+			BlockHash:   hash,
+			TxHash:      tx.Hash(),
+			Status:      stores.DiamondStatusNormal,
+		}
+		for _, act := range tx.GetActionList() {
+			entry := base
+			switch a := act.(type) {
+			case *actions.Action_4_DiamondCreate:
+				entry.Kind = DiamondHistoryKindMint
+				entry.ToAddress = a.Address
+				add([]fields.DiamondName{a.Diamond}, entry)
+				mints[uint32(a.Number)] = a.Diamond
+			case *actions.Action_5_DiamondTransfer:
+				entry.Kind = DiamondHistoryKindTransfer
+				entry.FromAddress = tx.GetAddress()
+				entry.ToAddress = a.ToAddress
+				add([]fields.DiamondName{a.Diamond}, entry)
+			case *actions.Action_6_OutfeeQuantityDiamondTransfer:
+				entry.Kind = DiamondHistoryKindTransfer
+				entry.FromAddress = a.FromAddress
+				entry.ToAddress = a.ToAddress
+				add(a.DiamondList.Diamonds, entry)
+			case *actions.Action_15_DiamondsSystemLendingCreate:
+				entry.Kind = DiamondHistoryKindMortgage
+				entry.FromAddress = tx.GetAddress()
+				entry.ToAddress = tx.GetAddress()
+				entry.Status = stores.DiamondStatusLendingSystem
+				entry.LendingId = a.LendingID
+				add(a.MortgageDiamondList.Diamonds, entry)
+			case *actions.Action_19_UsersLendingCreate:
+				entry.Kind = DiamondHistoryKindMortgage
+				entry.FromAddress = a.MortgagorAddress
+				entry.ToAddress = a.MortgagorAddress
+				entry.Status = stores.DiamondStatusLendingOtherUser
+				entry.LendingId = a.LendingID
+				add(a.MortgageDiamondList.Diamonds, entry)
+			case *actions.Action_16_DiamondsSystemLendingRansom:
+				if state == nil {
+					continue
+				}
+				lend, e := state.DiamondSystemLending(a.LendingID)
+				if e != nil {
+					return nil, nil, e
+				}
+				if lend == nil {
+					continue
+				}
+				entry.Kind = DiamondHistoryKindRedeem
+				entry.FromAddress = lend.MainAddress
+				entry.ToAddress = tx.GetAddress() // the redeemer gets the diamonds
+				entry.LendingId = a.LendingID
+				add(lend.MortgageDiamondList.Diamonds, entry)
+			case *actions.Action_20_UsersLendingRansom:
+				if state == nil {
+					continue
+				}
+				lend, e := state.UserLending(a.LendingID)
+				if e != nil {
+					return nil, nil, e
+				}
+				if lend == nil {
+					continue
+				}
+				entry.Kind = DiamondHistoryKindRedeem
+				entry.FromAddress = lend.MortgagorAddress
+				entry.ToAddress = tx.GetAddress()
+				entry.LendingId = a.LendingID
+				add(lend.MortgageDiamondList.Diamonds, entry)
+			}
+		}
+	}
+	return items, mints, nil
+}
+
+func (x *DiamondHistoryIndexer) setOwner(name string, from, to fields.Address) {
+	if from != nil {
+		if names, ok := x.owners[string(from)]; ok {
+			delete(names, name)
+			if len(names) == 0 {
+				delete(x.owners, string(from))
+			}
+		}
+	}
+	if to != nil {
+		names, ok := x.owners[string(to)]
+		if !ok {
+			names = make(map[string]bool)
+			x.owners[string(to)] = names
+		}
+		names[name] = true
+	}
+}
+
+func (x *DiamondHistoryIndexer) ConnectBlock(block interfaces.Block) error {
+	x.mux.Lock()
+	defer x.mux.Unlock()
+
+	hash := block.Hash()
+	if _, ok := x.blocks[string(hash)]; ok {
+		return fmt.Errorf("block %d <%s> already indexed.", block.GetHeight(), hash.ToHex())
+	}
+	if x.tip != nil && !block.GetPrevHash().Equal(x.tip) {
+		return fmt.Errorf("block %d prev hash <%s> not the indexed tip <%s>.", block.GetHeight(), block.GetPrevHash().ToHex(), x.tip.ToHex())
+	}
+	items, mints, e := x.blockEntries(block)
+	if e != nil {
+		return e
+	}
+	names := make([]fields.DiamondName, 0, len(items))
+	for _, item := range items {
+		k := string(item.name)
+		var owner fields.Address = nil
+		if list := x.history[k]; len(list) > 0 {
+			owner = list[len(list)-1].ToAddress
+		} else {
+			owner = item.entry.FromAddress // history starts after the index base
+		}
+		x.history[k] = append(x.history[k], item.entry)
+		x.setOwner(k, owner, item.entry.ToAddress)
+		names = append(names, item.name)
+	}
+	numbers := make(map[uint32]bool)
+	for num, name := range mints {
+		x.numbers[num] = name
+		numbers[num] = true
+	}
+	x.blocks[string(hash)] = names
+	x.mints[string(hash)] = numbers
+	x.tip = hash
+	return nil
+}
+
+// Remove the entries of the block, it must be the latest indexed block
+func (x *DiamondHistoryIndexer) DisconnectBlock(block interfaces.Block) error {
+	x.mux.Lock()
+	defer x.mux.Unlock()
+
+	hash := block.Hash()
+	names, ok := x.blocks[string(hash)]
+	if !ok {
+		return fmt.Errorf("block %d <%s> not indexed.", block.GetHeight(), hash.ToHex())
+	}
+	if !hash.Equal(x.tip) {
+		return fmt.Errorf("block %d <%s> not the indexed tip.", block.GetHeight(), hash.ToHex())
+	}
+	for i := len(names) - 1; i >= 0; i-- {
+		k := string(names[i])
+		list := x.history[k]
+		last := len(list) - 1
+		if last < 0 || !list[last].BlockHash.Equal(hash) {
+			return fmt.Errorf("diamond history of block %d broken.", block.GetHeight())
+		}
+		entry := list[last]
+		if last == 0 {
+			delete(x.history, k)
+			x.setOwner(k, entry.ToAddress, entry.FromAddress)
+		} else {
+			x.history[k] = list[:last]
+			x.setOwner(k, entry.ToAddress, list[last-1].ToAddress)
+		}
+	}
+	for num := range x.mints[string(hash)] {
+		delete(x.numbers, num)
+	}
+	delete(x.blocks, string(hash))
+	delete(x.mints, string(hash))
+	x.tip = block.GetPrevHash()
+	return nil
+}
+
+/**************************** query ****************************/
+
+// Entries in chain order, nil if not indexed
+func (x *DiamondHistoryIndexer) GetDiamondHistory(name fields.DiamondName) []*DiamondHistoryEntry {
+	x.mux.RLock()
+	defer x.mux.RUnlock()
+
+	list := x.history[string(name)]
+	if list == nil {
+		return nil
+	}
+	return append([]*DiamondHistoryEntry{}, list...)
+}
+
+func (x *DiamondHistoryIndexer) GetDiamondHistoryByNumber(number uint32) (fields.DiamondName, []*DiamondHistoryEntry) {
+	x.mux.RLock()
+	name, ok := x.numbers[number]
+	x.mux.RUnlock()
+	if !ok {
+		return nil, nil
+	}
+	return name, x.GetDiamondHistory(name)
+}
+
+// Diamonds the address owns now, mortgaged diamonds included, sorted by name
+func (x *DiamondHistoryIndexer) GetDiamondsByOwner(addr fields.Address) []fields.DiamondName {
+	x.mux.RLock()
+	defer x.mux.RUnlock()
+
+	names := make([]string, 0, len(x.owners[string(addr)]))
+	for name := range x.owners[string(addr)] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	res := make([]fields.DiamondName, len(names))
+	for i, name := range names {
+		res[i] = fields.DiamondName(name)
+	}
+	return res
+}
+
+/**************************** events ****************************/
+
+func (x *DiamondHistoryIndexer) HandleEvent(ev events.Event) error {
+	switch e := ev.(type) {
+	case events.BlockConnected:
+		return x.ConnectBlock(e.Block)
+	case events.BlockDisconnected:
+		return x.DisconnectBlock(e.Block)
+	}
+	return nil
+}
+
+// Subscribe the block events of the bus and index them, until unsubscribe
+func (x *DiamondHistoryIndexer) Run(bus *events.Bus, onerr func(error)) *events.Subscription {
+	sub := bus.Subscribe(64, events.FullPolicyBlock, events.FilterTypes(events.EventTypeBlockConnected, events.EventTypeBlockDisconnected))
+	go func() {
+		for ev := range sub.Chan() {
+			if e := x.HandleEvent(ev); e != nil && onerr != nil {
+				onerr(e)
+			}
+		}
+	}()
+	return sub
+}
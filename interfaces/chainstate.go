@@ -40,3 +40,9 @@ type ChainStateImmutable interface {
 
 	Close() // Close file handle, etc
 }
+
+// Optional for the immutable state and the chain engine, type assert to use it
+type BlockRollbacker interface {
+	// Undo blocks down to the height, return the height after rollback
+	RollbackToBlockHeight(uint64) (uint64, error)
+}
@@ -0,0 +1,71 @@
+package testchain
+
+import (
+	"github.com/hacash/core/account"
+	"github.com/hacash/core/blocks"
+	"github.com/hacash/core/coinbase"
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/interfaces"
+	"github.com/hacash/core/interfacev2"
+	"github.com/hacash/core/transactions"
+)
+
+/**
+ * Blocks and txs built the same way for the tests of the chain packages
+ * Only imported by _test.go files, the blocks are not mined and the difficulty is not checked
+ */
+
+// Next block of prev 300 seconds later, the coinbase pays the reward of the height to the miner
+func NewBlock(prev interfaces.BlockHeadMetaRead, miner fields.Address, txs ...interfaces.Transaction) *blocks.Block_v1 {
+	blk := blocks.NewEmptyBlockVersion1(prev)
+	if prev != nil {
+		blk.Timestamp = fields.BlockTxTimestamp(prev.GetTimestamp() + 300)
+	}
+	fillBlock(blk, miner, txs)
+	return blk
+}
+
+// Block at height without the prev block
+func NewBlockAtHeight(height uint64, miner fields.Address, txs ...interfaces.Transaction) *blocks.Block_v1 {
+	blk := blocks.NewEmptyBlockV1()
+	blk.Height = fields.BlockHeight(height)
+	fillBlock(blk, miner, txs)
+	return blk
+}
+
+// Coinbase of the version of the height, the txs and the mrkl root
+// The coinbase keeps its default address if miner is nil
+func fillBlock(blk *blocks.Block_v1, miner fields.Address, txs []interfaces.Transaction) {
+	height := blk.GetHeight()
+	cbtx := transactions.NewTransaction_0_CoinbaseV0()
+	if height >= coinbase.ExtendDataVersion1BlockHeight {
+		cbtx = transactions.NewTransaction_0_CoinbaseV1()
+	}
+	if miner != nil {
+		cbtx.Address = miner
+	}
+	cbtx.Reward = *coinbase.BlockCoinBaseReward(height)
+	blk.AddTrs(cbtx)
+	for _, tx := range txs {
+		blk.AddTrs(tx)
+	}
+	blk.SetMrklRoot(blocks.CalculateMrklRoot(blk.GetTrsList()))
+}
+
+// Type 2 tx of from at the timestamp with fee 1:246, signed by the accounts if any
+func NewTx(ts uint64, from fields.Address, signs []*account.Account, acts ...interfacev2.Action) *transactions.Transaction_2_Simple {
+	tx, _ := transactions.NewEmptyTransaction_2_Simple(from)
+	tx.Timestamp = fields.BlockTxTimestamp(ts)
+	tx.Fee = *fields.NewAmountSmall(1, 246)
+	for _, act := range acts {
+		tx.AppendAction(act)
+	}
+	if len(signs) > 0 {
+		prikeys := make(map[string][]byte)
+		for _, acc := range signs {
+			prikeys[string(acc.Address)] = acc.PrivateKey
+		}
+		tx.FillNeedSigns(prikeys, nil)
+	}
+	return tx
+}
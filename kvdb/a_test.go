@@ -0,0 +1,118 @@
+package kvdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var _ Database = &MemoryDB{}
+var _ Database = &FileDB{}
+
+func checkDatabase(t *testing.T, db Database) {
+	db.Put([]byte("a2"), []byte("v2"))
+	db.Put([]byte("a1"), []byte("v1"))
+	db.Put([]byte("b1"), []byte("x"))
+	if v, _ := db.Get([]byte("a1")); string(v) != "v1" {
+		t.Fatal("get error")
+	}
+	if v, e := db.Get([]byte("zz")); v != nil || e != nil {
+		t.Fatal("not exist must be nil")
+	}
+
+	snap, e := db.Snapshot()
+	if e != nil {
+		t.Fatal(e)
+	}
+	batch := NewBatch()
+	batch.Put([]byte("a3"), []byte("v3"))
+	batch.Delete([]byte("a1"))
+	if e := db.Write(batch); e != nil {
+		t.Fatal(e)
+	}
+
+	keys := ""
+	db.Iterate([]byte("a"), func(key []byte, value []byte) bool {
+		keys += string(key) + "=" + string(value) + ","
+		return true
+	})
+	if keys != "a2=v2,a3=v3," {
+		t.Fatal("iterate error", keys)
+	}
+	// snapshot keeps the old view
+	if v, _ := snap.Get([]byte("a1")); string(v) != "v1" {
+		t.Fatal("snapshot changed")
+	}
+	if v, _ := snap.Get([]byte("a3")); v != nil {
+		t.Fatal("snapshot see new item")
+	}
+	snap.Release()
+
+	table := NewTable(db, "b")
+	if v, _ := table.Get([]byte("1")); string(v) != "x" {
+		t.Fatal("table error")
+	}
+}
+
+func Test_memory(t *testing.T) {
+	checkDatabase(t, NewMemoryDB())
+}
+
+func Test_file(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "kvdb")
+	defer os.RemoveAll(dir)
+
+	db, e := OpenFileDB(dir)
+	if e != nil {
+		t.Fatal(e)
+	}
+	db.SetNoSync(true)
+	checkDatabase(t, db)
+	db.Close()
+
+	// reopen and cut the unfinished tail
+	logfile := filepath.Join(dir, fileDBLogName)
+	body, _ := ioutil.ReadFile(logfile)
+	ioutil.WriteFile(logfile, append(body, 0, 0, 0, 9, 1, 2), 0666)
+	db, e = OpenFileDB(dir)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if db.RepairedBytes() != 6 {
+		t.Fatal("tail not cut", db.RepairedBytes())
+	}
+	if v, _ := db.Get([]byte("a3")); string(v) != "v3" {
+		t.Fatal("reopen error")
+	}
+	if v, _ := db.Get([]byte("a1")); v != nil {
+		t.Fatal("deleted item back")
+	}
+
+	// compact
+	snap, _ := db.Snapshot()
+	if _, e := db.Compact(); e == nil {
+		t.Fatal("must wait snapshot release")
+	}
+	snap.Release()
+	saved, e := db.Compact()
+	if e != nil || saved <= 0 || db.GarbageBytes() != 0 {
+		t.Fatal("compact error", e, saved)
+	}
+	if v, _ := db.Get([]byte("b1")); string(v) != "x" {
+		t.Fatal("compact lost item")
+	}
+	db.Put([]byte("c1"), []byte("y"))
+	db.Close()
+
+	// a broken record in the middle is not cut with the records after it
+	body, _ = ioutil.ReadFile(logfile)
+	body[fileDBRecordHeadSize+1] ^= 0xff
+	ioutil.WriteFile(logfile, body, 0666)
+	if _, e := OpenFileDB(dir); e == nil {
+		t.Fatal("broken record in the middle must fail")
+	}
+	if stat, _ := os.Stat(logfile); stat.Size() != int64(len(body)) {
+		t.Fatal("records after the broken one are cut")
+	}
+}
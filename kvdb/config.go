@@ -0,0 +1,34 @@
+package kvdb
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/hacash/core/sys"
+)
+
+// Backend names for the config
+//
+//	[database]
+//	backend = file
+const (
+	BackendMemory = "memory"
+	BackendFile   = "file"
+)
+
+// Open the database of the name in the versioned data dir with the backend of the config
+func OpenByInicnf(cnf *sys.Inicnf, name string) (Database, error) {
+	backend := cnf.Section("database").Key("backend").MustString(BackendFile)
+	switch backend {
+	case BackendMemory:
+		return NewMemoryDB(), nil
+	case BackendFile:
+		db, e := OpenFileDB(filepath.Join(cnf.MustDataDirWithVersion(), name))
+		if e != nil {
+			return nil, e
+		}
+		db.SetNoSync(cnf.Section("database").Key("no_sync").MustBool(false))
+		return db, nil
+	}
+	return nil, fmt.Errorf("kvdb backend <%s> not support.", backend)
+}
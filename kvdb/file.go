@@ -0,0 +1,472 @@
+package kvdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+/**
+ * Embedded file database
+ * Every write appends one record of the whole batch to a log file, keys and value offsets are indexed in memory
+ * record: body size (4) + crc32 of body (4) + body
+ * body:   item count (uvarint) + items, item: kind (1) + key size (uvarint) + key [+ value size (uvarint) + value]
+ * A broken record at the tail is the unfinished write of a crash, it is cut when open
+ * A broken record with valid records after it is damage of the file, open fails instead of dropping them
+ */
+
+const (
+	fileDBLogName     = "kvdb.log"
+	fileDBCompactName = "kvdb.log.compact"
+
+	fileDBItemPut    byte = 1
+	fileDBItemDelete byte = 2
+
+	fileDBRecordHeadSize = 8
+	fileDBCompactBatch   = 4096
+)
+
+type fileValueRef struct {
+	offset int64
+	size   uint32
+}
+
+type FileDB struct {
+	dir     string
+	file    *os.File
+	size    int64 // end of the valid records
+	index   map[string]fileValueRef
+	garbage int64 // bytes of values overwritten or deleted
+	cut     int64 // bytes of the broken tail cut when open
+
+	snapshots int  // compact waits until all snapshots are released
+	noSync    bool // skip fsync after each write
+	closed    bool
+
+	mux sync.RWMutex
+}
+
+// Open or create the database in the dir
+func OpenFileDB(dir string) (*FileDB, error) {
+	if e := os.MkdirAll(dir, 0777); e != nil {
+		return nil, e
+	}
+	db := &FileDB{
+		dir: dir,
+	}
+	if e := db.open(); e != nil {
+		return nil, e
+	}
+	return db, nil
+}
+
+// Do not fsync after each write, faster but the latest writes may lost on power off
+func (db *FileDB) SetNoSync(set bool) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	db.noSync = set
+}
+
+// Bytes of the broken tail cut when open
+func (db *FileDB) RepairedBytes() int64 {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	return db.cut
+}
+
+// Bytes of old values in the log, compact when it grows large
+func (db *FileDB) GarbageBytes() int64 {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	return db.garbage
+}
+
+func (db *FileDB) open() error {
+	file, e := os.OpenFile(filepath.Join(db.dir, fileDBLogName), os.O_RDWR|os.O_CREATE, 0666)
+	if e != nil {
+		return e
+	}
+	db.file = file
+	db.index = make(map[string]fileValueRef)
+	db.size = 0
+	db.garbage = 0
+	stat, e := file.Stat()
+	if e != nil {
+		return e
+	}
+	head := make([]byte, fileDBRecordHeadSize)
+	for db.size < stat.Size() {
+		if _, e := file.ReadAt(head, db.size); e != nil {
+			break // broken head
+		}
+		bodysize := int64(binary.BigEndian.Uint32(head[0:4]))
+		if db.size+fileDBRecordHeadSize+bodysize > stat.Size() {
+			break // unfinished body
+		}
+		body := make([]byte, bodysize)
+		if _, e := file.ReadAt(body, db.size+fileDBRecordHeadSize); e != nil {
+			break
+		}
+		if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(head[4:8]) {
+			break
+		}
+		if e := db.applyRecord(body, db.size+fileDBRecordHeadSize); e != nil {
+			break
+		}
+		db.size += fileDBRecordHeadSize + bodysize
+	}
+	if db.size < stat.Size() {
+		// only the last record can be an unfinished write
+		after, e := findRecordAfter(file, db.size, stat.Size())
+		if e != nil {
+			return e
+		}
+		if after > 0 {
+			return fmt.Errorf("kvdb log broken at %d but a valid record is at %d.", db.size, after)
+		}
+		db.cut = stat.Size() - db.size
+		if e := file.Truncate(db.size); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Offset of the first valid record after the broken one at start, 0 if not find
+func findRecordAfter(file *os.File, start int64, end int64) (int64, error) {
+	rest := make([]byte, end-start)
+	if _, e := file.ReadAt(rest, start); e != nil {
+		return 0, e
+	}
+	for i := 1; i+fileDBRecordHeadSize < len(rest); i++ {
+		bodysize := int(binary.BigEndian.Uint32(rest[i : i+4]))
+		if bodysize == 0 || i+fileDBRecordHeadSize+bodysize > len(rest) {
+			continue // empty batches are never written
+		}
+		body := rest[i+fileDBRecordHeadSize : i+fileDBRecordHeadSize+bodysize]
+		if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(rest[i+4:i+8]) {
+			continue
+		}
+		if _, e := parseRecordBody(body); e == nil {
+			return start + int64(i), nil
+		}
+	}
+	return 0, nil
+}
+
+// Index the items of the record body at the offset of the file
+func (db *FileDB) applyRecord(body []byte, offset int64) error {
+	items, e := parseRecordBody(body)
+	if e != nil {
+		return e
+	}
+	for _, item := range items {
+		if old, ok := db.index[string(item.key)]; ok {
+			db.garbage += int64(old.size)
+		}
+		if item.delete {
+			delete(db.index, string(item.key))
+		} else {
+			db.index[string(item.key)] = fileValueRef{offset + item.valueOffset, uint32(len(item.value))}
+		}
+	}
+	return nil
+}
+
+type recordItem struct {
+	batchItem
+	valueOffset int64 // in the body
+}
+
+func readUvarint(body []byte, seek *int) (uint64, error) {
+	num, n := binary.Uvarint(body[*seek:])
+	if n <= 0 {
+		return 0, fmt.Errorf("kvdb record varint error.")
+	}
+	*seek += n
+	return num, nil
+}
+
+func parseRecordBody(body []byte) ([]*recordItem, error) {
+	seek := 0
+	count, e := readUvarint(body, &seek)
+	if e != nil {
+		return nil, e
+	}
+	items := make([]*recordItem, 0, count)
+	for i := uint64(0); i < count; i++ {
+		if seek >= len(body) {
+			return nil, fmt.Errorf("kvdb record item %d out of range.", i)
+		}
+		item := &recordItem{}
+		kind := body[seek]
+		seek++
+		klen, e := readUvarint(body, &seek)
+		if e != nil {
+			return nil, e
+		}
+		if uint64(seek)+klen > uint64(len(body)) {
+			return nil, fmt.Errorf("kvdb record key out of range.")
+		}
+		item.key = body[seek : seek+int(klen)]
+		seek += int(klen)
+		switch kind {
+		case fileDBItemPut:
+			vlen, e := readUvarint(body, &seek)
+			if e != nil {
+				return nil, e
+			}
+			if uint64(seek)+vlen > uint64(len(body)) {
+				return nil, fmt.Errorf("kvdb record value out of range.")
+			}
+			item.value = body[seek : seek+int(vlen)]
+			item.valueOffset = int64(seek)
+			seek += int(vlen)
+		case fileDBItemDelete:
+			item.delete = true
+		default:
+			return nil, fmt.Errorf("kvdb record item kind %d error.", kind)
+		}
+		items = append(items, item)
+	}
+	if seek != len(body) {
+		return nil, fmt.Errorf("kvdb record has %d extra bytes.", len(body)-seek)
+	}
+	return items, nil
+}
+
+func appendUvarint(buf []byte, num uint64) []byte {
+	tmp := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(tmp, num)
+	return append(buf, tmp[:n]...)
+}
+
+func serializeRecord(items []*batchItem) []byte {
+	body := make([]byte, 0)
+	body = appendUvarint(body, uint64(len(items)))
+	for _, item := range items {
+		if item.delete {
+			body = append(body, fileDBItemDelete)
+		} else {
+			body = append(body, fileDBItemPut)
+		}
+		body = appendUvarint(body, uint64(len(item.key)))
+		body = append(body, item.key...)
+		if !item.delete {
+			body = appendUvarint(body, uint64(len(item.value)))
+			body = append(body, item.value...)
+		}
+	}
+	record := make([]byte, fileDBRecordHeadSize, fileDBRecordHeadSize+len(body))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(body)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(body))
+	return append(record, body...)
+}
+
+/**************************** read write ****************************/
+
+func readValue(file *os.File, ref fileValueRef) ([]byte, error) {
+	value := make([]byte, ref.size)
+	if _, e := file.ReadAt(value, ref.offset); e != nil && e != io.EOF {
+		return nil, e
+	}
+	return value, nil
+}
+
+func (db *FileDB) Get(key []byte) ([]byte, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	if db.closed {
+		return nil, fmt.Errorf("kvdb closed.")
+	}
+	ref, ok := db.index[string(key)]
+	if !ok {
+		return nil, nil // not find
+	}
+	return readValue(db.file, ref)
+}
+
+func (db *FileDB) Put(key []byte, value []byte) error {
+	batch := NewBatch()
+	batch.Put(key, value)
+	return db.Write(batch)
+}
+
+func (db *FileDB) Delete(key []byte) error {
+	batch := NewBatch()
+	batch.Delete(key)
+	return db.Write(batch)
+}
+
+func (db *FileDB) Write(batch *Batch) error {
+	if batch.Len() == 0 {
+		return nil
+	}
+	record := serializeRecord(batch.items)
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	if db.closed {
+		return fmt.Errorf("kvdb closed.")
+	}
+	if _, e := db.file.WriteAt(record, db.size); e != nil {
+		db.file.Truncate(db.size) // drop the part written
+		return e
+	}
+	if !db.noSync {
+		if e := db.file.Sync(); e != nil {
+			return e
+		}
+	}
+	if e := db.applyRecord(record[fileDBRecordHeadSize:], db.size+fileDBRecordHeadSize); e != nil {
+		return e
+	}
+	db.size += int64(len(record))
+	return nil
+}
+
+func (db *FileDB) Iterate(prefix []byte, fn func(key []byte, value []byte) bool) error {
+	snap, e := db.Snapshot()
+	if e != nil {
+		return e
+	}
+	defer snap.Release()
+	return snap.Iterate(prefix, fn)
+}
+
+// Copy of the index, the log is append only so the values stay in place
+func (db *FileDB) Snapshot() (Snapshot, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	if db.closed {
+		return nil, fmt.Errorf("kvdb closed.")
+	}
+	index := make(map[string]fileValueRef, len(db.index))
+	for k, v := range db.index {
+		index[k] = v
+	}
+	db.snapshots++
+	return &fileSnapshot{db: db, file: db.file, index: index}, nil
+}
+
+// Rewrite the live items into a new log, return the bytes saved
+func (db *FileDB) Compact() (int64, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	if db.closed {
+		return 0, fmt.Errorf("kvdb closed.")
+	}
+	if db.snapshots > 0 {
+		return 0, fmt.Errorf("kvdb has %d snapshots not released.", db.snapshots)
+	}
+	keys := make([]string, 0, len(db.index))
+	for k := range db.index {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	tmppath := filepath.Join(db.dir, fileDBCompactName)
+	tmp, e := os.OpenFile(tmppath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if e != nil {
+		return 0, e
+	}
+	items := make([]*batchItem, 0, fileDBCompactBatch)
+	flush := func() error {
+		if len(items) == 0 {
+			return nil
+		}
+		_, e := tmp.Write(serializeRecord(items))
+		items = items[:0]
+		return e
+	}
+	for _, k := range keys {
+		value, e := readValue(db.file, db.index[k])
+		if e == nil {
+			items = append(items, &batchItem{key: []byte(k), value: value})
+			if len(items) >= fileDBCompactBatch {
+				e = flush()
+			}
+		}
+		if e != nil {
+			tmp.Close()
+			os.Remove(tmppath)
+			return 0, e
+		}
+	}
+	if e := flush(); e != nil {
+		tmp.Close()
+		os.Remove(tmppath)
+		return 0, e
+	}
+	if e := tmp.Sync(); e != nil {
+		tmp.Close()
+		return 0, e
+	}
+	tmp.Close()
+	oldsize := db.size
+	db.file.Close()
+	if e := os.Rename(tmppath, filepath.Join(db.dir, fileDBLogName)); e != nil {
+		return 0, e
+	}
+	if e := db.open(); e != nil {
+		db.closed = true
+		return 0, e
+	}
+	return oldsize - db.size, nil
+}
+
+func (db *FileDB) Close() error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	if db.closed {
+		return nil
+	}
+	db.closed = true
+	return db.file.Close()
+}
+
+type fileSnapshot struct {
+	db    *FileDB
+	file  *os.File
+	index map[string]fileValueRef
+	once  sync.Once
+}
+
+func (s *fileSnapshot) Get(key []byte) ([]byte, error) {
+	ref, ok := s.index[string(key)]
+	if !ok {
+		return nil, nil
+	}
+	return readValue(s.file, ref)
+}
+
+func (s *fileSnapshot) Iterate(prefix []byte, fn func(key []byte, value []byte) bool) error {
+	keys := make([]string, 0)
+	for k := range s.index {
+		if hasPrefix([]byte(k), prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		value, e := readValue(s.file, s.index[k])
+		if e != nil {
+			return e
+		}
+		if !fn([]byte(k), value) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *fileSnapshot) Release() {
+	s.once.Do(func() {
+		s.db.mux.Lock()
+		s.db.snapshots--
+		s.db.mux.Unlock()
+	})
+}
@@ -0,0 +1,133 @@
+package kvdb
+
+import (
+	"bytes"
+)
+
+/**
+ * Key value database
+ * Storage backend of the chain state and the block store, the backend is selected by config
+ * Keys are read in byte order, a nil value from Get means not exist
+ */
+
+type Reader interface {
+	Get(key []byte) ([]byte, error)
+	// All items with the prefix in key order, stop when fn returns false
+	Iterate(prefix []byte, fn func(key []byte, value []byte) bool) error
+}
+
+type Writer interface {
+	Put(key []byte, value []byte) error
+	Delete(key []byte) error
+}
+
+// Read only view of the data at the time it is taken
+type Snapshot interface {
+	Reader
+	Release()
+}
+
+type Database interface {
+	Reader
+	Writer
+
+	// Write all items of the batch or none of them
+	Write(batch *Batch) error
+	Snapshot() (Snapshot, error)
+	Close() error
+}
+
+/**************************** batch ****************************/
+
+type batchItem struct {
+	key    []byte
+	value  []byte
+	delete bool
+}
+
+// Changes written together by Database.Write
+type Batch struct {
+	items []*batchItem
+}
+
+func NewBatch() *Batch {
+	return &Batch{
+		items: make([]*batchItem, 0),
+	}
+}
+
+func (b *Batch) Put(key []byte, value []byte) {
+	b.items = append(b.items, &batchItem{
+		key:   append([]byte{}, key...),
+		value: append([]byte{}, value...),
+	})
+}
+
+func (b *Batch) Delete(key []byte) {
+	b.items = append(b.items, &batchItem{
+		key:    append([]byte{}, key...),
+		delete: true,
+	})
+}
+
+func (b *Batch) Len() int {
+	return len(b.items)
+}
+
+func (b *Batch) Reset() {
+	b.items = b.items[:0]
+}
+
+/**************************** table ****************************/
+
+// Key prefix namespace of one store type in the database
+type Table struct {
+	db     Database
+	prefix []byte
+}
+
+func NewTable(db Database, prefix string) *Table {
+	return &Table{
+		db:     db,
+		prefix: []byte(prefix),
+	}
+}
+
+func (t *Table) Key(key []byte) []byte {
+	return append(append([]byte{}, t.prefix...), key...)
+}
+
+func (t *Table) Database() Database {
+	return t.db
+}
+
+func (t *Table) Get(key []byte) ([]byte, error) {
+	return t.db.Get(t.Key(key))
+}
+
+func (t *Table) Put(key []byte, value []byte) error {
+	return t.db.Put(t.Key(key), value)
+}
+
+func (t *Table) Delete(key []byte) error {
+	return t.db.Delete(t.Key(key))
+}
+
+// Keys without the table prefix
+func (t *Table) Iterate(prefix []byte, fn func(key []byte, value []byte) bool) error {
+	return t.db.Iterate(t.Key(prefix), func(key []byte, value []byte) bool {
+		return fn(key[len(t.prefix):], value)
+	})
+}
+
+func (t *Table) BatchPut(batch *Batch, key []byte, value []byte) {
+	batch.Put(t.Key(key), value)
+}
+
+func (t *Table) BatchDelete(batch *Batch, key []byte) {
+	batch.Delete(t.Key(key))
+}
+
+func hasPrefix(key []byte, prefix []byte) bool {
+	return bytes.HasPrefix(key, prefix)
+}
@@ -0,0 +1,129 @@
+package kvdb
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Database that keeps everything in memory, for tests and temporary nodes
+type MemoryDB struct {
+	datas  map[string][]byte
+	closed bool
+
+	mux sync.RWMutex
+}
+
+func NewMemoryDB() *MemoryDB {
+	return &MemoryDB{
+		datas: make(map[string][]byte),
+	}
+}
+
+func (m *MemoryDB) Get(key []byte) ([]byte, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	if m.closed {
+		return nil, fmt.Errorf("kvdb closed.")
+	}
+	value, ok := m.datas[string(key)]
+	if !ok {
+		return nil, nil // not find
+	}
+	return append([]byte{}, value...), nil
+}
+
+func (m *MemoryDB) Put(key []byte, value []byte) error {
+	batch := NewBatch()
+	batch.Put(key, value)
+	return m.Write(batch)
+}
+
+func (m *MemoryDB) Delete(key []byte) error {
+	batch := NewBatch()
+	batch.Delete(key)
+	return m.Write(batch)
+}
+
+func (m *MemoryDB) Write(batch *Batch) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.closed {
+		return fmt.Errorf("kvdb closed.")
+	}
+	for _, item := range batch.items {
+		if item.delete {
+			delete(m.datas, string(item.key))
+		} else {
+			m.datas[string(item.key)] = append([]byte{}, item.value...)
+		}
+	}
+	return nil
+}
+
+func iterateMap(datas map[string][]byte, prefix []byte, fn func(key []byte, value []byte) bool) {
+	keys := make([]string, 0)
+	for k := range datas {
+		if hasPrefix([]byte(k), prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !fn([]byte(k), append([]byte{}, datas[k]...)) {
+			return
+		}
+	}
+}
+
+func (m *MemoryDB) Iterate(prefix []byte, fn func(key []byte, value []byte) bool) error {
+	snap, e := m.Snapshot()
+	if e != nil {
+		return e
+	}
+	defer snap.Release()
+	return snap.Iterate(prefix, fn)
+}
+
+// Copy of all data, the cost grows with the size of the database
+func (m *MemoryDB) Snapshot() (Snapshot, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	if m.closed {
+		return nil, fmt.Errorf("kvdb closed.")
+	}
+	datas := make(map[string][]byte, len(m.datas))
+	for k, v := range m.datas {
+		datas[k] = v // values are never changed in place
+	}
+	return &memorySnapshot{datas}, nil
+}
+
+func (m *MemoryDB) Close() error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.closed = true
+	m.datas = make(map[string][]byte)
+	return nil
+}
+
+type memorySnapshot struct {
+	datas map[string][]byte
+}
+
+func (s *memorySnapshot) Get(key []byte) ([]byte, error) {
+	value, ok := s.datas[string(key)]
+	if !ok {
+		return nil, nil
+	}
+	return append([]byte{}, value...), nil
+}
+
+func (s *memorySnapshot) Iterate(prefix []byte, fn func(key []byte, value []byte) bool) error {
+	iterateMap(s.datas, prefix, fn)
+	return nil
+}
+
+func (s *memorySnapshot) Release() {
+	s.datas = nil
+}
@@ -0,0 +1,174 @@
+package lightclient
+
+import (
+	"fmt"
+	"github.com/hacash/core/blocks"
+	"github.com/hacash/core/difficulty"
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/interfaces"
+	"math/big"
+	"testing"
+)
+
+// Any hash is valid, difficulty never changes
+type testRules struct{}
+
+func (testRules) CheckProofOfWork(hash fields.Hash, difficulty uint32) bool {
+	return true
+}
+func (testRules) NextDifficulty(prev interfaces.BlockHeadMetaRead, headerByHeight func(uint64) interfaces.BlockHeadMetaRead) (uint32, error) {
+	return prev.GetDifficulty(), nil
+}
+func (testRules) CalculateWork(difficulty uint32) *big.Int {
+	return big.NewInt(1)
+}
+
+func nextHeader(prev interfaces.Block, nonce uint32) *blocks.Block_v1 {
+	blk := blocks.NewEmptyBlockVersion1(prev)
+	blk.Timestamp = fields.BlockTxTimestamp(prev.GetTimestamp() + 300)
+	blk.Nonce = fields.VarUint4(nonce)
+	return blk
+}
+
+func Test_header_chain(t *testing.T) {
+
+	base := blocks.NewEmptyBlockV1()
+	base.Timestamp = 1549250700
+	base.Difficulty = 1234
+	chain := NewHeaderChain(base, testRules{})
+	chain.NowTimestamp = func() uint64 { return 1549250700 + 3000 }
+
+	h1 := nextHeader(base, 1)
+	h2 := nextHeader(h1, 1)
+	h3 := nextHeader(h2, 1)
+	for _, h := range []*blocks.Block_v1{h1, h2, h3} {
+		if ok, e := chain.AddHeader(h); !ok || e != nil {
+			t.Fatal(ok, e)
+		}
+	}
+	// fork from h1, longer
+	f2 := nextHeader(h1, 2)
+	f3 := nextHeader(f2, 2)
+	f4 := nextHeader(f3, 2)
+	if ok, _ := chain.AddHeader(f2); ok {
+		t.Fatal("side chain must not be best")
+	}
+	chain.AddHeader(f3)
+	if ok, e := chain.AddHeader(f4); !ok || e != nil {
+		t.Fatal("reorg failed", e)
+	}
+	if chain.BestHeight() != 4 || !chain.HeaderByHeight(2).Hash().Equal(f2.Hash()) {
+		t.Fatal("best chain error")
+	}
+	if chain.IsInBestChain(h2.Hash()) || chain.HeaderByHash(h2.Hash()) == nil {
+		t.Fatal("side header error")
+	}
+	// bad headers
+	bad := nextHeader(f4, 3)
+	bad.Difficulty = 1
+	if _, e := chain.AddHeader(bad); e == nil {
+		t.Fatal("difficulty must be checked")
+	}
+	bad = nextHeader(f4, 3)
+	bad.Timestamp = fields.BlockTxTimestamp(f4.GetTimestamp())
+	if _, e := chain.AddHeader(bad); e == nil {
+		t.Fatal("timestamp must be checked")
+	}
+	bad = nextHeader(f4, 3)
+	bad.PrevHash = h3.Hash()
+	if _, e := chain.AddHeader(bad); e == nil {
+		t.Fatal("height must be checked")
+	}
+
+	// save and load
+	bts, _ := chain.Serialize()
+	fmt.Println("header chain size", len(bts))
+	chain2, e := LoadHeaderChain(bts, testRules{})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !chain2.BestHeader().Hash().Equal(f4.Hash()) || chain2.BestWork().Int64() != 4 {
+		t.Fatal("load error")
+	}
+}
+
+// Mainnet difficulty retarget, any hash is valid
+type retargetRules struct {
+	difficulty.MainnetRules
+}
+
+func (retargetRules) CheckProofOfWork(hash fields.Hash, difficulty uint32) bool {
+	return true
+}
+
+func Test_checkpoint_retarget(t *testing.T) {
+
+	// blocks every 150 seconds, the difficulty goes up at 288 and 576
+	rules := retargetRules{}
+	genesis := blocks.NewEmptyBlockV1()
+	genesis.Timestamp = 1549250700
+	headers := []interfaces.Block{genesis}
+	for i := 1; i <= 600; i++ {
+		prev := headers[i-1]
+		blk := blocks.NewEmptyBlockVersion1(prev)
+		blk.Timestamp = fields.BlockTxTimestamp(prev.GetTimestamp() + 150)
+		diff, _ := rules.NextDifficulty(prev, func(hei uint64) interfaces.BlockHeadMetaRead {
+			return headers[hei]
+		})
+		blk.Difficulty = fields.VarUint4(diff)
+		headers = append(headers, blk)
+	}
+	if headers[576].GetDifficulty() == headers[575].GetDifficulty() {
+		t.Fatal("difficulty not retarget at 576")
+	}
+	chain := NewHeaderChain(headers[0], rules)
+	chain.NowTimestamp = func() uint64 { return uint64(headers[600].GetTimestamp()) }
+	if _, e := chain.AddHeader(headers[1]); e != nil {
+		t.Fatal(e)
+	}
+
+	// checkpoint 300 alone can not check the retarget at 576, it reads header 288
+	chain = NewHeaderChain(headers[300], rules)
+	for i := 301; i < 576; i++ {
+		if _, e := chain.AddHeader(headers[i]); e != nil {
+			t.Fatal(e)
+		}
+	}
+	if _, e := chain.AddHeader(headers[576]); e == nil {
+		t.Fatal("retarget must fail without the header 288")
+	}
+
+	// checkpoint 300 with the previous 287 headers
+	if _, e := NewHeaderChainByCheckpoint(headers[14:301], rules); e == nil {
+		t.Fatal("checkpoint with 287 headers must fail")
+	}
+	chain, e := NewHeaderChainByCheckpoint(headers[13:301], rules)
+	if e != nil {
+		t.Fatal(e)
+	}
+	chain.NowTimestamp = func() uint64 { return uint64(headers[600].GetTimestamp()) }
+	for i := 301; i <= 600; i++ {
+		if ok, e := chain.AddHeader(headers[i]); !ok || e != nil {
+			t.Fatal(i, ok, e)
+		}
+	}
+	bad := blocks.NewEmptyBlockVersion1(headers[575])
+	bad.Timestamp = fields.BlockTxTimestamp(headers[575].GetTimestamp() + 150)
+	bad.Difficulty = fields.VarUint4(headers[575].GetDifficulty())
+	if _, e := chain.AddHeader(bad); e == nil {
+		t.Fatal("retarget difficulty must be checked")
+	}
+	if chain.HeaderByHeight(13) == nil || chain.HeaderByHeight(12) != nil {
+		t.Fatal("checkpoint headers error")
+	}
+
+	// save and load keep the checkpoint headers
+	bts, _ := chain.Serialize()
+	chain2, e := LoadHeaderChain(bts, rules)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !chain2.BestHeader().Hash().Equal(headers[600].Hash()) {
+		t.Fatal("load checkpoint chain error")
+	}
+}
@@ -0,0 +1,258 @@
+package lightclient
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/hacash/core/blocks"
+	"github.com/hacash/core/difficulty"
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/interfaces"
+)
+
+const (
+	// Header timestamp cannot be later than local time over this seconds
+	HeaderMaxFutureSeconds = 60 * 60
+)
+
+// Difficulty rules of the chain
+type DifficultyRules interface {
+	// Whether the block hash meets the difficulty
+	CheckProofOfWork(hash fields.Hash, difficulty uint32) bool
+	// Difficulty the next block of prev must use, headerByHeight reads the ancestors of prev
+	NextDifficulty(prev interfaces.BlockHeadMetaRead, headerByHeight func(uint64) interfaces.BlockHeadMetaRead) (uint32, error)
+	// Work of one block mined with the difficulty
+	CalculateWork(difficulty uint32) *big.Int
+}
+
+type headerNode struct {
+	head   interfaces.Block // head and meta only
+	parent *headerNode
+	work   *big.Int // cumulative work from the base header
+}
+
+// Verified header tree, the branch with the most cumulative work is the best chain
+type HeaderChain struct {
+	rules DifficultyRules
+
+	nodes   map[string]*headerNode
+	best    []*headerNode      // best chain, index is height minus base height
+	anchors []interfaces.Block // trusted headers before the base, the last one is the parent of base
+
+	// Local time for timestamp check
+	NowTimestamp func() uint64
+
+	mux sync.RWMutex
+}
+
+// The base header is trusted, such as the genesis block or a checkpoint
+// Nil rules use the mainnet difficulty rules
+func NewHeaderChain(base interfaces.Block, rules DifficultyRules) *HeaderChain {
+	if rules == nil {
+		rules = difficulty.MainnetRules{}
+	}
+	root := &headerNode{
+		head:   base,
+		parent: nil,
+		work:   big.NewInt(0),
+	}
+	return &HeaderChain{
+		rules: rules,
+		nodes: map[string]*headerNode{string(base.Hash()): root},
+		best:  []*headerNode{root},
+		NowTimestamp: func() uint64 {
+			return uint64(time.Now().Unix())
+		},
+	}
+}
+
+// The last header is the trusted checkpoint base, the headers before it are its ancestors
+// The difficulty retarget after the checkpoint reads them, so the previous 288 headers are required
+func NewHeaderChainByCheckpoint(heads []interfaces.Block, rules DifficultyRules) (*HeaderChain, error) {
+	if len(heads) == 0 {
+		return nil, fmt.Errorf("checkpoint headers is empty.")
+	}
+	base := heads[len(heads)-1]
+	need := uint64(difficulty.AdjustTargetDifficultyNumberOfBlocks)
+	if base.GetHeight()+1 < need {
+		need = base.GetHeight() + 1
+	}
+	if uint64(len(heads)) < need {
+		return nil, fmt.Errorf("checkpoint %d need %d headers but got %d.", base.GetHeight(), need, len(heads))
+	}
+	for i := 1; i < len(heads); i++ {
+		if heads[i].GetHeight() != heads[i-1].GetHeight()+1 || !heads[i].GetPrevHash().Equal(heads[i-1].Hash()) {
+			return nil, fmt.Errorf("checkpoint header %d not link to the prev.", heads[i].GetHeight())
+		}
+	}
+	chain := NewHeaderChain(base, rules)
+	chain.anchors = append([]interfaces.Block{}, heads[:len(heads)-1]...)
+	return chain, nil
+}
+
+func (c *HeaderChain) baseHeight() uint64 {
+	return c.best[0].head.GetHeight()
+}
+
+// Ancestor of the node at height
+func (c *HeaderChain) ancestor(node *headerNode, height uint64) *headerNode {
+	base := c.baseHeight()
+	if height < base || height > node.head.GetHeight() {
+		return nil
+	}
+	// branch joins the best chain
+	for node != nil {
+		idx := node.head.GetHeight() - base
+		if idx < uint64(len(c.best)) && c.best[idx] == node {
+			return c.best[height-base]
+		}
+		if node.head.GetHeight() == height {
+			return node
+		}
+		node = node.parent
+	}
+	return nil
+}
+
+// Trusted header before the base at height
+func (c *HeaderChain) anchorByHeight(height uint64) interfaces.BlockHeadMetaRead {
+	base := c.baseHeight()
+	if height >= base || base-height > uint64(len(c.anchors)) {
+		return nil
+	}
+	return c.anchors[uint64(len(c.anchors))-(base-height)]
+}
+
+// Check the header and add it to the tree, return whether the best chain changed
+func (c *HeaderChain) AddHeader(head interfaces.Block) (bool, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	return c.addHeaderUnsafe(head, true)
+}
+
+func (c *HeaderChain) addHeaderUnsafe(head interfaces.Block, checkpow bool) (bool, error) {
+	hash := blocks.CalculateBlockHash(head)
+	if _, ok := c.nodes[string(hash)]; ok {
+		return false, nil // already have
+	}
+	parent, ok := c.nodes[string(head.GetPrevHash())]
+	if !ok {
+		return false, fmt.Errorf("header %d prev hash <%s> not find.", head.GetHeight(), head.GetPrevHash().ToHex())
+	}
+	prev := parent.head
+	height := head.GetHeight()
+	if height != prev.GetHeight()+1 {
+		return false, fmt.Errorf("header height need %d but got %d.", prev.GetHeight()+1, height)
+	}
+	// timestamp
+	if head.GetTimestamp() <= prev.GetTimestamp() {
+		return false, fmt.Errorf("header %d timestamp %d must be after prev %d.", height, head.GetTimestamp(), prev.GetTimestamp())
+	}
+	if checkpow && head.GetTimestamp() > c.NowTimestamp()+HeaderMaxFutureSeconds {
+		return false, fmt.Errorf("header %d timestamp %d is in the future.", height, head.GetTimestamp())
+	}
+	// difficulty
+	needdiff, e := c.rules.NextDifficulty(prev, func(hei uint64) interfaces.BlockHeadMetaRead {
+		if n := c.ancestor(parent, hei); n != nil {
+			return n.head
+		}
+		return c.anchorByHeight(hei)
+	})
+	if e != nil {
+		return false, e
+	}
+	if head.GetDifficulty() != needdiff {
+		return false, fmt.Errorf("header %d difficulty need %d but got %d.", height, needdiff, head.GetDifficulty())
+	}
+	// proof of work
+	if checkpow && !c.rules.CheckProofOfWork(hash, head.GetDifficulty()) {
+		return false, fmt.Errorf("header %d hash <%s> not meet difficulty %d.", height, hash.ToHex(), head.GetDifficulty())
+	}
+	node := &headerNode{
+		head:   head,
+		parent: parent,
+		work:   new(big.Int).Add(parent.work, c.rules.CalculateWork(head.GetDifficulty())),
+	}
+	c.nodes[string(hash)] = node
+	if node.work.Cmp(c.best[len(c.best)-1].work) <= 0 {
+		return false, nil // side chain
+	}
+	c.switchBestChain(node)
+	return true, nil
+}
+
+// Reorganize the best chain to end with the node
+func (c *HeaderChain) switchBestChain(node *headerNode) {
+	base := c.baseHeight()
+	newbest := make([]*headerNode, node.head.GetHeight()-base+1)
+	copy(newbest, c.best)
+	for n := node; n != nil; n = n.parent {
+		idx := n.head.GetHeight() - base
+		if idx < uint64(len(c.best)) && c.best[idx] == n {
+			break // fork point
+		}
+		newbest[idx] = n
+	}
+	c.best = newbest
+}
+
+// Header in the best chain
+func (c *HeaderChain) HeaderByHeight(height uint64) interfaces.BlockHeadMetaRead {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+
+	base := c.baseHeight()
+	if height < base {
+		return c.anchorByHeight(height)
+	}
+	if height-base >= uint64(len(c.best)) {
+		return nil
+	}
+	return c.best[height-base].head
+}
+
+// Any known header, include side chains
+func (c *HeaderChain) HeaderByHash(hash fields.Hash) interfaces.BlockHeadMetaRead {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+
+	if n, ok := c.nodes[string(hash)]; ok {
+		return n.head
+	}
+	return nil
+}
+
+// Whether the header is in the best chain
+func (c *HeaderChain) IsInBestChain(hash fields.Hash) bool {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+
+	n, ok := c.nodes[string(hash)]
+	if !ok {
+		return false
+	}
+	idx := n.head.GetHeight() - c.baseHeight()
+	return idx < uint64(len(c.best)) && c.best[idx] == n
+}
+
+func (c *HeaderChain) BestHeader() interfaces.BlockHeadMetaRead {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+
+	return c.best[len(c.best)-1].head
+}
+
+func (c *HeaderChain) BestHeight() uint64 {
+	return c.BestHeader().GetHeight()
+}
+
+// Cumulative work of the best chain from the base header
+func (c *HeaderChain) BestWork() *big.Int {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+
+	return new(big.Int).Set(c.best[len(c.best)-1].work)
+}
@@ -0,0 +1,91 @@
+package lightclient
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/hacash/core/blocks"
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/interfaces"
+)
+
+/**
+ * The trusted headers before the base and the best chain are saved, 89 bytes of head and meta each
+ * Side chains are dropped and can be downloaded again
+ */
+
+func (c *HeaderChain) Serialize() ([]byte, error) {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+
+	var buffer = new(bytes.Buffer)
+	b1, _ := fields.VarUint5(len(c.anchors)).Serialize()
+	buffer.Write(b1)
+	for _, head := range c.anchors {
+		b, e := head.SerializeExcludeTransactions()
+		if e != nil {
+			return nil, e
+		}
+		buffer.Write(b)
+	}
+	b2, _ := fields.VarUint5(len(c.best)).Serialize()
+	buffer.Write(b2)
+	for _, n := range c.best {
+		b, e := n.head.SerializeExcludeTransactions()
+		if e != nil {
+			return nil, e
+		}
+		buffer.Write(b)
+	}
+	return buffer.Bytes(), nil
+}
+
+func parseHeaderList(buf []byte, seek uint32) ([]interfaces.Block, uint32, error) {
+	var count fields.VarUint5
+	seek, e := count.Parse(buf, seek)
+	if e != nil {
+		return nil, 0, e
+	}
+	if uint64(seek)+uint64(count)*(blocks.BlockHeadSize+blocks.BlockMetaSizeV1) > uint64(len(buf)) {
+		return nil, 0, fmt.Errorf("[LoadHeaderChain] seek out of buf len.")
+	}
+	heads := make([]interfaces.Block, 0, int(count))
+	for i := uint64(0); i < uint64(count); i++ {
+		var head interfaces.Block
+		head, seek, e = blocks.ParseExcludeTransactions(buf, seek)
+		if e != nil {
+			return nil, 0, e
+		}
+		heads = append(heads, head)
+	}
+	return heads, seek, nil
+}
+
+// Load saved headers, the base and the headers before it are trusted
+// Linkage, timestamps and difficulty are checked again, the proof of work is trusted for fast start
+func LoadHeaderChain(buf []byte, rules DifficultyRules) (*HeaderChain, error) {
+	anchors, seek, e := parseHeaderList(buf, 0)
+	if e != nil {
+		return nil, e
+	}
+	heads, _, e := parseHeaderList(buf, seek)
+	if e != nil {
+		return nil, e
+	}
+	if len(heads) < 1 {
+		return nil, fmt.Errorf("header chain is empty.")
+	}
+	chain := NewHeaderChain(heads[0], rules)
+	if len(anchors) > 0 {
+		if chain, e = NewHeaderChainByCheckpoint(append(anchors, heads[0]), rules); e != nil {
+			return nil, e
+		}
+	}
+	for _, head := range heads[1:] {
+		_, e = chain.addHeaderUnsafe(head, false)
+		if e != nil {
+			return nil, e
+		}
+	}
+	return chain, nil
+}
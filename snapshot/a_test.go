@@ -0,0 +1,280 @@
+package snapshot
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/hacash/core/account"
+	"github.com/hacash/core/actions"
+	"github.com/hacash/core/blocks"
+	"github.com/hacash/core/chainengine"
+	"github.com/hacash/core/chainstate"
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/interfaces"
+	"github.com/hacash/core/internal/testchain"
+	"github.com/hacash/core/kvdb"
+	"github.com/hacash/core/stores"
+	"github.com/hacash/core/sys"
+	"github.com/hacash/core/transactions"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+)
+
+var _ chainstate.StateIterator = &chainstate.MemoryChainState{}
+
+func Test_balance_snapshot(t *testing.T) {
+
+	acc1 := account.CreateAccountByPassword("123456")
+	acc2 := account.CreateAccountByPassword("qwerty")
+	acc3 := account.CreateAccountByPassword("asdfgh")
+
+	state := chainstate.NewMemoryChainStateImmutable(nil)
+	state.BalanceSet(acc1.Address, stores.NewBalanceWithAmount(fields.NewAmountSmall(5, 248)))
+	state.BalanceSet(acc2.Address, stores.NewBalanceWithAmount(fields.NewAmountSmall(20, 248)))
+	bls3 := stores.NewBalanceWithAmount(fields.NewAmountSmall(5, 248))
+	bls3.Satoshi = 3000
+	bls3.Diamond = 1
+	state.BalanceSet(acc3.Address, bls3)
+	state.DiamondSet(fields.DiamondName("WTYUIA"), &stores.Diamond{Status: stores.DiamondStatusLendingSystem, Address: acc3.Address})
+	paychan := stores.CreateEmptyChannel()
+	paychan.LeftAddress = acc1.Address
+	paychan.RightAddress = acc2.Address
+	paychan.LeftAmount = *fields.NewAmountSmall(1, 248)
+	paychan.RightAmount = *fields.NewAmountSmall(2, 248)
+	state.ChannelCreate(make([]byte, stores.ChannelIdLength), paychan)
+
+	snap, e := BuildBalanceSnapshot(state)
+	if e != nil {
+		t.Fatal(e)
+	}
+	tt := snap.Totals
+	fmt.Println(bigAmountString(tt.Hacash), tt.Satoshi, tt.Diamond, tt.OpenChannels, bigAmountString(tt.ChannelHacash))
+	if tt.Accounts != 3 || tt.Satoshi != 3000 || tt.Diamond != 1 || tt.MortgagedDiamonds != 1 || tt.OpenChannels != 1 {
+		t.Fatal("totals error")
+	}
+	if bigAmountString(tt.Hacash) != "ㄜ3:249" || bigAmountString(tt.ChannelHacash) != "ㄜ3:248" {
+		t.Fatal("hac totals error")
+	}
+
+	// rich list, same HAC sorted by address
+	rich := snap.RichList(2)
+	if len(rich) != 2 || !rich[0].Address.Equal(acc2.Address) {
+		t.Fatal("rich list error")
+	}
+
+	// deterministic and committed
+	body1, _ := snap.Serialize()
+	snap2, _ := BuildBalanceSnapshot(state)
+	body2, _ := snap2.Serialize()
+	if !bytes.Equal(body1, body2) {
+		t.Fatal("snapshot not deterministic")
+	}
+	back, e := ParseBalanceSnapshot(body1)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(back.Accounts) != 3 || back.Totals.Hacash.Cmp(tt.Hacash) != 0 || back.Totals.Satoshi != 3000 {
+		t.Fatal("parse error")
+	}
+	body1[60] ^= 1
+	if _, e := ParseBalanceSnapshot(body1); e == nil {
+		t.Fatal("must check the commitment")
+	}
+
+	// files
+	dir, _ := ioutil.TempDir("", "hcbs")
+	defer os.RemoveAll(dir)
+	commit, e := ExportBalanceSnapshot(state, dir, 10)
+	if e != nil {
+		t.Fatal(e)
+	}
+	csvbody, _ := ioutil.ReadFile(dir + "/balances_0.csv")
+	if !bytes.Contains(csvbody, []byte(commit.ToHex())) {
+		t.Fatal("csv not carry the commitment")
+	}
+	fmt.Println(string(csvbody))
+}
+
+func Test_state_snapshot_checkpoints(t *testing.T) {
+
+	hash := fields.CalculateHash([]byte{1}).ToHex()
+	file, _ := ioutil.TempFile("", "hcss*.ini")
+	defer os.Remove(file.Name())
+	file.WriteString("[snapshot]\ncheckpoints = 3:" + hash + ", 9:" + hash + "\n")
+	file.Close()
+	cnf, _ := sys.LoadInicnf(file.Name())
+	checkpoints, e := StateSnapshotCheckpointsByInicnf(cnf)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(checkpoints) != 2 || checkpoints[3] != hash || checkpoints[9] != hash {
+		t.Fatal("checkpoints error", checkpoints)
+	}
+	cnf.Section("snapshot").Key("checkpoints").SetValue("3:abcd")
+	if _, e := StateSnapshotCheckpointsByInicnf(cnf); e == nil {
+		t.Fatal("checkpoint hash not checked")
+	}
+}
+
+// Any hash is valid, the ancestors of two blocks before must be readable
+type testRules struct{}
+
+func (testRules) CheckProofOfWork(hash fields.Hash, difficulty uint32) bool {
+	return true
+}
+func (testRules) NextDifficulty(prev interfaces.BlockHeadMetaRead, headerByHeight func(uint64) interfaces.BlockHeadMetaRead) (uint32, error) {
+	if headerByHeight(prev.GetHeight()-2) == nil {
+		return 0, fmt.Errorf("head %d not find", prev.GetHeight()-2)
+	}
+	return prev.GetDifficulty(), nil
+}
+func (testRules) CalculateWork(difficulty uint32) *big.Int {
+	return big.NewInt(1)
+}
+
+func Test_state_snapshot_sync(t *testing.T) {
+
+	acc1 := account.CreateAccountByPassword("123456")
+	acc2 := account.CreateAccountByPassword("qwerty")
+	publisher := account.CreateAccountByPassword("publisher")
+
+	// source node at height 3
+	db := kvdb.NewMemoryDB()
+	store := chainstate.NewKVBlockStore(db)
+	base, _ := chainstate.OpenKVChainStateImmutable(db, store)
+	var prev interfaces.Block = blocks.NewEmptyBlockV1()
+	chain := []interfaces.Block{prev}
+	for i := 1; i <= 3; i++ {
+		prev = testchain.NewBlock(prev, acc1.Address)
+		chain = append(chain, prev)
+	}
+	for i, blk := range chain {
+		store.SaveBlock(blk)
+		store.UpdateSetBlockHashReferToHeight(uint64(i), blk.Hash())
+	}
+	fork, _ := base.ForkNextBlock(3, prev.Hash(), prev)
+	bls1 := stores.NewBalanceWithAmount(fields.NewAmountSmall(100, 248))
+	bls1.Diamond = 1
+	fork.BalanceSet(acc1.Address, bls1)
+	fork.DiamondSet(fields.DiamondName("WTYUIA"), &stores.Diamond{Status: stores.DiamondStatusNormal, Address: acc1.Address})
+	fork.DiamondSet(fields.DiamondName("HXVMEK"), &stores.Diamond{Status: stores.DiamondStatusNormal, Address: acc2.Address})
+	total := stores.NewTotalSupplyStoreData()
+	total.Set(0, 100)
+	fork.UpdateSetTotalSupply(total)
+	var diamond *stores.DiamondSmelt
+	for i, name := range []string{"WTYUIA", "HXVMEK"} {
+		diamond = &stores.DiamondSmelt{
+			Diamond:              fields.DiamondName(name),
+			Number:               fields.DiamondNumber(i + 1),
+			ContainBlockHeight:   fields.BlockHeight(i + 2),
+			ContainBlockHash:     chain[i+2].Hash(),
+			PrevContainBlockHash: chain[i+1].Hash(),
+			MinerAddress:         acc2.Address,
+			ApproxFeeOffer:       *fields.NewAmountSmall(1, 248),
+			Nonce:                make([]byte, 8),
+			CustomMessage:        make([]byte, 32),
+			AverageBidBurnPrice:  5,
+			LifeGene:             chain[i+2].Hash(),
+		}
+		store.SaveDiamond(diamond)
+		store.UpdateSetDiamondNameReferToNumber(uint32(diamond.Number), diamond.Diamond)
+	}
+	status := chainstate.NewLatestStatus()
+	status.SetLastestDiamond(diamond)
+	fork.LatestStatusSet(status)
+	if _, e := fork.ImmutableWriteToDisk(); e != nil {
+		t.Fatal(e)
+	}
+	store.SaveBTCMoveLogPageData(1, []*stores.SatoshiGenesis{{TransferNo: 1, BitcoinQuantity: 10, OriginAddress: acc1.Address, BitcoinTransferHash: chain[1].Hash()}})
+
+	snap, e := BuildStateSnapshot(base)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if _, e := snap.Serialize(); e == nil {
+		t.Fatal("must sign before serialize")
+	}
+	snap.SignBy(publisher)
+	buf, e := snap.Serialize()
+	if e != nil {
+		t.Fatal(e)
+	}
+	commitment, _ := snap.Commitment()
+	fmt.Println("state snapshot", len(buf), commitment.ToHex(), len(snap.Items))
+
+	// checkpoint not match
+	newstate := func() *chainstate.MemoryChainState {
+		db := kvdb.NewMemoryDB()
+		state, _ := chainstate.OpenKVChainStateImmutable(db, chainstate.NewKVBlockStore(db))
+		return state
+	}
+	if _, _, e := LoadStateSnapshot(buf, map[uint64]string{3: fields.CalculateHash(nil).ToHex()}, newstate()); e == nil {
+		t.Fatal("checkpoint not checked")
+	}
+	broken := append([]byte{}, buf...)
+	broken[50]++
+	if _, e := ParseStateSnapshot(broken); e == nil {
+		t.Fatal("commitment not checked")
+	}
+
+	// new node
+	state := newstate()
+	loaded, head, e := LoadStateSnapshot(buf, map[uint64]string{3: commitment.ToHex()}, state)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !loaded.Signer().Equal(publisher.Address) || !head.Hash().Equal(prev.Hash()) || state.GetPendingBlockHeight() != 3 {
+		t.Fatal("load error")
+	}
+	bls, _ := state.Balance(acc1.Address)
+	supply, _ := state.ReadTotalSupply()
+	latest, _ := state.ReadLastestDiamond()
+	if bls == nil || supply.Get(0) != 100 || latest == nil || string(latest.Diamond) != string(diamond.Diamond) {
+		t.Fatal("state items error")
+	}
+	newstore := state.BlockStore()
+	for number := uint32(1); number <= 2; number++ {
+		if d, _ := newstore.ReadDiamondByNumber(number); d == nil || uint32(d.Number) != number {
+			t.Fatal("diamond", number, "not in block store")
+		}
+	}
+	if page, _ := newstore.GetBTCMoveLogPageData(1); len(page) != 1 {
+		t.Fatal("btc move log not loaded")
+	}
+	if _, _, e := LoadStateSnapshot(buf, map[uint64]string{3: commitment.ToHex()}, state); e == nil {
+		t.Fatal("load twice")
+	}
+
+	// mortgage the diamond before the latest
+	defer func(mark bool) { sys.TestDebugLocalDevelopmentMark = mark }(sys.TestDebugLocalDevelopmentMark)
+	sys.TestDebugLocalDevelopmentMark = true
+	lending := &actions.Action_15_DiamondsSystemLendingCreate{
+		LendingID:           append([]byte{1}, bytes.Repeat([]byte{1}, stores.DiamondSyslendIdLength-1)...),
+		MortgageDiamondList: fields.DiamondListMaxLen200{Count: 1, Diamonds: []fields.DiamondName{fields.DiamondName("WTYUIA")}},
+		LoanTotalAmount:     *fields.NewAmountByUnit248(5),
+		BorrowPeriod:        1,
+	}
+	lendtx, _ := transactions.NewEmptyTransaction_2_Simple(acc1.Address)
+	lendtx.AppendAction(lending)
+	lending.SetBelongTrs(lendtx)
+	next, _ := state.ForkNextBlock(4, fields.CalculateHash([]byte{4}), nil)
+	if e := lending.WriteInChainState(next); e != nil {
+		t.Fatal("diamond lending after snapshot error", e)
+	}
+
+	// sync the blocks after the snapshot
+	cnf := chainengine.NewEmptyChainEngineConfig()
+	cnf.Rules = testRules{}
+	engine := chainengine.NewChainEngine(cnf, state, head)
+	if e := engine.Start(); e != nil {
+		t.Fatal(e)
+	}
+	b4 := testchain.NewBlock(prev, acc1.Address)
+	if e := engine.InsertBlock(b4, "sync"); e != nil {
+		t.Fatal(e)
+	}
+	if _, tip, _ := engine.LatestBlock(); !tip.Hash().Equal(b4.Hash()) {
+		t.Fatal("sync after snapshot error")
+	}
+}
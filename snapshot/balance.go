@@ -0,0 +1,341 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/hacash/core/chainstate"
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/stores"
+)
+
+/**
+ * Balance snapshot
+ * All balances of a state at one height, sorted by address, with the totals of each asset
+ * The binary form ends with the sha3 hash of the content, the CSV form carries the same hash
+ */
+
+const (
+	balanceSnapshotMagic   = "HCBS"
+	BalanceSnapshotVersion = uint8(1)
+)
+
+type AccountBalance struct {
+	Address fields.Address
+	Balance *stores.Balance
+}
+
+// Totals of each asset in the state
+type AssetTotals struct {
+	Accounts     uint64 // balance items
+	HacAccounts  uint64
+	SatAccounts  uint64
+	HacdAccounts uint64
+
+	Hacash  *big.Int // in balances, unit is the smallest
+	Satoshi uint64
+	Diamond uint64
+
+	Diamonds          uint64 // all minted
+	MortgagedDiamonds uint64 // to system or user lending
+
+	OpenChannels   uint64 // opening or challenging
+	ChannelHacash  *big.Int
+	ChannelSatoshi uint64
+
+	Lockbls       uint64 // not released all
+	LockblsHacash *big.Int
+
+	DiamondLendings uint64 // not redeemed
+	BitcoinLendings uint64
+	UserLendings    uint64
+}
+
+func newAssetTotals() *AssetTotals {
+	return &AssetTotals{
+		Hacash:        big.NewInt(0),
+		ChannelHacash: big.NewInt(0),
+		LockblsHacash: big.NewInt(0),
+	}
+}
+
+type BalanceSnapshot struct {
+	Height    uint64
+	BlockHash fields.Hash
+	Accounts  []*AccountBalance // address order
+	Totals    *AssetTotals
+}
+
+// Walk the whole state, it should be the immutable state of the height
+func BuildBalanceSnapshot(state chainstate.StateIterator) (*BalanceSnapshot, error) {
+	snap := &BalanceSnapshot{
+		Height:    state.GetPendingBlockHeight(),
+		BlockHash: state.GetPendingBlockHash(),
+		Accounts:  make([]*AccountBalance, 0),
+		Totals:    newAssetTotals(),
+	}
+	if snap.BlockHash == nil {
+		snap.BlockHash = make([]byte, fields.HashSize)
+	}
+	tt := snap.Totals
+	e := state.IterateBalances(func(addr fields.Address, bls *stores.Balance) bool {
+		snap.Accounts = append(snap.Accounts, &AccountBalance{addr, bls})
+		tt.Accounts++
+		if bls.Hacash.IsPositive() {
+			tt.HacAccounts++
+			tt.Hacash.Add(tt.Hacash, bls.Hacash.GetValue())
+		}
+		if bls.Satoshi > 0 {
+			tt.SatAccounts++
+			tt.Satoshi += uint64(bls.Satoshi)
+		}
+		if bls.Diamond > 0 {
+			tt.HacdAccounts++
+			tt.Diamond += uint64(bls.Diamond)
+		}
+		return true
+	})
+	if e != nil {
+		return nil, e
+	}
+	e = state.IterateDiamonds(func(name fields.DiamondName, dia *stores.Diamond) bool {
+		tt.Diamonds++
+		if dia.Status != stores.DiamondStatusNormal {
+			tt.MortgagedDiamonds++
+		}
+		return true
+	})
+	if e != nil {
+		return nil, e
+	}
+	e = state.IterateChannels(func(id fields.ChannelId, paychan *stores.Channel) bool {
+		if paychan.IsClosed() {
+			return true
+		}
+		tt.OpenChannels++
+		tt.ChannelHacash.Add(tt.ChannelHacash, paychan.LeftAmount.GetValue())
+		tt.ChannelHacash.Add(tt.ChannelHacash, paychan.RightAmount.GetValue())
+		tt.ChannelSatoshi += uint64(paychan.LeftSatoshi.GetRealSatoshi() + paychan.RightSatoshi.GetRealSatoshi())
+		return true
+	})
+	if e != nil {
+		return nil, e
+	}
+	e = state.IterateLockbls(func(id fields.LockblsId, lock *stores.Lockbls) bool {
+		if lock.BalanceAmount.IsPositive() {
+			tt.Lockbls++
+			tt.LockblsHacash.Add(tt.LockblsHacash, lock.BalanceAmount.GetValue())
+		}
+		return true
+	})
+	if e != nil {
+		return nil, e
+	}
+	e = state.IterateDiamondSystemLendings(func(id fields.DiamondSyslendId, lend *stores.DiamondSystemLending) bool {
+		if lend.IsRansomed.Check() == false {
+			tt.DiamondLendings++
+		}
+		return true
+	})
+	if e != nil {
+		return nil, e
+	}
+	e = state.IterateBitcoinSystemLendings(func(id fields.BitcoinSyslendId, lend *stores.BitcoinSystemLending) bool {
+		if lend.IsRansomed.Check() == false {
+			tt.BitcoinLendings++
+		}
+		return true
+	})
+	if e != nil {
+		return nil, e
+	}
+	e = state.IterateUserLendings(func(id fields.UserLendingId, lend *stores.UserLending) bool {
+		if lend.IsRansomed.Check() == false {
+			tt.UserLendings++
+		}
+		return true
+	})
+	if e != nil {
+		return nil, e
+	}
+	return snap, nil
+}
+
+// Accounts with most HAC first, the same HAC sorted by address
+func (s *BalanceSnapshot) RichList(limit int) []*AccountBalance {
+	list := append([]*AccountBalance{}, s.Accounts...)
+	values := make(map[string]*big.Int, len(list))
+	for _, acc := range list {
+		values[string(acc.Address)] = acc.Balance.Hacash.GetValue()
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		c := values[string(list[i].Address)].Cmp(values[string(list[j].Address)])
+		if c != 0 {
+			return c > 0
+		}
+		return bytes.Compare(list[i].Address, list[j].Address) < 0
+	})
+	if limit > 0 && limit < len(list) {
+		list = list[:limit]
+	}
+	return list
+}
+
+/**************************** binary ****************************/
+
+func serializeBigAmount(buf *bytes.Buffer, num *big.Int) error {
+	amt, e := fields.NewAmountByBigInt(new(big.Int).Set(num))
+	if e != nil {
+		return e
+	}
+	body, e := amt.Serialize()
+	if e != nil {
+		return e
+	}
+	buf.Write(body)
+	return nil
+}
+
+func parseBigAmount(buf []byte, seek uint32) (*big.Int, uint32, error) {
+	amt := fields.NewEmptyAmount()
+	seek, e := amt.Parse(buf, seek)
+	if e != nil {
+		return nil, 0, e
+	}
+	return amt.GetValue(), seek, nil
+}
+
+// Content without the commitment hash
+func (s *BalanceSnapshot) serializeContent() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	buf.WriteString(balanceSnapshotMagic)
+	buf.WriteByte(BalanceSnapshotVersion)
+	binary.Write(buf, binary.BigEndian, s.Height)
+	buf.Write(s.BlockHash)
+	binary.Write(buf, binary.BigEndian, uint32(len(s.Accounts)))
+	for _, acc := range s.Accounts {
+		buf.Write(acc.Address)
+		body, e := acc.Balance.Serialize()
+		if e != nil {
+			return nil, e
+		}
+		buf.Write(body)
+	}
+	tt := s.Totals
+	for _, num := range []uint64{tt.Accounts, tt.HacAccounts, tt.SatAccounts, tt.HacdAccounts} {
+		binary.Write(buf, binary.BigEndian, num)
+	}
+	if e := serializeBigAmount(buf, tt.Hacash); e != nil {
+		return nil, e
+	}
+	for _, num := range []uint64{tt.Satoshi, tt.Diamond, tt.Diamonds, tt.MortgagedDiamonds, tt.OpenChannels} {
+		binary.Write(buf, binary.BigEndian, num)
+	}
+	if e := serializeBigAmount(buf, tt.ChannelHacash); e != nil {
+		return nil, e
+	}
+	binary.Write(buf, binary.BigEndian, tt.ChannelSatoshi)
+	binary.Write(buf, binary.BigEndian, tt.Lockbls)
+	if e := serializeBigAmount(buf, tt.LockblsHacash); e != nil {
+		return nil, e
+	}
+	for _, num := range []uint64{tt.DiamondLendings, tt.BitcoinLendings, tt.UserLendings} {
+		binary.Write(buf, binary.BigEndian, num)
+	}
+	return buf.Bytes(), nil
+}
+
+// Hash committed to the snapshot content
+func (s *BalanceSnapshot) Commitment() (fields.Hash, error) {
+	body, e := s.serializeContent()
+	if e != nil {
+		return nil, e
+	}
+	return fields.CalculateHash(body), nil
+}
+
+// Content followed by the commitment hash
+func (s *BalanceSnapshot) Serialize() ([]byte, error) {
+	body, e := s.serializeContent()
+	if e != nil {
+		return nil, e
+	}
+	return append(body, fields.CalculateHash(body)...), nil
+}
+
+// Parse and check the commitment hash
+func ParseBalanceSnapshot(buf []byte) (*BalanceSnapshot, error) {
+	if len(buf) < len(balanceSnapshotMagic)+1+8+fields.HashSize+4+fields.HashSize {
+		return nil, fmt.Errorf("balance snapshot size %d too short.", len(buf))
+	}
+	body := buf[:len(buf)-fields.HashSize]
+	if !fields.CalculateHash(body).Equal(buf[len(body):]) {
+		return nil, fmt.Errorf("balance snapshot commitment hash not match.")
+	}
+	if string(body[:4]) != balanceSnapshotMagic {
+		return nil, fmt.Errorf("not a balance snapshot.")
+	}
+	if body[4] != BalanceSnapshotVersion {
+		return nil, fmt.Errorf("balance snapshot version %d not support.", body[4])
+	}
+	s := &BalanceSnapshot{
+		Height:    binary.BigEndian.Uint64(body[5:13]),
+		BlockHash: append([]byte{}, body[13:45]...),
+		Totals:    newAssetTotals(),
+	}
+	count := binary.BigEndian.Uint32(body[45:49])
+	seek := uint32(49)
+	s.Accounts = make([]*AccountBalance, 0, count)
+	var e error
+	for i := uint32(0); i < count; i++ {
+		if int(seek)+fields.AddressSize > len(body) {
+			return nil, fmt.Errorf("balance snapshot account %d out of range.", i)
+		}
+		addr := fields.Address(append([]byte{}, body[seek:seek+fields.AddressSize]...))
+		bls := stores.NewEmptyBalance()
+		seek, e = bls.Parse(body, seek+fields.AddressSize)
+		if e != nil {
+			return nil, e
+		}
+		s.Accounts = append(s.Accounts, &AccountBalance{addr, bls})
+	}
+	readUint64s := func(nums ...*uint64) error {
+		for _, num := range nums {
+			if int(seek)+8 > len(body) {
+				return fmt.Errorf("balance snapshot totals out of range.")
+			}
+			*num = binary.BigEndian.Uint64(body[seek : seek+8])
+			seek += 8
+		}
+		return nil
+	}
+	tt := s.Totals
+	if e = readUint64s(&tt.Accounts, &tt.HacAccounts, &tt.SatAccounts, &tt.HacdAccounts); e != nil {
+		return nil, e
+	}
+	if tt.Hacash, seek, e = parseBigAmount(body, seek); e != nil {
+		return nil, e
+	}
+	if e = readUint64s(&tt.Satoshi, &tt.Diamond, &tt.Diamonds, &tt.MortgagedDiamonds, &tt.OpenChannels); e != nil {
+		return nil, e
+	}
+	if tt.ChannelHacash, seek, e = parseBigAmount(body, seek); e != nil {
+		return nil, e
+	}
+	if e = readUint64s(&tt.ChannelSatoshi, &tt.Lockbls); e != nil {
+		return nil, e
+	}
+	if tt.LockblsHacash, seek, e = parseBigAmount(body, seek); e != nil {
+		return nil, e
+	}
+	if e = readUint64s(&tt.DiamondLendings, &tt.BitcoinLendings, &tt.UserLendings); e != nil {
+		return nil, e
+	}
+	if int(seek) != len(body) {
+		return nil, fmt.Errorf("balance snapshot has %d extra bytes.", len(body)-int(seek))
+	}
+	return s, nil
+}
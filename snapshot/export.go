@@ -0,0 +1,139 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strconv"
+
+	"github.com/hacash/core/chainstate"
+	"github.com/hacash/core/fields"
+)
+
+/**
+ * Export the balance snapshot to files
+ * balances_<height>.bin, balances_<height>.csv and richlist_<height>.csv
+ */
+
+func bigAmountString(num *big.Int) string {
+	amt, e := fields.NewAmountByBigInt(new(big.Int).Set(num))
+	if e != nil {
+		return num.String()
+	}
+	return amt.ToFinString()
+}
+
+func writeSnapshotHead(w *csv.Writer, s *BalanceSnapshot, commit fields.Hash) {
+	w.Write([]string{"#height", strconv.FormatUint(s.Height, 10)})
+	w.Write([]string{"#block", s.BlockHash.ToHex()})
+	w.Write([]string{"#commitment", commit.ToHex()})
+}
+
+// One line each account in address order, the totals follow
+func (s *BalanceSnapshot) WriteCSV(out io.Writer) error {
+	commit, e := s.Commitment()
+	if e != nil {
+		return e
+	}
+	w := csv.NewWriter(out)
+	writeSnapshotHead(w, s, commit)
+	w.Write([]string{"address", "hac", "sat", "hacd"})
+	for _, acc := range s.Accounts {
+		w.Write([]string{
+			acc.Address.ToReadable(),
+			acc.Balance.Hacash.ToFinString(),
+			strconv.FormatUint(uint64(acc.Balance.Satoshi), 10),
+			strconv.FormatUint(uint64(acc.Balance.Diamond), 10),
+		})
+	}
+	tt := s.Totals
+	totals := [][2]string{
+		{"accounts", strconv.FormatUint(tt.Accounts, 10)},
+		{"hac_accounts", strconv.FormatUint(tt.HacAccounts, 10)},
+		{"sat_accounts", strconv.FormatUint(tt.SatAccounts, 10)},
+		{"hacd_accounts", strconv.FormatUint(tt.HacdAccounts, 10)},
+		{"hac", bigAmountString(tt.Hacash)},
+		{"sat", strconv.FormatUint(tt.Satoshi, 10)},
+		{"hacd", strconv.FormatUint(tt.Diamond, 10)},
+		{"diamonds", strconv.FormatUint(tt.Diamonds, 10)},
+		{"mortgaged_diamonds", strconv.FormatUint(tt.MortgagedDiamonds, 10)},
+		{"open_channels", strconv.FormatUint(tt.OpenChannels, 10)},
+		{"channel_hac", bigAmountString(tt.ChannelHacash)},
+		{"channel_sat", strconv.FormatUint(tt.ChannelSatoshi, 10)},
+		{"lockbls", strconv.FormatUint(tt.Lockbls, 10)},
+		{"lockbls_hac", bigAmountString(tt.LockblsHacash)},
+		{"diamond_lendings", strconv.FormatUint(tt.DiamondLendings, 10)},
+		{"bitcoin_lendings", strconv.FormatUint(tt.BitcoinLendings, 10)},
+		{"user_lendings", strconv.FormatUint(tt.UserLendings, 10)},
+	}
+	for _, one := range totals {
+		w.Write([]string{"#total", one[0], one[1]})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// Top accounts by HAC, limit 0 means all
+func (s *BalanceSnapshot) WriteRichListCSV(out io.Writer, limit int) error {
+	commit, e := s.Commitment()
+	if e != nil {
+		return e
+	}
+	w := csv.NewWriter(out)
+	writeSnapshotHead(w, s, commit)
+	w.Write([]string{"rank", "address", "hac", "percent"})
+	total := new(big.Float).SetInt(s.Totals.Hacash)
+	for i, acc := range s.RichList(limit) {
+		percent := "0"
+		if s.Totals.Hacash.Sign() > 0 {
+			pct := new(big.Float).SetInt(acc.Balance.Hacash.GetValue())
+			pct.Quo(pct, total).Mul(pct, big.NewFloat(100))
+			percent = pct.Text('f', 6)
+		}
+		w.Write([]string{
+			strconv.Itoa(i + 1),
+			acc.Address.ToReadable(),
+			acc.Balance.Hacash.ToFinString(),
+			percent,
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// Build the snapshot of the state and write all files into the dir, return the commitment hash
+func ExportBalanceSnapshot(state chainstate.StateIterator, dir string, richlimit int) (fields.Hash, error) {
+	snap, e := BuildBalanceSnapshot(state)
+	if e != nil {
+		return nil, e
+	}
+	body, e := snap.Serialize()
+	if e != nil {
+		return nil, e
+	}
+	name := func(kind, ext string) string {
+		return filepath.Join(dir, fmt.Sprintf("%s_%d.%s", kind, snap.Height, ext))
+	}
+	if e := ioutil.WriteFile(name("balances", "bin"), body, 0666); e != nil {
+		return nil, e
+	}
+	csvbuf := new(bytes.Buffer)
+	if e := snap.WriteCSV(csvbuf); e != nil {
+		return nil, e
+	}
+	if e := ioutil.WriteFile(name("balances", "csv"), csvbuf.Bytes(), 0666); e != nil {
+		return nil, e
+	}
+	csvbuf.Reset()
+	if e := snap.WriteRichListCSV(csvbuf, richlimit); e != nil {
+		return nil, e
+	}
+	if e := ioutil.WriteFile(name("richlist", "csv"), csvbuf.Bytes(), 0666); e != nil {
+		return nil, e
+	}
+	return body[len(body)-fields.HashSize:], nil
+}
@@ -0,0 +1,153 @@
+package snapshot
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hacash/core/blocks"
+	"github.com/hacash/core/chainstate"
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/interfaces"
+	"github.com/hacash/core/sys"
+)
+
+/**
+ * Fast sync from state snapshot
+ * A new node loads a snapshot whose commitment matches the checkpoint of its height,
+ * then starts the chain engine on the block of the height and syncs only the blocks after it:
+ *
+ *   checkpoints, e := snapshot.StateSnapshotCheckpointsByInicnf(cnf)
+ *   snap, base, e := snapshot.LoadStateSnapshot(buf, checkpoints, state)
+ *   engine := chainengine.NewChainEngine(cnf, state, base)
+ *
+ * The signature tells who published the snapshot, the trust comes from the checkpoint
+ * No checkpoint is built in yet, the ones set in the config by the node operator are the only trust root for now
+ */
+
+// Commitment hash hex of the trusted state snapshots by height, added when a snapshot is published
+// Empty until the first snapshot is published, fast sync needs a checkpoint in the config until then
+var StateSnapshotCheckpoints = map[uint64]string{}
+
+// The built in checkpoints and the ones in the config
+//
+//	[snapshot]
+//	checkpoints = <height>:<commitment hash hex>, ...
+func StateSnapshotCheckpointsByInicnf(cnf *sys.Inicnf) (map[uint64]string, error) {
+	checkpoints := make(map[uint64]string, len(StateSnapshotCheckpoints))
+	for height, hash := range StateSnapshotCheckpoints {
+		checkpoints[height] = hash
+	}
+	for _, item := range cnf.StringValueList("snapshot", "checkpoints") {
+		parts := strings.Split(item, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("state snapshot checkpoint <%s> format error.", item)
+		}
+		height, e := strconv.ParseUint(parts[0], 10, 64)
+		if e != nil {
+			return nil, fmt.Errorf("state snapshot checkpoint <%s> height error.", item)
+		}
+		hash, e := hex.DecodeString(parts[1])
+		if e != nil || len(hash) != fields.HashSize {
+			return nil, fmt.Errorf("state snapshot checkpoint <%s> hash error.", item)
+		}
+		if have, ok := checkpoints[height]; ok && !strings.EqualFold(have, parts[1]) {
+			return nil, fmt.Errorf("state snapshot checkpoint of height %d conflicts with <%s>.", height, have)
+		}
+		checkpoints[height] = parts[1]
+	}
+	return checkpoints, nil
+}
+
+// Check the heads are linked and end at the block of the snapshot, return the last one
+func (s *StateSnapshot) checkHeads() (interfaces.Block, error) {
+	if len(s.Heads) == 0 {
+		return nil, fmt.Errorf("state snapshot has no block head.")
+	}
+	var prev interfaces.Block = nil
+	for i, body := range s.Heads {
+		head, _, e := blocks.ParseExcludeTransactions(body, 0)
+		if e != nil {
+			return nil, e
+		}
+		height := s.Height - uint64(len(s.Heads)-1-i)
+		if head.GetHeight() != height {
+			return nil, fmt.Errorf("state snapshot head height need %d but got %d.", height, head.GetHeight())
+		}
+		if prev != nil && !head.GetPrevHash().Equal(prev.Hash()) {
+			return nil, fmt.Errorf("state snapshot head %d not link to the prev.", height)
+		}
+		prev = head
+	}
+	if !prev.Hash().Equal(s.BlockHash) {
+		return nil, fmt.Errorf("state snapshot block hash need <%s> but got <%s>.", s.BlockHash.ToHex(), prev.Hash().ToHex())
+	}
+	return prev, nil
+}
+
+// Fill the empty immutable state and its block store by the snapshot matching the checkpoint
+// Return the block head of the snapshot height, the base block for the chain engine
+func LoadStateSnapshot(buf []byte, checkpoints map[uint64]string, state *chainstate.MemoryChainState) (*StateSnapshot, interfaces.Block, error) {
+	if state.GetPendingBlockHeight() > 0 {
+		return nil, nil, fmt.Errorf("state to load the snapshot must be empty.")
+	}
+	snap, e := ParseStateSnapshot(buf)
+	if e != nil {
+		return nil, nil, e
+	}
+	want, ok := checkpoints[snap.Height]
+	if !ok {
+		return nil, nil, fmt.Errorf("no state snapshot checkpoint of height %d.", snap.Height)
+	}
+	hash, e := snap.Commitment()
+	if e != nil {
+		return nil, nil, e
+	}
+	if !strings.EqualFold(want, hash.ToHex()) {
+		return nil, nil, fmt.Errorf("state snapshot commitment <%s> not match the checkpoint <%s>.", hash.ToHex(), want)
+	}
+	base, e := snap.checkHeads()
+	if e != nil {
+		return nil, nil, e
+	}
+	if e := snap.checkDiamonds(); e != nil {
+		return nil, nil, e
+	}
+	store := state.BlockStore()
+	heads, ok := store.(chainstate.BlockHeadStore)
+	if !ok {
+		return nil, nil, fmt.Errorf("block store not support saving block heads.")
+	}
+	// block store
+	for i, body := range snap.Heads {
+		height := snap.Height - uint64(len(snap.Heads)-1-i)
+		head, _, _ := blocks.ParseExcludeTransactions(body, 0)
+		if e := heads.SaveBlockHead(height, head.Hash(), body); e != nil {
+			return nil, nil, e
+		}
+	}
+	for i, page := range snap.BTCMoveLogs {
+		if e := store.SaveBTCMoveLogPageData(i+1, page); e != nil {
+			return nil, nil, e
+		}
+	}
+	for _, diamond := range snap.Diamonds {
+		if e := store.SaveDiamond(diamond); e != nil {
+			return nil, nil, e
+		}
+		if e := store.UpdateSetDiamondNameReferToNumber(uint32(diamond.Number), diamond.Diamond); e != nil {
+			return nil, nil, e
+		}
+	}
+	// state
+	items := make(map[string][]byte, len(snap.Items))
+	for _, item := range snap.Items {
+		items[string(item.Key)] = item.Value
+	}
+	pending := chainstate.NewPendingStatus(snap.Height, snap.BlockHash, base)
+	if e := state.RestoreImmutableState(items, pending); e != nil {
+		return nil, nil, e
+	}
+	return snap, base, nil
+}
@@ -0,0 +1,419 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/hacash/core/account"
+	"github.com/hacash/core/blocks"
+	"github.com/hacash/core/chainstate"
+	"github.com/hacash/core/difficulty"
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/interfaces"
+	"github.com/hacash/core/stores"
+)
+
+/**
+ * State snapshot
+ * All items of the immutable state at one height: balances, diamonds, channels, lockbls, lendings, chaswap,
+ * tx hash indexes, total supply and the latest status with the latest diamond,
+ * the btc move log pages, the smelt records of all diamonds by number for the diamond lending,
+ * and the block heads before the height needed by the difficulty retarget
+ * The binary form is the content, the sha3 commitment hash of it and the signature of the commitment
+ */
+
+const (
+	stateSnapshotMagic   = "HCSS"
+	StateSnapshotVersion = uint8(2)
+
+	// Heads up to the snapshot height, the next blocks read them to retarget the difficulty
+	StateSnapshotHeadCount = difficulty.AdjustTargetDifficultyNumberOfBlocks
+)
+
+// Prefixes of all items in the state
+var stateSnapshotPrefixs = []byte{
+	chainstate.KeyPrefixBalance,
+	chainstate.KeyPrefixDiamond,
+	chainstate.KeyPrefixChannel,
+	chainstate.KeyPrefixLockbls,
+	chainstate.KeyPrefixDiamondLending,
+	chainstate.KeyPrefixBitcoinLending,
+	chainstate.KeyPrefixUserLending,
+	chainstate.KeyPrefixChaswap,
+	chainstate.KeyPrefixTxHash,
+	chainstate.KeyPrefixMoveBTCTxHash,
+	chainstate.KeyPrefixTotalSupply,
+	chainstate.KeyPrefixLatestStatus,
+}
+
+type StateItem struct {
+	Key   []byte // prefix + key, see chainstate.StoreKey
+	Value []byte
+}
+
+type StateSnapshot struct {
+	Height      uint64
+	BlockHash   fields.Hash
+	Heads       [][]byte // head and meta of the blocks, the last one is the block of the height
+	Items       []*StateItem
+	BTCMoveLogs [][]*stores.SatoshiGenesis // from page 1
+	Diamonds    []*stores.DiamondSmelt     // from number 1 to the latest
+	Signature   *fields.Sign               // sign of the commitment, nil is not signed
+}
+
+// Read the head and meta of the block at the height from the store
+func readBlockHead(store interfaces.BlockStoreRead, height uint64) ([]byte, error) {
+	if heads, ok := store.(chainstate.BlockHeadStore); ok {
+		_, head, e := heads.ReadBlockHeadBytesByHeight(height)
+		if e != nil {
+			return nil, e
+		}
+		if head != nil {
+			return head, nil
+		}
+	}
+	_, body, e := store.ReadBlockBytesByHeight(height)
+	if e != nil {
+		return nil, e
+	}
+	if body == nil {
+		return nil, fmt.Errorf("block %d not find.", height)
+	}
+	_, seek, e := blocks.ParseExcludeTransactions(body, 0)
+	if e != nil {
+		return nil, e
+	}
+	return body[:seek], nil
+}
+
+// Copy the immutable state and the btc move logs of its block store, sign it before publish
+func BuildStateSnapshot(state *chainstate.MemoryChainState) (*StateSnapshot, error) {
+	if !state.IsImmutable() {
+		return nil, fmt.Errorf("state snapshot must be built from the immutable state.")
+	}
+	snap := &StateSnapshot{
+		Height:      state.GetPendingBlockHeight(),
+		BlockHash:   state.GetPendingBlockHash(),
+		Heads:       make([][]byte, 0),
+		Items:       make([]*StateItem, 0),
+		BTCMoveLogs: make([][]*stores.SatoshiGenesis, 0),
+		Diamonds:    make([]*stores.DiamondSmelt, 0),
+	}
+	store := state.BlockStoreRead()
+	start := uint64(0)
+	if snap.Height >= StateSnapshotHeadCount {
+		start = snap.Height - StateSnapshotHeadCount + 1
+	}
+	for height := start; height <= snap.Height; height++ {
+		head, e := readBlockHead(store, height)
+		if e != nil {
+			return nil, e
+		}
+		snap.Heads = append(snap.Heads, head)
+	}
+	for _, prefix := range stateSnapshotPrefixs {
+		state.TraversalItems(prefix, func(key []byte, body []byte) bool {
+			snap.Items = append(snap.Items, &StateItem{[]byte(chainstate.StoreKey(prefix, key)), body})
+			return true
+		})
+	}
+	pages, e := store.GetBTCMoveLogTotalPage()
+	if e != nil {
+		return nil, e
+	}
+	for page := 1; page <= pages; page++ {
+		data, e := store.GetBTCMoveLogPageData(page)
+		if e != nil {
+			return nil, e
+		}
+		snap.BTCMoveLogs = append(snap.BTCMoveLogs, data)
+	}
+	latest, e := snap.LatestDiamond()
+	if e != nil {
+		return nil, e
+	}
+	if latest != nil {
+		for number := uint32(1); number <= uint32(latest.Number); number++ {
+			diamond, e := store.ReadDiamondByNumber(number)
+			if e != nil {
+				return nil, e
+			}
+			if diamond == nil {
+				return nil, fmt.Errorf("diamond %d not find in the block store.", number)
+			}
+			snap.Diamonds = append(snap.Diamonds, diamond)
+		}
+	}
+	return snap, nil
+}
+
+func (s *StateSnapshot) findItem(prefix byte, key []byte) []byte {
+	target := chainstate.StoreKey(prefix, key)
+	for _, item := range s.Items {
+		if string(item.Key) == target {
+			return item.Value
+		}
+	}
+	return nil
+}
+
+func (s *StateSnapshot) TotalSupply() (*stores.TotalSupply, error) {
+	total := stores.NewTotalSupplyStoreData()
+	if body := s.findItem(chainstate.KeyPrefixTotalSupply, nil); body != nil {
+		if _, e := total.Parse(body, 0); e != nil {
+			return nil, e
+		}
+	}
+	return total, nil
+}
+
+// Nil if no diamond minted
+func (s *StateSnapshot) LatestDiamond() (*stores.DiamondSmelt, error) {
+	body := s.findItem(chainstate.KeyPrefixLatestStatus, nil)
+	if body == nil {
+		return nil, nil
+	}
+	status := chainstate.NewLatestStatus()
+	if _, e := status.Parse(body, 0); e != nil {
+		return nil, e
+	}
+	return status.ReadLastestDiamond(), nil
+}
+
+// Smelt records must be all numbers up to the latest diamond
+func (s *StateSnapshot) checkDiamonds() error {
+	latest, e := s.LatestDiamond()
+	if e != nil {
+		return e
+	}
+	count := 0
+	if latest != nil {
+		count = int(latest.Number)
+	}
+	if len(s.Diamonds) != count {
+		return fmt.Errorf("state snapshot diamonds need %d but got %d.", count, len(s.Diamonds))
+	}
+	for i, diamond := range s.Diamonds {
+		if int(diamond.Number) != i+1 {
+			return fmt.Errorf("state snapshot diamond number need %d but got %d.", i+1, diamond.Number)
+		}
+	}
+	if count > 0 && string(s.Diamonds[count-1].Diamond) != string(latest.Diamond) {
+		return fmt.Errorf("state snapshot diamond %d not the latest <%s>.", count, string(latest.Diamond))
+	}
+	return nil
+}
+
+/**************************** binary ****************************/
+
+func writeSizeBytes(buf *bytes.Buffer, body []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(body)))
+	buf.Write(body)
+}
+
+// Content without the commitment hash and the signature
+func (s *StateSnapshot) serializeContent() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	buf.WriteString(stateSnapshotMagic)
+	buf.WriteByte(StateSnapshotVersion)
+	binary.Write(buf, binary.BigEndian, s.Height)
+	if len(s.BlockHash) != fields.HashSize {
+		return nil, fmt.Errorf("state snapshot block hash size error.")
+	}
+	buf.Write(s.BlockHash)
+	binary.Write(buf, binary.BigEndian, uint32(len(s.Heads)))
+	for _, head := range s.Heads {
+		writeSizeBytes(buf, head)
+	}
+	binary.Write(buf, binary.BigEndian, uint32(len(s.Items)))
+	for _, item := range s.Items {
+		writeSizeBytes(buf, item.Key)
+		writeSizeBytes(buf, item.Value)
+	}
+	binary.Write(buf, binary.BigEndian, uint32(len(s.BTCMoveLogs)))
+	for _, page := range s.BTCMoveLogs {
+		writeSizeBytes(buf, stores.SatoshiGenesisPageSerialize(page))
+	}
+	binary.Write(buf, binary.BigEndian, uint32(len(s.Diamonds)))
+	for _, diamond := range s.Diamonds {
+		body, e := diamond.Serialize()
+		if e != nil {
+			return nil, e
+		}
+		writeSizeBytes(buf, body)
+	}
+	return buf.Bytes(), nil
+}
+
+// Hash committed to the snapshot content
+func (s *StateSnapshot) Commitment() (fields.Hash, error) {
+	body, e := s.serializeContent()
+	if e != nil {
+		return nil, e
+	}
+	return fields.CalculateHash(body), nil
+}
+
+// Sign the commitment by the publisher
+func (s *StateSnapshot) SignBy(acc *account.Account) error {
+	hash, e := s.Commitment()
+	if e != nil {
+		return e
+	}
+	signature, e := acc.Private.Sign(hash)
+	if e != nil {
+		return e
+	}
+	s.Signature = &fields.Sign{
+		PublicKey: acc.PublicKey,
+		Signature: signature.Serialize64(),
+	}
+	return nil
+}
+
+// Address of the publisher, nil if not signed
+func (s *StateSnapshot) Signer() fields.Address {
+	if s.Signature == nil {
+		return nil
+	}
+	return s.Signature.GetAddress()
+}
+
+func (s *StateSnapshot) verifySignature(hash fields.Hash) error {
+	if s.Signature == nil {
+		return fmt.Errorf("state snapshot not signed.")
+	}
+	ok, e := account.CheckSignByHash32(hash, s.Signature.PublicKey, s.Signature.Signature)
+	if e != nil {
+		return e
+	}
+	if !ok {
+		return fmt.Errorf("state snapshot signature verify fail.")
+	}
+	return nil
+}
+
+// Content followed by the commitment hash and the signature
+func (s *StateSnapshot) Serialize() ([]byte, error) {
+	if s.Signature == nil {
+		return nil, fmt.Errorf("state snapshot not signed.")
+	}
+	body, e := s.serializeContent()
+	if e != nil {
+		return nil, e
+	}
+	sign, e := s.Signature.Serialize()
+	if e != nil {
+		return nil, e
+	}
+	return append(append(body, fields.CalculateHash(body)...), sign...), nil
+}
+
+// Parse and check the commitment hash and the signature
+func ParseStateSnapshot(buf []byte) (*StateSnapshot, error) {
+	tail := fields.HashSize + int(fields.SignSize)
+	if len(buf) < len(stateSnapshotMagic)+1+8+fields.HashSize+4*4+tail {
+		return nil, fmt.Errorf("state snapshot size %d too short.", len(buf))
+	}
+	body := buf[:len(buf)-tail]
+	hash := fields.CalculateHash(body)
+	if !hash.Equal(buf[len(body) : len(body)+fields.HashSize]) {
+		return nil, fmt.Errorf("state snapshot commitment hash not match.")
+	}
+	if string(body[:4]) != stateSnapshotMagic {
+		return nil, fmt.Errorf("not a state snapshot.")
+	}
+	if body[4] != StateSnapshotVersion {
+		return nil, fmt.Errorf("state snapshot version %d not support.", body[4])
+	}
+	s := &StateSnapshot{
+		Height:    binary.BigEndian.Uint64(body[5:13]),
+		BlockHash: append([]byte{}, body[13:45]...),
+		Signature: &fields.Sign{},
+	}
+	if _, e := s.Signature.Parse(buf, uint32(len(body)+fields.HashSize)); e != nil {
+		return nil, e
+	}
+	if e := s.verifySignature(hash); e != nil {
+		return nil, e
+	}
+	seek := 45
+	readCount := func() (int, error) {
+		if seek+4 > len(body) {
+			return 0, fmt.Errorf("state snapshot count out of range.")
+		}
+		num := int(binary.BigEndian.Uint32(body[seek : seek+4]))
+		seek += 4
+		return num, nil
+	}
+	readBytes := func() ([]byte, error) {
+		size, e := readCount()
+		if e != nil {
+			return nil, e
+		}
+		if seek+size > len(body) {
+			return nil, fmt.Errorf("state snapshot item out of range.")
+		}
+		data := append([]byte{}, body[seek:seek+size]...)
+		seek += size
+		return data, nil
+	}
+	count, e := readCount()
+	if e != nil {
+		return nil, e
+	}
+	s.Heads = make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		head, e := readBytes()
+		if e != nil {
+			return nil, e
+		}
+		s.Heads = append(s.Heads, head)
+	}
+	if count, e = readCount(); e != nil {
+		return nil, e
+	}
+	s.Items = make([]*StateItem, 0, count)
+	for i := 0; i < count; i++ {
+		key, e := readBytes()
+		if e != nil {
+			return nil, e
+		}
+		value, e := readBytes()
+		if e != nil {
+			return nil, e
+		}
+		s.Items = append(s.Items, &StateItem{key, value})
+	}
+	if count, e = readCount(); e != nil {
+		return nil, e
+	}
+	s.BTCMoveLogs = make([][]*stores.SatoshiGenesis, 0, count)
+	for i := 0; i < count; i++ {
+		page, e := readBytes()
+		if e != nil {
+			return nil, e
+		}
+		s.BTCMoveLogs = append(s.BTCMoveLogs, stores.SatoshiGenesisPageParse(page, 0))
+	}
+	if count, e = readCount(); e != nil {
+		return nil, e
+	}
+	s.Diamonds = make([]*stores.DiamondSmelt, 0, count)
+	for i := 0; i < count; i++ {
+		body, e := readBytes()
+		if e != nil {
+			return nil, e
+		}
+		diamond := &stores.DiamondSmelt{}
+		if _, e := diamond.Parse(body, 0); e != nil {
+			return nil, e
+		}
+		s.Diamonds = append(s.Diamonds, diamond)
+	}
+	if seek != len(body) {
+		return nil, fmt.Errorf("state snapshot has %d extra bytes.", len(body)-seek)
+	}
+	return s, nil
+}
@@ -0,0 +1,77 @@
+package statetree
+
+import (
+	"fmt"
+	"github.com/hacash/core/fields"
+	"testing"
+)
+
+func Test_proof(t *testing.T) {
+
+	tree := NewTree()
+	if !tree.Root().Equal(EmptyRoot()) {
+		t.Fatal("empty root error")
+	}
+	key := func(i int) fields.Hash {
+		return KeyHash([]byte(fmt.Sprintf("key%d", i)))
+	}
+	value := func(i int) []byte {
+		return []byte(fmt.Sprintf("value%d", i))
+	}
+	for i := 0; i < 50; i++ {
+		tree.Set(key(i), value(i))
+	}
+	root := tree.Root()
+	if root.ToHex() != "559fda30119995a2f496abc2c8693909adfbe354bc9771765ea8d3d75ca66048" {
+		t.Fatal("root changed", root.ToHex())
+	}
+	// root only depends on content, not the order
+	reverse := NewTree()
+	for i := 59; i >= 0; i-- {
+		reverse.Set(key(i), value(i))
+	}
+	for i := 50; i < 60; i++ {
+		reverse.Delete(key(i))
+	}
+	if !reverse.Root().Equal(root) || reverse.Count() != 50 {
+		t.Fatal("root depends on order")
+	}
+	for i := 0; i < 60; i++ {
+		proof := tree.Prove(key(i))
+		bts, _ := proof.Serialize()
+		if uint32(len(bts)) != proof.Size() {
+			t.Fatal("proof size error")
+		}
+		proof2 := &Proof{}
+		if _, e := proof2.Parse(bts, 0); e != nil {
+			t.Fatal(e)
+		}
+		if i < 50 {
+			if !VerifyProof(root, key(i), value(i), proof2) {
+				t.Fatal("inclusion proof fail", i)
+			}
+			if VerifyProof(root, key(i), value(i+1), proof2) || VerifyProof(root, key(i), nil, proof2) {
+				t.Fatal("wrong value must fail", i)
+			}
+		} else {
+			if !VerifyProof(root, key(i), nil, proof2) {
+				t.Fatal("absence proof fail", i)
+			}
+			if VerifyProof(root, key(i), value(i), proof2) {
+				t.Fatal("absent key must fail", i)
+			}
+		}
+	}
+	// root only depends on content
+	copytree := tree.Copy()
+	copytree.Set(key(3), value(100))
+	copytree.Set(key(100), value(100))
+	if copytree.Root().Equal(root) || !tree.Root().Equal(root) {
+		t.Fatal("copy error")
+	}
+	copytree.Set(key(3), value(3))
+	copytree.Delete(key(100))
+	if !copytree.Root().Equal(root) {
+		t.Fatal("root must be same")
+	}
+}
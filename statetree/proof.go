@@ -0,0 +1,116 @@
+package statetree
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/hacash/core/fields"
+)
+
+type Proof struct {
+	SiblingCount fields.VarUint2
+	Siblings     []fields.Hash // from the root to the leaf
+	// For absence proof, the other leaf at the place of the key, empty if the place is empty
+	LeafKeyHash   fields.Hash
+	LeafValueHash fields.Hash
+}
+
+func (p *Proof) hasOtherLeaf() bool {
+	return len(p.LeafKeyHash) == fields.HashSize
+}
+
+// Verify the key hash has the value in the tree of root, nil value means to prove absence
+func VerifyProof(root fields.Hash, keyhash fields.Hash, value []byte, proof *Proof) bool {
+	if len(keyhash) != fields.HashSize || int(proof.SiblingCount) != len(proof.Siblings) || len(proof.Siblings) > 256 {
+		return false
+	}
+	var cur fields.Hash
+	if value != nil {
+		if proof.hasOtherLeaf() {
+			return false
+		}
+		cur = leafHash(keyhash, ValueHash(value))
+	} else if proof.hasOtherLeaf() {
+		if proof.LeafKeyHash.Equal(keyhash) || len(proof.LeafValueHash) != fields.HashSize {
+			return false
+		}
+		// the other leaf must be on the path of the key
+		for d := 0; d < len(proof.Siblings); d++ {
+			if keyBit(proof.LeafKeyHash, d) != keyBit(keyhash, d) {
+				return false
+			}
+		}
+		cur = leafHash(proof.LeafKeyHash, proof.LeafValueHash)
+	} else {
+		cur = zeroHash
+	}
+	for d := len(proof.Siblings) - 1; d >= 0; d-- {
+		if keyBit(keyhash, d) == 0 {
+			cur = branchHash(cur, proof.Siblings[d])
+		} else {
+			cur = branchHash(proof.Siblings[d], cur)
+		}
+	}
+	return cur.Equal(root)
+}
+
+func (p *Proof) Size() uint32 {
+	size := p.SiblingCount.Size() + uint32(len(p.Siblings))*fields.HashSize + 1
+	if p.hasOtherLeaf() {
+		size += fields.HashSize * 2
+	}
+	return size
+}
+
+func (p *Proof) Serialize() ([]byte, error) {
+	var buffer = new(bytes.Buffer)
+	b1, _ := p.SiblingCount.Serialize()
+	buffer.Write(b1)
+	for _, hx := range p.Siblings {
+		buffer.Write(hx)
+	}
+	if p.hasOtherLeaf() {
+		buffer.WriteByte(1)
+		buffer.Write(p.LeafKeyHash)
+		buffer.Write(p.LeafValueHash)
+	} else {
+		buffer.WriteByte(0)
+	}
+	return buffer.Bytes(), nil
+}
+
+func (p *Proof) Parse(buf []byte, seek uint32) (uint32, error) {
+	var e error = nil
+	seek, e = p.SiblingCount.Parse(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	if p.SiblingCount > 256 {
+		return 0, fmt.Errorf("state proof sibling count %d overflow.", p.SiblingCount)
+	}
+	p.Siblings = make([]fields.Hash, int(p.SiblingCount))
+	for i := 0; i < int(p.SiblingCount); i++ {
+		seek, e = p.Siblings[i].Parse(buf, seek)
+		if e != nil {
+			return 0, e
+		}
+	}
+	if int(seek) >= len(buf) {
+		return 0, fmt.Errorf("[Proof.Parse] seek out of buf len.")
+	}
+	hasleaf := buf[seek]
+	seek++
+	p.LeafKeyHash = nil
+	p.LeafValueHash = nil
+	if hasleaf == 1 {
+		seek, e = p.LeafKeyHash.Parse(buf, seek)
+		if e != nil {
+			return 0, e
+		}
+		seek, e = p.LeafValueHash.Parse(buf, seek)
+		if e != nil {
+			return 0, e
+		}
+	}
+	return seek, nil
+}
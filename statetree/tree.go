@@ -0,0 +1,280 @@
+package statetree
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/hacash/core/fields"
+)
+
+/**
+ * Sparse merkle tree of 256 bits key hash
+ * A subtree with only one leaf is the leaf itself and an empty subtree is zero hash,
+ * so the path of a key is as long as needed to separate it from the other keys
+ * Nodes are immutable and keep their hash, a change or a proof costs the depth of the key only
+ */
+
+const (
+	leafNodeMark   = byte(0)
+	branchNodeMark = byte(1)
+)
+
+var zeroHash = fields.Hash(make([]byte, fields.HashSize))
+
+// Hash of empty tree
+func EmptyRoot() fields.Hash {
+	return append(fields.Hash{}, zeroHash...)
+}
+
+// Key hash of any key, the key decides the path in the tree
+func KeyHash(key []byte) fields.Hash {
+	return fields.CalculateHash(key)
+}
+
+func ValueHash(value []byte) fields.Hash {
+	return fields.CalculateHash(value)
+}
+
+func leafHash(keyhash fields.Hash, valuehash fields.Hash) fields.Hash {
+	var buf bytes.Buffer
+	buf.WriteByte(leafNodeMark)
+	buf.Write(keyhash)
+	buf.Write(valuehash)
+	return fields.CalculateHash(buf.Bytes())
+}
+
+func branchHash(left fields.Hash, right fields.Hash) fields.Hash {
+	var buf bytes.Buffer
+	buf.WriteByte(branchNodeMark)
+	buf.Write(left)
+	buf.Write(right)
+	return fields.CalculateHash(buf.Bytes())
+}
+
+// Bit of the key hash at depth, 0 is left
+func keyBit(keyhash []byte, depth int) byte {
+	return (keyhash[depth/8] >> (7 - uint(depth%8))) & 1
+}
+
+// Leaf or branch, never changed after created so the hash is cached
+// A branch always has two leaves or more under it
+type node struct {
+	keyhash   fields.Hash // leaf only
+	valuehash fields.Hash // leaf only
+	left      *node
+	right     *node
+	hash      fields.Hash
+}
+
+func (n *node) isLeaf() bool {
+	return n.keyhash != nil
+}
+
+func newLeaf(keyhash fields.Hash, valuehash fields.Hash) *node {
+	return &node{
+		keyhash:   keyhash,
+		valuehash: valuehash,
+		hash:      leafHash(keyhash, valuehash),
+	}
+}
+
+func newBranch(left *node, right *node) *node {
+	return &node{
+		left:  left,
+		right: right,
+		hash:  branchHash(nodeHash(left), nodeHash(right)),
+	}
+}
+
+func nodeHash(n *node) fields.Hash {
+	if n == nil {
+		return zeroHash
+	}
+	return n.hash
+}
+
+// Subtree of two different leaves at depth
+func mergeLeaves(a *node, b *node, depth int) *node {
+	ba, bb := keyBit(a.keyhash, depth), keyBit(b.keyhash, depth)
+	if ba != bb {
+		if ba == 0 {
+			return newBranch(a, b)
+		}
+		return newBranch(b, a)
+	}
+	sub := mergeLeaves(a, b, depth+1)
+	if ba == 0 {
+		return newBranch(sub, nil)
+	}
+	return newBranch(nil, sub)
+}
+
+// New subtree with the leaf set, the nodes not on the path are shared
+func insertNode(n *node, leaf *node, depth int) *node {
+	if n == nil {
+		return leaf
+	}
+	if n.isLeaf() {
+		if n.keyhash.Equal(leaf.keyhash) {
+			return leaf
+		}
+		return mergeLeaves(n, leaf, depth)
+	}
+	if keyBit(leaf.keyhash, depth) == 0 {
+		return newBranch(insertNode(n.left, leaf, depth+1), n.right)
+	}
+	return newBranch(n.left, insertNode(n.right, leaf, depth+1))
+}
+
+// New subtree without the key hash, a branch left with one leaf becomes the leaf
+func deleteNode(n *node, keyhash fields.Hash, depth int) *node {
+	if n == nil {
+		return nil
+	}
+	if n.isLeaf() {
+		if n.keyhash.Equal(keyhash) {
+			return nil
+		}
+		return n
+	}
+	left, right := n.left, n.right
+	if keyBit(keyhash, depth) == 0 {
+		left = deleteNode(left, keyhash, depth+1)
+		if left == n.left {
+			return n
+		}
+	} else {
+		right = deleteNode(right, keyhash, depth+1)
+		if right == n.right {
+			return n
+		}
+	}
+	if left == nil && (right == nil || right.isLeaf()) {
+		return right
+	}
+	if right == nil && left.isLeaf() {
+		return left
+	}
+	return newBranch(left, right)
+}
+
+type Tree struct {
+	root  *node
+	count int
+
+	mux sync.RWMutex
+}
+
+func NewTree() *Tree {
+	return &Tree{}
+}
+
+// Copy for the next block, changes of the copy do not affect this one
+// All nodes are shared, a change creates the new nodes on its path only
+func (t *Tree) Copy() *Tree {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+
+	return &Tree{
+		root:  t.root,
+		count: t.count,
+	}
+}
+
+func (t *Tree) Count() int {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+
+	return t.count
+}
+
+// Set value of the key hash, nil value means delete
+func (t *Tree) Set(keyhash fields.Hash, value []byte) {
+	if value == nil {
+		t.Delete(keyhash)
+		return
+	}
+	t.SetValueHash(keyhash, ValueHash(value))
+}
+
+func (t *Tree) SetValueHash(keyhash fields.Hash, valuehash fields.Hash) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if t.getUnsafe(keyhash) == nil {
+		t.count++
+	}
+	leaf := newLeaf(append(fields.Hash{}, keyhash...), append(fields.Hash{}, valuehash...))
+	t.root = insertNode(t.root, leaf, 0)
+}
+
+func (t *Tree) Delete(keyhash fields.Hash) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if t.getUnsafe(keyhash) != nil {
+		t.root = deleteNode(t.root, keyhash, 0)
+		t.count--
+	}
+}
+
+func (t *Tree) getUnsafe(keyhash fields.Hash) fields.Hash {
+	n := t.root
+	for depth := 0; n != nil && !n.isLeaf(); depth++ {
+		if keyBit(keyhash, depth) == 0 {
+			n = n.left
+		} else {
+			n = n.right
+		}
+	}
+	if n != nil && n.keyhash.Equal(keyhash) {
+		return n.valuehash
+	}
+	return nil
+}
+
+// Value hash of the key hash, nil if not exist
+func (t *Tree) Get(keyhash fields.Hash) fields.Hash {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+
+	if v := t.getUnsafe(keyhash); v != nil {
+		return append(fields.Hash{}, v...)
+	}
+	return nil
+}
+
+// Commitment of all values
+func (t *Tree) Root() fields.Hash {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+
+	return append(fields.Hash{}, nodeHash(t.root)...)
+}
+
+// Proof of inclusion or absence of the key hash
+func (t *Tree) Prove(keyhash fields.Hash) *Proof {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+
+	proof := &Proof{
+		Siblings: make([]fields.Hash, 0),
+	}
+	n := t.root
+	for depth := 0; n != nil && !n.isLeaf(); depth++ {
+		if keyBit(keyhash, depth) == 0 {
+			proof.Siblings = append(proof.Siblings, nodeHash(n.right))
+			n = n.left
+		} else {
+			proof.Siblings = append(proof.Siblings, nodeHash(n.left))
+			n = n.right
+		}
+	}
+	if n != nil && !n.keyhash.Equal(keyhash) {
+		// another leaf takes the place
+		proof.LeafKeyHash = append(fields.Hash{}, n.keyhash...)
+		proof.LeafValueHash = append(fields.Hash{}, n.valuehash...)
+	}
+	proof.SiblingCount = fields.VarUint2(len(proof.Siblings))
+	return proof
+}
@@ -53,8 +53,18 @@ func (this *Balance) Serialize() ([]byte, error) {
 }
 
 func (this *Balance) Parse(buf []byte, seek uint32) (uint32, error) {
-	seek, _ = this.Diamond.Parse(buf, seek)
-	seek, _ = this.Satoshi.Parse(buf, seek)
-	seek, _ = this.Hacash.Parse(buf, seek)
+	var e error
+	seek, e = this.Diamond.Parse(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	seek, e = this.Satoshi.Parse(buf, seek)
+	if e != nil {
+		return 0, e
+	}
+	seek, e = this.Hacash.Parse(buf, seek)
+	if e != nil {
+		return 0, e
+	}
 	return seek, nil
 }
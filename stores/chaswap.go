@@ -2,6 +2,7 @@ package stores
 
 import (
 	"bytes"
+	"fmt"
 	"github.com/hacash/core/fields"
 )
 
@@ -44,6 +45,9 @@ func (elm *Chaswap) Parse(buf []byte, seek uint32) (uint32, error) {
 		return 0, e
 	}
 	scn := int(elm.AddressCount)
+	if int(seek)+scn*int(fields.AddressSize) > len(buf) {
+		return 0, fmt.Errorf("[Chaswap.Parse] %w", fields.ErrSeekOutOfBuf)
+	}
 	elm.OnchainTransferFromAndMustSignAddresses = make([]fields.Address, scn)
 	for i := 0; i < scn; i++ {
 		seek, e = elm.OnchainTransferFromAndMustSignAddresses[i].Parse(buf, seek)
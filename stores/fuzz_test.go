@@ -0,0 +1,84 @@
+package stores
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hacash/core/fields"
+)
+
+type fuzzStore interface {
+	Serialize() ([]byte, error)
+	Parse([]byte, uint32) (uint32, error)
+}
+
+// Store records are read back from the state db, a record that changes in a round trip changes the state root
+func fuzzRoundTrip(t *testing.T, obj fuzzStore, obj2 fuzzStore, data []byte) {
+	seek, e := obj.Parse(data, 0)
+	if e != nil {
+		return
+	}
+	if int(seek) > len(data) {
+		t.Fatalf("seek %d out of data len %d", seek, len(data))
+	}
+	bts, e := obj.Serialize()
+	if e != nil {
+		return
+	}
+	seek, e = obj2.Parse(bts, 0)
+	if e != nil {
+		t.Fatalf("parse serialized data error: %v", e)
+	}
+	if int(seek) != len(bts) {
+		t.Fatalf("parse serialized data seek %d but len %d", seek, len(bts))
+	}
+	bts2, _ := obj2.Serialize()
+	if !bytes.Equal(bts, bts2) {
+		t.Fatalf("round trip not match %x != %x", bts, bts2)
+	}
+}
+
+var fuzzStoreCreators = []func() fuzzStore{
+	func() fuzzStore { return new(Balance) },
+	func() fuzzStore { return new(BitcoinSystemLending) },
+	func() fuzzStore { return new(Channel) },
+	func() fuzzStore { return new(Chaswap) },
+	func() fuzzStore { return new(Diamond) },
+	func() fuzzStore { return new(DiamondSystemLending) },
+	func() fuzzStore { return new(DiamondSmelt) },
+	func() fuzzStore { return new(Lockbls) },
+	func() fuzzStore { return new(SatoshiGenesis) },
+	func() fuzzStore { return new(TotalSupply) },
+	func() fuzzStore { return new(UserLending) },
+}
+
+// Seeds are built from the store constructors, no records of a mainnet state db are checked in
+func FuzzParseStores(f *testing.F) {
+	addr, _ := fields.CheckReadableAddress("1AVRuFXNFi3rdMrPH4hdqSgFrEBnWisWaS")
+	amt := fields.NewAmountSmall(1, 248)
+	seeds := make([][]byte, 0)
+	for _, obj := range []fuzzStore{
+		NewBalanceWithAmount(amt),
+		NewBitcoinSystemLending(*addr),
+		CreateEmptyChannel(),
+		&Chaswap{AddressCount: 2, OnchainTransferFromAndMustSignAddresses: []fields.Address{*addr, *addr}},
+		NewDiamond(*addr),
+		NewDiamondSystemLending(*addr),
+		NewEmptyLockbls(*addr),
+		NewTotalSupplyStoreData(),
+	} {
+		if bts, e := obj.Serialize(); e == nil {
+			seeds = append(seeds, bts)
+		}
+	}
+	seeds = append(seeds, []byte{}, []byte{0, 255, 255}, bytes.Repeat([]byte{1}, 300))
+	for i := range fuzzStoreCreators {
+		for _, seed := range seeds {
+			f.Add(uint8(i), seed)
+		}
+	}
+	f.Fuzz(func(t *testing.T, ty uint8, data []byte) {
+		create := fuzzStoreCreators[int(ty)%len(fuzzStoreCreators)]
+		fuzzRoundTrip(t, create(), create(), data)
+	})
+}
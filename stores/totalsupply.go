@@ -141,11 +141,18 @@ func (t *TotalSupply) Serialize() ([]byte, error) {
 // Deserialization
 func (t *TotalSupply) Parse(buf []byte, seek uint32) (uint32, error) {
 	if int(seek)+1 > len(buf) {
-		return 0, fmt.Errorf("TotalSupply Parse: buf too short")
+		return 0, fmt.Errorf("TotalSupply Parse: %w", fields.ErrSeekOutOfBuf)
 	}
 	tysize := int(buf[seek])
-	t.changeMark = make([]bool, tysize)
+	if tysize > typeSizeMax {
+		return 0, fmt.Errorf("TotalSupply Parse: type size %d overflow", tysize)
+	}
 	seek += 1
+	if int(seek)+tysize*8 > len(buf) {
+		return 0, fmt.Errorf("TotalSupply Parse: %w", fields.ErrSeekOutOfBuf)
+	}
+	t.changeMark = make([]bool, typeSizeMax)
+	t.dataBytes = make([]float64, typeSizeMax)
 	for i := 0; i < tysize; i++ {
 		t.changeMark[i] = true
 		intbts := binary.BigEndian.Uint64(buf[seek : seek+8])
@@ -0,0 +1,166 @@
+package stream
+
+import (
+	"bytes"
+	"errors"
+	"github.com/hacash/core/account"
+	"github.com/hacash/core/actions"
+	"github.com/hacash/core/blocks"
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/interfaces"
+	"github.com/hacash/core/internal/testchain"
+	"io"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// Transfers to self
+func newTestTransfers(acc *account.Account, txnum int) []interfaces.Transaction {
+	txs := make([]interfaces.Transaction, txnum)
+	for i := range txs {
+		txs[i] = testchain.NewTx(uint64(i+1), acc.Address, nil, actions.NewAction_1_SimpleToTransfer(acc.Address, fields.NewAmountSmall(1, 248)))
+	}
+	return txs
+}
+
+func Test_decode(t *testing.T) {
+
+	acc1 := account.CreateAccountByPassword("123456")
+
+	var stuff bytes.Buffer
+	list := []interfaces.Block{
+		testchain.NewBlockAtHeight(1, acc1.Address),
+		testchain.NewBlockAtHeight(2, acc1.Address, newTestTransfers(acc1, 100)...),
+		testchain.NewBlockAtHeight(3, acc1.Address, newTestTransfers(acc1, 5)...),
+	}
+	for _, blk := range list {
+		bts, _ := blk.Serialize()
+		stuff.Write(bts)
+	}
+	all := stuff.Bytes()
+
+	// one byte each read
+	decoder := NewDecoder(iotest.OneByteReader(bytes.NewReader(all)))
+	for _, blk := range list {
+		blk2, e := decoder.DecodeBlock()
+		if e != nil {
+			t.Fatal(e)
+		}
+		if !blk2.Hash().Equal(blk.Hash()) || len(blk2.GetTrsList()) != len(blk.GetTrsList()) {
+			t.Fatal("decode block error")
+		}
+	}
+	if !decoder.IsEnd() {
+		t.Fatal("must be end")
+	}
+	if _, e := decoder.DecodeBlock(); e != io.EOF {
+		t.Fatal("must be EOF", e)
+	}
+
+	// truncated
+	for _, cut := range []int{1, 50, 89, 200, len(all) - 1} {
+		decoder = NewDecoder(bytes.NewReader(all[:len(all)-cut]))
+		var e error
+		for e == nil {
+			_, e = decoder.DecodeBlock()
+		}
+		if !errors.Is(e, io.ErrUnexpectedEOF) {
+			t.Fatal(cut, "must be unexpected EOF", e)
+		}
+	}
+
+	// every cut of an object asks for more data
+	for _, blk := range list {
+		bts, _ := blk.Serialize()
+		for i := 0; i < len(bts); i++ {
+			if _, _, e := blocks.ParseBlock(bts[:i], 0); !errors.Is(e, fields.ErrSeekOutOfBuf) {
+				t.Fatal(i, "cut block must be out of buf", e)
+			}
+		}
+	}
+
+	// oversized
+	decoder = NewDecoder(bytes.NewReader(all))
+	decoder.MaxBlockSize = 1024
+	decoder.DecodeBlock()
+	if _, e := decoder.DecodeBlock(); e == nil {
+		t.Fatal("oversized block must fail")
+	}
+
+	// garbage never panics
+	garbage := [][]byte{
+		{1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0},
+		bytes.Repeat([]byte{1}, 200),
+		bytes.Repeat([]byte{0xff}, 5000),
+		append([]byte{1}, bytes.Repeat([]byte{0}, 75)...),
+	}
+	for i, g := range garbage {
+		if _, e := NewDecoder(bytes.NewReader(g)).DecodeBlock(); e == nil {
+			t.Fatal(i, "garbage block must fail")
+		}
+		NewDecoder(bytes.NewReader(g)).DecodeTransaction()
+		NewDecoder(bytes.NewReader(g)).DecodeAction()
+	}
+
+	// transactions and actions
+	tx := list[1].GetTrsList()[3]
+	txbts, _ := tx.Serialize()
+	act := tx.GetActionList()[0]
+	actbts, _ := act.Serialize()
+	decoder = NewDecoder(bytes.NewReader(append(append([]byte{}, txbts...), actbts...)))
+	tx2, e := decoder.DecodeTransaction()
+	if e != nil || !tx2.Hash().Equal(tx.Hash()) {
+		t.Fatal("decode tx error", e)
+	}
+	act2, e := decoder.DecodeAction()
+	if e != nil || act2.Kind() != act.Kind() {
+		t.Fatal("decode action error", e)
+	}
+
+	// live connection, a small object does not wait for more data
+	reader, writer := io.Pipe()
+	defer writer.Close()
+	go writer.Write(txbts)
+	done := make(chan error, 1)
+	go func() {
+		_, e := NewDecoder(reader).DecodeTransaction()
+		done <- e
+	}()
+	select {
+	case e := <-done:
+		if e != nil {
+			t.Fatal(e)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("decode tx blocked on live connection")
+	}
+	// bad data fails without waiting for more
+	go writer.Write([]byte{0xee, 1, 2, 3})
+	if _, e := NewDecoder(reader).DecodeTransaction(); e == nil || errors.Is(e, fields.ErrSeekOutOfBuf) {
+		t.Fatal("bad tx type must fail", e)
+	}
+
+	// a large block is parsed a few times, not after every chunk
+	bigbts, _ := testchain.NewBlockAtHeight(4, acc1.Address, newTestTransfers(acc1, 3000)...).Serialize()
+	parses := 0
+	decoder = NewDecoder(bytes.NewReader(bigbts))
+	e = decoder.Decode(DefaultMaxBlockSize, func(buf []byte) (uint32, error) {
+		parses++
+		_, seek, e := blocks.ParseBlock(buf, 0)
+		return seek, e
+	})
+	if e != nil || parses > 12 {
+		t.Fatal("large block parsed", parses, "times", len(bigbts), e)
+	}
+
+	// reader returns nothing forever
+	if _, e := NewDecoder(emptyReader{}).DecodeBlock(); !errors.Is(e, io.ErrNoProgress) {
+		t.Fatal("empty reads must fail", e)
+	}
+}
+
+// Read returns zero bytes and no error
+type emptyReader struct{}
+
+func (emptyReader) Read(p []byte) (int, error) { return 0, nil }
@@ -0,0 +1,172 @@
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/hacash/core/actions"
+	"github.com/hacash/core/blocks"
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/interfaces"
+	"github.com/hacash/core/transactions"
+)
+
+/**
+ * Decode objects one by one from a reader
+ * More is read only when the parse reaches the end of data, the data wanted doubles after each such parse
+ * A short read means the reader has no more for now, the data is parsed then without waiting for the wanted size
+ */
+
+const (
+	DefaultMaxBlockSize       = 1024 * 1024 * 4
+	DefaultMaxTransactionSize = 1024 * 64
+	DefaultMaxActionSize      = 1024 * 32
+
+	readChunkSize = 1024 * 4
+
+	maxEmptyReads = 100 // reads of zero bytes in a row before giving up
+)
+
+type Decoder struct {
+	reader     io.Reader
+	buf        []byte // read but not decoded
+	eof        bool
+	emptyReads int
+
+	MaxBlockSize       uint32
+	MaxTransactionSize uint32
+	MaxActionSize      uint32
+}
+
+func NewDecoder(reader io.Reader) *Decoder {
+	return &Decoder{
+		reader:             reader,
+		buf:                make([]byte, 0),
+		eof:                false,
+		MaxBlockSize:       DefaultMaxBlockSize,
+		MaxTransactionSize: DefaultMaxTransactionSize,
+		MaxActionSize:      DefaultMaxActionSize,
+	}
+}
+
+// Whether all data is decoded
+func (d *Decoder) IsEnd() bool {
+	for len(d.buf) == 0 && !d.eof {
+		if _, e := d.read(readChunkSize); e != nil {
+			return false
+		}
+	}
+	return len(d.buf) == 0 && d.eof
+}
+
+// One read of the reader, at most size bytes, return whether it is a short read
+func (d *Decoder) read(size int) (bool, error) {
+	if size > readChunkSize {
+		size = readChunkSize
+	}
+	chunk := make([]byte, size)
+	n, e := d.reader.Read(chunk)
+	d.buf = append(d.buf, chunk[:n]...)
+	if e == io.EOF {
+		d.eof = true
+	} else if e != nil {
+		return false, e
+	}
+	if n == 0 && !d.eof {
+		d.emptyReads++
+		if d.emptyReads >= maxEmptyReads {
+			return false, io.ErrNoProgress
+		}
+		return false, nil // not short, nothing new to parse
+	}
+	d.emptyReads = 0
+	return n < size, nil
+}
+
+// Decode one object, return io.EOF if no data left
+// Parse must return an error wrapping fields.ErrSeekOutOfBuf if the data is not complete
+func (d *Decoder) Decode(maxsize uint32, parse func(buf []byte) (uint32, error)) error {
+	want := readChunkSize
+	for {
+		if len(d.buf) > 0 {
+			seek, e := parse(d.buf)
+			if e == nil {
+				if seek == 0 || int(seek) > len(d.buf) {
+					return fmt.Errorf("parse seek %d out of data len %d.", seek, len(d.buf))
+				}
+				if seek > maxsize {
+					return fmt.Errorf("object size %d overflow max size %d.", seek, maxsize)
+				}
+				d.buf = d.buf[seek:]
+				return nil
+			}
+			if !errors.Is(e, fields.ErrSeekOutOfBuf) {
+				return e // bad data, more bytes cannot make it right
+			}
+			if len(d.buf) >= int(maxsize) {
+				return fmt.Errorf("object not complete in max size %d: %v", maxsize, e)
+			}
+			if d.eof {
+				return fmt.Errorf("%w: %s", io.ErrUnexpectedEOF, e.Error())
+			}
+			want = len(d.buf) * 2
+			if want > int(maxsize) {
+				want = int(maxsize)
+			}
+		} else if d.eof {
+			return io.EOF
+		}
+		for len(d.buf) < want && !d.eof {
+			short, e := d.read(want - len(d.buf))
+			if e != nil {
+				return e
+			}
+			if short {
+				break
+			}
+		}
+	}
+}
+
+func (d *Decoder) DecodeBlock() (interfaces.Block, error) {
+	var block interfaces.Block
+	e := d.Decode(d.MaxBlockSize, func(buf []byte) (uint32, error) {
+		var seek uint32
+		var e error
+		block, seek, e = blocks.ParseBlock(buf, 0)
+		return seek, e
+	})
+	if e != nil {
+		return nil, e
+	}
+	return block, nil
+}
+
+func (d *Decoder) DecodeTransaction() (interfaces.Transaction, error) {
+	var tx interfaces.Transaction
+	e := d.Decode(d.MaxTransactionSize, func(buf []byte) (uint32, error) {
+		var seek uint32
+		var e error
+		tx, seek, e = transactions.ParseTransaction(buf, 0)
+		return seek, e
+	})
+	if e != nil {
+		return nil, e
+	}
+	return tx, nil
+}
+
+func (d *Decoder) DecodeAction() (interfaces.Action, error) {
+	var act interfaces.Action
+	e := d.Decode(d.MaxActionSize, func(buf []byte) (uint32, error) {
+		var seek uint32
+		var e error
+		act, seek, e = actions.ParseAction(buf, 0)
+		return seek, e
+	})
+	if e != nil {
+		return nil, e
+	}
+	return act, nil
+}
@@ -15,6 +15,33 @@ import (
 // Global development test tag
 var TestDebugLocalDevelopmentMark bool = false
 var TransactionSystemCheckChainID uint64 = 0 // fork or test chain ID
+var SignatureCanonicalCheckHeight uint64 = 0 // reject non-canonical high S signature from this block height, 0 is disabled
+
+// Whether the strict low S signature check is active at the block height
+func IsSignatureCanonicalCheckActive(height uint64) bool {
+	return SignatureCanonicalCheckHeight > 0 && height >= SignatureCanonicalCheckHeight
+}
+
+var WitnessVerifyActiveHeight uint64 = 0 // check coinbase witness votes from this block height, 0 is disabled
+
+// Whether the coinbase witness votes are checked at the block height
+func IsWitnessVerifyActive(height uint64) bool {
+	return WitnessVerifyActiveHeight > 0 && height >= WitnessVerifyActiveHeight
+}
+
+var AggregatedSignActiveHeight uint64 = 0 // actions signed by one aggregated schnorr signature valid from this block height, 0 is disabled
+
+// Whether the aggregated signature actions are valid at the block height
+func IsAggregatedSignActive(height uint64) bool {
+	return AggregatedSignActiveHeight > 0 && height >= AggregatedSignActiveHeight
+}
+
+var BlockVersion2ActiveHeight uint64 = 0 // blocks must be version 2 from this block height, 0 is disabled
+
+// Whether blocks of the height must be version 2
+func IsBlockVersion2Active(height uint64) bool {
+	return BlockVersion2ActiveHeight > 0 && height >= BlockVersion2ActiveHeight
+}
 
 type Inicnf struct {
 	inicnf.File
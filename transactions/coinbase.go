@@ -6,10 +6,12 @@ import (
 	"fmt"
 	"github.com/hacash/core/account"
 	"github.com/hacash/core/actions"
+	"github.com/hacash/core/coinbase"
 	"github.com/hacash/core/fields"
 	"github.com/hacash/core/interfaces"
 	"github.com/hacash/core/interfacev2"
 	"github.com/hacash/core/stores"
+	"github.com/hacash/core/witness"
 	"math/big"
 )
 
@@ -25,7 +27,7 @@ type Transaction_0_Coinbase struct {
 	MinerNonce   fields.Bytes32
 	WitnessCount fields.VarUint1 // Number of voting witnesses
 	WitnessSigs  []uint8         // Witness specified hash mantissa
-	Witnesses    []fields.Sign   // Signature of prev block hash, voting fork
+	Witnesses    []fields.Sign   // Signature of prev block hash and mantissa, voting fork
 
 	/* -------- -------- */
 
@@ -105,6 +107,10 @@ func (trs *Transaction_0_Coinbase) GetReward() *fields.Amount {
 	return &trs.Reward
 }
 
+func (trs *Transaction_0_Coinbase) GetExtendDataVersion() uint8 {
+	return uint8(trs.ExtendDataVersion)
+}
+
 func (trs *Transaction_0_Coinbase) Type() uint8 {
 	return 0
 }
@@ -179,11 +185,14 @@ func (trs *Transaction_0_Coinbase) Parse(buf []byte, seek uint32) (uint32, error
 		}
 		if trs.WitnessCount > 0 {
 			lenwc := int(trs.WitnessCount)
+			if int(seek)+lenwc*(1+int(fields.SignSize)) > len(buf) {
+				return 0, fmt.Errorf("%w", fields.ErrSeekOutOfBuf)
+			}
 			trs.WitnessSigs = make([]uint8, lenwc)
 			trs.Witnesses = make([]fields.Sign, lenwc)
 			for i := 0; i < lenwc; i++ {
 				if seek >= uint32(len(buf)) {
-					return 0, fmt.Errorf("seek out of buf len.")
+					return 0, fmt.Errorf("%w", fields.ErrSeekOutOfBuf)
 				}
 				trs.WitnessSigs[i] = buf[seek]
 				seek++
@@ -191,7 +200,7 @@ func (trs *Transaction_0_Coinbase) Parse(buf []byte, seek uint32) (uint32, error
 			for i := 0; i < lenwc; i++ {
 				var sign fields.Sign
 				if seek >= uint32(len(buf)) {
-					return 0, fmt.Errorf("seek out of buf len.")
+					return 0, fmt.Errorf("%w", fields.ErrSeekOutOfBuf)
 				}
 				seek, e = sign.Parse(buf, seek)
 				if e != nil {
@@ -301,8 +310,48 @@ func (trs *Transaction_0_Coinbase) VerifyAllNeedSigns() (bool, error) {
 	return true, nil
 }
 
+// Witness votes, empty before extend data version 1
+func (trs *Transaction_0_Coinbase) GetWitnesses() ([]uint8, []fields.Sign) {
+	if trs.ExtendDataVersion < 1 {
+		return []uint8{}, []fields.Sign{}
+	}
+	return trs.WitnessSigs, trs.Witnesses
+}
+
+// Append a witness vote of prev block hash, the sign must be made with the same mantissa by witness.Sign
+func (trs *Transaction_0_Coinbase) AddWitness(mantissa uint8, sign fields.Sign) error {
+	if trs.ExtendDataVersion < 1 {
+		return fmt.Errorf("coinbase extend data version %d cannot carry witness.", trs.ExtendDataVersion)
+	}
+	if trs.WitnessCount >= 255 {
+		return fmt.Errorf("coinbase witness count overflow.")
+	}
+	trs.WitnessCount += 1
+	trs.WitnessSigs = append(trs.WitnessSigs, mantissa)
+	trs.Witnesses = append(trs.Witnesses, sign)
+	return nil
+}
+
+// Verify witness votes of prev block hash by the default registry, see witness.Registry.VerifyWitnesses
+func (trs *Transaction_0_Coinbase) VerifyWitnessSigns(height uint64, stage uint16, prevhash fields.Hash) (bool, error) {
+	mantissas, signs := trs.GetWitnesses()
+	_, e := witness.DefaultRegistry.VerifyWitnesses(height, stage, prevhash, mantissas, signs)
+	if e != nil {
+		return false, e
+	}
+	return true, nil
+}
+
 func (trs *Transaction_0_Coinbase) WriteInChainState(state interfaces.ChainStateOperation) error {
 
+	// Check reward and version
+	if !state.IsDatabaseVersionRebuildMode() {
+		e := coinbase.CheckCoinbase(trs, state.GetPendingBlockHeight())
+		if e != nil {
+			return e
+		}
+	}
+
 	// Total supply statistics
 	// reward
 	totalsupply, e2 := state.ReadTotalSupply()
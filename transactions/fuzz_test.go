@@ -0,0 +1,75 @@
+package transactions
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/hacash/core/account"
+	"github.com/hacash/core/actions"
+	"github.com/hacash/core/fields"
+)
+
+// Txs come from peers and the pool, a parsed tx must serialize back to the bytes its hash is over
+// The genesis coinbase is checked in under testdata/fuzz, the type 2 seed is signed here
+func FuzzParseTransactions(f *testing.F) {
+	feeamt, _ := fields.NewAmountFromFinString("ㄜ1:246")
+	mainaddr, _ := fields.CheckReadableAddress("1MzNY1oA3kfgYi75zquj3SRUPYztzXHzK9")
+	tx, _ := NewEmptyTransaction_2_Simple(*mainaddr)
+	tx.Fee = *feeamt
+	tx.Timestamp = 1618839281
+	tx.AppendAction(actions.NewAction_1_SimpleToTransfer(*mainaddr, feeamt))
+	acc := account.CreateAccountByPassword("123456")
+	tx.FillNeedSigns(map[string][]byte{string(acc.Address): acc.PrivateKey}, nil)
+	txbody, _ := tx.Serialize()
+
+	cbtx := NewTransaction_0_CoinbaseV1()
+	cbtx.Address = *mainaddr
+	cbtx.Reward = *feeamt
+	cbtx.Message = "hardertodobetter"
+	cbbody, _ := cbtx.Serialize()
+
+	for _, seed := range [][]byte{
+		txbody,
+		cbbody,
+		{},
+		{2},
+		append([]byte{0}, bytes.Repeat([]byte{1}, 100)...),
+		append([]byte{2}, bytes.Repeat([]byte{0xff}, 200)...),
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		trs, seek, e := ParseTransaction(data, 0)
+		if e != nil {
+			return
+		}
+		if int(seek) > len(data) {
+			t.Fatalf("seek %d out of data len %d", seek, len(data))
+		}
+		if tx2, ok := trs.(*Transaction_2_Simple); ok && tx2.MultisignCount > 0 {
+			return // legacy multisign encoding does not round trip
+		}
+		bts, e := trs.Serialize()
+		if e != nil {
+			return
+		}
+		trs2, seek, e := ParseTransaction(bts, 0)
+		if e != nil {
+			t.Fatalf("parse serialized data error: %v", e)
+		}
+		if int(seek) != len(bts) {
+			t.Fatalf("parse serialized data seek %d but len %d", seek, len(bts))
+		}
+		bts2, _ := trs2.Serialize()
+		if !bytes.Equal(bts, bts2) {
+			t.Fatalf("round trip not match %x != %x", bts, bts2)
+		}
+		// a cut transaction asks for more data, see stream.Decoder
+		for i := 0; i < len(bts); i++ {
+			if _, _, e := ParseTransaction(bts[:i], 0); !errors.Is(e, fields.ErrSeekOutOfBuf) {
+				t.Fatalf("cut %d of %x must be out of buf: %v", i, bts, e)
+			}
+		}
+	})
+}
@@ -2,6 +2,7 @@ package transactions
 
 import (
 	"fmt"
+	"github.com/hacash/core/fields"
 	"github.com/hacash/core/interfaces"
 )
 
@@ -23,7 +24,7 @@ func NewTransactionByType(ty uint8) (interfaces.Transaction, error) {
 
 func ParseTransaction(buf []byte, seek uint32) (interfaces.Transaction, uint32, error) {
 	if seek >= uint32(len(buf)) {
-		return nil, 0, fmt.Errorf("buf length over range")
+		return nil, 0, fmt.Errorf("buf length over range, %w", fields.ErrSeekOutOfBuf)
 	}
 	ty := uint8(buf[seek])
 	var trx, e1 = NewTransactionByType(ty)
@@ -508,6 +508,12 @@ func (trs *Transaction_2_Simple) WriteInChainState(state interfaces.ChainStateOp
 			return fmt.Errorf("BlockHeight more than 20w trs.Fee.Size() must less than 6 bytes.")
 		}
 	}
+	// Reject high S signature
+	if sys.IsSignatureCanonicalCheckActive(state.GetPendingBlockHeight()) {
+		if e := fields.CheckCanonicalSigns(trs.Signs); e != nil {
+			return e
+		}
+	}
 	// actions
 	for i := 0; i < len(trs.Actions); i++ {
 		trs.Actions[i].SetBelongTrs(trs)
@@ -0,0 +1,99 @@
+package witness
+
+import (
+	"fmt"
+	"github.com/hacash/core/account"
+	"github.com/hacash/core/crypto/btcec"
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/sys"
+	"math/big"
+	"testing"
+)
+
+func Test_verify_witnesses(t *testing.T) {
+
+	defer func(height, canonical uint64) {
+		sys.WitnessVerifyActiveHeight = height
+		sys.SignatureCanonicalCheckHeight = canonical
+	}(sys.WitnessVerifyActiveHeight, sys.SignatureCanonicalCheckHeight)
+
+	acc1 := account.CreateAccountByPassword("123456")
+	acc2 := account.CreateAccountByPassword("qwerty")
+	acc3 := account.CreateAccountByPassword("asdfgh")
+	prevhash := fields.CalculateHash([]byte("prev block"))
+	forkhash := fields.CalculateHash([]byte("fork block"))
+	registry := NewRegistry()
+	e := registry.SetStage(1, []*Witness{{acc1.PublicKey, 3}, {acc2.PublicKey, 2}})
+	if e != nil {
+		t.Fatal(e)
+	}
+	mantissas := []uint8{7, 200}
+	s1, _ := Sign(acc1, prevhash, mantissas[0])
+	s2, _ := Sign(acc2, prevhash, mantissas[1])
+	s3, _ := Sign(acc3, prevhash, 0)
+
+	// not active
+	sys.WitnessVerifyActiveHeight = 0
+	if w, e := registry.VerifyWitnesses(100, 1, prevhash, []uint8{0}, []fields.Sign{s3}); w != 0 || e != nil {
+		t.Fatal("votes checked before the activation", w, e)
+	}
+	sys.WitnessVerifyActiveHeight = 100
+	if w, e := registry.VerifyWitnesses(99, 1, prevhash, []uint8{0}, []fields.Sign{s3}); w != 0 || e != nil {
+		t.Fatal("votes checked below the active height", w, e)
+	}
+	// stage without registered witnesses
+	if w, e := registry.VerifyWitnesses(100, 2, prevhash, []uint8{0}, []fields.Sign{s3}); w != 0 || e != nil {
+		t.Fatal("votes checked in stage without witnesses", w, e)
+	}
+
+	weight, e := registry.VerifyWitnesses(100, 1, prevhash, mantissas, []fields.Sign{s1, s2})
+	if e != nil || weight != 5 {
+		t.Fatal(weight, e)
+	}
+	// not registered
+	if _, e = registry.VerifyWitnesses(100, 1, prevhash, mantissas, []fields.Sign{s1, s3}); e == nil {
+		t.Fatal("witness key must be checked")
+	} else {
+		fmt.Println(e)
+	}
+	// vote for another fork
+	if _, e = registry.VerifyWitnesses(100, 1, forkhash, mantissas[:1], []fields.Sign{s1}); e == nil {
+		t.Fatal("fork vote must fail")
+	}
+	// valid signature with another mantissa
+	if _, e = registry.VerifyWitnesses(100, 1, prevhash, []uint8{8}, []fields.Sign{s1}); e == nil {
+		t.Fatal("mantissa must be bound in the signature")
+	}
+	if _, e = registry.VerifyWitnesses(100, 1, prevhash, []uint8{200, 7}, []fields.Sign{s1, s2}); e == nil {
+		t.Fatal("swapped mantissas must fail")
+	}
+	// duplicate
+	if _, e = registry.VerifyWitnesses(100, 1, prevhash, []uint8{7, 7}, []fields.Sign{s1, s1}); e == nil {
+		t.Fatal("duplicate must be checked")
+	}
+	// count
+	if _, e = registry.VerifyWitnesses(100, 1, prevhash, mantissas[:1], []fields.Sign{s1, s2}); e == nil {
+		t.Fatal("mantissa count must be checked")
+	}
+	// bad signature
+	bad := s2
+	bad.Signature = append([]byte{}, s1.Signature...)
+	if _, e = registry.VerifyWitnesses(100, 1, prevhash, mantissas[:1], []fields.Sign{bad}); e == nil {
+		t.Fatal("signature must be checked")
+	}
+
+	// high S is rejected only from the canonical check height
+	sig, _ := btcec.ParseSignatureByte64(s1.Signature)
+	high := s1
+	high.Signature = make([]byte, 64)
+	copy(high.Signature, s1.Signature[:32])
+	sb := new(big.Int).Sub(btcec.S256().N, sig.S).Bytes()
+	copy(high.Signature[64-len(sb):], sb)
+	sys.SignatureCanonicalCheckHeight = 200
+	if _, e = registry.VerifyWitnesses(199, 1, prevhash, mantissas[:1], []fields.Sign{high}); e != nil {
+		t.Fatal(e)
+	}
+	if _, e = registry.VerifyWitnesses(200, 1, prevhash, mantissas[:1], []fields.Sign{high}); e == nil {
+		t.Fatal("high S must be rejected from the canonical check height")
+	}
+}
@@ -0,0 +1,98 @@
+package witness
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/hacash/core/fields"
+)
+
+// Witness key allowed to vote in a stage
+type Witness struct {
+	PublicKey fields.Bytes33
+	Weight    uint64
+}
+
+func (w *Witness) GetAddress() fields.Address {
+	sign := fields.Sign{PublicKey: w.PublicKey}
+	return sign.GetAddress()
+}
+
+// Allowed witness keys of each block WitnessStage
+type Registry struct {
+	stages map[uint16][]*Witness
+
+	mux sync.RWMutex
+}
+
+// Registry used by block verification, empty by default
+var DefaultRegistry = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{
+		stages: make(map[uint16][]*Witness),
+	}
+}
+
+// Replace the witness list of the stage
+func (r *Registry) SetStage(stage uint16, witnesses []*Witness) error {
+	for i, w := range witnesses {
+		if len(w.PublicKey) != 33 {
+			return fmt.Errorf("witness %d public key length error.", i)
+		}
+		if w.Weight == 0 {
+			return fmt.Errorf("witness %s weight cannot be zero.", w.GetAddress().ToReadable())
+		}
+		for _, o := range witnesses[:i] {
+			if bytes.Equal(o.PublicKey, w.PublicKey) {
+				return fmt.Errorf("witness %s is duplicate.", w.GetAddress().ToReadable())
+			}
+		}
+	}
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.stages[stage] = witnesses
+	return nil
+}
+
+// Whether the stage has any registered witness
+func (r *Registry) HasStage(stage uint16) bool {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	return len(r.stages[stage]) > 0
+}
+
+func (r *Registry) Stage(stage uint16) []*Witness {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	return r.stages[stage]
+}
+
+// Find the witness of the stage by public key, nil if not allowed
+func (r *Registry) Find(stage uint16, pubkey fields.Bytes33) *Witness {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	for _, w := range r.stages[stage] {
+		if bytes.Equal(w.PublicKey, pubkey) {
+			return w
+		}
+	}
+	return nil
+}
+
+// Sum of the weight of all witnesses in the stage
+func (r *Registry) TotalWeight(stage uint16) uint64 {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	total := uint64(0)
+	for _, w := range r.stages[stage] {
+		total += w.Weight
+	}
+	return total
+}
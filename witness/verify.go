@@ -0,0 +1,122 @@
+package witness
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/hacash/core/account"
+	"github.com/hacash/core/fields"
+	"github.com/hacash/core/interfaces"
+	"github.com/hacash/core/sys"
+)
+
+/**
+ * A witness votes for a fork by signing the prev block hash with the hash mantissa it specifies, see Transaction_0_Coinbase.Witnesses
+ * The signed message is sha3(prevhash + mantissa), so a vote cannot be replayed with another mantissa
+ * Votes are checked only from sys.WitnessVerifyActiveHeight and only in the stages with registered witnesses,
+ * so the coinbases before the activation are valid whatever they carry
+ */
+
+// Coinbase carries witness votes
+type WitnessCarrier interface {
+	GetWitnesses() ([]uint8, []fields.Sign)
+}
+
+// Message a witness signs, the prev block hash and the mantissa
+func VoteHash(prevhash fields.Hash, mantissa uint8) fields.Hash {
+	stuff := make([]byte, 0, fields.HashSize+1)
+	stuff = append(stuff, prevhash...)
+	stuff = append(stuff, mantissa)
+	return fields.CalculateHash(stuff)
+}
+
+// Vote for the prev block hash with the mantissa
+func Sign(acc *account.Account, prevhash fields.Hash, mantissa uint8) (fields.Sign, error) {
+	if len(prevhash) != fields.HashSize {
+		return fields.Sign{}, fmt.Errorf("prev hash size error.")
+	}
+	signature, e := acc.Private.Sign(VoteHash(prevhash, mantissa))
+	if e != nil {
+		return fields.Sign{}, e
+	}
+	return fields.Sign{
+		PublicKey: acc.PublicKey,
+		Signature: signature.Serialize64(),
+	}, nil
+}
+
+// Whether the votes of the block at height in the stage are checked
+func (r *Registry) IsVerifyActive(height uint64, stage uint16) bool {
+	return sys.IsWitnessVerifyActive(height) && r.HasStage(stage)
+}
+
+// Check all witness votes of the block at height, return the total weight
+// Zero weight without error if the votes are not checked at the height or in the stage
+func (r *Registry) VerifyWitnesses(height uint64, stage uint16, prevhash fields.Hash, mantissas []uint8, signs []fields.Sign) (uint64, error) {
+	if !r.IsVerifyActive(height, stage) {
+		return 0, nil
+	}
+	if len(mantissas) != len(signs) {
+		return 0, fmt.Errorf("witness mantissa count %d not match sign count %d.", len(mantissas), len(signs))
+	}
+	if len(prevhash) != fields.HashSize {
+		return 0, fmt.Errorf("prev hash size error.")
+	}
+	canonical := sys.IsSignatureCanonicalCheckActive(height)
+	weight := uint64(0)
+	for i, sign := range signs {
+		addr := sign.GetAddress().ToReadable()
+		for _, o := range signs[:i] {
+			if bytes.Equal(o.PublicKey, sign.PublicKey) {
+				return 0, fmt.Errorf("witness %s is duplicate.", addr)
+			}
+		}
+		w := r.Find(stage, sign.PublicKey)
+		if w == nil {
+			return 0, fmt.Errorf("witness %s not allowed in stage %d.", addr, stage)
+		}
+		if canonical && !sign.IsCanonical() {
+			return 0, fmt.Errorf("witness %s signature is not canonical.", addr)
+		}
+		ok, e := account.CheckSignByHash32(VoteHash(prevhash, mantissas[i]), sign.PublicKey, sign.Signature)
+		if e != nil {
+			return 0, e
+		}
+		if !ok {
+			return 0, fmt.Errorf("witness %s signature verify fail.", addr)
+		}
+		weight += w.Weight
+	}
+	return weight, nil
+}
+
+// Total weight of the valid votes in the block coinbase, 0 if any vote is invalid or votes are not checked
+func (r *Registry) BlockWeight(block interfaces.Block) uint64 {
+	trslist := block.GetTrsList()
+	if len(trslist) < 1 {
+		return 0
+	}
+	carrier, ok := trslist[0].(WitnessCarrier)
+	if !ok {
+		return 0
+	}
+	mantissas, signs := carrier.GetWitnesses()
+	weight, e := r.VerifyWitnesses(block.GetHeight(), block.GetWitnessStage(), block.GetPrevHash(), mantissas, signs)
+	if e != nil {
+		return 0
+	}
+	return weight
+}
+
+// Tie-breaker of two fork blocks with the same cumulative work
+// Return 1 if a has more witness weight, -1 if b has, 0 if the same and the first seen is kept
+func (r *Registry) CompareFork(a, b interfaces.Block) int {
+	wa, wb := r.BlockWeight(a), r.BlockWeight(b)
+	if wa > wb {
+		return 1
+	}
+	if wa < wb {
+		return -1
+	}
+	return 0
+}